@@ -0,0 +1,434 @@
+package assistant
+
+import (
+	"fmt"
+	"fromkeith/my-desktop-server/attachments"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/quoted"
+	"fromkeith/my-desktop-server/redact"
+	"fromkeith/my-desktop-server/settings"
+	"fromkeith/my-desktop-server/usage"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// must match the dimensions of the vs_message_summaries index, see LLM_EMBED_DIMENSIONS
+var embedDimensions = llm.ConfigFromEnv().EmbedDimensions
+
+const (
+	maxQuestionLength = 1000
+	// messages retrieved for the context
+	defaultSources = 8
+	maxSources     = 20
+	// body chunks kept per message
+	chunksPerMessage = 2
+	chunkLength      = 1200
+)
+
+type AskRequest struct {
+	Question string `validate:"required" json:"question"`
+	// messages to retrieve. Defaults to 8, max 20
+	Limit int `json:"limit"`
+} // @name AskRequest
+
+// a message given to the model as context
+type AskSource struct {
+	MessageId    string          `validate:"required" json:"messageId"`
+	ThreadId     string          `validate:"required" json:"threadId"`
+	Subject      string          `validate:"required" json:"subject"`
+	Sender       data.PersonInfo `validate:"required" json:"sender"`
+	InternalDate int64           `validate:"required" json:"internalDate"`
+	Score        float64         `validate:"required" json:"score"`
+} // @name AskSource
+
+// a statement in the answer, and the messages that support it
+type AskClaim struct {
+	Text       string   `validate:"required" json:"text"`
+	MessageIds []string `validate:"required" json:"messageIds"`
+} // @name AskClaim
+
+type AskAnswer struct {
+	Answer string     `validate:"required" json:"answer"`
+	Claims []AskClaim `validate:"required" json:"claims"`
+	// false if the messages didn't have the answer
+	Answered bool `validate:"required" json:"answered"`
+} // @name AskAnswer
+
+type summaryHit struct {
+	MessageId string  `bson:"messageId"`
+	Summary   string  `bson:"summary"`
+	Score     float64 `bson:"score"`
+}
+
+type contextMessage struct {
+	source  AskSource
+	summary string
+	chunks  []string
+}
+
+const askInstructions = `You answer questions about the user's email. Use only the emails given below; if they don't contain the answer, say so and set Answered to false. Never guess.
+Break the answer into claims. Each claim lists the MessageIds of the emails that support it, copied exactly from the "MessageId:" lines.
+Return as JSON (Answer, Claims, Answered). Answer is the full answer, in plain language.`
+
+var askSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"Answer": map[string]any{
+			"type":        "string",
+			"description": "The answer to the question",
+		},
+		"Claims": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"Text": map[string]any{
+						"type":        "string",
+						"description": "A single statement from the answer",
+					},
+					"MessageIds": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type":        "string",
+							"description": "The MessageId of an email that supports the statement",
+						},
+					},
+				},
+				"required": []string{"Text", "MessageIds"},
+			},
+		},
+		"Answered": map[string]any{
+			"type":        "boolean",
+			"description": "False if the emails don't contain the answer",
+		},
+	},
+	"required": []string{"Answer", "Claims", "Answered"},
+}
+
+// sends an error event, as the stream has already started
+func streamError(r *gin.Context, status int, message string) {
+	r.Status(status)
+	r.SSEvent("error", gin.H{"error": message})
+}
+
+// Ask godoc
+// @Summary      Ask your mailbox
+// @Description  Answers a question using only the account's emails, citing the messages behind each claim. Streams "sources" with the messages found, then a "claim" per claim, then "answer" with the whole answer. Failures are sent as an "error" event.
+// @Tags         ai
+// @Accept       json
+// @Param        request body AskRequest true "Question"
+// @Produce      event-stream
+// @Router       /assistant/ask [post]
+func Ask(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	var req AskRequest
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		streamError(r, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" || len(req.Question) > maxQuestionLength {
+		streamError(r, http.StatusBadRequest, "question is required, and at most 1000 characters")
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxSources {
+		req.Limit = defaultSources
+	}
+	fail := func(err error, message string) {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg(message)
+		streamError(r, http.StatusInternalServerError, "Failed to answer the question")
+	}
+	over, err := usage.OverBudget(r, accountId)
+	if err != nil {
+		fail(err, "failed to check budget")
+		return
+	}
+	if over {
+		streamError(r, http.StatusTooManyRequests, "AI budget exceeded")
+		return
+	}
+	records := make([]usage.Record, 0, 2)
+	defer func() {
+		if err := usage.Save(r, records...); err != nil {
+			log.Error().
+				Ctx(r).
+				Err(err).
+				Msg("failed to save usage")
+		}
+	}()
+
+	level := settings.RedactionLevel(r, accountId)
+	question, _ := redact.Redact(req.Question, level)
+	embedded, err := globals.LLM().Embed(r, []string{question}, llm.EmbedOptions{
+		TaskType:   "RETRIEVAL_QUERY",
+		Dimensions: embedDimensions,
+	})
+	if err != nil {
+		fail(err, "failed to embed question")
+		return
+	}
+	records = append(records, usage.Record{
+		AccountId:       accountId,
+		Provider:        globals.LLM().Name(),
+		Model:           embedded.Model,
+		Operation:       usage.OperationAsk,
+		EmbeddingTokens: embedded.Usage.PromptTokens,
+	})
+
+	messages, err := retrieve(r, accountId, embedded.Vectors[0], req.Limit, req.Question)
+	if err != nil {
+		fail(err, "failed to retrieve messages")
+		return
+	}
+	sources := make([]AskSource, 0, len(messages))
+	for _, m := range messages {
+		sources = append(sources, m.source)
+	}
+	r.SSEvent("sources", sources)
+	r.Writer.Flush()
+	if len(messages) == 0 {
+		r.SSEvent("answer", AskAnswer{
+			Answer: "I couldn't find any emails about that.",
+			Claims: make([]AskClaim, 0),
+		})
+		return
+	}
+
+	result, err := globals.LLM().GenerateJSON(r, llm.JSONRequest{
+		System: askInstructions,
+		Prompt: askPrompt(req.Question, messages, level),
+		Schema: askSchema,
+	})
+	if err != nil {
+		fail(err, "failed to generate answer")
+		return
+	}
+	records = append(records, usage.Generated(globals.LLM(), accountId, "", usage.OperationAsk, result.Usage))
+	var answer AskAnswer
+	if err := json.Unmarshal([]byte(result.Text), &answer); err != nil {
+		fail(err, "failed to unmarshal answer")
+		return
+	}
+	answer.Claims = validClaims(answer.Claims, sources)
+	for _, claim := range answer.Claims {
+		r.SSEvent("claim", claim)
+		r.Writer.Flush()
+	}
+	r.SSEvent("answer", answer)
+}
+
+// the messages most relevant to the question, with the body chunks that best match it
+func retrieve(r *gin.Context, accountId string, vector []float32, limit int, question string) ([]contextMessage, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$vectorSearch", Value: bson.M{
+			"index":         "vs_message_summaries",
+			"path":          "embedding",
+			"queryVector":   vector,
+			"numCandidates": limit * 20,
+			"limit":         limit,
+			"filter":        bson.M{"accountId": accountId},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":       0,
+			"messageId": 1,
+			"summary":   1,
+			"score":     bson.M{"$meta": "vectorSearchScore"},
+		}}},
+	}
+	cursor, err := globals.DocDb().Collection("MessageSummaries").Aggregate(r, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(r)
+	var hits []summaryHit
+	if err := cursor.All(r, &hits); err != nil {
+		return nil, err
+	}
+	if len(hits) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, data.ToDocumentId(accountId, hit.MessageId))
+	}
+
+	msgCursor, err := globals.DocDb().Collection("Messages").Find(r, bson.M{"_id": bson.M{"$in": ids}, "isDeleted": bson.M{"$ne": true}})
+	if err != nil {
+		return nil, err
+	}
+	defer msgCursor.Close(r)
+	var entries []data.GmailEntry
+	if err := msgCursor.All(r, &entries); err != nil {
+		return nil, err
+	}
+	bodyCursor, err := globals.DocDb().Collection("MessageBodies").Find(r, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer bodyCursor.Close(r)
+	var bodies []data.GmailEntryBody
+	if err := bodyCursor.All(r, &bodies); err != nil {
+		return nil, err
+	}
+
+	terms := queryTerms(question)
+	// keep the order from the vector search
+	messages := make([]contextMessage, 0, len(hits))
+	for _, hit := range hits {
+		i := slices.IndexFunc(entries, func(e data.GmailEntry) bool { return e.MessageId == hit.MessageId })
+		if i < 0 {
+			continue // deleted
+		}
+		entry := entries[i]
+		m := contextMessage{
+			source: AskSource{
+				MessageId:    entry.MessageId,
+				ThreadId:     entry.ThreadId,
+				Subject:      entry.Subject,
+				Sender:       entry.Sender,
+				InternalDate: entry.InternalDate,
+				Score:        hit.Score,
+			},
+			summary: hit.Summary,
+		}
+		if b := slices.IndexFunc(bodies, func(b data.GmailEntryBody) bool { return b.MessageId == hit.MessageId }); b >= 0 {
+			m.chunks = bestChunks(bodyText(bodies[b]), terms)
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+func bodyText(body data.GmailEntryBody) string {
+	text := body.NewContent
+	if text == "" {
+		// bodies fetched before we stored the new content
+		text = quoted.StripText(body.PlainText)
+	}
+	return text
+}
+
+// too common to say which chunk answers the question
+var stopWords = []string{"the", "and", "for", "with", "from", "about", "what", "when", "where", "which", "who", "why", "how", "does", "did", "was", "are", "have", "has", "you", "your", "any", "this", "that"}
+
+// the lowercased words of the question worth matching on
+func queryTerms(question string) []string {
+	terms := make([]string, 0)
+	for _, word := range strings.FieldsFunc(strings.ToLower(question), func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c > 127)
+	}) {
+		if len(word) >= 3 && !slices.Contains(stopWords, word) && !slices.Contains(terms, word) {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// splits the body into paragraphs grouped up to chunkLength, and keeps the ones with the most question terms
+func bestChunks(text string, terms []string) []string {
+	chunks := make([]string, 0)
+	var current strings.Builder
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		if current.Len() > 0 && current.Len()+len(para) > chunkLength {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		para = attachments.Truncate(para, chunkLength)
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(para)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	if len(chunks) <= chunksPerMessage {
+		return chunks
+	}
+	scores := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		lower := strings.ToLower(chunk)
+		for _, term := range terms {
+			if strings.Contains(lower, term) {
+				scores[i]++
+			}
+		}
+	}
+	// the top scoring, ties to the earliest, then back in reading order
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return scores[b] - scores[a] })
+	keep := order[:chunksPerMessage]
+	slices.Sort(keep)
+	best := make([]string, 0, chunksPerMessage)
+	for _, i := range keep {
+		best = append(best, chunks[i])
+	}
+	return best
+}
+
+// the context and question. The content is redacted, but not the ids, so the model can still cite them
+func askPrompt(question string, messages []contextMessage, level redact.Level) string {
+	mask := func(text string) string {
+		masked, _ := redact.Redact(text, level)
+		return masked
+	}
+	var sb strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&sb, "MessageId: %s\nFrom: %s <%s>\nDate: %s\nSubject: %s\nSummary: %s\n",
+			m.source.MessageId,
+			m.source.Sender.Name,
+			m.source.Sender.Email,
+			time.UnixMilli(m.source.InternalDate).UTC().Format(time.RFC1123),
+			mask(m.source.Subject),
+			mask(strings.ReplaceAll(m.summary, "\n", " ")),
+		)
+		for _, chunk := range m.chunks {
+			sb.WriteString("---\n")
+			sb.WriteString(mask(chunk))
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n=====\n\n")
+	}
+	sb.WriteString("Question: ")
+	sb.WriteString(mask(question))
+	return sb.String()
+}
+
+// drops citations of messages that weren't in the context, so every id the client gets is real
+func validClaims(claims []AskClaim, sources []AskSource) []AskClaim {
+	valid := make([]AskClaim, 0, len(claims))
+	for _, claim := range claims {
+		ids := make([]string, 0, len(claim.MessageIds))
+		for _, id := range claim.MessageIds {
+			id = strings.TrimSpace(id)
+			if slices.ContainsFunc(sources, func(s AskSource) bool { return s.MessageId == id }) && !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		claim.MessageIds = ids
+		valid = append(valid, claim)
+	}
+	return valid
+}
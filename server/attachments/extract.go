@@ -0,0 +1,219 @@
+package attachments
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"fromkeith/my-desktop-server/gmail/data"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// bigger attachments aren't downloaded
+	MaxSize = 10 * 1024 * 1024
+	// text kept per attachment
+	MaxTextLength = 100 * 1024
+	// guards against zip bombs in docx files
+	maxUncompressed = 50 * 1024 * 1024
+)
+
+const (
+	kindPdf  = "pdf"
+	kindDocx = "docx"
+	kindText = "text"
+	kindCsv  = "csv"
+)
+
+var ErrUnsupported = errors.New("unsupported attachment type")
+
+// the parser for the attachment, or "" if we can't read it.
+// Mail clients often send application/octet-stream, so the extension is checked too
+func kindOf(mimeType, filename string) string {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	switch mimeType {
+	case "application/pdf":
+		return kindPdf
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return kindDocx
+	case "text/csv":
+		return kindCsv
+	case "text/plain":
+		return kindText
+	}
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".pdf":
+		return kindPdf
+	case ".docx":
+		return kindDocx
+	case ".csv":
+		return kindCsv
+	case ".txt", ".md", ".log":
+		return kindText
+	}
+	return ""
+}
+
+func Supported(mimeType, filename string) bool {
+	return kindOf(mimeType, filename) != ""
+}
+
+// pulls the text out of the attachment. Truncated to MaxTextLength
+func Extract(mimeType, filename string, content []byte) (text string, truncated bool, err error) {
+	switch kindOf(mimeType, filename) {
+	case kindPdf:
+		text, err = extractPdf(content)
+	case kindDocx:
+		text, err = extractDocx(content)
+	case kindCsv:
+		text = extractCsv(content)
+	case kindText:
+		text = string(content)
+	default:
+		return "", false, ErrUnsupported
+	}
+	if err != nil {
+		return "", false, err
+	}
+	text = strings.TrimSpace(strings.ToValidUTF8(text, ""))
+	if len(text) > MaxTextLength {
+		text = Truncate(text, MaxTextLength)
+		truncated = true
+	}
+	return text, truncated, nil
+}
+
+func extractPdf(content []byte) (text string, err error) {
+	// the parser panics on some malformed files
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("failed to parse pdf: %v", rec)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", err
+	}
+	plain, err := r.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(io.LimitReader(plain, MaxTextLength*2))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// the text of word/document.xml. Paragraphs become lines
+func extractDocx(content []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", err
+	}
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		return docxText(io.LimitReader(rc, maxUncompressed))
+	}
+	return "", errors.New("docx has no word/document.xml")
+}
+
+func docxText(r io.Reader) (string, error) {
+	var sb strings.Builder
+	decoder := xml.NewDecoder(r)
+	inText := false
+	for sb.Len() < MaxTextLength*2 {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteString("\t")
+			case "br", "cr":
+				sb.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// one row per line, so the model can read it as a table
+func extractCsv(content []byte) string {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	var sb strings.Builder
+	for sb.Len() < MaxTextLength*2 {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// not really csv. The raw text is still useful
+			return string(content)
+		}
+		sb.WriteString(strings.Join(record, " | "))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// cuts to at most max bytes, without splitting a character
+func Truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// the attachments' text, for a prompt. At most max bytes, shared between the attachments
+func ForPrompt(texts []data.AttachmentText, max int) string {
+	if len(texts) == 0 || max <= 0 {
+		return ""
+	}
+	per := max / len(texts)
+	var sb strings.Builder
+	for _, t := range texts {
+		if t.Text == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "--- Attachment: %s ---\n%s\n", t.Filename, Truncate(t.Text, per))
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,122 @@
+package backfill
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type StartBackfillRequest struct {
+	// messages queued per minute. Defaults to 60, max 1000
+	RatePerMinute int `json:"ratePerMinute"`
+} // @name StartBackfillRequest
+
+// StartBackfill godoc
+// @Summary      Start an AI backfill
+// @Description  Re-processes this account's messages that were enriched with an older prompt, model or taxonomy.
+// @Tags         ai
+// @Accept       json
+// @Param        request body StartBackfillRequest false "Options"
+// @Produce      json
+// @Success      200  {object}  Job
+// @Failure      409  "A backfill is already running"
+// @Router       /ai/backfill [post]
+func StartBackfill(r *gin.Context) {
+	var req StartBackfillRequest
+	if r.Request.ContentLength > 0 {
+		if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+			r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	job, err := CreateJob(r, r.GetString("accountId"), req.RatePerMinute)
+	if errors.Is(err, ErrJobRunning) {
+		r.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to start backfill")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start backfill"})
+		return
+	}
+	r.JSON(http.StatusOK, job)
+}
+
+// ListBackfills godoc
+// @Summary      List AI backfills
+// @Tags         ai
+// @Param        limit query int false "Max jobs to return"
+// @Produce      json
+// @Success      200  {array}  Job
+// @Router       /ai/backfill [get]
+func ListBackfills(r *gin.Context) {
+	limit, _ := strconv.Atoi(r.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	jobs, err := ListJobs(r, r.GetString("accountId"), limit)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to list backfills")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list backfills"})
+		return
+	}
+	r.JSON(http.StatusOK, jobs)
+}
+
+// GetBackfill godoc
+// @Summary      Get an AI backfill
+// @Description  Progress of the backfill, including how many stale messages remain.
+// @Tags         ai
+// @Param        jobId path string true "jobId"
+// @Produce      json
+// @Success      200  {object}  Job
+// @Router       /ai/backfill/{jobId} [get]
+func GetBackfill(r *gin.Context) {
+	job, err := GetJob(r, r.GetString("accountId"), r.Param("jobId"))
+	if errors.Is(err, ErrJobNotFound) {
+		r.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to get backfill")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get backfill"})
+		return
+	}
+	r.JSON(http.StatusOK, job)
+}
+
+// CancelBackfill godoc
+// @Summary      Cancel an AI backfill
+// @Description  Stops queuing messages. Messages already queued are still processed.
+// @Tags         ai
+// @Param        jobId path string true "jobId"
+// @Success      200
+// @Router       /ai/backfill/{jobId}/cancel [post]
+func CancelBackfill(r *gin.Context) {
+	err := CancelJob(r, r.GetString("accountId"), r.Param("jobId"))
+	if errors.Is(err, ErrJobNotFound) {
+		r.JSON(http.StatusNotFound, gin.H{"error": "No running backfill with that id"})
+		return
+	}
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to cancel backfill")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel backfill"})
+		return
+	}
+	r.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,308 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/enrichment"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/taxonomy"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+
+	DefaultRatePerMinute = 60
+	MaxRatePerMinute     = 1000
+)
+
+var (
+	ErrJobRunning  = errors.New("a backfill is already running for this account")
+	ErrJobNotFound = errors.New("backfill job not found")
+)
+
+// a re-processing of an account's messages that were enriched with an older version
+type Job struct {
+	JobId     string `validate:"required" json:"jobId"`
+	AccountId string `json:"-"`
+	// running, completed or cancelled
+	Status        string         `validate:"required" json:"status"`
+	Version       data.AiVersion `json:"-"`
+	RatePerMinute int            `validate:"required" json:"ratePerMinute"`
+	// stale messages when the job started
+	Total int64 `validate:"required" json:"total"`
+	// messages sent to the gemini service so far
+	Queued int64 `validate:"required" json:"queued"`
+	// stale messages still left. Only filled in by GetJob
+	Remaining     int64  `json:"remaining"`
+	LastMessageId string `json:"-"`
+	// the last error. The job keeps retrying
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `validate:"required" json:"createdAt"`
+	UpdatedAt time.Time `validate:"required" json:"updatedAt"`
+	LastRunAt time.Time `json:"-"`
+} // @name AiBackfillJob
+
+const jobColumns = `
+	jobId, accountId, status,
+	promptHash, model, taxonomyVersion,
+	ratePerMinute, total, queued,
+	lastMessageId, error,
+	createdAt, updatedAt, lastRunAt`
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.JobId,
+		&job.AccountId,
+		&job.Status,
+		&job.Version.PromptHash,
+		&job.Version.Model,
+		&job.Version.TaxonomyVersion,
+		&job.RatePerMinute,
+		&job.Total,
+		&job.Queued,
+		&job.LastMessageId,
+		&job.Error,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.LastRunAt,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// starts a backfill that brings the account's messages up to the current version
+func CreateJob(ctx context.Context, accountId string, ratePerMinute int) (*Job, error) {
+	if ratePerMinute <= 0 {
+		ratePerMinute = DefaultRatePerMinute
+	}
+	if ratePerMinute > MaxRatePerMinute {
+		ratePerMinute = MaxRatePerMinute
+	}
+	accountTaxonomy, err := taxonomy.Get(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	version := enrichment.CurrentVersion(globals.LLM(), accountTaxonomy.Version)
+	total, err := globals.DocDb().Collection("Messages").CountDocuments(ctx, enrichment.StaleFilter(accountId, version))
+	if err != nil {
+		return nil, err
+	}
+	status := StatusRunning
+	if total == 0 {
+		status = StatusCompleted // nothing to do
+	}
+	now := time.Now().UTC()
+	row := globals.Db().QueryRow(ctx, `
+		INSERT INTO AiBackfillJobs (
+			jobId, accountId, status,
+			promptHash, model, taxonomyVersion,
+			ratePerMinute, total,
+			createdAt, updatedAt
+		) VALUES (
+			$1, $2, $3,
+			$4, $5, $6,
+			$7, $8,
+			$9, $9
+		) RETURNING `+jobColumns,
+		"backfill_"+uuid.New().String(),
+		accountId,
+		status,
+		version.PromptHash,
+		version.Model,
+		version.TaxonomyVersion,
+		ratePerMinute,
+		total,
+		now,
+	)
+	job, err := scanJob(row)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrJobRunning
+	}
+	return job, err
+}
+
+// gets the job, with how many stale messages are left
+func GetJob(ctx context.Context, accountId, jobId string) (*Job, error) {
+	job, err := scanJob(globals.Db().QueryRow(ctx, `
+		SELECT `+jobColumns+`
+		FROM AiBackfillJobs
+		WHERE accountId = $1
+		AND jobId = $2
+		`, accountId, jobId))
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := globals.DocDb().Collection("Messages").CountDocuments(ctx, enrichment.StaleFilter(accountId, job.Version))
+	if err != nil {
+		return nil, err
+	}
+	job.Remaining = remaining
+	return job, nil
+}
+
+// most recent first
+func ListJobs(ctx context.Context, accountId string, limit int) ([]Job, error) {
+	rows, err := globals.Db().Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM AiBackfillJobs
+		WHERE accountId = $1
+		ORDER BY createdAt DESC
+		LIMIT $2
+		`, accountId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := make([]Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+func CancelJob(ctx context.Context, accountId, jobId string) error {
+	tag, err := globals.Db().Exec(ctx, `
+		UPDATE AiBackfillJobs
+		SET status = $3, updatedAt = $4
+		WHERE accountId = $1
+		AND jobId = $2
+		AND status = $5
+		`, accountId, jobId, StatusCancelled, time.Now().UTC(), StatusRunning)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// runs due jobs. Queues up to ratePerMinute messages, per minute, for each job.
+// Safe to run from multiple instances, as jobs are locked while they run.
+func RunDue(ctx context.Context, available *kafka.Writer, interval time.Duration) error {
+	tx, err := globals.Db().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM AiBackfillJobs
+		WHERE status = $1
+		AND lastRunAt <= $2
+		FOR UPDATE SKIP LOCKED
+		`, StatusRunning, time.Now().UTC().Add(-interval+interval/10)) // allow for ticker jitter
+	if err != nil {
+		return err
+	}
+	jobs := make([]Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, *job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		batchSize := int64(job.RatePerMinute) * int64(interval) / int64(time.Minute)
+		if batchSize < 1 {
+			batchSize = 1
+		}
+		queued, lastId, stepErr := queueBatch(ctx, job, batchSize, available)
+		status := job.Status
+		errMsg := ""
+		if stepErr != nil {
+			// try again next run
+			errMsg = stepErr.Error()
+		} else if int64(queued) < batchSize {
+			status = StatusCompleted
+		}
+		if lastId == "" {
+			lastId = job.LastMessageId
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE AiBackfillJobs
+			SET status = $2,
+				queued = queued + $3,
+				lastMessageId = $4,
+				error = $5,
+				updatedAt = $6,
+				lastRunAt = $6
+			WHERE jobId = $1
+			`, job.JobId, status, queued, lastId, errMsg, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// queues the next stale messages after the job's last message
+func queueBatch(ctx context.Context, job Job, batchSize int64, available *kafka.Writer) (int, string, error) {
+	filter := enrichment.StaleFilter(job.AccountId, job.Version)
+	if job.LastMessageId != "" {
+		filter["_id"] = bson.M{"$gt": job.LastMessageId}
+	}
+	opts := options.Find().SetSort(bson.D{{"_id", 1}}).SetLimit(batchSize)
+	cursor, err := globals.DocDb().Collection("Messages").Find(ctx, filter, opts)
+	if err != nil {
+		return 0, "", err
+	}
+	defer cursor.Close(ctx)
+	var entries []data.GmailEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return 0, "", err
+	}
+	if len(entries) == 0 {
+		return 0, "", nil
+	}
+	msgs := make([]kafka.Message, 0, len(entries))
+	for _, entry := range entries {
+		entryBytes, _ := json.Marshal(data.EmailInjestedPayload{
+			MessageId:     entry.MessageId,
+			AccountId:     entry.AccountId,
+			Entry:         entry,
+			BackfillJobId: job.JobId,
+		})
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(entry.ToDocumentId()),
+			Value: entryBytes,
+		})
+	}
+	if err := available.WriteMessages(ctx, msgs...); err != nil {
+		return 0, "", err
+	}
+	return len(entries), entries[len(entries)-1].ToDocumentId(), nil
+}
@@ -0,0 +1,90 @@
+package deadletter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// most entries replayed in one call
+const maxReplay = 500
+
+type ListDeadLettersResponse struct {
+	Entries []Entry `validate:"required" json:"entries"`
+} // @name ListDeadLettersResponse
+
+type ReplayDeadLettersRequest struct {
+	Ids []string `validate:"required" json:"ids"`
+} // @name ReplayDeadLettersRequest
+
+type ReplayDeadLettersResponse struct {
+	// the ids sent back to their source topic. Parked and already replayed entries are skipped
+	Replayed []string `validate:"required" json:"replayed"`
+} // @name ReplayDeadLettersResponse
+
+// ListDeadLetters godoc
+// @Summary      List dead lettered messages
+// @Description  Messages the services failed to process, newest first. Admins only.
+// @Tags         admin
+// @Param        topic query string false "The DLQ. eg. gemini_dlq or gemini_dlq_parked"
+// @Param        accountId query string false "Only this account's messages"
+// @Param        error query string false "Only messages whose error contains this"
+// @Param        state query string false "dead or replayed"
+// @Param        limit query int false "Max entries to return"
+// @Produce      json
+// @Success      200  {object}  ListDeadLettersResponse
+// @Router       /admin/deadLetters [get]
+func ListDeadLetters(r *gin.Context) {
+	limit, _ := strconv.ParseInt(r.Query("limit"), 10, 64)
+	entries, err := List(r, Filter{
+		Topic:     r.Query("topic"),
+		AccountId: r.Query("accountId"),
+		Error:     r.Query("error"),
+		State:     r.Query("state"),
+		Limit:     limit,
+	})
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to list dead letters")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+	r.JSON(http.StatusOK, ListDeadLettersResponse{Entries: entries})
+}
+
+// ReplayDeadLetters godoc
+// @Summary      Replay dead lettered messages
+// @Description  Sends the messages back to the topic they failed on. Each keeps its attempt count, so one that keeps failing is eventually parked. Admins only.
+// @Tags         admin
+// @Accept       json
+// @Param        request body ReplayDeadLettersRequest true "The entries to replay"
+// @Produce      json
+// @Success      200  {object}  ReplayDeadLettersResponse
+// @Router       /admin/deadLetters/replay [post]
+func ReplayDeadLetters(r *gin.Context) {
+	var req ReplayDeadLettersRequest
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Ids) == 0 || len(req.Ids) > maxReplay {
+		r.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("between 1 and %d ids are required", maxReplay)})
+		return
+	}
+	replayed, err := Replay(r, req.Ids)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Strs("replayed", replayed).
+			Msg("failed to replay dead letters")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay dead letters"})
+		return
+	}
+	r.JSON(http.StatusOK, ReplayDeadLettersResponse{Replayed: replayed})
+}
@@ -0,0 +1,51 @@
+package deadletter
+
+import (
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// set on each dead lettered message. They're kept when it's replayed, so the attempts carry over
+const (
+	HeaderError       = "dlq-error"
+	HeaderAttempts    = "dlq-attempts"
+	HeaderSourceTopic = "dlq-source-topic"
+	HeaderFailedAt    = "dlq-failed-at"
+)
+
+// the message, with why it failed. Workers call this on messages they send to the DLQ
+func WithError(msg kafka.Message, err error) kafka.Message {
+	reason := "unknown"
+	if err != nil {
+		reason = err.Error()
+	}
+	msg.Headers = setHeader(msg.Headers, HeaderError, reason)
+	return msg
+}
+
+// times the message has been dead lettered. 0 if it never has
+func Attempts(msg kafka.Message) int {
+	attempts, _ := strconv.Atoi(header(msg.Headers, HeaderAttempts))
+	return attempts
+}
+
+func header(headers []kafka.Header, key string) string {
+	for _, h := range headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// replaces the header if it's already there. Copies, so the caller's message isn't changed
+func setHeader(headers []kafka.Header, key string, value string) []kafka.Header {
+	res := make([]kafka.Header, 0, len(headers)+1)
+	for _, h := range headers {
+		if h.Key != key {
+			res = append(res, h)
+		}
+	}
+	return append(res, kafka.Header{Key: key, Value: []byte(value)})
+}
@@ -0,0 +1,191 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"fromkeith/my-desktop-server/globals"
+	"regexp"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	StateDead     = "dead"
+	StateReplayed = "replayed"
+)
+
+// a message read from a DLQ, or its parked topic
+type Entry struct {
+	// topic:partition:offset
+	Id string `validate:"required" json:"id" bson:"_id"`
+	// the DLQ, or its parked topic
+	Topic string `validate:"required" json:"topic" bson:"topic"`
+	// where a replay sends it
+	SourceTopic string `validate:"required" json:"sourceTopic" bson:"sourceTopic"`
+	Key         string `json:"key" bson:"key"`
+	// from the payload, if it has one
+	AccountId string `json:"accountId,omitempty" bson:"accountId,omitempty"`
+	Error     string `validate:"required" json:"error" bson:"error"`
+	// times it has been dead lettered
+	Attempts int `validate:"required" json:"attempts" bson:"attempts"`
+	// failed too many times. It can't be replayed
+	Parked bool `json:"parked" bson:"parked"`
+	// dead or replayed
+	State      string     `validate:"required" json:"state" bson:"state"`
+	FailedAt   time.Time  `validate:"required" json:"failedAt" bson:"failedAt"`
+	ReplayedAt *time.Time `json:"replayedAt,omitempty" bson:"replayedAt,omitempty"`
+	Payload    string     `validate:"required" json:"payload" bson:"payload"`
+	// sent back with it on replay, so the attempts carry over
+	Headers []kafka.Header `json:"-" bson:"headers"`
+} // @name DeadLetter
+
+type Filter struct {
+	Topic     string
+	AccountId string
+	// matched anywhere in the error, ignoring case
+	Error string
+	// dead or replayed. Everything if empty
+	State string
+	Limit int64
+}
+
+func collection() *mongo.Collection {
+	return globals.DocDb().Collection("DeadLetters")
+}
+
+// stores a message read from a DLQ. Reading it again doesn't undo a replay
+func Record(ctx context.Context, msg kafka.Message) error {
+	entry := Entry{
+		Id:          fmt.Sprintf("%s:%d:%d", msg.Topic, msg.Partition, msg.Offset),
+		Topic:       msg.Topic,
+		SourceTopic: header(msg.Headers, HeaderSourceTopic),
+		Key:         string(msg.Key),
+		Error:       header(msg.Headers, HeaderError),
+		Attempts:    Attempts(msg),
+		State:       StateDead,
+		FailedAt:    msg.Time.UTC(),
+		Payload:     string(msg.Value),
+		Headers:     msg.Headers,
+	}
+	for _, topic := range Topics {
+		entry.Parked = entry.Parked || msg.Topic == ParkedTopic(topic)
+	}
+	if failedAt, err := time.Parse(time.RFC3339, header(msg.Headers, HeaderFailedAt)); err == nil {
+		entry.FailedAt = failedAt
+	}
+	// both the email_injest and email_injest_available payloads have it
+	var payload struct {
+		AccountId string
+	}
+	if err := json.Unmarshal(msg.Value, &payload); err == nil {
+		entry.AccountId = payload.AccountId
+	}
+	_, err := collection().UpdateOne(
+		ctx,
+		bson.M{"_id": entry.Id},
+		bson.M{"$setOnInsert": entry},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// newest first
+func List(ctx context.Context, filter Filter) ([]Entry, error) {
+	query := bson.M{}
+	if filter.Topic != "" {
+		query["topic"] = filter.Topic
+	}
+	if filter.AccountId != "" {
+		query["accountId"] = filter.AccountId
+	}
+	if filter.Error != "" {
+		query["error"] = bson.M{"$regex": regexp.QuoteMeta(filter.Error), "$options": "i"}
+	}
+	if filter.State != "" {
+		query["state"] = filter.State
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	cursor, err := collection().Find(
+		ctx,
+		query,
+		options.Find().SetSort(bson.D{{"failedAt", -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	entries := make([]Entry, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// sends the entries back to their source topics. Parked and already replayed entries are skipped.
+// Returns the ids that were replayed
+func Replay(ctx context.Context, ids []string) ([]string, error) {
+	cursor, err := collection().Find(ctx, bson.M{
+		"_id":    bson.M{"$in": ids},
+		"state":  StateDead,
+		"parked": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var entries []Entry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	bySource := make(map[string][]Entry)
+	for _, entry := range entries {
+		bySource[entry.SourceTopic] = append(bySource[entry.SourceTopic], entry)
+	}
+	replayed := make([]string, 0, len(entries))
+	for source, entries := range bySource {
+		if source == "" {
+			// dead lettered before the headers were added
+			continue
+		}
+		msgs := make([]kafka.Message, 0, len(entries))
+		entryIds := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			msgs = append(msgs, kafka.Message{
+				Key:     []byte(entry.Key),
+				Value:   []byte(entry.Payload),
+				Headers: entry.Headers,
+			})
+			entryIds = append(entryIds, entry.Id)
+		}
+		if err := writeTo(ctx, source, msgs); err != nil {
+			return replayed, err
+		}
+		now := time.Now().UTC()
+		_, err := collection().UpdateMany(
+			ctx,
+			bson.M{"_id": bson.M{"$in": entryIds}},
+			bson.M{"$set": bson.M{"state": StateReplayed, "replayedAt": now}},
+		)
+		if err != nil {
+			return replayed, err
+		}
+		replayed = append(replayed, entryIds...)
+	}
+	return replayed, nil
+}
+
+func writeTo(ctx context.Context, topic string, msgs []kafka.Message) error {
+	w := globals.KafkaWriter(topic)
+	defer w.Close()
+	return w.WriteMessages(ctx, msgs...)
+}
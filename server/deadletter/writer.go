@@ -0,0 +1,92 @@
+package deadletter
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// the topics services dead letter to
+var Topics = []string{"email_inject_dlq", "gemini_dlq"}
+
+// dead lettered this many times, a message is parked for good rather than put back in the DLQ
+func MaxAttempts() int {
+	if attempts, err := strconv.Atoi(os.Getenv("DLQ_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		return attempts
+	}
+	return 3
+}
+
+// where messages from the topic are parked once they've failed too many times
+func ParkedTopic(topic string) string {
+	return topic + "_parked"
+}
+
+// writes failed messages to a DLQ, with the headers needed to list and replay them
+type Writer struct {
+	source      string
+	maxAttempts int
+	dead        *kafka.Writer
+	parked      *kafka.Writer
+}
+
+// for messages read from source. You are responsible for closing it
+func NewWriter(topic string, source string) *Writer {
+	return &Writer{
+		source:      source,
+		maxAttempts: MaxAttempts(),
+		dead:        globals.KafkaWriter(topic),
+		parked:      globals.KafkaWriter(ParkedTopic(topic)),
+	}
+}
+
+// dead letters the messages. Those that failed too many times are parked
+func (w *Writer) Write(ctx context.Context, msgs ...kafka.Message) error {
+	dead := make([]kafka.Message, 0, len(msgs))
+	parked := make([]kafka.Message, 0)
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, msg := range msgs {
+		attempts := Attempts(msg) + 1
+		headers := msg.Headers
+		if header(headers, HeaderError) == "" {
+			headers = setHeader(headers, HeaderError, "unknown")
+		}
+		headers = setHeader(headers, HeaderAttempts, strconv.Itoa(attempts))
+		headers = setHeader(headers, HeaderSourceTopic, w.source)
+		headers = setHeader(headers, HeaderFailedAt, now)
+		// overwrite topic and other metadata
+		out := kafka.Message{
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: headers,
+		}
+		if attempts >= w.maxAttempts {
+			parked = append(parked, out)
+		} else {
+			dead = append(dead, out)
+		}
+	}
+	if len(dead) > 0 {
+		if err := w.dead.WriteMessages(ctx, dead...); err != nil {
+			return err
+		}
+	}
+	if len(parked) > 0 {
+		if err := w.parked.WriteMessages(ctx, parked...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	deadErr := w.dead.Close()
+	if err := w.parked.Close(); err != nil {
+		return err
+	}
+	return deadErr
+}
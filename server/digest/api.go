@@ -0,0 +1,125 @@
+package digest
+
+import (
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/settings"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type ListDigestsResponse struct {
+	// most recent first
+	Digests []data.Digest `validate:"required" json:"digests"`
+} // @name ListDigestsResponse
+
+// ListDigests godoc
+// @Summary      List daily digests
+// @Description  The most recent digests, newest first.
+// @Tags         digest
+// @Param        limit query int false "Number of digests to return. Defaults to 7, max 60"
+// @Produce      json
+// @Success      200  {object}  ListDigestsResponse
+// @Router       /digests [get]
+func ListDigests(r *gin.Context) {
+	limit, _ := strconv.ParseInt(r.Query("limit"), 10, 64)
+	if limit <= 0 || limit > 60 {
+		limit = 7
+	}
+	cursor, err := globals.DocDb().Collection("Digests").Find(
+		r,
+		bson.M{"accountId": r.GetString("accountId")},
+		options.Find().SetSort(bson.D{{"digestId", -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(r)
+	var digests []data.Digest
+	if err := cursor.All(r, &digests); err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if digests == nil {
+		digests = make([]data.Digest, 0)
+	}
+	r.JSON(http.StatusOK, ListDigestsResponse{Digests: digests})
+}
+
+// GetDigest godoc
+// @Summary      Get a daily digest
+// @Tags         digest
+// @Param        digestId path string true "The YYYY-MM-DD it was delivered"
+// @Produce      json
+// @Success      200  {object}  data.Digest
+// @Failure      404  "Digest not found"
+// @Router       /digests/{digestId} [get]
+func GetDigest(r *gin.Context) {
+	digest, err := Get(r, r.GetString("accountId"), r.Param("digestId"))
+	if errors.Is(err, ErrNotFound) {
+		r.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to get digest")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get digest"})
+		return
+	}
+	r.JSON(http.StatusOK, digest)
+}
+
+// GenerateDigest godoc
+// @Summary      Generate today's digest now
+// @Description  Builds the digest of the last 24h, without waiting for the delivery time. Replaces today's digest if there is one.
+// @Tags         digest
+// @Param        send query bool false "Also email it"
+// @Produce      json
+// @Success      200  {object}  data.Digest
+// @Router       /digests/generate [post]
+func GenerateDigest(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	fail := func(err error) {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to generate digest")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate digest"})
+	}
+	accountSettings, err := settings.Get(r, accountId)
+	if err != nil {
+		fail(err)
+		return
+	}
+	now := time.Now()
+	loc, err := time.LoadLocation(accountSettings.Digest.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	digest, err := Generate(r, accountId, now.In(loc).Format(time.DateOnly), accountSettings.Digest, now)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := Save(r, digest); err != nil {
+		fail(err)
+		return
+	}
+	if send, _ := strconv.ParseBool(r.Query("send")); send {
+		if err := Send(r, digest); err != nil {
+			fail(err)
+			return
+		}
+	}
+	r.JSON(http.StatusOK, digest)
+}
@@ -0,0 +1,275 @@
+package digest
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/client"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/taxonomy"
+	"html/template"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// messages scored at least this are listed as important
+	importantThreshold = 0.6
+	// a digest covers at most this many messages
+	maxMessages = 500
+	// for messages that don't match the account's categories
+	otherCategory = "Other"
+)
+
+var ErrNotFound = errors.New("digest not found")
+
+// the digest id, if the account's delivery time has passed today
+func Due(settings data.DigestSettings, now time.Time) (string, bool) {
+	loc, err := time.LoadLocation(settings.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	deliverAt, err := time.Parse("15:04", settings.DeliveryTime)
+	if err != nil {
+		return "", false
+	}
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), deliverAt.Hour(), deliverAt.Minute(), 0, 0, loc)
+	if local.Before(today) {
+		return "", false
+	}
+	return local.Format(time.DateOnly), true
+}
+
+// builds the digest of the messages received in the 24h before now
+func Generate(ctx context.Context, accountId string, digestId string, settings data.DigestSettings, now time.Time) (*data.Digest, error) {
+	to := now.UTC()
+	from := to.Add(-24 * time.Hour)
+
+	cursor, err := globals.DocDb().Collection("Messages").Find(
+		ctx,
+		bson.M{
+			"accountId":    accountId,
+			"isDeleted":    bson.M{"$ne": true},
+			"internalDate": bson.M{"$gte": from.UnixMilli(), "$lt": to.UnixMilli()},
+			// only what was received. This also leaves out the digests we sent
+			"labels": bson.M{"$nin": []string{"SENT", "DRAFT", "SPAM", "TRASH"}},
+		},
+		options.Find().
+			SetSort(bson.D{{"internalDate", -1}}).
+			SetLimit(maxMessages).
+			SetProjection(bson.M{
+				"messageId":    1,
+				"threadId":     1,
+				"subject":      1,
+				"snippet":      1,
+				"sender":       1,
+				"summary":      1,
+				"categories":   1,
+				"importance":   1,
+				"internalDate": 1,
+			}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var messages []data.GmailEntry
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	accountTaxonomy, err := taxonomy.Get(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	digest := &data.Digest{
+		AccountId: accountId,
+		DigestId:  digestId,
+		From:      from,
+		To:        to,
+		Groups:    group(messages, accountTaxonomy.Categories, settings.Categories),
+	}
+	for _, g := range digest.Groups {
+		digest.MessageCount += int64(len(g.Important) + len(g.Other))
+	}
+	return digest, nil
+}
+
+// groups the messages by their L1 category, and splits out the important ones.
+// Only the included categories are kept, unless it's empty
+func group(messages []data.GmailEntry, categories []data.TaxonomyCategory, included []string) []data.DigestGroup {
+	// the L1 name, for each L1 and L2 name
+	l1 := make(map[string]string)
+	for _, c := range categories {
+		l1[strings.ToLower(c.Name)] = c.Name
+		for _, sub := range c.Subcategories {
+			l1[strings.ToLower(sub)] = c.Name
+		}
+	}
+	groups := make([]data.DigestGroup, 0)
+	for _, msg := range messages {
+		category := otherCategory
+		for _, c := range msg.Categories {
+			if name, ok := l1[strings.ToLower(c)]; ok {
+				category = name
+				break
+			}
+		}
+		if len(included) > 0 && !slices.Contains(included, strings.ToLower(category)) {
+			continue
+		}
+		i := slices.IndexFunc(groups, func(g data.DigestGroup) bool { return g.Category == category })
+		if i < 0 {
+			groups = append(groups, data.DigestGroup{
+				Category:  category,
+				Important: make([]data.DigestMessage, 0),
+				Other:     make([]data.DigestMessage, 0),
+			})
+			i = len(groups) - 1
+		}
+		summary := msg.Summary
+		if summary == "" {
+			summary = msg.Snippet
+		}
+		item := data.DigestMessage{
+			MessageId:    msg.MessageId,
+			ThreadId:     msg.ThreadId,
+			Subject:      msg.Subject,
+			Sender:       msg.Sender,
+			Summary:      summary,
+			InternalDate: msg.InternalDate,
+		}
+		if msg.Importance != nil {
+			item.Importance = *msg.Importance
+		}
+		if item.Importance >= importantThreshold {
+			groups[i].Important = append(groups[i].Important, item)
+		} else {
+			groups[i].Other = append(groups[i].Other, item)
+		}
+	}
+	byImportance := func(a, b data.DigestMessage) int {
+		if a.Importance != b.Importance {
+			return cmp.Compare(b.Importance, a.Importance)
+		}
+		return cmp.Compare(b.InternalDate, a.InternalDate)
+	}
+	for _, g := range groups {
+		slices.SortFunc(g.Important, byImportance)
+		slices.SortFunc(g.Other, byImportance)
+	}
+	// categories with important mail first, then the busiest
+	slices.SortFunc(groups, func(a, b data.DigestGroup) int {
+		if len(a.Important) != len(b.Important) {
+			return len(b.Important) - len(a.Important)
+		}
+		if len(a.Other) != len(b.Other) {
+			return len(b.Other) - len(a.Other)
+		}
+		return strings.Compare(a.Category, b.Category)
+	})
+	return groups
+}
+
+// saves the digest, replacing any with the same id
+func Save(ctx context.Context, digest *data.Digest) error {
+	res := globals.DocDb().Collection("Digests").FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": digest.ToDocumentId()},
+		bson.M{
+			"$set": bson.M{
+				"accountId":    digest.AccountId,
+				"digestId":     digest.DigestId,
+				"from":         digest.From,
+				"to":           digest.To,
+				"messageCount": digest.MessageCount,
+				"groups":       digest.Groups,
+			},
+			"$currentDate": bson.M{"updatedAt": true},
+			"$setOnInsert": bson.M{"createdAt": time.Now().UTC()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	)
+	return res.Decode(digest)
+}
+
+func Get(ctx context.Context, accountId string, digestId string) (*data.Digest, error) {
+	var digest data.Digest
+	err := globals.DocDb().Collection("Digests").FindOne(ctx, bson.M{"_id": data.ToDocumentId(accountId, digestId)}).Decode(&digest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &digest, nil
+}
+
+var emailTemplate = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"sender": func(p data.PersonInfo) string {
+		if p.Name != "" {
+			return p.Name
+		}
+		return p.Email
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; max-width: 640px;">
+<h2>Your daily digest</h2>
+<p>{{.MessageCount}} messages since {{.From.Format "Jan 2, 15:04 MST"}}</p>
+{{range .Groups}}
+<h3>{{.Category}}</h3>
+{{if .Important}}<p><strong>Important</strong></p>
+<ul>{{range .Important}}
+<li><strong>{{.Subject}}</strong> &mdash; {{sender .Sender}}<br>{{.Summary}}</li>{{end}}
+</ul>{{end}}
+{{if .Other}}<ul>{{range .Other}}
+<li>{{.Subject}} &mdash; {{sender .Sender}}<br><span style="color: #555;">{{.Summary}}</span></li>{{end}}
+</ul>{{end}}
+{{else}}
+<p>Nothing new.</p>
+{{end}}
+</body>
+</html>`))
+
+func RenderHtml(digest *data.Digest) (string, error) {
+	var buf bytes.Buffer
+	if err := emailTemplate.Execute(&buf, digest); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// emails the digest to the account, and marks it as sent
+func Send(ctx context.Context, digest *data.Digest) error {
+	body, err := RenderHtml(digest)
+	if err != nil {
+		return err
+	}
+	gmailClient, err := client.GmailClient(ctx, digest.AccountId)
+	if err != nil {
+		return err
+	}
+	if _, err := gmailClient.SendHtmlToSelf(ctx, "Daily digest for "+digest.DigestId, body); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	digest.SentAt = &now
+	_, err = globals.DocDb().Collection("Digests").UpdateOne(
+		ctx,
+		bson.M{"_id": digest.ToDocumentId()},
+		bson.M{
+			"$set":         bson.M{"sentAt": now},
+			"$currentDate": bson.M{"updatedAt": true},
+		},
+	)
+	return err
+}
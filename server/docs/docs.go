@@ -16,119 +16,172 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/gmail/inbox": {
+        "/admin/deadLetters": {
             "get": {
-                "description": "List the user's email inbox",
+                "description": "Messages the services failed to process, newest first. Admins only.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "admin"
+                ],
+                "summary": "List dead lettered messages",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The DLQ. eg. gemini_dlq or gemini_dlq_parked",
+                        "name": "topic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this account's messages",
+                        "name": "accountId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages whose error contains this",
+                        "name": "error",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "dead or replayed",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Max entries to return",
+                        "name": "limit",
+                        "in": "query"
+                    }
                 ],
-                "summary": "List the inbox",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/GmailEntry"
-                            }
+                            "$ref": "#/definitions/ListDeadLettersResponse"
                         }
                     }
                 }
             }
         },
-        "/gmail/message/:messageId": {
-            "get": {
+        "/admin/deadLetters/replay": {
+            "post": {
+                "description": "Sends the messages back to the topic they failed on. Each keeps its attempt count, so one that keeps failing is eventually parked. Admins only.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "admin"
+                ],
+                "summary": "Replay dead lettered messages",
+                "parameters": [
+                    {
+                        "description": "The entries to replay",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/ReplayDeadLettersRequest"
+                        }
+                    }
                 ],
-                "summary": "Get the basic information about a message",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/GmailEntry"
+                            "$ref": "#/definitions/ReplayDeadLettersResponse"
                         }
                     }
                 }
             }
         },
-        "/gmail/message/:messageId/contents": {
+        "/ai/backfill": {
             "get": {
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "ai"
+                ],
+                "summary": "List AI backfills",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Max jobs to return",
+                        "name": "limit",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get the contents of a message",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/GmailEntryBody"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/AiBackfillJob"
+                            }
                         }
                     }
                 }
-            }
-        },
-        "/gmail/thread/{threadId}": {
-            "get": {
+            },
+            "post": {
+                "description": "Re-processes this account's messages that were enriched with an older prompt, model or taxonomy.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "ai"
+                ],
+                "summary": "Start an AI backfill",
+                "parameters": [
+                    {
+                        "description": "Options",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/StartBackfillRequest"
+                        }
+                    }
                 ],
-                "summary": "List all messages in a thread",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/GmailEntry"
-                            }
+                            "$ref": "#/definitions/AiBackfillJob"
                         }
+                    },
+                    "409": {
+                        "description": "A backfill is already running"
                     }
                 }
             }
         },
-        "/messages/aggregate/pullCategories": {
+        "/ai/backfill/{jobId}": {
             "get": {
-                "description": "Sync endpoint to pull all changes to categories for this account.",
+                "description": "Progress of the backfill, including how many stale messages remain.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "ai"
                 ],
-                "summary": "Get summary of the categories in this account",
+                "summary": "Get an AI backfill",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "category",
-                        "name": "category",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Last updated time",
-                        "name": "updatedAt",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Batch size",
-                        "name": "limit",
-                        "in": "query",
+                        "description": "jobId",
+                        "name": "jobId",
+                        "in": "path",
                         "required": true
                     }
                 ],
@@ -136,195 +189,195 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/PullCategoriesResponse"
+                            "$ref": "#/definitions/AiBackfillJob"
                         }
                     }
                 }
             }
         },
-        "/messages/aggregate/pullTags": {
-            "get": {
-                "description": "Sync endpoint to pull all changes to tags for this account.",
-                "produces": [
-                    "application/json"
-                ],
+        "/ai/backfill/{jobId}/cancel": {
+            "post": {
+                "description": "Stops queuing messages. Messages already queued are still processed.",
                 "tags": [
-                    "email"
+                    "ai"
                 ],
-                "summary": "Get summary of the tags in this account",
+                "summary": "Cancel an AI backfill",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Tag",
-                        "name": "tag",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Last updated time",
-                        "name": "updatedAt",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Batch size",
-                        "name": "limit",
-                        "in": "query",
+                        "description": "jobId",
+                        "name": "jobId",
+                        "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/PullTagsResponse"
-                        }
+                        "description": "OK"
                     }
                 }
             }
         },
-        "/messages/pull": {
-            "get": {
-                "description": "Sync endpoint to pull all changes to messages for this account.",
+        "/ai/budget": {
+            "put": {
+                "description": "Token limits per UTC day and month. 0 is unlimited. Messages over budget are deferred, not dropped. When the server sets a limit, it can only be lowered.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "ai"
                 ],
-                "summary": "Get Messages",
+                "summary": "Set the AI budget",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "messageid",
-                        "name": "messageId",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Last updated time",
-                        "name": "updatedAt",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Batch size",
-                        "name": "limit",
-                        "in": "query",
-                        "required": true
+                        "description": "Budget",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/AiBudget"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/PullMessagesResponse"
+                            "$ref": "#/definitions/AiBudget"
                         }
                     }
                 }
             }
         },
-        "/messages/pullStream": {
+        "/ai/usage": {
             "get": {
-                "description": "Sync endpoint to allow for for push from server to client of changes to messages.",
+                "description": "Tokens used by this account, against its budget.",
                 "produces": [
-                    "text/event-stream"
+                    "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "ai"
                 ],
-                "summary": "Stream Messages",
-                "responses": {}
+                "summary": "AI usage",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Days of daily usage to return. Defaults to 30, max 90",
+                        "name": "days",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AiUsageReport"
+                        }
+                    }
+                }
             }
         },
-        "/messages/push": {
+        "/assistant/ask": {
             "post": {
-                "description": "Sync endpoint to push client changes to messages for this account.",
+                "description": "Answers a question using only the account's emails, citing the messages behind each claim. Streams \"sources\" with the messages found, then a \"claim\" per claim, then \"answer\" with the whole answer. Failures are sent as an \"error\" event.",
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
-                    "application/json"
+                    "text/event-stream"
                 ],
                 "tags": [
-                    "email"
+                    "ai"
                 ],
-                "summary": "Update Messages",
+                "summary": "Ask your mailbox",
                 "parameters": [
                     {
-                        "description": "Push Message Request",
+                        "description": "Question",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/PushMessageRequest"
+                            "$ref": "#/definitions/AskRequest"
                         }
                     }
                 ],
+                "responses": {}
+            }
+        },
+        "/digests": {
+            "get": {
+                "description": "The most recent digests, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "digest"
+                ],
+                "summary": "List daily digests",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of digests to return. Defaults to 7, max 60",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/PushMessageResponse"
+                            "$ref": "#/definitions/ListDigestsResponse"
                         }
                     }
                 }
             }
         },
-        "/people/pull": {
-            "get": {
-                "description": "Pulls the people database to be local",
+        "/digests/generate": {
+            "post": {
+                "description": "Builds the digest of the last 24h, without waiting for the delivery time. Replaces today's digest if there is one.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "people"
+                    "digest"
+                ],
+                "summary": "Generate today's digest now",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Also email it",
+                        "name": "send",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Pull the people database",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/PullPeopleResponse"
+                            "$ref": "#/definitions/Digest"
                         }
                     }
                 }
             }
         },
-        "/threads/pull": {
+        "/digests/{digestId}": {
             "get": {
-                "description": "Sync endpoint to pull all changes to threads for this account.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "email"
+                    "digest"
                 ],
-                "summary": "Get Threads",
+                "summary": "Get a daily digest",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "threadId",
-                        "name": "threadId",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Last updated time",
-                        "name": "updatedAt",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "integer",
-                        "description": "Batch size",
-                        "name": "limit",
-                        "in": "query",
+                        "description": "The YYYY-MM-DD it was delivered",
+                        "name": "digestId",
+                        "in": "path",
                         "required": true
                     }
                 ],
@@ -332,441 +385,2883 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/PullThreadResponse"
+                            "$ref": "#/definitions/Digest"
                         }
+                    },
+                    "404": {
+                        "description": "Digest not found"
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "CategoryInfo": {
-            "type": "object",
-            "properties": {
-                "category": {
-                    "type": "string"
-                },
-                "messageCount": {
-                    "type": "integer"
-                },
-                "updatedAt": {
-                    "type": "string"
-                }
+        },
+        "/entities": {
+            "get": {
+                "description": "Finds orders, shipments, flights and bills extracted from this account's emails. Sorted by date, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "entities"
+                ],
+                "summary": "Search extracted entities",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "order, shipment, flight or bill",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Exact order number, tracking number, PNR or biller",
+                        "name": "key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Case insensitive match on the key, merchant, carrier or biller",
+                        "name": "text",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only entities from this message",
+                        "name": "messageId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Dated on or after. YYYY-MM-DD",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Dated on or before. YYYY-MM-DD",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of entities to return",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/QueryEntitiesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/entities/pull": {
+            "get": {
+                "description": "Sync endpoint to pull all changes to the orders, shipments, flights and bills extracted from this account's emails.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "entities"
+                ],
+                "summary": "Get Extracted Entities",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "entityId",
+                        "name": "entityId",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last updated time",
+                        "name": "updatedAt",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Batch size",
+                        "name": "limit",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullEntitiesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/entities/pullStream": {
+            "get": {
+                "description": "Sync endpoint to allow for for push from server to client of changes to the extracted entities.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "entities"
+                ],
+                "summary": "Stream Extracted Entities",
+                "responses": {}
+            }
+        },
+        "/gmail/inbox": {
+            "get": {
+                "description": "List the user's email inbox",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "List the inbox",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/GmailEntry"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/gmail/message/:messageId": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Get the basic information about a message",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/GmailEntry"
+                        }
+                    }
+                }
+            }
+        },
+        "/gmail/message/:messageId/contents": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Get the contents of a message",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/GmailEntryBody"
+                        }
+                    }
+                }
+            }
+        },
+        "/gmail/thread/{threadId}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "List all messages in a thread",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/GmailEntry"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/aggregate/pullCategories": {
+            "get": {
+                "description": "Sync endpoint to pull all changes to categories for this account.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Get summary of the categories in this account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "category",
+                        "name": "category",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last updated time",
+                        "name": "updatedAt",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Batch size",
+                        "name": "limit",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullCategoriesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/aggregate/pullTags": {
+            "get": {
+                "description": "Sync endpoint to pull all changes to tags for this account.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Get summary of the tags in this account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag",
+                        "name": "tag",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last updated time",
+                        "name": "updatedAt",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Batch size",
+                        "name": "limit",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullTagsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/priority": {
+            "get": {
+                "description": "Inbox messages sorted by importance, most important first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Priority inbox",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of messages to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only unread messages",
+                        "name": "unreadOnly",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PriorityMessagesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/pull": {
+            "get": {
+                "description": "Sync endpoint to pull all changes to messages for this account.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Get Messages",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "messageid",
+                        "name": "messageId",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last updated time",
+                        "name": "updatedAt",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Batch size",
+                        "name": "limit",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullMessagesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/pullStream": {
+            "get": {
+                "description": "Sync endpoint to allow for for push from server to client of changes to messages. Also sends a \"warning\" event, with a PhishingWarning, when a message is found to be risky.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Stream Messages",
+                "responses": {}
+            }
+        },
+        "/messages/push": {
+            "post": {
+                "description": "Sync endpoint to push client changes to messages for this account.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Update Messages",
+                "parameters": [
+                    {
+                        "description": "Push Message Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/PushMessageRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PushMessageResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/{messageId}/feedback": {
+            "post": {
+                "description": "Tells us what the user did with a message, so future importance scores learn from it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Importance feedback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "messageId",
+                        "name": "messageId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Feedback",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/ImportanceFeedbackRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/messages/{messageId}/similar": {
+            "get": {
+                "description": "Finds the messages most similar to the given message, using its summary embedding.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "More like this",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "messageId",
+                        "name": "messageId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of messages to return",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages received after this time (RFC3339)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages received before this time (RFC3339)",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only messages from the same sender",
+                        "name": "sameSender",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/SimilarMessagesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/{messageId}/translate": {
+            "post": {
+                "description": "Translates the message's body, without quoted replies, as markdown. Sensitive values are masked first, per the account's redaction level. Translations are cached per message and language.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Translate a message",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "messageId",
+                        "name": "messageId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Language to translate to. Defaults to the account's language",
+                        "name": "language",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/TranslateMessageResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Message not found"
+                    },
+                    "429": {
+                        "description": "AI budget exceeded"
+                    }
+                }
+            }
+        },
+        "/people/pull": {
+            "get": {
+                "description": "Pulls the people database to be local",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "people"
+                ],
+                "summary": "Pull the people database",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullPeopleResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/savedSearches": {
+            "post": {
+                "description": "Saves a named search for this account. Its counts are kept up to date as messages change.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "search"
+                ],
+                "summary": "Create a saved search",
+                "parameters": [
+                    {
+                        "description": "Saved search",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/SaveSearchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/SavedSearch"
+                        }
+                    }
+                }
+            }
+        },
+        "/savedSearches/pull": {
+            "get": {
+                "description": "Sync endpoint to pull all changes to saved searches, and their counts, for this account.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "search"
+                ],
+                "summary": "Get Saved Searches",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "searchId",
+                        "name": "searchId",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last updated time",
+                        "name": "updatedAt",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Batch size",
+                        "name": "limit",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullSavedSearchesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/savedSearches/pullStream": {
+            "get": {
+                "description": "Sync endpoint to allow for for push from server to client of changes to saved searches and their counts.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "search"
+                ],
+                "summary": "Stream Saved Searches",
+                "responses": {}
+            }
+        },
+        "/savedSearches/{searchId}": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "search"
+                ],
+                "summary": "Update a saved search",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "searchId",
+                        "name": "searchId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Saved search",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/SaveSearchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/SavedSearch"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "search"
+                ],
+                "summary": "Delete a saved search",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "searchId",
+                        "name": "searchId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    }
+                }
+            }
+        },
+        "/settings": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "settings"
+                ],
+                "summary": "Get account settings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountSettings"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "settings"
+                ],
+                "summary": "Save account settings",
+                "parameters": [
+                    {
+                        "description": "Settings",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/SaveSettingsRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountSettings"
+                        }
+                    }
+                }
+            }
+        },
+        "/taxonomy": {
+            "get": {
+                "description": "The L1/L2 categories the AI uses to categorize this account's emails.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "taxonomy"
+                ],
+                "summary": "Get the category taxonomy",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountTaxonomy"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replaces all the categories. New emails are categorized with it, existing emails are not changed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "taxonomy"
+                ],
+                "summary": "Replace the category taxonomy",
+                "parameters": [
+                    {
+                        "description": "Taxonomy",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/SaveTaxonomyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountTaxonomy"
+                        }
+                    },
+                    "409": {
+                        "description": "Version mismatch"
+                    }
+                }
+            }
+        },
+        "/taxonomy/categories": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "taxonomy"
+                ],
+                "summary": "Add an L1 category",
+                "parameters": [
+                    {
+                        "description": "Category",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/TaxonomyCategory"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountTaxonomy"
+                        }
+                    },
+                    "409": {
+                        "description": "Category exists, or version mismatch"
+                    }
+                }
+            }
+        },
+        "/taxonomy/categories/{name}": {
+            "put": {
+                "description": "Renames the category and/or replaces its L2 sub categories.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "taxonomy"
+                ],
+                "summary": "Update an L1 category",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Current category name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Category",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/TaxonomyCategory"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountTaxonomy"
+                        }
+                    },
+                    "404": {
+                        "description": "Category not found"
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "taxonomy"
+                ],
+                "summary": "Delete an L1 category",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Category name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountTaxonomy"
+                        }
+                    },
+                    "404": {
+                        "description": "Category not found"
+                    }
+                }
+            }
+        },
+        "/taxonomy/reset": {
+            "post": {
+                "description": "Restores the default categories.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "taxonomy"
+                ],
+                "summary": "Reset the category taxonomy",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/AccountTaxonomy"
+                        }
+                    }
+                }
+            }
+        },
+        "/threads/pull": {
+            "get": {
+                "description": "Sync endpoint to pull all changes to threads for this account.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Get Threads",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "threadId",
+                        "name": "threadId",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last updated time",
+                        "name": "updatedAt",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Batch size",
+                        "name": "limit",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullThreadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/threads/pullStream": {
+            "get": {
+                "description": "Sync endpoint to allow for for push from server to client of changes to Threads.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Stream Threads",
+                "responses": {}
+            }
+        },
+        "/threads/{threadId}/draftReply": {
+            "post": {
+                "description": "Writes a reply to the latest message in the thread, using the thread, its todos and the account's tone. Saved as a Gmail draft in the thread for the user to review and send.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Draft a reply with AI",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "threadId",
+                        "name": "threadId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Options",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/DraftReplyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/DraftReplyResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/topics/pull": {
+            "get": {
+                "description": "Sync endpoint to pull all changes to the discovered topics for this account.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Get Topics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "topicId",
+                        "name": "topicId",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last updated time",
+                        "name": "updatedAt",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Batch size",
+                        "name": "limit",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/PullTopicsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/topics/pullStream": {
+            "get": {
+                "description": "Sync endpoint to allow for for push from server to client of changes to the discovered topics.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Stream Topics",
+                "responses": {}
+            }
+        },
+        "/topics/{topicId}/messages": {
+            "get": {
+                "description": "The messages in a topic, newest first. Only recent messages are clustered, so older ones have no topic.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "email"
+                ],
+                "summary": "Topic messages",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "topicId",
+                        "name": "topicId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only messages received before this internalDate, to page through them",
+                        "name": "before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of messages to return",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/TopicMessagesResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "AccountSettings": {
+            "type": "object",
+            "required": [
+                "createdAt",
+                "digest",
+                "updatedAt"
+            ],
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "digest": {
+                    "description": "when and what the daily digest covers",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/DigestSettings"
+                        }
+                    ]
+                },
+                "language": {
+                    "description": "ISO 639-1 code messages are translated to. eg. en",
+                    "type": "string"
+                },
+                "redactionLevel": {
+                    "description": "how strictly to mask sensitive values before mail is sent to the LLM. off, standard or strict",
+                    "type": "string"
+                },
+                "replyTone": {
+                    "description": "how AI drafted replies should sound. eg. \"friendly, short, no exclamation marks\"",
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "appended to AI drafted replies",
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "AccountTaxonomy": {
+            "type": "object",
+            "required": [
+                "categories",
+                "createdAt",
+                "updatedAt",
+                "version"
+            ],
+            "properties": {
+                "categories": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/TaxonomyCategory"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "bumped on every change",
+                    "type": "integer"
+                }
+            }
+        },
+        "AiBackfillJob": {
+            "type": "object",
+            "required": [
+                "createdAt",
+                "jobId",
+                "queued",
+                "ratePerMinute",
+                "status",
+                "total",
+                "updatedAt"
+            ],
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "error": {
+                    "description": "the last error. The job keeps retrying",
+                    "type": "string"
+                },
+                "jobId": {
+                    "type": "string"
+                },
+                "queued": {
+                    "description": "messages sent to the gemini service so far",
+                    "type": "integer"
+                },
+                "ratePerMinute": {
+                    "type": "integer"
+                },
+                "remaining": {
+                    "description": "stale messages still left. Only filled in by GetJob",
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "running, completed or cancelled",
+                    "type": "string"
+                },
+                "total": {
+                    "description": "stale messages when the job started",
+                    "type": "integer"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "AiBudget": {
+            "type": "object",
+            "required": [
+                "dailyTokens",
+                "monthlyTokens"
+            ],
+            "properties": {
+                "dailyTokens": {
+                    "type": "integer"
+                },
+                "monthlyTokens": {
+                    "type": "integer"
+                }
+            }
+        },
+        "AiDailyUsage": {
+            "type": "object",
+            "required": [
+                "date",
+                "embeddingTokens",
+                "model",
+                "operation",
+                "outputTokens",
+                "promptTokens",
+                "provider",
+                "total"
+            ],
+            "properties": {
+                "date": {
+                    "description": "YYYY-MM-DD, in UTC",
+                    "type": "string"
+                },
+                "embeddingTokens": {
+                    "type": "integer"
+                },
+                "model": {
+                    "type": "string"
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "outputTokens": {
+                    "type": "integer"
+                },
+                "promptTokens": {
+                    "type": "integer"
+                },
+                "provider": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "AiUsageReport": {
+            "type": "object",
+            "required": [
+                "budget",
+                "daily",
+                "deferred",
+                "month",
+                "today"
+            ],
+            "properties": {
+                "budget": {
+                    "$ref": "#/definitions/AiBudget"
+                },
+                "daily": {
+                    "description": "per day, model and operation. Most recent first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/AiDailyUsage"
+                    }
+                },
+                "deferred": {
+                    "description": "messages waiting for the account to be under budget",
+                    "type": "integer"
+                },
+                "month": {
+                    "$ref": "#/definitions/AiUsageTotals"
+                },
+                "today": {
+                    "$ref": "#/definitions/AiUsageTotals"
+                }
+            }
+        },
+        "AiUsageTotals": {
+            "type": "object",
+            "required": [
+                "embeddingTokens",
+                "outputTokens",
+                "promptTokens",
+                "total"
+            ],
+            "properties": {
+                "embeddingTokens": {
+                    "type": "integer"
+                },
+                "outputTokens": {
+                    "type": "integer"
+                },
+                "promptTokens": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "AskRequest": {
+            "type": "object",
+            "required": [
+                "question"
+            ],
+            "properties": {
+                "limit": {
+                    "description": "messages to retrieve. Defaults to 8, max 20",
+                    "type": "integer"
+                },
+                "question": {
+                    "type": "string"
+                }
+            }
+        },
+        "AttachmentText": {
+            "type": "object",
+            "required": [
+                "attachmentId",
+                "filename",
+                "mimeType",
+                "size",
+                "text",
+                "truncated"
+            ],
+            "properties": {
+                "attachmentId": {
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "mimeType": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "text": {
+                    "type": "string"
+                },
+                "truncated": {
+                    "description": "the text was cut short",
+                    "type": "boolean"
+                }
+            }
+        },
+        "BillEntity": {
+            "type": "object",
+            "required": [
+                "amount",
+                "biller",
+                "currency",
+                "dueDate",
+                "status"
+            ],
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "biller": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "dueDate": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "eg. paid, due, overdue",
+                    "type": "string"
+                }
+            }
+        },
+        "CategoryInfo": {
+            "type": "object",
+            "properties": {
+                "category": {
+                    "type": "string"
+                },
+                "messageCount": {
+                    "type": "integer"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointCategory": {
+            "type": "object",
+            "properties": {
+                "category": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointExtractedEntity": {
+            "type": "object",
+            "required": [
+                "entityId",
+                "updatedAt"
+            ],
+            "properties": {
+                "entityId": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointMessages": {
+            "type": "object",
+            "properties": {
+                "messageId": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointPerson": {
+            "type": "object",
+            "properties": {
+                "personId": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointSavedSearch": {
+            "type": "object",
+            "required": [
+                "searchId",
+                "updatedAt"
+            ],
+            "properties": {
+                "searchId": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointTag": {
+            "type": "object",
+            "required": [
+                "tag",
+                "updatedAt"
+            ],
+            "properties": {
+                "tag": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointThreads": {
+            "type": "object",
+            "required": [
+                "threadId",
+                "updatedAt"
+            ],
+            "properties": {
+                "threadId": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "CheckpointTopic": {
+            "type": "object",
+            "required": [
+                "topicId",
+                "updatedAt"
+            ],
+            "properties": {
+                "topicId": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "DeadLetter": {
+            "type": "object",
+            "required": [
+                "attempts",
+                "error",
+                "failedAt",
+                "id",
+                "payload",
+                "sourceTopic",
+                "state",
+                "topic"
+            ],
+            "properties": {
+                "accountId": {
+                    "description": "from the payload, if it has one",
+                    "type": "string"
+                },
+                "attempts": {
+                    "description": "times it has been dead lettered",
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "failedAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "description": "topic:partition:offset",
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "parked": {
+                    "description": "failed too many times. It can't be replayed",
+                    "type": "boolean"
+                },
+                "payload": {
+                    "type": "string"
+                },
+                "replayedAt": {
+                    "type": "string"
+                },
+                "sourceTopic": {
+                    "description": "where a replay sends it",
+                    "type": "string"
+                },
+                "state": {
+                    "description": "dead or replayed",
+                    "type": "string"
+                },
+                "topic": {
+                    "description": "the DLQ, or its parked topic",
+                    "type": "string"
+                }
+            }
+        },
+        "Digest": {
+            "type": "object",
+            "required": [
+                "createdAt",
+                "digestId",
+                "from",
+                "groups",
+                "messageCount",
+                "to",
+                "updatedAt"
+            ],
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "digestId": {
+                    "description": "the YYYY-MM-DD it was delivered, in the account's time zone",
+                    "type": "string"
+                },
+                "from": {
+                    "description": "the messages received in [From, To)",
+                    "type": "string"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/DigestGroup"
+                    }
+                },
+                "messageCount": {
+                    "type": "integer"
+                },
+                "sentAt": {
+                    "description": "when it was emailed. Missing if it wasn't",
+                    "type": "string"
+                },
+                "to": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "DigestGroup": {
+            "type": "object",
+            "required": [
+                "category",
+                "important",
+                "other"
+            ],
+            "properties": {
+                "category": {
+                    "type": "string"
+                },
+                "important": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/DigestMessage"
+                    }
+                },
+                "other": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/DigestMessage"
+                    }
+                }
+            }
+        },
+        "DigestMessage": {
+            "type": "object",
+            "required": [
+                "importance",
+                "internalDate",
+                "messageId",
+                "sender",
+                "subject",
+                "summary",
+                "threadId"
+            ],
+            "properties": {
+                "importance": {
+                    "type": "number"
+                },
+                "internalDate": {
+                    "type": "integer"
+                },
+                "messageId": {
+                    "type": "string"
+                },
+                "sender": {
+                    "$ref": "#/definitions/PersonInfo"
+                },
+                "subject": {
+                    "type": "string"
+                },
+                "summary": {
+                    "description": "the AI summary, or the snippet if it wasn't enriched",
+                    "type": "string"
+                },
+                "threadId": {
+                    "type": "string"
+                }
+            }
+        },
+        "DigestSettings": {
+            "type": "object",
+            "required": [
+                "categories",
+                "deliveryTime",
+                "enabled",
+                "sendEmail",
+                "timeZone"
+            ],
+            "properties": {
+                "categories": {
+                    "description": "the L1 categories to include. Empty for all",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "deliveryTime": {
+                    "description": "HH:MM, in TimeZone",
+                    "type": "string"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "sendEmail": {
+                    "description": "also email the digest to the account, from itself",
+                    "type": "boolean"
+                },
+                "timeZone": {
+                    "description": "IANA name. eg. America/Vancouver",
+                    "type": "string"
+                }
+            }
+        },
+        "DraftReplyRequest": {
+            "type": "object",
+            "properties": {
+                "instructions": {
+                    "description": "extra guidance for this reply. eg. \"politely decline\"",
+                    "type": "string"
+                },
+                "replyAll": {
+                    "description": "reply to everyone on the message, not just the sender",
+                    "type": "boolean"
+                }
+            }
+        },
+        "DraftReplyResponse": {
+            "type": "object",
+            "required": [
+                "body",
+                "draftId",
+                "inReplyToMessageId",
+                "messageId",
+                "threadId"
+            ],
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "draftId": {
+                    "type": "string"
+                },
+                "inReplyToMessageId": {
+                    "description": "the message being replied to",
+                    "type": "string"
+                },
+                "messageId": {
+                    "description": "the gmail message id of the draft",
+                    "type": "string"
+                },
+                "threadId": {
+                    "type": "string"
+                }
+            }
+        },
+        "ExtractedEntity": {
+            "type": "object",
+            "required": [
+                "createdAt",
+                "date",
+                "entityId",
+                "internalDate",
+                "isDeleted",
+                "key",
+                "messageId",
+                "threadId",
+                "type",
+                "updatedAt"
+            ],
+            "properties": {
+                "bill": {
+                    "$ref": "#/definitions/BillEntity"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "date": {
+                    "description": "YYYY-MM-DD. eg. when the bill is due, or the first flight departs. Empty if unknown",
+                    "type": "string"
+                },
+                "entityId": {
+                    "description": "the message id and type. A message has at most one of each type",
+                    "type": "string"
+                },
+                "flight": {
+                    "$ref": "#/definitions/FlightEntity"
+                },
+                "internalDate": {
+                    "description": "copied from the message",
+                    "type": "integer"
+                },
+                "isDeleted": {
+                    "type": "boolean"
+                },
+                "key": {
+                    "description": "what identifies it, for lookups. eg. the order or tracking number, or PNR",
+                    "type": "string"
+                },
+                "messageId": {
+                    "type": "string"
+                },
+                "order": {
+                    "$ref": "#/definitions/OrderEntity"
+                },
+                "shipment": {
+                    "$ref": "#/definitions/ShipmentEntity"
+                },
+                "threadId": {
+                    "type": "string"
+                },
+                "type": {
+                    "description": "see EntityOrder etc.",
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "FlightEntity": {
+            "type": "object",
+            "required": [
+                "legs",
+                "passengers",
+                "pnr"
+            ],
+            "properties": {
+                "legs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/FlightLeg"
+                    }
+                },
+                "passengers": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "pnr": {
+                    "description": "the booking reference",
+                    "type": "string"
+                }
+            }
+        },
+        "FlightLeg": {
+            "type": "object",
+            "required": [
+                "airline",
+                "arrivalAirport",
+                "arrivalTime",
+                "departureAirport",
+                "departureTime",
+                "flightNumber"
+            ],
+            "properties": {
+                "airline": {
+                    "type": "string"
+                },
+                "arrivalAirport": {
+                    "type": "string"
+                },
+                "arrivalTime": {
+                    "type": "string"
+                },
+                "departureAirport": {
+                    "type": "string"
+                },
+                "departureTime": {
+                    "type": "string"
+                },
+                "flightNumber": {
+                    "type": "string"
+                }
+            }
+        },
+        "GmailEntry": {
+            "type": "object",
+            "required": [
+                "additionalReceivers",
+                "categories",
+                "createdAt",
+                "headers",
+                "historyId",
+                "internalDate",
+                "isDeleted",
+                "labels",
+                "messageId",
+                "receivedAt",
+                "receiver",
+                "revisionCount",
+                "sender",
+                "snippet",
+                "subject",
+                "tags",
+                "threadId",
+                "todos",
+                "updatedAt",
+                "userId"
+            ],
+            "properties": {
+                "additionalReceivers": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/PersonInfo"
+                        }
+                    }
+                },
+                "categories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "headers": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "historyId": {
+                    "type": "integer"
+                },
+                "importance": {
+                    "description": "set by the importance service. 0-1, higher is more important. Missing until scored",
+                    "type": "number"
+                },
+                "internalDate": {
+                    "description": "The internal message creation timestamp (epoch ms), which determines ordering in the inbox. For normal SMTP-received email, this represents the time the message was originally accepted by Google, which is more reliable than the Date header. However, for API-migrated mail, it can be configured by client to be based on the Date header.",
+                    "type": "integer"
+                },
+                "isDeleted": {
+                    "description": "generated by us",
+                    "type": "boolean"
+                },
+                "labels": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "language": {
+                    "description": "ISO 639-1 code detected when fetched. Empty if it couldn't be told",
+                    "type": "string"
+                },
+                "messageId": {
+                    "type": "string"
+                },
+                "phishing": {
+                    "description": "set by the phishingAnalyzer service. Missing until analyzed",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/PhishingRisk"
+                        }
+                    ]
+                },
+                "receivedAt": {
+                    "description": "probaly use internalDate",
+                    "type": "string"
+                },
+                "receiver": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/PersonInfo"
+                    }
+                },
+                "redactions": {
+                    "description": "what was masked before the body was sent to the LLM",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/Redaction"
+                    }
+                },
+                "replyTo": {
+                    "$ref": "#/definitions/PersonInfo"
+                },
+                "revisionCount": {
+                    "type": "integer"
+                },
+                "sender": {
+                    "$ref": "#/definitions/PersonInfo"
+                },
+                "senderAuth": {
+                    "description": "parsed from the Authentication-Results when fetched. Missing for mail we sent",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/SenderAuth"
+                        }
+                    ]
+                },
+                "snippet": {
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string"
+                },
+                "summary": {
+                    "description": "set by the gemini service. 1-3 lines. Empty until the message is enriched",
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "threadId": {
+                    "type": "string"
+                },
+                "todos": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "topicId": {
+                    "description": "set by the topics service, empty until the account is clustered",
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "description": "For Sync + Conflict Resolution",
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                }
+            }
+        },
+        "GmailEntryBody": {
+            "type": "object",
+            "required": [
+                "createdAt",
+                "hasAttachments",
+                "messageId",
+                "revisionCount",
+                "updatedAt",
+                "userId"
+            ],
+            "properties": {
+                "attachmentIds": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "attachmentTexts": {
+                    "description": "text pulled out of pdf, docx, csv and text attachments",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/AttachmentText"
+                    }
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "hasAttachments": {
+                    "type": "integer"
+                },
+                "html": {
+                    "type": "string"
+                },
+                "messageId": {
+                    "type": "string"
+                },
+                "newContent": {
+                    "description": "the message without quoted replies or the signature. For the AI, and hiding quoted text",
+                    "type": "string"
+                },
+                "plainText": {
+                    "type": "string"
+                },
+                "revisionCount": {
+                    "type": "integer"
+                },
+                "updatedAt": {
+                    "description": "For Sync + Conflict Resolution",
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                }
             }
         },
-        "CheckpointCategory": {
+        "GooglePerson": {
             "type": "object",
+            "required": [
+                "createdAt",
+                "person",
+                "personId",
+                "revisionCount",
+                "updatedAt"
+            ],
             "properties": {
-                "category": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "person": {
+                    "$ref": "#/definitions/people.Person"
+                },
+                "personId": {
                     "type": "string"
                 },
+                "revisionCount": {
+                    "type": "integer"
+                },
                 "updatedAt": {
+                    "description": "For Sync + Conflict Resolution",
                     "type": "string"
                 }
             }
         },
-        "CheckpointMessages": {
+        "ImportanceFeedbackRequest": {
+            "type": "object",
+            "required": [
+                "action"
+            ],
+            "properties": {
+                "action": {
+                    "description": "replied, opened or archivedUnread",
+                    "type": "string"
+                }
+            }
+        },
+        "ListDeadLettersResponse": {
+            "type": "object",
+            "required": [
+                "entries"
+            ],
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/DeadLetter"
+                    }
+                }
+            }
+        },
+        "ListDigestsResponse": {
+            "type": "object",
+            "required": [
+                "digests"
+            ],
+            "properties": {
+                "digests": {
+                    "description": "most recent first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/Digest"
+                    }
+                }
+            }
+        },
+        "MessageBasic": {
+            "type": "object",
+            "required": [
+                "internalDate",
+                "labels",
+                "messageId"
+            ],
+            "properties": {
+                "internalDate": {
+                    "type": "integer"
+                },
+                "labels": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "messageId": {
+                    "type": "string"
+                },
+                "sender": {
+                    "$ref": "#/definitions/PersonInfo"
+                },
+                "snippet": {
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string"
+                }
+            }
+        },
+        "MessageTranslation": {
             "type": "object",
+            "required": [
+                "createdAt",
+                "language",
+                "messageId",
+                "sourceLanguage",
+                "text"
+            ],
             "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "language": {
+                    "description": "ISO 639-1 code the body was translated to",
+                    "type": "string"
+                },
                 "messageId": {
                     "type": "string"
                 },
-                "updatedAt": {
+                "sourceLanguage": {
+                    "description": "the language the model found the body was in",
+                    "type": "string"
+                },
+                "text": {
+                    "description": "markdown, like the body it was translated from",
+                    "type": "string"
+                }
+            }
+        },
+        "OrderEntity": {
+            "type": "object",
+            "required": [
+                "currency",
+                "items",
+                "merchant",
+                "orderDate",
+                "orderNumber",
+                "total"
+            ],
+            "properties": {
+                "currency": {
+                    "type": "string"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "merchant": {
+                    "type": "string"
+                },
+                "orderDate": {
+                    "type": "string"
+                },
+                "orderNumber": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "number"
+                }
+            }
+        },
+        "PersonInfo": {
+            "type": "object",
+            "required": [
+                "email",
+                "name"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "PhishingReason": {
+            "type": "object",
+            "required": [
+                "detail",
+                "type"
+            ],
+            "properties": {
+                "detail": {
+                    "description": "what was found. eg. \"paypa1.com looks like paypal.com\"",
+                    "type": "string"
+                },
+                "type": {
+                    "description": "eg. displayName, lookalikeDomain",
                     "type": "string"
                 }
             }
         },
-        "CheckpointPerson": {
+        "PhishingRisk": {
+            "type": "object",
+            "required": [
+                "analyzedAt",
+                "reasons",
+                "score",
+                "warning"
+            ],
+            "properties": {
+                "analyzedAt": {
+                    "type": "string"
+                },
+                "reasons": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/PhishingReason"
+                    }
+                },
+                "score": {
+                    "description": "0-1, higher is riskier",
+                    "type": "number"
+                },
+                "warning": {
+                    "description": "the score is high enough to warn about",
+                    "type": "boolean"
+                }
+            }
+        },
+        "PriorityMessagesResponse": {
+            "type": "object",
+            "required": [
+                "messages"
+            ],
+            "properties": {
+                "messages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/GmailEntry"
+                    }
+                }
+            }
+        },
+        "PullCategoriesResponse": {
+            "type": "object",
+            "properties": {
+                "categories": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/CategoryInfo"
+                    }
+                },
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointCategory"
+                }
+            }
+        },
+        "PullEntitiesResponse": {
+            "type": "object",
+            "required": [
+                "checkpoint",
+                "entities"
+            ],
+            "properties": {
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointExtractedEntity"
+                },
+                "entities": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ExtractedEntity"
+                    }
+                }
+            }
+        },
+        "PullMessagesResponse": {
+            "type": "object",
+            "properties": {
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointMessages"
+                },
+                "messages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/GmailEntry"
+                    }
+                }
+            }
+        },
+        "PullPeopleResponse": {
+            "type": "object",
+            "properties": {
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointPerson"
+                },
+                "people": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/GooglePerson"
+                    }
+                }
+            }
+        },
+        "PullSavedSearchesResponse": {
             "type": "object",
+            "required": [
+                "checkpoint",
+                "searches"
+            ],
             "properties": {
-                "personId": {
-                    "type": "string"
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointSavedSearch"
                 },
-                "updatedAt": {
-                    "type": "string"
+                "searches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/SavedSearch"
+                    }
                 }
             }
         },
-        "CheckpointTag": {
+        "PullTagsResponse": {
             "type": "object",
             "required": [
-                "tag",
-                "updatedAt"
+                "checkpoint",
+                "tags"
             ],
             "properties": {
-                "tag": {
-                    "type": "string"
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointTag"
                 },
-                "updatedAt": {
-                    "type": "string"
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/TagInfo"
+                    }
                 }
             }
         },
-        "CheckpointThreads": {
+        "PullThreadResponse": {
             "type": "object",
             "required": [
-                "threadId",
-                "updatedAt"
+                "checkpoint",
+                "threads"
             ],
             "properties": {
-                "threadId": {
-                    "type": "string"
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointThreads"
                 },
-                "updatedAt": {
-                    "type": "string"
+                "threads": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/Thread"
+                    }
                 }
             }
         },
-        "GmailEntry": {
+        "PullTopicsResponse": {
             "type": "object",
             "required": [
-                "additionalReceivers",
-                "categories",
-                "createdAt",
-                "headers",
-                "historyId",
-                "internalDate",
-                "isDeleted",
-                "labels",
-                "messageId",
-                "receivedAt",
-                "receiver",
-                "revisionCount",
-                "sender",
-                "snippet",
-                "subject",
-                "tags",
-                "threadId",
-                "todos",
-                "updatedAt",
-                "userId"
+                "checkpoint",
+                "topics"
             ],
             "properties": {
-                "additionalReceivers": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "type": "array",
-                        "items": {
-                            "$ref": "#/definitions/PersonInfo"
-                        }
-                    }
+                "checkpoint": {
+                    "$ref": "#/definitions/CheckpointTopic"
                 },
-                "categories": {
+                "topics": {
                     "type": "array",
                     "items": {
-                        "type": "string"
-                    }
-                },
-                "createdAt": {
-                    "type": "string"
-                },
-                "headers": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "type": "string"
+                        "$ref": "#/definitions/Topic"
                     }
-                },
-                "historyId": {
-                    "type": "integer"
-                },
-                "internalDate": {
-                    "description": "The internal message creation timestamp (epoch ms), which determines ordering in the inbox. For normal SMTP-received email, this represents the time the message was originally accepted by Google, which is more reliable than the Date header. However, for API-migrated mail, it can be configured by client to be based on the Date header.",
-                    "type": "integer"
-                },
-                "isDeleted": {
-                    "description": "generated by us",
-                    "type": "boolean"
-                },
-                "labels": {
+                }
+            }
+        },
+        "PushMessageRequest": {
+            "type": "object",
+            "required": [
+                "rows"
+            ],
+            "properties": {
+                "rows": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "$ref": "#/definitions/PushMessageRow"
                     }
-                },
-                "messageId": {
-                    "type": "string"
-                },
-                "receivedAt": {
-                    "description": "probaly use internalDate",
-                    "type": "string"
-                },
-                "receiver": {
+                }
+            }
+        },
+        "PushMessageResponse": {
+            "type": "object",
+            "required": [
+                "conflicts"
+            ],
+            "properties": {
+                "conflicts": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/PersonInfo"
+                        "$ref": "#/definitions/GmailEntry"
                     }
+                }
+            }
+        },
+        "PushMessageRow": {
+            "type": "object",
+            "properties": {
+                "assumedMasterState": {
+                    "$ref": "#/definitions/GmailEntry"
                 },
-                "replyTo": {
-                    "$ref": "#/definitions/PersonInfo"
-                },
-                "revisionCount": {
-                    "type": "integer"
-                },
-                "sender": {
-                    "$ref": "#/definitions/PersonInfo"
-                },
-                "snippet": {
-                    "type": "string"
-                },
-                "subject": {
-                    "type": "string"
-                },
-                "tags": {
+                "newDocumentState": {
+                    "$ref": "#/definitions/GmailEntry"
+                }
+            }
+        },
+        "QueryEntitiesResponse": {
+            "type": "object",
+            "required": [
+                "entities"
+            ],
+            "properties": {
+                "entities": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "$ref": "#/definitions/ExtractedEntity"
                     }
+                }
+            }
+        },
+        "Redaction": {
+            "type": "object",
+            "required": [
+                "count",
+                "type"
+            ],
+            "properties": {
+                "count": {
+                    "type": "integer"
                 },
-                "threadId": {
+                "type": {
+                    "description": "eg. creditCard, phone",
                     "type": "string"
-                },
-                "todos": {
+                }
+            }
+        },
+        "ReplayDeadLettersRequest": {
+            "type": "object",
+            "required": [
+                "ids"
+            ],
+            "properties": {
+                "ids": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
-                },
-                "updatedAt": {
-                    "description": "For Sync + Conflict Resolution",
-                    "type": "string"
-                },
-                "userId": {
-                    "type": "string"
                 }
             }
         },
-        "GmailEntryBody": {
+        "ReplayDeadLettersResponse": {
             "type": "object",
             "required": [
-                "createdAt",
-                "hasAttachments",
-                "messageId",
-                "revisionCount",
-                "updatedAt",
-                "userId"
+                "replayed"
             ],
             "properties": {
-                "attachmentIds": {
+                "replayed": {
+                    "description": "the ids sent back to their source topic. Parked and already replayed entries are skipped",
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
-                },
-                "createdAt": {
-                    "type": "string"
-                },
-                "hasAttachments": {
-                    "type": "integer"
-                },
-                "html": {
-                    "type": "string"
-                },
-                "messageId": {
-                    "type": "string"
-                },
-                "plainText": {
-                    "type": "string"
-                },
-                "revisionCount": {
-                    "type": "integer"
-                },
-                "updatedAt": {
-                    "description": "For Sync + Conflict Resolution",
-                    "type": "string"
-                },
-                "userId": {
-                    "type": "string"
                 }
             }
         },
-        "GooglePerson": {
+        "SaveSearchRequest": {
             "type": "object",
             "required": [
-                "createdAt",
-                "person",
-                "personId",
-                "revisionCount",
-                "updatedAt"
+                "name",
+                "query"
             ],
             "properties": {
-                "createdAt": {
+                "name": {
                     "type": "string"
                 },
-                "person": {
-                    "$ref": "#/definitions/people.Person"
+                "query": {
+                    "$ref": "#/definitions/SavedSearchQuery"
+                }
+            }
+        },
+        "SaveSettingsRequest": {
+            "type": "object",
+            "properties": {
+                "digest": {
+                    "description": "left unchanged if missing",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/DigestSettings"
+                        }
+                    ]
                 },
-                "personId": {
+                "language": {
+                    "description": "ISO 639-1 code, or a BCP 47 tag, messages are translated to. Defaults to en",
                     "type": "string"
                 },
-                "revisionCount": {
-                    "type": "integer"
+                "redactionLevel": {
+                    "description": "off, standard or strict. Defaults to standard",
+                    "type": "string"
                 },
-                "updatedAt": {
-                    "description": "For Sync + Conflict Resolution",
+                "replyTone": {
+                    "type": "string"
+                },
+                "signature": {
                     "type": "string"
                 }
             }
         },
-        "MessageBasic": {
+        "SaveTaxonomyRequest": {
             "type": "object",
             "required": [
-                "internalDate",
-                "labels",
-                "messageId"
+                "categories"
             ],
             "properties": {
-                "internalDate": {
-                    "type": "integer"
-                },
-                "labels": {
+                "categories": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "$ref": "#/definitions/TaxonomyCategory"
                     }
                 },
-                "messageId": {
-                    "type": "string"
-                },
-                "sender": {
-                    "$ref": "#/definitions/PersonInfo"
-                },
-                "snippet": {
-                    "type": "string"
-                },
-                "subject": {
-                    "type": "string"
+                "version": {
+                    "description": "the version this change was based on. 0 to overwrite regardless",
+                    "type": "integer"
                 }
             }
         },
-        "PersonInfo": {
+        "SavedSearch": {
             "type": "object",
             "required": [
-                "email",
-                "name"
+                "countedAt",
+                "createdAt",
+                "isDeleted",
+                "name",
+                "query",
+                "searchId",
+                "totalCount",
+                "unreadCount",
+                "updatedAt"
             ],
             "properties": {
-                "email": {
+                "countedAt": {
+                    "type": "string"
+                },
+                "createdAt": {
                     "type": "string"
                 },
+                "isDeleted": {
+                    "type": "boolean"
+                },
                 "name": {
                     "type": "string"
+                },
+                "query": {
+                    "$ref": "#/definitions/SavedSearchQuery"
+                },
+                "searchId": {
+                    "type": "string"
+                },
+                "totalCount": {
+                    "type": "integer"
+                },
+                "unreadCount": {
+                    "type": "integer"
+                },
+                "updatedAt": {
+                    "type": "string"
                 }
             }
         },
-        "PullCategoriesResponse": {
+        "SavedSearchQuery": {
             "type": "object",
             "properties": {
                 "categories": {
+                    "description": "matches any of these categories",
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/CategoryInfo"
+                        "type": "string"
                     }
                 },
-                "checkpoint": {
-                    "$ref": "#/definitions/CheckpointCategory"
-                }
-            }
-        },
-        "PullMessagesResponse": {
-            "type": "object",
-            "properties": {
-                "checkpoint": {
-                    "$ref": "#/definitions/CheckpointMessages"
+                "labels": {
+                    "description": "must have all of these gmail labels",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 },
-                "messages": {
+                "senders": {
+                    "description": "from any of these email addresses. eg. a VIP list",
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/GmailEntry"
+                        "type": "string"
                     }
-                }
-            }
-        },
-        "PullPeopleResponse": {
-            "type": "object",
-            "properties": {
-                "checkpoint": {
-                    "$ref": "#/definitions/CheckpointPerson"
                 },
-                "people": {
+                "tags": {
+                    "description": "matches any of these tags",
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/GooglePerson"
+                        "type": "string"
                     }
+                },
+                "text": {
+                    "description": "case insensitive match on the subject or snippet",
+                    "type": "string"
+                },
+                "topicId": {
+                    "type": "string"
+                },
+                "unreadOnly": {
+                    "type": "boolean"
+                },
+                "withinDays": {
+                    "description": "only messages received in the last N days",
+                    "type": "integer"
                 }
             }
         },
-        "PullTagsResponse": {
+        "SenderAuth": {
             "type": "object",
             "required": [
-                "checkpoint",
-                "tags"
+                "aligned",
+                "fromDomain",
+                "verdict"
             ],
             "properties": {
-                "checkpoint": {
-                    "$ref": "#/definitions/CheckpointTag"
+                "aligned": {
+                    "description": "SPF or DKIM passed for the From domain, or another domain of the same organization",
+                    "type": "boolean"
                 },
-                "tags": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/TagInfo"
-                    }
+                "arc": {
+                    "description": "the forwarding chain, eg. through a mailing list. Empty if it wasn't forwarded",
+                    "type": "string"
+                },
+                "dkim": {
+                    "description": "pass, fail, neutral, none, policy, temperror or permerror. Empty if it wasn't checked",
+                    "type": "string"
+                },
+                "dkimDomain": {
+                    "description": "the domain that signed the message",
+                    "type": "string"
+                },
+                "dmarc": {
+                    "description": "pass, fail, bestguesspass, none, temperror or permerror. Empty if it wasn't checked",
+                    "type": "string"
+                },
+                "dmarcPolicy": {
+                    "description": "the From domain's published policy. none, quarantine or reject",
+                    "type": "string"
+                },
+                "fromDomain": {
+                    "description": "the domain of the From header",
+                    "type": "string"
+                },
+                "spf": {
+                    "description": "pass, fail, softfail, neutral, none, temperror or permerror. Empty if it wasn't checked",
+                    "type": "string"
+                },
+                "spfDomain": {
+                    "description": "the envelope sender's domain, that SPF checked",
+                    "type": "string"
+                },
+                "verdict": {
+                    "description": "verified, unverified or failed",
+                    "type": "string"
                 }
             }
         },
-        "PullThreadResponse": {
+        "ShipmentEntity": {
             "type": "object",
             "required": [
-                "checkpoint",
-                "threads"
+                "carrier",
+                "estimatedDelivery",
+                "orderNumber",
+                "status",
+                "trackingNumber",
+                "trackingUrl"
             ],
             "properties": {
-                "checkpoint": {
-                    "$ref": "#/definitions/CheckpointThreads"
+                "carrier": {
+                    "type": "string"
                 },
-                "threads": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/Thread"
-                    }
+                "estimatedDelivery": {
+                    "type": "string"
+                },
+                "orderNumber": {
+                    "description": "the order it's for, if mentioned",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "trackingNumber": {
+                    "type": "string"
+                },
+                "trackingUrl": {
+                    "type": "string"
                 }
             }
         },
-        "PushMessageRequest": {
+        "SimilarMessage": {
             "type": "object",
             "required": [
-                "rows"
+                "message",
+                "score"
             ],
             "properties": {
-                "rows": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/PushMessageRow"
-                    }
+                "message": {
+                    "$ref": "#/definitions/GmailEntry"
+                },
+                "score": {
+                    "type": "number"
                 }
             }
         },
-        "PushMessageResponse": {
+        "SimilarMessagesResponse": {
             "type": "object",
             "required": [
-                "conflicts"
+                "messages"
             ],
             "properties": {
-                "conflicts": {
+                "messages": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/GmailEntry"
+                        "$ref": "#/definitions/SimilarMessage"
                     }
                 }
             }
         },
-        "PushMessageRow": {
+        "StartBackfillRequest": {
             "type": "object",
             "properties": {
-                "assumedMasterState": {
-                    "$ref": "#/definitions/GmailEntry"
-                },
-                "newDocumentState": {
-                    "$ref": "#/definitions/GmailEntry"
+                "ratePerMinute": {
+                    "description": "messages queued per minute. Defaults to 60, max 1000",
+                    "type": "integer"
                 }
             }
         },
@@ -789,6 +3284,24 @@ const docTemplate = `{
                 }
             }
         },
+        "TaxonomyCategory": {
+            "type": "object",
+            "required": [
+                "name",
+                "subcategories"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "subcategories": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
         "Thread": {
             "type": "object",
             "required": [
@@ -800,6 +3313,14 @@ const docTemplate = `{
                 "updatedAt"
             ],
             "properties": {
+                "aiSummary": {
+                    "description": "set by the threadSummary service. Missing until the thread has enough messages",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/ThreadSummary"
+                        }
+                    ]
+                },
                 "categories": {
                     "type": "array",
                     "items": {
@@ -829,6 +3350,118 @@ const docTemplate = `{
                 }
             }
         },
+        "ThreadSummary": {
+            "type": "object",
+            "required": [
+                "generatedAt",
+                "lastMessageId",
+                "messageCount",
+                "openQuestions",
+                "summary",
+                "todos"
+            ],
+            "properties": {
+                "generatedAt": {
+                    "type": "string"
+                },
+                "lastMessageId": {
+                    "type": "string"
+                },
+                "messageCount": {
+                    "description": "what was summarized, so we know when it is out of date",
+                    "type": "integer"
+                },
+                "openQuestions": {
+                    "description": "questions asked in the thread that haven't been answered yet",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "summary": {
+                    "type": "string"
+                },
+                "todos": {
+                    "description": "action items that are still outstanding",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "Topic": {
+            "type": "object",
+            "required": [
+                "createdAt",
+                "description",
+                "isDeleted",
+                "label",
+                "messageCount",
+                "sampleMessageIds",
+                "topicId",
+                "updatedAt"
+            ],
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "isDeleted": {
+                    "type": "boolean"
+                },
+                "label": {
+                    "type": "string"
+                },
+                "messageCount": {
+                    "type": "integer"
+                },
+                "sampleMessageIds": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "topicId": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "TopicMessagesResponse": {
+            "type": "object",
+            "required": [
+                "messages"
+            ],
+            "properties": {
+                "messages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/GmailEntry"
+                    }
+                }
+            }
+        },
+        "TranslateMessageResponse": {
+            "type": "object",
+            "required": [
+                "cached",
+                "translation"
+            ],
+            "properties": {
+                "cached": {
+                    "description": "it was translated before",
+                    "type": "boolean"
+                },
+                "translation": {
+                    "$ref": "#/definitions/MessageTranslation"
+                }
+            }
+        },
         "people.Address": {
             "type": "object",
             "properties": {
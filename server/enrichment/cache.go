@@ -0,0 +1,123 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/hex"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/utils"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// the analysis and embedding of an email's content. Newsletters and notifications
+// arrive nearly identical to many accounts, so they only need to be analyzed once.
+type CachedAnalysis struct {
+	// see CacheKey
+	Key           string    `bson:"_id"`
+	Result        Result    `bson:"result"`
+	EmbeddingText string    `bson:"embeddingText"`
+	Embedding     []float32 `bson:"embedding"`
+	// the entity extraction's response. Parsed again for each message that reuses it, see ParseEntities.
+	// Empty if it wasn't run, eg. there was nothing to extract
+	Entities  string    `bson:"entities,omitempty"`
+	Hits      int64     `bson:"hits"`
+	CreatedAt time.Time `bson:"createdAt"`
+	// expires from the cache 30 days after it was last used
+	LastUsedAt time.Time `bson:"lastUsedAt"`
+}
+
+var (
+	whitespace = regexp.MustCompile(`\s+`)
+	// tracking params differ per recipient, but don't change what the email says
+	urlQuery = regexp.MustCompile(`(https?://[^\s?#)\]>"]+)[?#][^\s)\]>"]*`)
+)
+
+// the content, without the parts that differ per recipient or send
+func normalize(text string) string {
+	text = urlQuery.ReplaceAllString(text, "$1")
+	return strings.TrimSpace(whitespace.ReplaceAllString(text, " "))
+}
+
+// identifies the content sent to the model, and everything that shapes its answer:
+// the instructions (including the account's categories), and the models.
+// Hash the redacted text, as that's what the model sees.
+func CacheKey(provider llm.Provider, instructions string, dimensions int32, subject string, body string) string {
+	parts := []string{
+		instructions,
+		provider.Name(),
+		provider.Model(),
+		provider.EmbedModel(),
+		strconv.Itoa(int(dimensions)),
+		normalize(subject),
+		normalize(body),
+	}
+	return hex.EncodeToString(utils.Sha256Bytes(strings.Join(parts, "\x00")))
+}
+
+// the cached analyses for the keys that have one
+func GetCached(ctx context.Context, keys []string) (map[string]CachedAnalysis, error) {
+	found := make(map[string]CachedAnalysis)
+	if len(keys) == 0 {
+		return found, nil
+	}
+	cursor, err := globals.DocDb().Collection("AiAnalysisCache").Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var cached []CachedAnalysis
+	if err := cursor.All(ctx, &cached); err != nil {
+		return nil, err
+	}
+	for _, c := range cached {
+		found[c.Key] = c
+	}
+	return found, nil
+}
+
+// saves new analyses, and bumps the hits of the ones that were used.
+// The entities of an added analysis are saved even if it was already cached, as older entries don't have them
+func SaveCached(ctx context.Context, added []CachedAnalysis, usedKeys []string) error {
+	writes := make([]mongo.WriteModel, 0, len(added)+len(usedKeys))
+	now := time.Now().UTC()
+	for _, c := range added {
+		set := bson.M{"lastUsedAt": now}
+		if c.Entities != "" {
+			set["entities"] = c.Entities
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": c.Key}).
+			SetUpdate(bson.M{
+				"$setOnInsert": bson.M{
+					"result":        c.Result,
+					"embeddingText": c.EmbeddingText,
+					"embedding":     c.Embedding,
+					"hits":          0,
+					"createdAt":     now,
+				},
+				"$set": set,
+			}).
+			SetUpsert(true),
+		)
+	}
+	for _, key := range usedKeys {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": key}).
+			SetUpdate(bson.M{
+				"$inc": bson.M{"hits": 1},
+				"$set": bson.M{"lastUsedAt": now},
+			}),
+		)
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err := globals.DocDb().Collection("AiAnalysisCache").BulkWrite(ctx, writes)
+	return err
+}
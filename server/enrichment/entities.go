@@ -0,0 +1,264 @@
+package enrichment
+
+import (
+	"fromkeith/my-desktop-server/gmail/data"
+	"slices"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// categories or tags, that suggest the email holds the entity. Matched against
+// the lowercased names, so they work with an account's own taxonomy
+var entityKeywords = map[string][]string{
+	data.EntityOrder:    {"order", "receipt", "purchase"},
+	data.EntityShipment: {"shipping", "shipment", "delivery", "tracking", "package"},
+	data.EntityFlight:   {"flight", "itinerar", "airline", "boarding"},
+	data.EntityBill:     {"bill", "invoice", "payment due", "statement"},
+}
+
+func stringProperty(description string) map[string]any {
+	return map[string]any{
+		"type":        "string",
+		"description": description,
+	}
+}
+
+func foundProperty(entity string) map[string]any {
+	return map[string]any{
+		"type":        "boolean",
+		"description": "True if the email contains " + entity + ". If false, leave the other fields empty.",
+	}
+}
+
+// the JSON the model must return for each entity type. Matches the data.*Entity structs
+var EntitySchemas = map[string]map[string]any{
+	data.EntityOrder: {
+		"type": "object",
+		"properties": map[string]any{
+			"Found":       foundProperty("an order confirmation or receipt"),
+			"Merchant":    stringProperty("Who the order was placed with"),
+			"OrderNumber": stringProperty("The order number, exactly as written"),
+			"Total": map[string]any{
+				"type":        "number",
+				"description": "The order total. 0 if not given",
+			},
+			"Currency":  stringProperty("ISO 4217 currency code of the total. eg. USD"),
+			"OrderDate": stringProperty("When the order was placed. YYYY-MM-DD"),
+			"Items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":        "string",
+					"description": "The name of an item ordered",
+				},
+			},
+		},
+		"required": []string{"Found", "Merchant", "OrderNumber", "Total", "Currency", "OrderDate", "Items"},
+	},
+	data.EntityShipment: {
+		"type": "object",
+		"properties": map[string]any{
+			"Found":             foundProperty("shipping or delivery tracking"),
+			"Carrier":           stringProperty("The shipping carrier. eg. UPS, Canada Post"),
+			"TrackingNumber":    stringProperty("The tracking number, exactly as written"),
+			"TrackingUrl":       stringProperty("The link to track the package"),
+			"Status":            stringProperty("One of: ordered, shipped, in transit, out for delivery, delivered, exception"),
+			"EstimatedDelivery": stringProperty("The expected delivery date. YYYY-MM-DD"),
+			"OrderNumber":       stringProperty("The order number the shipment is for"),
+		},
+		"required": []string{"Found", "Carrier", "TrackingNumber", "TrackingUrl", "Status", "EstimatedDelivery", "OrderNumber"},
+	},
+	data.EntityFlight: {
+		"type": "object",
+		"properties": map[string]any{
+			"Found": foundProperty("a flight booking or itinerary"),
+			"Pnr":   stringProperty("The booking reference / confirmation code (PNR)"),
+			"Passengers": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":        "string",
+					"description": "A passenger's name",
+				},
+			},
+			"Legs": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"Airline":          stringProperty("The operating airline"),
+						"FlightNumber":     stringProperty("eg. AC 123"),
+						"DepartureAirport": stringProperty("IATA airport code"),
+						"ArrivalAirport":   stringProperty("IATA airport code"),
+						"DepartureTime":    stringProperty("Local departure time. YYYY-MM-DDTHH:MM"),
+						"ArrivalTime":      stringProperty("Local arrival time. YYYY-MM-DDTHH:MM"),
+					},
+					"required": []string{"Airline", "FlightNumber", "DepartureAirport", "ArrivalAirport", "DepartureTime", "ArrivalTime"},
+				},
+				"description": "Each flight, in the order they are flown",
+			},
+		},
+		"required": []string{"Found", "Pnr", "Passengers", "Legs"},
+	},
+	data.EntityBill: {
+		"type": "object",
+		"properties": map[string]any{
+			"Found":  foundProperty("a bill or invoice to pay"),
+			"Biller": stringProperty("Who the bill is from"),
+			"Amount": map[string]any{
+				"type":        "number",
+				"description": "The amount due. 0 if not given",
+			},
+			"Currency": stringProperty("ISO 4217 currency code of the amount. eg. USD"),
+			"DueDate":  stringProperty("When payment is due. YYYY-MM-DD"),
+			"Status":   stringProperty("One of: due, paid, overdue"),
+		},
+		"required": []string{"Found", "Biller", "Amount", "Currency", "DueDate", "Status"},
+	},
+}
+
+// the system instructions for the extraction pass
+const EntityInstructions = `You extract structured records from emails. For each record type requested, set Found to true only if the email contains it, and copy values exactly as written. Leave fields you can't find empty. Dates are YYYY-MM-DD.
+Return as JSON, with a property for each requested type.`
+
+// the entity types worth extracting from an email with these categories and tags
+func EntityTypesFor(categories []string, tags []string) []string {
+	labels := append(slices.Clone(categories), tags...)
+	types := make([]string, 0)
+	for entityType, keywords := range entityKeywords {
+		if slices.ContainsFunc(labels, func(label string) bool {
+			label = strings.ToLower(label)
+			return slices.ContainsFunc(keywords, func(k string) bool { return strings.Contains(label, k) })
+		}) {
+			types = append(types, entityType)
+		}
+	}
+	slices.Sort(types)
+	return types
+}
+
+// the schema for extracting the types together, in one call
+func EntitySchema(types []string) map[string]any {
+	properties := make(map[string]any, len(types))
+	for _, t := range types {
+		properties[t] = EntitySchemas[t]
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   types,
+	}
+}
+
+type foundOrder struct {
+	Found bool
+	data.OrderEntity
+}
+
+type foundShipment struct {
+	Found bool
+	data.ShipmentEntity
+}
+
+type foundFlight struct {
+	Found bool
+	data.FlightEntity
+}
+
+type foundBill struct {
+	Found bool
+	data.BillEntity
+}
+
+// the entities the model found, for the message. Types it didn't find are left out
+func ParseEntities(text string, entry data.GmailEntry) ([]data.ExtractedEntity, error) {
+	var raw map[string]jsoniter.RawMessage
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return nil, err
+	}
+	entities := make([]data.ExtractedEntity, 0, len(raw))
+	for entityType, value := range raw {
+		entity := data.ExtractedEntity{
+			AccountId:    entry.AccountId,
+			EntityId:     data.EntityId(entry.MessageId, entityType),
+			MessageId:    entry.MessageId,
+			ThreadId:     entry.ThreadId,
+			Type:         entityType,
+			InternalDate: entry.InternalDate,
+		}
+		switch entityType {
+		case data.EntityOrder:
+			var found foundOrder
+			if err := json.Unmarshal(value, &found); err != nil {
+				return nil, err
+			}
+			if !found.Found {
+				continue
+			}
+			if found.Items == nil {
+				found.Items = make([]string, 0)
+			}
+			entity.Order = &found.OrderEntity
+			entity.Key = found.OrderNumber
+			entity.Date = isoDate(found.OrderDate)
+		case data.EntityShipment:
+			var found foundShipment
+			if err := json.Unmarshal(value, &found); err != nil {
+				return nil, err
+			}
+			if !found.Found {
+				continue
+			}
+			entity.Shipment = &found.ShipmentEntity
+			entity.Key = found.TrackingNumber
+			entity.Date = isoDate(found.EstimatedDelivery)
+		case data.EntityFlight:
+			var found foundFlight
+			if err := json.Unmarshal(value, &found); err != nil {
+				return nil, err
+			}
+			if !found.Found {
+				continue
+			}
+			if found.Passengers == nil {
+				found.Passengers = make([]string, 0)
+			}
+			if found.Legs == nil {
+				found.Legs = make([]data.FlightLeg, 0)
+			}
+			entity.Flight = &found.FlightEntity
+			entity.Key = found.Pnr
+			if len(found.Legs) > 0 {
+				entity.Date = isoDate(found.Legs[0].DepartureTime)
+			}
+		case data.EntityBill:
+			var found foundBill
+			if err := json.Unmarshal(value, &found); err != nil {
+				return nil, err
+			}
+			if !found.Found {
+				continue
+			}
+			entity.Bill = &found.BillEntity
+			entity.Key = found.Biller
+			entity.Date = isoDate(found.DueDate)
+		default:
+			continue // not something we asked for
+		}
+		entity.Key = strings.TrimSpace(entity.Key)
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+// the YYYY-MM-DD at the start of the value, or "" if it isn't a date
+func isoDate(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < len(time.DateOnly) {
+		return ""
+	}
+	if _, err := time.Parse(time.DateOnly, value[:len(time.DateOnly)]); err != nil {
+		return ""
+	}
+	return value[:len(time.DateOnly)]
+}
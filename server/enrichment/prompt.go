@@ -0,0 +1,116 @@
+package enrichment
+
+import (
+	"encoding/hex"
+	"fmt"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/taxonomy"
+	"fromkeith/my-desktop-server/utils"
+
+	jsoniter "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// what the model returns for each email
+type Result struct {
+	Theme      string
+	Summary    string
+	Categories []string
+	Tags       []string
+	Todos      []string
+}
+
+// the JSON the model must return. Matches Result
+var ResponseSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"Theme": map[string]any{
+			"type":        "string",
+			"description": "Theme of the email",
+		},
+		"Summary": map[string]any{
+			"type":        "string",
+			"description": "1-3 line summary of this email",
+		},
+		"Categories": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":        "string",
+				"description": "The L1 and L2 categories for this email.",
+			},
+		},
+		"Tags": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":        "string",
+				"description": "A tag for this email. Must be 1 word.",
+			},
+		},
+		"Todos": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":        "string",
+				"description": "An action item to complete when replying to this email",
+			},
+			"description": "A list of action items to complete when replying to this email. Can be an empty array if no action is needed.",
+		},
+	},
+	"required": []string{"Theme", "Summary", "Categories", "Tags", "Todos"},
+}
+
+// the system instructions, with the account's categories
+func Instructions(categories []data.TaxonomyCategory) string {
+	return fmt.Sprintf(`You help categorize & summarize emails. Get out the theme (1 line), a summary (1-3 lines), suggested categories (L1, L2), tags (3-8 short tokens, as a string list), and a TODO list for replying to the email.
+Return as JSON (Theme, Summary, Categories, Tags, Todos)
+
+The categories are below, L1 is the heading, L2 is bullet under the heading:
+
+%s
+`, taxonomy.Format(categories))
+}
+
+// a line for the prompt when the sender failed authentication, so spam and phishing are called out.
+// Empty otherwise, so the prompt and cache key of most mail are unchanged
+func SenderAuthNote(auth *data.SenderAuth) string {
+	if auth == nil || auth.Verdict != data.SenderAuthFailed {
+		return ""
+	}
+	return fmt.Sprintf("Sender authentication: failed (spf=%s dkim=%s dmarc=%s). The From address (%s) is likely forged.",
+		orNone(auth.Spf), orNone(auth.Dkim), orNone(auth.Dmarc), auth.FromDomain)
+}
+
+func orNone(result string) string {
+	if result == "" {
+		return "none"
+	}
+	return result
+}
+
+// the taxonomy is versioned separately, so it's left out of the hash
+var promptHash = func() string {
+	schema, _ := json.Marshal(ResponseSchema)
+	return hex.EncodeToString(utils.Sha256Bytes(Instructions(nil) + string(schema)))[:16]
+}()
+
+// the version of messages enriched now, with the provider's default model
+func CurrentVersion(provider llm.Provider, taxonomyVersion int64) data.AiVersion {
+	return data.AiVersion{
+		PromptHash:      promptHash,
+		Model:           provider.Name() + "/" + provider.Model(),
+		TaxonomyVersion: taxonomyVersion,
+	}
+}
+
+// messages in the account that were not enriched with this version.
+// Includes messages that were never enriched.
+func StaleFilter(accountId string, version data.AiVersion) bson.M {
+	return bson.M{
+		"accountId": accountId,
+		"isDeleted": bson.M{"$ne": true},
+		"labels":    bson.M{"$ne": "SPAM"}, // we don't AI spam
+		"aiVersion": bson.M{"$ne": version},
+	}
+}
@@ -0,0 +1,92 @@
+package entities
+
+import (
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type SyncCheckpoint struct {
+	EntityId  string `validate:"required" json:"entityId"`
+	UpdatedAt string `validate:"required" json:"updatedAt"`
+} // @name CheckpointExtractedEntity
+
+type PullEntitiesResponse struct {
+	Entities   []data.ExtractedEntity `validate:"required" json:"entities"`
+	Checkpoint SyncCheckpoint         `validate:"required" json:"checkpoint"`
+} // @name PullEntitiesResponse
+
+// PullEntities godoc
+// @Summary      Get Extracted Entities
+// @Description  Sync endpoint to pull all changes to the orders, shipments, flights and bills extracted from this account's emails.
+// @Tags         entities
+// @Produce      json
+// @Param        entityId query string true "entityId"
+// @Param        updatedAt query string true "Last updated time"
+// @Param        limit query int true "Batch size"
+// @Success      200  {object}  PullEntitiesResponse
+// @Router       /entities/pull [get]
+func PullEntities(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	entityId := r.Query("entityId")
+	lastId := data.ToDocumentId(accountId, entityId)
+	updatedAtStr := r.Query("updatedAt")
+	updatedAt, _ := time.Parse(time.RFC3339Nano, updatedAtStr)
+
+	batchSizeStr := r.Query("limit")
+	batchSize, _ := strconv.ParseInt(batchSizeStr, 10, 64)
+	if batchSize <= 0 || batchSize > 100 {
+		batchSize = 10
+	}
+
+	opts := options.Find().SetSort(bson.D{{"updatedAt", 1}, {"_id", 1}}).SetLimit(batchSize)
+	cursor, err := globals.DocDb().Collection("ExtractedEntities").Find(
+		r,
+		bson.M{
+			"$or": []bson.M{
+				bson.M{"updatedAt": bson.M{"$gt": updatedAt}},
+				bson.M{
+					"updatedAt": updatedAt,
+					"_id":       bson.M{"$gt": lastId},
+				},
+			},
+			"accountId": accountId,
+		},
+		opts,
+	)
+	if err != nil {
+		r.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(r)
+
+	var entities []data.ExtractedEntity
+	if err := cursor.All(r, &entities); err != nil {
+		r.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var nextId string
+	var nextUpdatedAt string
+	if len(entities) > 0 {
+		last := entities[len(entities)-1]
+		nextId = last.EntityId
+		nextUpdatedAt = last.UpdatedAt.Format(time.RFC3339Nano)
+	} else {
+		nextId = entityId
+		nextUpdatedAt = updatedAtStr
+	}
+
+	r.JSON(200, PullEntitiesResponse{
+		Entities:   entities,
+		Checkpoint: SyncCheckpoint{EntityId: nextId, UpdatedAt: nextUpdatedAt},
+	})
+}
@@ -0,0 +1,96 @@
+package entities
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/utils"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// PullStream godoc
+// @Summary      Stream Extracted Entities
+// @Description  Sync endpoint to allow for for push from server to client of changes to the extracted entities.
+// @Tags         entities
+// @Produce      event-stream
+// @Router       /entities/pullStream [get]
+func PullStream(r *gin.Context) {
+	accountId := r.GetString("accountId")
+
+	matchStage := bson.D{{
+		"$match", bson.D{
+			{"fullDocument.accountId", accountId},
+		},
+	}}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(10 * time.Second)
+	stream, err := globals.DocDb().Collection("ExtractedEntities").Watch(r, mongo.Pipeline{matchStage}, opts)
+	if err != nil {
+		r.Error(err)
+		return
+	}
+	defer stream.Close(r)
+
+	streamCtx, cancel := context.WithCancel(r.Request.Context())
+	defer cancel()
+
+	batchChan, batchErr := utils.BatchMongoStreamChannel(streamCtx, stream, 10, time.Second)
+
+	r.Set("Content-Type", "text/event-stream")
+	r.Stream(func(w io.Writer) bool {
+		select {
+		case err := <-batchErr:
+			if err != nil {
+				log.Error().
+					Ctx(r).
+					Err(err).
+					Msg("failed to batch entities in stream")
+				return false
+			}
+		case batch := <-batchChan:
+			payloads := make([]data.ExtractedEntity, 0, len(batch))
+			chkPoint := SyncCheckpoint{}
+			for _, ev := range batch {
+				full, ok := ev["fullDocument"]
+				if !ok {
+					return true
+				}
+				raw, _ := bson.Marshal(full)
+				var entity data.ExtractedEntity
+				if err := bson.Unmarshal(raw, &entity); err != nil {
+					log.Error().
+						Ctx(r).
+						Err(err).
+						Any("full", full).
+						Msg("failed to unmarshal entity in stream")
+					return true
+				}
+				payloads = append(payloads, entity)
+				at := entity.UpdatedAt.Format(time.RFC3339Nano)
+				if at > chkPoint.UpdatedAt {
+					chkPoint = SyncCheckpoint{EntityId: entity.EntityId, UpdatedAt: at}
+				} else if at == chkPoint.UpdatedAt && entity.EntityId > chkPoint.EntityId {
+					chkPoint = SyncCheckpoint{EntityId: entity.EntityId, UpdatedAt: at}
+				}
+			}
+			payload, _ := json.Marshal(PullEntitiesResponse{
+				Entities:   payloads,
+				Checkpoint: chkPoint,
+			})
+			r.SSEvent("message", payload)
+			return true
+		case <-time.After(time.Second):
+			return true // allow the request to check its status or end
+		}
+		return true
+	})
+
+}
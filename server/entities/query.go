@@ -0,0 +1,102 @@
+package entities
+
+import (
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var entityTypes = []string{data.EntityOrder, data.EntityShipment, data.EntityFlight, data.EntityBill}
+
+type QueryEntitiesResponse struct {
+	Entities []data.ExtractedEntity `validate:"required" json:"entities"`
+} // @name QueryEntitiesResponse
+
+// QueryEntities godoc
+// @Summary      Search extracted entities
+// @Description  Finds orders, shipments, flights and bills extracted from this account's emails. Sorted by date, newest first.
+// @Tags         entities
+// @Produce      json
+// @Param        type query string false "order, shipment, flight or bill"
+// @Param        key query string false "Exact order number, tracking number, PNR or biller"
+// @Param        text query string false "Case insensitive match on the key, merchant, carrier or biller"
+// @Param        messageId query string false "Only entities from this message"
+// @Param        from query string false "Dated on or after. YYYY-MM-DD"
+// @Param        to query string false "Dated on or before. YYYY-MM-DD"
+// @Param        limit query int false "Number of entities to return"
+// @Success      200  {object}  QueryEntitiesResponse
+// @Router       /entities [get]
+func QueryEntities(r *gin.Context) {
+	filter := bson.M{
+		"accountId": r.GetString("accountId"),
+		"isDeleted": false,
+	}
+	if entityType := r.Query("type"); entityType != "" {
+		if !slices.Contains(entityTypes, entityType) {
+			r.JSON(http.StatusBadRequest, gin.H{"error": "type must be one of order, shipment, flight or bill"})
+			return
+		}
+		filter["type"] = entityType
+	}
+	if key := r.Query("key"); key != "" {
+		filter["key"] = key
+	}
+	if text := r.Query("text"); text != "" {
+		pattern := bson.Regex{Pattern: regexp.QuoteMeta(text), Options: "i"}
+		filter["$or"] = []bson.M{
+			{"key": pattern},
+			{"order.merchant": pattern},
+			{"shipment.carrier": pattern},
+			{"bill.biller": pattern},
+		}
+	}
+	if messageId := r.Query("messageId"); messageId != "" {
+		filter["messageId"] = messageId
+	}
+	dateRange := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		value := r.Query(param)
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			r.JSON(http.StatusBadRequest, gin.H{"error": param + " must be YYYY-MM-DD"})
+			return
+		}
+		dateRange[op] = value
+	}
+	if len(dateRange) > 0 {
+		filter["date"] = dateRange
+	}
+	limit, _ := strconv.ParseInt(r.Query("limit"), 10, 64)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{"date", -1}, {"internalDate", -1}}).
+		SetLimit(limit)
+	cursor, err := globals.DocDb().Collection("ExtractedEntities").Find(r, filter, opts)
+	if err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(r)
+	var entities []data.ExtractedEntity
+	if err := cursor.All(r, &entities); err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if entities == nil {
+		entities = make([]data.ExtractedEntity, 0)
+	}
+	r.JSON(http.StatusOK, QueryEntitiesResponse{Entities: entities})
+}
@@ -2,23 +2,49 @@ package globals
 
 import (
 	"context"
+	"fmt"
+	"fromkeith/my-desktop-server/llm"
+	"sync"
 
 	"google.golang.org/genai"
 )
 
 var (
 	geminiClient *genai.Client
+	geminiOnce   sync.Once
+	llmProvider  llm.Provider
+	// the provider's rate limiter is shared by its callers, so there must only be one
+	llmOnce sync.Once
 )
 
 func Gemini() *genai.Client {
-	if geminiClient != nil {
-		return geminiClient
-	}
-	var err error
-	// gets api key from env
-	geminiClient, err = genai.NewClient(context.Background(), nil)
-	if err != nil {
-		panic(err)
-	}
+	geminiOnce.Do(func() {
+		var err error
+		// gets api key from env
+		geminiClient, err = genai.NewClient(context.Background(), nil)
+		if err != nil {
+			panic(err)
+		}
+	})
 	return geminiClient
 }
+
+// the configured LLM provider. Transient errors are retried, see llm.WithRetry and llm.ConfigFromEnv
+func LLM() llm.Provider {
+	llmOnce.Do(func() {
+		cfg := llm.ConfigFromEnv()
+		var provider llm.Provider
+		switch cfg.Provider {
+		case "gemini":
+			provider = llm.NewGemini(Gemini(), cfg)
+		case "openai":
+			provider = llm.NewOpenAi(cfg)
+		case "ollama":
+			provider = llm.NewOllama(cfg)
+		default:
+			panic(fmt.Sprintf("unknown LLM_PROVIDER: %s", cfg.Provider))
+		}
+		llmProvider = llm.WithRetry(provider, cfg)
+	})
+	return llmProvider
+}
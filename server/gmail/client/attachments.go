@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fromkeith/my-desktop-server/attachments"
+	"fromkeith/my-desktop-server/gmail/data"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/gmail/v1"
+)
+
+// most attachments to read per message
+const maxAttachmentsPerMessage = 5
+
+// the parts of the message that are attachments we can read text from
+func readableAttachments(p *gmail.MessagePart) []*gmail.MessagePart {
+	if p == nil {
+		return nil
+	}
+	found := make([]*gmail.MessagePart, 0)
+	if p.Filename != "" && p.Body != nil && p.Body.AttachmentId != "" &&
+		p.Body.Size <= attachments.MaxSize && attachments.Supported(p.MimeType, p.Filename) {
+		found = append(found, p)
+	}
+	for _, part := range p.Parts {
+		found = append(found, readableAttachments(part)...)
+	}
+	return found
+}
+
+// downloads the readable attachments, and pulls their text out.
+// Attachments that fail are skipped, so the message is still saved.
+func (g *googleClient) extractAttachmentTexts(ctx context.Context, msg *gmail.Message) []data.AttachmentText {
+	parts := readableAttachments(msg.Payload)
+	if len(parts) > maxAttachmentsPerMessage {
+		parts = parts[:maxAttachmentsPerMessage]
+	}
+	texts := make([]data.AttachmentText, 0, len(parts))
+	for _, part := range parts {
+		logErr := func(err error, message string) {
+			log.Warn().
+				Ctx(ctx).
+				Str("messageId", msg.Id).
+				Str("filename", part.Filename).
+				Err(err).
+				Msg(message)
+		}
+		att, err := g.gmail.Users.Messages.Attachments.Get(g.userId, msg.Id, part.Body.AttachmentId).Context(ctx).Do()
+		if err != nil {
+			logErr(err, "Failed to download attachment")
+			continue
+		}
+		content, err := base64.URLEncoding.DecodeString(att.Data)
+		if err != nil {
+			// some come without padding
+			content, err = base64.RawURLEncoding.DecodeString(att.Data)
+		}
+		if err != nil {
+			logErr(err, "Failed to decode attachment")
+			continue
+		}
+		text, truncated, err := attachments.Extract(part.MimeType, part.Filename, content)
+		if err != nil {
+			logErr(err, "Failed to extract attachment text")
+			continue
+		}
+		if text == "" {
+			continue // eg. a scanned pdf
+		}
+		texts = append(texts, data.AttachmentText{
+			AttachmentId: part.Body.AttachmentId,
+			Filename:     part.Filename,
+			MimeType:     part.MimeType,
+			Size:         part.Body.Size,
+			Text:         text,
+			Truncated:    truncated,
+		})
+	}
+	return texts
+}
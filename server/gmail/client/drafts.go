@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fromkeith/my-desktop-server/gmail/data"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// a plain text reply, to be saved as a draft
+type ReplyDraft struct {
+	ThreadId string
+	To       []data.PersonInfo
+	Cc       []data.PersonInfo
+	Subject  string
+	// Message-ID header of the message being replied to
+	InReplyTo string
+	// References header of the message being replied to
+	References string
+	Body       string
+}
+
+// saves the reply as a draft in the thread. The user reviews and sends it from gmail, or any client.
+func (g *googleClient) CreateReplyDraft(ctx context.Context, reply ReplyDraft) (*gmail.Draft, error) {
+	raw, err := buildReplyMime(reply)
+	if err != nil {
+		return nil, err
+	}
+	draft := &gmail.Draft{
+		Message: &gmail.Message{
+			ThreadId: reply.ThreadId,
+			Raw:      base64.URLEncoding.EncodeToString(raw),
+		},
+	}
+	return g.gmail.Users.Drafts.Create(g.userId, draft).Context(ctx).Do()
+}
+
+func buildReplyMime(reply ReplyDraft) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader := func(k, v string) {
+		if v == "" {
+			return
+		}
+		buf.WriteString(k + ": " + v + "\r\n")
+	}
+	writeHeader("To", addressList(reply.To))
+	writeHeader("Cc", addressList(reply.Cc))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", replySubject(reply.Subject)))
+	// these keep the reply threaded for the recipients too
+	writeHeader("In-Reply-To", reply.InReplyTo)
+	writeHeader("References", strings.TrimSpace(reply.References+" "+reply.InReplyTo))
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", `text/plain; charset="UTF-8"`)
+	writeHeader("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	body := strings.ReplaceAll(reply.Body, "\r\n", "\n")
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func addressList(people []data.PersonInfo) string {
+	out := make([]string, 0, len(people))
+	for _, p := range people {
+		if p.Email == "" {
+			continue
+		}
+		addr := mail.Address{Name: p.Name, Address: p.Email}
+		out = append(out, addr.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+func replySubject(subject string) string {
+	if strings.HasPrefix(strings.ToLower(subject), "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
@@ -5,6 +5,9 @@ import (
 	"encoding/base64"
 	"fromkeith/my-desktop-server/globals"
 	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/quoted"
+	"fromkeith/my-desktop-server/senderauth"
+	"fromkeith/my-desktop-server/translation"
 	"net/mail"
 	"os"
 	"strconv"
@@ -267,6 +270,8 @@ func (g *googleClient) BootstrapEmail(ctx context.Context) error {
 				MessageId: m.Id,
 				AccountId: g.accountId,
 				UserId:    g.userId,
+				// there's hundreds, so the AI can take its time
+				Bootstrap: true,
 			})
 			msg := kafka.Message{
 				Key:   []byte(g.accountId + ";" + m.Id),
@@ -385,21 +390,46 @@ func (g *googleClient) FetchGmailEntry(ctx context.Context, id string) (*data.Gm
 		Tags:       make([]string, 0),
 		Categories: make([]string, 0),
 	}
+	entry.SenderAuth = senderauth.Parse(authHeaders(msg.Payload.Headers), entry.Sender.Email)
 
 	text, html, hasAtt, inlineIds := extractBodies(msg.Payload)
 	hasAttInt := 0
 	if hasAtt {
 		hasAttInt = 1
 	}
+	newContent, err := quoted.NewContent(text, html)
+	if err != nil {
+		// not worth failing the message over. Readers fall back to the full body
+		log.Warn().
+			Ctx(ctx).
+			Str("messageId", id).
+			Err(err).
+			Msg("Failed to strip quoted text")
+	}
+	if newContent != "" {
+		entry.Language = translation.Detect(entry.Subject + "\n" + newContent)
+	} else {
+		entry.Language = translation.Detect(entry.Subject + "\n" + text)
+	}
+	if entry.Language == "" {
+		// too short to tell, so trust what the sender said
+		if lang, err := translation.NormalizeLanguage(strings.Split(headers["content-language"], ",")[0]); err == nil {
+			entry.Language = lang
+		}
+	}
 	body := data.GmailEntryBody{
 		UserId:         entry.UserId,
 		MessageId:      entry.MessageId,
 		AccountId:      entry.AccountId,
+		HistoryId:      entry.HistoryId,
 		PlainText:      text,
 		Html:           html,
+		NewContent:     newContent,
 		HasAttachments: hasAttInt,
 		AttachmentIds:  inlineIds,
 	}
+	// not just when hasAtt, as text attachments aren't counted
+	body.AttachmentTexts = g.extractAttachmentTexts(ctx, msg)
 	return &entry, &body, nil
 
 }
@@ -439,6 +469,22 @@ func personFrom(headers map[string]string, field string) data.PersonInfo {
 	}
 }
 
+// the headers authentication is read from. Kept apart, as headerMap joins repeated headers
+func authHeaders(hs []*gmail.MessagePartHeader) senderauth.Headers {
+	var out senderauth.Headers
+	for _, h := range hs {
+		switch strings.ToLower(h.Name) {
+		case "authentication-results":
+			out.AuthenticationResults = append(out.AuthenticationResults, h.Value)
+		case "received-spf":
+			out.ReceivedSpf = append(out.ReceivedSpf, h.Value)
+		case "dkim-signature":
+			out.DkimSignatures = append(out.DkimSignatures, h.Value)
+		}
+	}
+	return out
+}
+
 func headerMap(hs []*gmail.MessagePartHeader) map[string]string {
 	m := make(map[string]string, len(hs))
 	for _, h := range hs {
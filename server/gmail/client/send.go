@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// sends an html email from the account, to itself. eg. the daily digest
+func (g *googleClient) SendHtmlToSelf(ctx context.Context, subject string, htmlBody string) (*gmail.Message, error) {
+	profile, err := g.gmail.Users.GetProfile(g.userId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := buildHtmlMime(profile.EmailAddress, subject, htmlBody)
+	if err != nil {
+		return nil, err
+	}
+	msg := &gmail.Message{
+		Raw: base64.URLEncoding.EncodeToString(raw),
+	}
+	return g.gmail.Users.Messages.Send(g.userId, msg).Context(ctx).Do()
+}
+
+func buildHtmlMime(to string, subject string, htmlBody string) ([]byte, error) {
+	var buf bytes.Buffer
+	addr := mail.Address{Address: to}
+	buf.WriteString("From: " + addr.String() + "\r\n")
+	buf.WriteString("To: " + addr.String() + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString(`Content-Type: text/html; charset="UTF-8"` + "\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(htmlBody)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -2,17 +2,31 @@ package data
 
 import "time"
 
+// identifies how a message was enriched, so we can find messages to re-process
+// when the prompt, model or account's taxonomy changes
+type AiVersion struct {
+	// hash of the instructions + response schema
+	PromptHash string `bson:"promptHash"`
+	// provider/model. eg. gemini/gemini-flash-latest
+	Model           string `bson:"model"`
+	TaxonomyVersion int64  `bson:"taxonomyVersion"`
+}
+
 type EmailSummaryEmbedding struct {
 	AccountId string    `bson:"accountId"`
 	MessageId string    `bson:"messageId"`
 	Embedding []float32 `bson:"embedding"`
 	// useful metadata
-	Sender    PersonInfo   `bson:"sender"`
-	Receiver  []PersonInfo `bson:"receiver"`
-	Summary   string       `bson:"summary"`
-	Version   int          `bson:"version"`
-	UpdatedAt time.Time    `bson:"updatedAt"`
-	CreatedAt time.Time    `bson:"createdAt"`
+	Sender   PersonInfo   `bson:"sender"`
+	Receiver []PersonInfo `bson:"receiver"`
+	Summary  string       `bson:"summary"`
+	// copied from the message so vector searches can filter on it
+	InternalDate int64 `bson:"internalDate"`
+	// assigned by the topics service
+	TopicId   string    `bson:"topicId,omitempty"`
+	AiVersion AiVersion `bson:"aiVersion"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	CreatedAt time.Time `bson:"createdAt"`
 }
 
 func (g EmailSummaryEmbedding) ToDocumentId() string {
@@ -34,6 +34,26 @@ type GmailEntry struct {
 	Tags       []string `validate:"required" bson:"tags"`
 	Categories []string `validate:"required" bson:"categories"`
 	Todos      []string `validate:"required" bson:"todos"`
+	// set by the topics service, empty until the account is clustered
+	TopicId string `bson:"topicId,omitempty"`
+	// set by the gemini service. Empty until the message is enriched
+	AiVersion *AiVersion `json:"-" bson:"aiVersion,omitempty"`
+	// set by the gemini service. 1-3 lines. Empty until the message is enriched
+	Summary string `json:",omitempty" bson:"summary,omitempty"`
+	// ISO 639-1 code detected when fetched. Empty if it couldn't be told
+	Language string `json:",omitempty" bson:"language,omitempty"`
+	// parsed from the Authentication-Results when fetched. Missing for mail we sent
+	SenderAuth *SenderAuth `json:",omitempty" bson:"senderAuth,omitempty"`
+	// set by the phishingAnalyzer service. Missing until analyzed
+	Phishing *PhishingRisk `json:",omitempty" bson:"phishing,omitempty"`
+	// what was masked before the body was sent to the LLM
+	Redactions []Redaction `json:",omitempty" bson:"redactions,omitempty"`
+	// set by the importance service. 0-1, higher is more important. Missing until scored
+	Importance *float64 `json:",omitempty" bson:"importance,omitempty"`
+	// the inputs to the importance score, kept so feedback can train on them
+	ImportanceFeatures map[string]float64 `json:"-" bson:"importanceFeatures,omitempty"`
+	// feedback already learned from this message. eg. "opened"
+	ImportanceFeedback []string `json:"-" bson:"importanceFeedback,omitempty"`
 
 	//
 	// used in database, but not returned via API
@@ -55,12 +75,18 @@ func ToDocumentId(accountId, messageId string) string {
 }
 
 type GmailEntryBody struct {
-	UserId         string   `validate:"required" bson:"userId"`
-	MessageId      string   `validate:"required" bson:"messageId"`
-	PlainText      string   `bson:"plainText"`
-	Html           string   `bson:"html"`
+	UserId    string `validate:"required" bson:"userId"`
+	MessageId string `validate:"required" bson:"messageId"`
+	// the entry's HistoryId when it was fetched
+	HistoryId uint64 `json:"-" bson:"historyId,omitempty"`
+	PlainText string `bson:"plainText"`
+	Html      string `bson:"html"`
+	// the message without quoted replies or the signature. For the AI, and hiding quoted text
+	NewContent     string   `bson:"newContent"`
 	HasAttachments int      `validate:"required" bson:"hasAttachments"`
 	AttachmentIds  []string `bson:"attachmentIds"`
+	// text pulled out of pdf, docx, csv and text attachments
+	AttachmentTexts []AttachmentText `json:",omitempty" bson:"attachmentTexts,omitempty"`
 	// used in database, but not returned via API
 	AccountId string `json:"-" bson:"accountId"`
 	// For Sync + Conflict Resolution
@@ -71,6 +97,16 @@ type GmailEntryBody struct {
 	LastBatchWriteId string `json:"-" bson:"lastBatchWriteId"`
 } // @name GmailEntryBody
 
+type AttachmentText struct {
+	AttachmentId string `validate:"required" bson:"attachmentId"`
+	Filename     string `validate:"required" bson:"filename"`
+	MimeType     string `validate:"required" bson:"mimeType"`
+	Size         int64  `validate:"required" bson:"size"`
+	Text         string `validate:"required" bson:"text"`
+	// the text was cut short
+	Truncated bool `validate:"required" bson:"truncated"`
+} // @name AttachmentText
+
 func (g GmailEntryBody) ToDocumentId() string {
 	return g.AccountId + ";" + g.MessageId
 }
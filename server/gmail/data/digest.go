@@ -0,0 +1,53 @@
+package data
+
+import "time"
+
+type DigestSettings struct {
+	Enabled bool `validate:"required" json:"enabled" bson:"enabled"`
+	// also email the digest to the account, from itself
+	SendEmail bool `validate:"required" json:"sendEmail" bson:"sendEmail"`
+	// HH:MM, in TimeZone
+	DeliveryTime string `validate:"required" json:"deliveryTime" bson:"deliveryTime"`
+	// IANA name. eg. America/Vancouver
+	TimeZone string `validate:"required" json:"timeZone" bson:"timeZone"`
+	// the L1 categories to include. Empty for all
+	Categories []string `validate:"required" json:"categories" bson:"categories"`
+} // @name DigestSettings
+
+type DigestMessage struct {
+	MessageId string     `validate:"required" json:"messageId" bson:"messageId"`
+	ThreadId  string     `validate:"required" json:"threadId" bson:"threadId"`
+	Subject   string     `validate:"required" json:"subject" bson:"subject"`
+	Sender    PersonInfo `validate:"required" json:"sender" bson:"sender"`
+	// the AI summary, or the snippet if it wasn't enriched
+	Summary      string  `validate:"required" json:"summary" bson:"summary"`
+	Importance   float64 `validate:"required" json:"importance" bson:"importance"`
+	InternalDate int64   `validate:"required" json:"internalDate" bson:"internalDate"`
+} // @name DigestMessage
+
+// the messages in an L1 category, most important first
+type DigestGroup struct {
+	Category  string          `validate:"required" json:"category" bson:"category"`
+	Important []DigestMessage `validate:"required" json:"important" bson:"important"`
+	Other     []DigestMessage `validate:"required" json:"other" bson:"other"`
+} // @name DigestGroup
+
+// a summary of the mail received in a day
+type Digest struct {
+	AccountId string `json:"-" bson:"accountId"`
+	// the YYYY-MM-DD it was delivered, in the account's time zone
+	DigestId string `validate:"required" json:"digestId" bson:"digestId"`
+	// the messages received in [From, To)
+	From         time.Time     `validate:"required" json:"from" bson:"from"`
+	To           time.Time     `validate:"required" json:"to" bson:"to"`
+	MessageCount int64         `validate:"required" json:"messageCount" bson:"messageCount"`
+	Groups       []DigestGroup `validate:"required" json:"groups" bson:"groups"`
+	// when it was emailed. Missing if it wasn't
+	SentAt    *time.Time `json:"sentAt,omitempty" bson:"sentAt,omitempty"`
+	UpdatedAt time.Time  `validate:"required" json:"updatedAt" bson:"updatedAt"`
+	CreatedAt time.Time  `validate:"required" json:"createdAt" bson:"createdAt"`
+} // @name Digest
+
+func (g Digest) ToDocumentId() string {
+	return ToDocumentId(g.AccountId, g.DigestId)
+}
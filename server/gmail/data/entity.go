@@ -0,0 +1,88 @@
+package data
+
+import "time"
+
+// the kinds of records extracted from mail
+const (
+	EntityOrder    = "order"
+	EntityShipment = "shipment"
+	EntityFlight   = "flight"
+	EntityBill     = "bill"
+)
+
+type OrderEntity struct {
+	Merchant    string   `validate:"required" json:"merchant" bson:"merchant"`
+	OrderNumber string   `validate:"required" json:"orderNumber" bson:"orderNumber"`
+	Total       float64  `validate:"required" json:"total" bson:"total"`
+	Currency    string   `validate:"required" json:"currency" bson:"currency"`
+	OrderDate   string   `validate:"required" json:"orderDate" bson:"orderDate"`
+	Items       []string `validate:"required" json:"items" bson:"items"`
+} // @name OrderEntity
+
+type ShipmentEntity struct {
+	Carrier           string `validate:"required" json:"carrier" bson:"carrier"`
+	TrackingNumber    string `validate:"required" json:"trackingNumber" bson:"trackingNumber"`
+	TrackingUrl       string `validate:"required" json:"trackingUrl" bson:"trackingUrl"`
+	Status            string `validate:"required" json:"status" bson:"status"`
+	EstimatedDelivery string `validate:"required" json:"estimatedDelivery" bson:"estimatedDelivery"`
+	// the order it's for, if mentioned
+	OrderNumber string `validate:"required" json:"orderNumber" bson:"orderNumber"`
+} // @name ShipmentEntity
+
+type FlightLeg struct {
+	Airline          string `validate:"required" json:"airline" bson:"airline"`
+	FlightNumber     string `validate:"required" json:"flightNumber" bson:"flightNumber"`
+	DepartureAirport string `validate:"required" json:"departureAirport" bson:"departureAirport"`
+	ArrivalAirport   string `validate:"required" json:"arrivalAirport" bson:"arrivalAirport"`
+	DepartureTime    string `validate:"required" json:"departureTime" bson:"departureTime"`
+	ArrivalTime      string `validate:"required" json:"arrivalTime" bson:"arrivalTime"`
+} // @name FlightLeg
+
+type FlightEntity struct {
+	// the booking reference
+	Pnr        string      `validate:"required" json:"pnr" bson:"pnr"`
+	Passengers []string    `validate:"required" json:"passengers" bson:"passengers"`
+	Legs       []FlightLeg `validate:"required" json:"legs" bson:"legs"`
+} // @name FlightEntity
+
+type BillEntity struct {
+	Biller   string  `validate:"required" json:"biller" bson:"biller"`
+	Amount   float64 `validate:"required" json:"amount" bson:"amount"`
+	Currency string  `validate:"required" json:"currency" bson:"currency"`
+	DueDate  string  `validate:"required" json:"dueDate" bson:"dueDate"`
+	// eg. paid, due, overdue
+	Status string `validate:"required" json:"status" bson:"status"`
+} // @name BillEntity
+
+// a typed record pulled out of a message by the gemini service.
+// Only the field matching Type is set
+type ExtractedEntity struct {
+	AccountId string `json:"-" bson:"accountId"`
+	// the message id and type. A message has at most one of each type
+	EntityId  string `validate:"required" json:"entityId" bson:"entityId"`
+	MessageId string `validate:"required" json:"messageId" bson:"messageId"`
+	ThreadId  string `validate:"required" json:"threadId" bson:"threadId"`
+	// see EntityOrder etc.
+	Type string `validate:"required" json:"type" bson:"type"`
+	// what identifies it, for lookups. eg. the order or tracking number, or PNR
+	Key string `validate:"required" json:"key" bson:"key"`
+	// YYYY-MM-DD. eg. when the bill is due, or the first flight departs. Empty if unknown
+	Date     string          `validate:"required" json:"date" bson:"date"`
+	Order    *OrderEntity    `json:"order,omitempty" bson:"order,omitempty"`
+	Shipment *ShipmentEntity `json:"shipment,omitempty" bson:"shipment,omitempty"`
+	Flight   *FlightEntity   `json:"flight,omitempty" bson:"flight,omitempty"`
+	Bill     *BillEntity     `json:"bill,omitempty" bson:"bill,omitempty"`
+	// copied from the message
+	InternalDate int64     `validate:"required" json:"internalDate" bson:"internalDate"`
+	IsDeleted    bool      `validate:"required" json:"isDeleted" bson:"isDeleted"`
+	UpdatedAt    time.Time `validate:"required" json:"updatedAt" bson:"updatedAt"`
+	CreatedAt    time.Time `validate:"required" json:"createdAt" bson:"createdAt"`
+} // @name ExtractedEntity
+
+func EntityId(messageId, entityType string) string {
+	return messageId + "-" + entityType
+}
+
+func (g ExtractedEntity) ToDocumentId() string {
+	return ToDocumentId(g.AccountId, g.EntityId)
+}
@@ -2,12 +2,14 @@ package data
 
 import (
 	"context"
+	"errors"
 	"fromkeith/my-desktop-server/globals"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 // TODO: This should use a queue system instead of channels and a worker.
@@ -78,7 +80,7 @@ func StartWriter(ctx context.Context) {
 		case entry := <-writerQueue:
 			writeWait = append(writeWait, entry)
 			if len(writeWait) == 100 {
-				if err := BulkWriteEmails(ctx, writeWait); err != nil {
+				if err := BulkWriteEmails(ctx, writeWait, WriteOver); err != nil {
 					log.Error().
 						Ctx(ctx).
 						Err(err).
@@ -98,7 +100,7 @@ func StartWriter(ctx context.Context) {
 				modifyWait = modifyWait[:0]
 			}
 		case <-time.After(time.Second):
-			if err := BulkWriteEmails(ctx, writeWait); err != nil {
+			if err := BulkWriteEmails(ctx, writeWait, WriteOver); err != nil {
 				log.Error().
 					Ctx(ctx).
 					Err(err).
@@ -186,12 +188,39 @@ func bulkModifyEmails(ctx context.Context, entries []modifyGmailEntry) error {
 	return nil
 }
 
-func BulkWriteEmails(ctx context.Context, entries []GmailEntry) error {
+// how the bulk writers treat entries that are already stored
+type WriteMode int
+
+const (
+	// writes over whatever is stored
+	WriteOver WriteMode = iota
+	// skips entries whose revision (historyId) is already stored, or older than what is.
+	// Safe to repeat, eg. when the Kafka message that fetched them is read again
+	WriteNewer
+)
+
+func (m WriteMode) filter(id string, historyId uint64) bson.M {
+	if m == WriteNewer {
+		return newerRevision(id, historyId)
+	}
+	return bson.M{"_id": id}
+}
+
+func (m WriteMode) bulkWrite(ctx context.Context, col *mongo.Collection, models []mongo.WriteModel) error {
+	if m == WriteNewer {
+		_, err := col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return ignoreStaleRevisions(err)
+	}
+	_, err := col.BulkWrite(ctx, models)
+	return err
+}
+
+func BulkWriteEmails(ctx context.Context, entries []GmailEntry, mode WriteMode) error {
 	if len(entries) == 0 {
 		return nil
 	}
 	// bulk writes the entries to mongoDB
-	// updates/writes over existing entries.
+	// updates/writes over existing entries, unless mode says otherwise.
 	batchWriteModels := make([]mongo.WriteModel, 0, len(entries))
 	for _, entry := range entries {
 		// if updating.. needs to increment the version in the database
@@ -204,7 +233,7 @@ func BulkWriteEmails(ctx context.Context, entries []GmailEntry) error {
 		delete(doc, "createdAt") // let $setOnInsert handle this
 
 		batchWriteModels = append(batchWriteModels, mongo.NewUpdateOneModel().
-			SetFilter(bson.M{"_id": entry.ToDocumentId()}).
+			SetFilter(mode.filter(entry.ToDocumentId(), entry.HistoryId)).
 			SetUpdate(bson.M{
 				"$set":         doc,
 				"$currentDate": bson.M{"updatedAt": true},
@@ -217,9 +246,32 @@ func BulkWriteEmails(ctx context.Context, entries []GmailEntry) error {
 		)
 	}
 	col := globals.DocDb().Collection("Messages")
-	if _, err := col.BulkWrite(ctx, batchWriteModels); err != nil {
+	return mode.bulkWrite(ctx, col, batchWriteModels)
+}
+
+// matches the document only if it's at an older revision. If it's at this one or newer,
+// the upsert tries to insert it again and fails with a duplicate key, which ignoreStaleRevisions drops
+func newerRevision(id string, historyId uint64) bson.M {
+	return bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"historyId": bson.M{"$lt": historyId}},
+			bson.M{"historyId": bson.M{"$exists": false}},
+		},
+	}
+}
+
+// nil if the only errors are the duplicate keys of revisions that were already written
+func ignoreStaleRevisions(err error) error {
+	var bulkErr mongo.BulkWriteException
+	if err == nil || !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
 		return err
 	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -235,7 +287,7 @@ func StartBodyWriter(ctx context.Context) {
 		case entry := <-bodyQueue:
 			writeWait = append(writeWait, entry)
 			if len(writeWait) == 100 {
-				if err := BulkWriteEmailBodies(ctx, writeWait); err != nil {
+				if err := BulkWriteEmailBodies(ctx, writeWait, WriteOver); err != nil {
 					log.Error().
 						Ctx(ctx).
 						Err(err).
@@ -244,7 +296,7 @@ func StartBodyWriter(ctx context.Context) {
 				writeWait = writeWait[:0]
 			}
 		case <-time.After(time.Second):
-			if err := BulkWriteEmailBodies(ctx, writeWait); err != nil {
+			if err := BulkWriteEmailBodies(ctx, writeWait, WriteOver); err != nil {
 				log.Error().
 					Ctx(ctx).
 					Err(err).
@@ -257,12 +309,13 @@ func StartBodyWriter(ctx context.Context) {
 	}
 }
 
-func BulkWriteEmailBodies(ctx context.Context, entries []GmailEntryBody) error {
+// keyed by the entry's revision the same as BulkWriteEmails
+func BulkWriteEmailBodies(ctx context.Context, entries []GmailEntryBody, mode WriteMode) error {
 	if len(entries) == 0 {
 		return nil
 	}
 	// bulk writes the entries to mongoDB
-	// updates/writes over existing entries.
+	// updates/writes over existing entries, unless mode says otherwise.
 	batchWriteModels := make([]mongo.WriteModel, 0, len(entries))
 	for _, entry := range entries {
 		// if updating.. needs to increment the version in the database
@@ -273,7 +326,7 @@ func BulkWriteEmailBodies(ctx context.Context, entries []GmailEntryBody) error {
 		delete(doc, "revisionCount")
 		delete(doc, "createdAt") // let $setOnInsert handle this
 		batchWriteModels = append(batchWriteModels, mongo.NewUpdateOneModel().
-			SetFilter(bson.M{"_id": entry.ToDocumentId()}).
+			SetFilter(mode.filter(entry.ToDocumentId(), entry.HistoryId)).
 			SetUpdate(bson.M{
 				"$set":         doc,
 				"$currentDate": bson.M{"updatedAt": true},
@@ -286,10 +339,7 @@ func BulkWriteEmailBodies(ctx context.Context, entries []GmailEntryBody) error {
 		)
 	}
 	col := globals.DocDb().Collection("MessageBodies")
-	if _, err := col.BulkWrite(ctx, batchWriteModels); err != nil {
-		return err
-	}
-	return nil
+	return mode.bulkWrite(ctx, col, batchWriteModels)
 }
 
 func BulkWriteEmailSummaries(ctx context.Context, entries []EmailSummaryEmbedding) error {
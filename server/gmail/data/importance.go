@@ -0,0 +1,19 @@
+package data
+
+import "time"
+
+// an account's learned importance model. A logistic regression over the message's features
+type ImportanceWeights struct {
+	AccountId string             `json:"-" bson:"accountId"`
+	Bias      float64            `json:"bias" bson:"bias"`
+	Weights   map[string]float64 `json:"weights" bson:"weights"`
+	// number of feedback updates. Also used to detect concurrent updates
+	Updates   int64     `json:"updates" bson:"updates"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+} // @name ImportanceWeights
+
+// one per account, so it's keyed by the account
+func (g ImportanceWeights) ToDocumentId() string {
+	return g.AccountId
+}
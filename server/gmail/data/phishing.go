@@ -0,0 +1,33 @@
+package data
+
+import "time"
+
+const (
+	// the display name is a contact's, but the address isn't one of theirs
+	PhishingDisplayName = "displayName"
+	// the sender's domain is a letter or two off a domain we correspond with
+	PhishingLookalike = "lookalikeDomain"
+	// the sender's domain looks the same as one we correspond with, using similar characters
+	PhishingHomoglyph = "homoglyphDomain"
+	// a link's text is a different site than where it goes
+	PhishingLinkMismatch = "linkMismatch"
+	// the sender failed DMARC, so the From address is likely forged
+	PhishingDmarcFailed = "dmarcFailed"
+)
+
+// why a message looks like phishing
+type PhishingReason struct {
+	// eg. displayName, lookalikeDomain
+	Type string `validate:"required" json:"type" bson:"type"`
+	// what was found. eg. "paypa1.com looks like paypal.com"
+	Detail string `validate:"required" json:"detail" bson:"detail"`
+} // @name PhishingReason
+
+type PhishingRisk struct {
+	// 0-1, higher is riskier
+	Score   float64          `validate:"required" json:"score" bson:"score"`
+	Reasons []PhishingReason `validate:"required" json:"reasons" bson:"reasons"`
+	// the score is high enough to warn about
+	Warning    bool      `validate:"required" json:"warning" bson:"warning"`
+	AnalyzedAt time.Time `validate:"required" json:"analyzedAt" bson:"analyzedAt"`
+} // @name PhishingRisk
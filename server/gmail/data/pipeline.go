@@ -5,6 +5,10 @@ type EmailInjestPayload struct {
 	MessageId string
 	AccountId string
 	UserId    string
+	// loaded when the account signed up, rather than a new message
+	Bootstrap bool `json:",omitempty"`
+	// write it even if this revision is already stored, eg. a re-injest
+	Force bool `json:",omitempty"`
 }
 
 // output of email-injestor. Kafka topic:email_injest_available
@@ -12,4 +16,8 @@ type EmailInjestedPayload struct {
 	MessageId string
 	AccountId string
 	Entry     GmailEntry
+	// set when re-queued by a backfill job, rather than a new message
+	BackfillJobId string `json:",omitempty"`
+	// see EmailInjestPayload.Bootstrap
+	Bootstrap bool `json:",omitempty"`
 }
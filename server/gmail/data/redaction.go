@@ -0,0 +1,8 @@
+package data
+
+// sensitive values masked before the message was sent to the LLM
+type Redaction struct {
+	// eg. creditCard, phone
+	Type  string `validate:"required" json:"type" bson:"type"`
+	Count int    `validate:"required" json:"count" bson:"count"`
+} // @name Redaction
@@ -0,0 +1,38 @@
+package data
+
+import "time"
+
+// the filters of a saved search. Empty fields are ignored
+type SavedSearchQuery struct {
+	// matches any of these categories
+	Categories []string `json:"categories" bson:"categories"`
+	// matches any of these tags
+	Tags []string `json:"tags" bson:"tags"`
+	// must have all of these gmail labels
+	Labels []string `json:"labels" bson:"labels"`
+	// from any of these email addresses. eg. a VIP list
+	Senders []string `json:"senders" bson:"senders"`
+	TopicId string   `json:"topicId" bson:"topicId"`
+	// case insensitive match on the subject or snippet
+	Text string `json:"text" bson:"text"`
+	// only messages received in the last N days
+	WithinDays int  `json:"withinDays" bson:"withinDays"`
+	UnreadOnly bool `json:"unreadOnly" bson:"unreadOnly"`
+} // @name SavedSearchQuery
+
+type SavedSearch struct {
+	AccountId   string           `json:"-" bson:"accountId"`
+	SearchId    string           `validate:"required" json:"searchId" bson:"searchId"`
+	Name        string           `validate:"required" json:"name" bson:"name"`
+	Query       SavedSearchQuery `validate:"required" json:"query" bson:"query"`
+	TotalCount  int64            `validate:"required" json:"totalCount" bson:"totalCount"`
+	UnreadCount int64            `validate:"required" json:"unreadCount" bson:"unreadCount"`
+	CountedAt   time.Time        `validate:"required" json:"countedAt" bson:"countedAt"`
+	IsDeleted   bool             `validate:"required" json:"isDeleted" bson:"isDeleted"`
+	UpdatedAt   time.Time        `validate:"required" json:"updatedAt" bson:"updatedAt"`
+	CreatedAt   time.Time        `validate:"required" json:"createdAt" bson:"createdAt"`
+} // @name SavedSearch
+
+func (g SavedSearch) ToDocumentId() string {
+	return ToDocumentId(g.AccountId, g.SearchId)
+}
@@ -0,0 +1,34 @@
+package data
+
+const (
+	// DMARC passed, or SPF or DKIM passed for the From domain
+	SenderAuthVerified = "verified"
+	// nothing passed for the From domain, but nothing failed either
+	SenderAuthUnverified = "unverified"
+	// the From domain is likely forged
+	SenderAuthFailed = "failed"
+)
+
+// the SPF, DKIM, DMARC and ARC verdicts from the receiving server's Authentication-Results
+type SenderAuth struct {
+	// pass, fail, softfail, neutral, none, temperror or permerror. Empty if it wasn't checked
+	Spf string `json:"spf" bson:"spf"`
+	// the envelope sender's domain, that SPF checked
+	SpfDomain string `json:"spfDomain" bson:"spfDomain"`
+	// pass, fail, neutral, none, policy, temperror or permerror. Empty if it wasn't checked
+	Dkim string `json:"dkim" bson:"dkim"`
+	// the domain that signed the message
+	DkimDomain string `json:"dkimDomain" bson:"dkimDomain"`
+	// pass, fail, bestguesspass, none, temperror or permerror. Empty if it wasn't checked
+	Dmarc string `json:"dmarc" bson:"dmarc"`
+	// the From domain's published policy. none, quarantine or reject
+	DmarcPolicy string `json:"dmarcPolicy" bson:"dmarcPolicy"`
+	// the forwarding chain, eg. through a mailing list. Empty if it wasn't forwarded
+	Arc string `json:"arc" bson:"arc"`
+	// the domain of the From header
+	FromDomain string `validate:"required" json:"fromDomain" bson:"fromDomain"`
+	// SPF or DKIM passed for the From domain, or another domain of the same organization
+	Aligned bool `validate:"required" json:"aligned" bson:"aligned"`
+	// verified, unverified or failed
+	Verdict string `validate:"required" json:"verdict" bson:"verdict"`
+} // @name SenderAuth
@@ -0,0 +1,25 @@
+package data
+
+import "time"
+
+// per account preferences
+type AccountSettings struct {
+	AccountId string `json:"-" bson:"accountId"`
+	// how AI drafted replies should sound. eg. "friendly, short, no exclamation marks"
+	ReplyTone string `json:"replyTone" bson:"replyTone"`
+	// appended to AI drafted replies
+	Signature string `json:"signature" bson:"signature"`
+	// how strictly to mask sensitive values before mail is sent to the LLM. off, standard or strict
+	RedactionLevel string `json:"redactionLevel" bson:"redactionLevel"`
+	// ISO 639-1 code messages are translated to. eg. en
+	Language string `json:"language" bson:"language"`
+	// when and what the daily digest covers
+	Digest    DigestSettings `validate:"required" json:"digest" bson:"digest"`
+	UpdatedAt time.Time      `validate:"required" json:"updatedAt" bson:"updatedAt"`
+	CreatedAt time.Time      `validate:"required" json:"createdAt" bson:"createdAt"`
+} // @name AccountSettings
+
+// one per account, so it's keyed by the account
+func (g AccountSettings) ToDocumentId() string {
+	return g.AccountId
+}
@@ -0,0 +1,24 @@
+package data
+
+import "time"
+
+// an L1 category, and its L2 sub categories
+type TaxonomyCategory struct {
+	Name          string   `validate:"required" json:"name" bson:"name"`
+	Subcategories []string `validate:"required" json:"subcategories" bson:"subcategories"`
+} // @name TaxonomyCategory
+
+// the categories the AI picks from when categorizing an account's emails
+type AccountTaxonomy struct {
+	AccountId  string             `json:"-" bson:"accountId"`
+	Categories []TaxonomyCategory `validate:"required" json:"categories" bson:"categories"`
+	// bumped on every change
+	Version   int64     `validate:"required" json:"version" bson:"version"`
+	UpdatedAt time.Time `validate:"required" json:"updatedAt" bson:"updatedAt"`
+	CreatedAt time.Time `validate:"required" json:"createdAt" bson:"createdAt"`
+} // @name AccountTaxonomy
+
+// one per account, so it's keyed by the account
+func (g AccountTaxonomy) ToDocumentId() string {
+	return g.AccountId
+}
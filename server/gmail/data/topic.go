@@ -0,0 +1,21 @@
+package data
+
+import "time"
+
+// a group of similar messages found by clustering the summary embeddings
+type Topic struct {
+	AccountId        string    `json:"-" bson:"accountId"`
+	TopicId          string    `validate:"required" json:"topicId" bson:"topicId"`
+	Label            string    `validate:"required" json:"label" bson:"label"`
+	Description      string    `validate:"required" json:"description" bson:"description"`
+	MessageCount     int64     `validate:"required" json:"messageCount" bson:"messageCount"`
+	SampleMessageIds []string  `validate:"required" json:"sampleMessageIds" bson:"sampleMessageIds"`
+	Centroid         []float32 `json:"-" bson:"centroid"`
+	IsDeleted        bool      `validate:"required" json:"isDeleted" bson:"isDeleted"`
+	UpdatedAt        time.Time `validate:"required" json:"updatedAt" bson:"updatedAt"`
+	CreatedAt        time.Time `validate:"required" json:"createdAt" bson:"createdAt"`
+} // @name Topic
+
+func (g Topic) ToDocumentId() string {
+	return ToDocumentId(g.AccountId, g.TopicId)
+}
@@ -0,0 +1,20 @@
+package data
+
+import "time"
+
+// a message body translated by the LLM. Cached per message and language
+type MessageTranslation struct {
+	AccountId string `json:"-" bson:"accountId"`
+	MessageId string `validate:"required" json:"messageId" bson:"messageId"`
+	// ISO 639-1 code the body was translated to
+	Language string `validate:"required" json:"language" bson:"language"`
+	// the language the model found the body was in
+	SourceLanguage string `validate:"required" json:"sourceLanguage" bson:"sourceLanguage"`
+	// markdown, like the body it was translated from
+	Text      string    `validate:"required" json:"text" bson:"text"`
+	CreatedAt time.Time `validate:"required" json:"createdAt" bson:"createdAt"`
+} // @name MessageTranslation
+
+func (g MessageTranslation) ToDocumentId() string {
+	return ToDocumentId(g.AccountId, g.MessageId) + ";" + g.Language
+}
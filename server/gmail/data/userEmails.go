@@ -0,0 +1,25 @@
+package data
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"strings"
+)
+
+// the email addresses that belong to the account, lowercased
+func AccountEmails(ctx context.Context, accountId string) ([]string, error) {
+	rows, err := globals.Db().Query(ctx, `SELECT emailAddress FROM UserEmails WHERE accountId = $1`, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	emails := make([]string, 0)
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		emails = append(emails, strings.ToLower(email))
+	}
+	return emails, rows.Err()
+}
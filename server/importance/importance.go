@@ -0,0 +1,286 @@
+package importance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	FeatureImportant = "important"
+	// we're in the To
+	FeatureDirect = "direct"
+	// we're only in the Cc
+	FeatureCc = "cc"
+	// how often we've emailed the sender
+	FeatureSentTo = "sentTo"
+	// how often the sender emails us
+	FeatureReceivedFrom = "receivedFrom"
+	// the sender is in our contacts
+	FeatureContact = "contact"
+	// one per AI category. eg. "category:billing & payments"
+	categoryPrefix = "category:"
+
+	learningRate = 0.1
+	// scores that moved less than this aren't re-written
+	minScoreChange = 0.001
+	// how many times to retry a weight update that raced another
+	maxLearnAttempts = 3
+	// how many of the latest messages are rescored when the weights change
+	rescoreRecent = 1000
+)
+
+// user feedback, and the importance it implies
+var Feedback = map[string]float64{
+	"replied":        1,
+	"opened":         0.7,
+	"archivedUnread": 0,
+}
+
+var ErrUnknownFeedback = errors.New("unknown feedback")
+
+// used until the account gives feedback
+func DefaultWeights(accountId string) data.ImportanceWeights {
+	return data.ImportanceWeights{
+		AccountId: accountId,
+		Bias:      -1.5,
+		Weights: map[string]float64{
+			FeatureImportant:    2,
+			FeatureDirect:       0.8,
+			FeatureCc:           -0.3,
+			FeatureSentTo:       2,
+			FeatureReceivedFrom: 0.5,
+			FeatureContact:      1,
+		},
+	}
+}
+
+func LoadWeights(ctx context.Context, accountId string) (*data.ImportanceWeights, error) {
+	weights := DefaultWeights(accountId)
+	err := globals.DocDb().Collection("ImportanceWeights").FindOne(ctx, bson.M{"_id": weights.ToDocumentId()}).Decode(&weights)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return &weights, nil
+}
+
+// 0-1, higher is more important
+func Score(weights data.ImportanceWeights, features map[string]float64) float64 {
+	z := weights.Bias
+	for k, v := range features {
+		z += weights.Weights[k] * v
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// scales a count into 0-1, flattening out around max
+func logScale(count int64, max float64) float64 {
+	return math.Min(1, math.Log1p(float64(count))/math.Log1p(max))
+}
+
+// feature names are used as mongo keys, which can't have dots or start with $
+func featureKey(name string) string {
+	return strings.NewReplacer(".", "_", "$", "_").Replace(name)
+}
+
+// the features of the message used to score it
+func ComputeFeatures(ctx context.Context, entry data.GmailEntry, myEmails []string) (map[string]float64, error) {
+	features := make(map[string]float64)
+	if slices.Contains(entry.Labels, "IMPORTANT") {
+		features[FeatureImportant] = 1
+	}
+	isMe := func(p data.PersonInfo) bool {
+		return slices.Contains(myEmails, strings.ToLower(p.Email))
+	}
+	if slices.ContainsFunc(entry.Receiver, isMe) {
+		features[FeatureDirect] = 1
+	} else if slices.ContainsFunc(entry.AdditionalReceivers["cc"], isMe) {
+		features[FeatureCc] = 1
+	}
+	for _, cat := range entry.Categories {
+		features[featureKey(categoryPrefix+cat)] = 1
+	}
+
+	sender := entry.Sender.Email
+	if sender == "" || isMe(entry.Sender) {
+		return features, nil
+	}
+	messages := globals.DocDb().Collection("Messages")
+	sentTo, err := messages.CountDocuments(ctx, bson.M{
+		"accountId":      entry.AccountId,
+		"labels":         "SENT",
+		"receiver.email": sender,
+	})
+	if err != nil {
+		return nil, err
+	}
+	features[FeatureSentTo] = logScale(sentTo, 20)
+	receivedFrom, err := messages.CountDocuments(ctx, bson.M{
+		"accountId":    entry.AccountId,
+		"sender.email": sender,
+	})
+	if err != nil {
+		return nil, err
+	}
+	features[FeatureReceivedFrom] = logScale(receivedFrom, 50)
+	contacts, err := globals.DocDb().Collection("People").CountDocuments(ctx, bson.M{
+		"accountId":                   entry.AccountId,
+		"person.emailaddresses.value": sender,
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return nil, err
+	}
+	if contacts > 0 {
+		features[FeatureContact] = 1
+	}
+	return features, nil
+}
+
+// recomputes and saves the message's score. Only writes if it changed.
+func Rescore(ctx context.Context, entry data.GmailEntry, myEmails []string) error {
+	features, err := ComputeFeatures(ctx, entry, myEmails)
+	if err != nil {
+		return err
+	}
+	weights, err := LoadWeights(ctx, entry.AccountId)
+	if err != nil {
+		return err
+	}
+	score := Score(*weights, features)
+	if entry.Importance != nil && math.Abs(*entry.Importance-score) < minScoreChange && maps.Equal(entry.ImportanceFeatures, features) {
+		return nil
+	}
+	// bump the revision so clients pull the new score
+	_, err = globals.DocDb().Collection("Messages").UpdateOne(ctx, bson.M{"_id": entry.ToDocumentId()}, bson.M{
+		"$set": bson.M{
+			"importance":         score,
+			"importanceFeatures": features,
+		},
+		"$currentDate": bson.M{"updatedAt": true},
+		"$inc":         bson.M{"revisionCount": 1},
+	})
+	return err
+}
+
+// one step of gradient descent towards the feedback's importance.
+// Only learns once per feedback type, per message.
+func Learn(ctx context.Context, entry data.GmailEntry, feedback string) error {
+	target, ok := Feedback[feedback]
+	if !ok {
+		return ErrUnknownFeedback
+	}
+	if entry.ImportanceFeatures == nil || slices.Contains(entry.ImportanceFeedback, feedback) {
+		return nil // not scored yet, or already learned
+	}
+	col := globals.DocDb().Collection("ImportanceWeights")
+	for range maxLearnAttempts {
+		weights, err := LoadWeights(ctx, entry.AccountId)
+		if err != nil {
+			return err
+		}
+		gradient := target - Score(*weights, entry.ImportanceFeatures)
+		next := maps.Clone(weights.Weights)
+		for k, v := range entry.ImportanceFeatures {
+			next[k] += learningRate * gradient * v
+		}
+		// only apply if nobody else updated the weights since we loaded them
+		_, err = col.UpdateOne(ctx,
+			bson.M{"_id": weights.ToDocumentId(), "updates": weights.Updates},
+			bson.M{
+				"$set": bson.M{
+					"accountId": weights.AccountId,
+					"bias":      weights.Bias + learningRate*gradient,
+					"weights":   next,
+					"updates":   weights.Updates + 1,
+				},
+				"$currentDate": bson.M{"updatedAt": true},
+				"$setOnInsert": bson.M{"createdAt": time.Now().UTC()},
+			},
+			options.UpdateOne().SetUpsert(true),
+		)
+		if mongo.IsDuplicateKeyError(err) {
+			continue // raced. the upsert tried to insert a second doc
+		}
+		if err != nil {
+			return err
+		}
+		_, err = globals.DocDb().Collection("Messages").UpdateOne(ctx,
+			bson.M{"_id": entry.ToDocumentId()},
+			bson.M{"$addToSet": bson.M{"importanceFeedback": feedback}},
+		)
+		if err != nil {
+			return err
+		}
+		// so the priority inbox reflects what we learned
+		if err := RescoreRecent(ctx, entry.AccountId); err != nil {
+			return fmt.Errorf("rescoring recent messages: %w", err)
+		}
+		return nil
+	}
+	return errors.New("failed to update importance weights, too much contention")
+}
+
+// rescores the account's latest messages with its current weights.
+// Their features don't change with the weights, so the saved ones are reused
+func RescoreRecent(ctx context.Context, accountId string) error {
+	weights, err := LoadWeights(ctx, accountId)
+	if err != nil {
+		return err
+	}
+	col := globals.DocDb().Collection("Messages")
+	cursor, err := col.Find(ctx,
+		bson.M{
+			"accountId":          accountId,
+			"isDeleted":          bson.M{"$ne": true},
+			"importanceFeatures": bson.M{"$exists": true},
+		},
+		options.Find().
+			SetSort(bson.D{{"internalDate", -1}}).
+			SetLimit(rescoreRecent).
+			SetProjection(bson.M{"importance": 1, "importanceFeatures": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var messages []struct {
+		Id                 string             `bson:"_id"`
+		Importance         *float64           `bson:"importance"`
+		ImportanceFeatures map[string]float64 `bson:"importanceFeatures"`
+	}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return err
+	}
+	toWrite := make([]mongo.WriteModel, 0)
+	for _, m := range messages {
+		score := Score(*weights, m.ImportanceFeatures)
+		if m.Importance != nil && math.Abs(*m.Importance-score) < minScoreChange {
+			continue
+		}
+		// bump the revision so clients pull the new score
+		toWrite = append(toWrite, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": m.Id}).
+			SetUpdate(bson.M{
+				"$set":         bson.M{"importance": score},
+				"$currentDate": bson.M{"updatedAt": true},
+				"$inc":         bson.M{"revisionCount": 1},
+			}),
+		)
+	}
+	if len(toWrite) == 0 {
+		return nil
+	}
+	_, err = col.BulkWrite(ctx, toWrite, options.BulkWrite().SetOrdered(false))
+	return err
+}
@@ -0,0 +1,181 @@
+package llm
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/genai"
+)
+
+const (
+	geminiDefaultModel      = "gemini-flash-latest"
+	geminiDefaultEmbedModel = "gemini-embedding-001"
+)
+
+type geminiProvider struct {
+	client *genai.Client
+	cfg    Config
+}
+
+// uses the Gemini api. The api key is read from env by genai.
+func NewGemini(client *genai.Client, cfg Config) Provider {
+	return &geminiProvider{client: client, cfg: cfg}
+}
+
+func (g *geminiProvider) Name() string {
+	return "gemini"
+}
+
+func (g *geminiProvider) Model() string {
+	model, _ := pickModel("", g.cfg.Model, geminiDefaultModel)
+	return model
+}
+
+func (g *geminiProvider) EmbedModel() string {
+	model, _ := pickModel("", g.cfg.EmbedModel, geminiDefaultEmbedModel)
+	return model
+}
+
+func (g *geminiProvider) GenerateJSON(ctx context.Context, req JSONRequest) (*JSONResponse, error) {
+	model, err := pickModel(req.Model, g.cfg.Model, geminiDefaultModel)
+	if err != nil {
+		return nil, err
+	}
+	result, err := g.client.Models.GenerateContent(ctx, model, genai.Text(req.Prompt), generateConfig(req))
+	if err != nil {
+		return nil, err
+	}
+	return jsonResponse(result), nil
+}
+
+func generateConfig(req JSONRequest) *genai.GenerateContentConfig {
+	config := &genai.GenerateContentConfig{
+		ResponseMIMEType:   "application/json",
+		ResponseJsonSchema: req.Schema,
+	}
+	if req.System != "" {
+		config.SystemInstruction = &genai.Content{
+			Parts: []*genai.Part{{
+				Text: req.System,
+			}},
+		}
+	}
+	return config
+}
+
+func jsonResponse(result *genai.GenerateContentResponse) *JSONResponse {
+	res := &JSONResponse{Text: result.Text()}
+	if result.UsageMetadata != nil {
+		res.Usage = Usage{
+			PromptTokens: int64(result.UsageMetadata.PromptTokenCount),
+			// thinking is billed as output
+			OutputTokens: int64(result.UsageMetadata.CandidatesTokenCount + result.UsageMetadata.ThoughtsTokenCount),
+		}
+	}
+	return res
+}
+
+// https://ai.google.dev/gemini-api/docs/batch-mode
+// The requests are sent inline, so they must be under 20MB together. All of them use the first one's model
+func (g *geminiProvider) CreateBatch(ctx context.Context, displayName string, reqs []JSONRequest) (string, error) {
+	if len(reqs) == 0 {
+		return "", errors.New("llm: empty batch")
+	}
+	model, err := pickModel(reqs[0].Model, g.cfg.Model, geminiDefaultModel)
+	if err != nil {
+		return "", err
+	}
+	inlined := make([]*genai.InlinedRequest, 0, len(reqs))
+	for _, req := range reqs {
+		inlined = append(inlined, &genai.InlinedRequest{
+			Contents: genai.Text(req.Prompt),
+			Config:   generateConfig(req),
+		})
+	}
+	job, err := g.client.Batches.Create(ctx, model, &genai.BatchJobSource{
+		InlinedRequests: inlined,
+	}, &genai.CreateBatchJobConfig{
+		DisplayName: displayName,
+	})
+	if err != nil {
+		return "", err
+	}
+	return job.Name, nil
+}
+
+func (g *geminiProvider) GetBatch(ctx context.Context, name string) (*BatchJob, error) {
+	job, err := g.client.Batches.Get(ctx, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := &BatchJob{Name: job.Name, State: BatchPending}
+	switch job.State {
+	case genai.JobStateSucceeded, genai.JobStatePartiallySucceeded:
+		res.State = BatchSucceeded
+	case genai.JobStateFailed, genai.JobStateCancelled, genai.JobStateExpired:
+		res.State = BatchFailed
+		if job.Error != nil {
+			res.Error = job.Error.Message
+		} else {
+			res.Error = string(job.State)
+		}
+		return res, nil
+	default:
+		return res, nil
+	}
+	if job.Dest == nil {
+		return res, nil
+	}
+	res.Responses = make([]BatchResponse, 0, len(job.Dest.InlinedResponses))
+	for _, inlined := range job.Dest.InlinedResponses {
+		switch {
+		case inlined.Error != nil:
+			res.Responses = append(res.Responses, BatchResponse{Error: inlined.Error.Message})
+		case inlined.Response == nil:
+			res.Responses = append(res.Responses, BatchResponse{Error: "no response"})
+		default:
+			res.Responses = append(res.Responses, BatchResponse{Response: jsonResponse(inlined.Response)})
+		}
+	}
+	return res, nil
+}
+
+func (g *geminiProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) (*EmbedResponse, error) {
+	model, err := pickModel(opts.Model, g.cfg.EmbedModel, geminiDefaultEmbedModel)
+	if err != nil {
+		return nil, err
+	}
+	contents := make([]*genai.Content, 0, len(texts))
+	for _, text := range texts {
+		contents = append(contents, genai.NewContentFromText(text, genai.RoleUser))
+	}
+	config := &genai.EmbedContentConfig{
+		TaskType: opts.TaskType,
+	}
+	if opts.Dimensions > 0 {
+		config.OutputDimensionality = &opts.Dimensions
+	}
+	// https://ai.google.dev/gemini-api/docs/embeddings
+	result, err := g.client.Models.EmbedContent(ctx, model, contents, config)
+	if err != nil {
+		return nil, err
+	}
+	res := &EmbedResponse{
+		Vectors: make([][]float32, 0, len(result.Embeddings)),
+		Model:   model,
+	}
+	for _, embd := range result.Embeddings {
+		res.Vectors = append(res.Vectors, embd.Values)
+		// only vertex reports token counts
+		if embd.Statistics != nil {
+			res.Usage.PromptTokens += int64(embd.Statistics.TokenCount)
+		}
+	}
+	if err := checkDimensions(res.Vectors, opts.Dimensions); err != nil {
+		return nil, err
+	}
+	if res.Usage.PromptTokens == 0 {
+		res.Usage.PromptTokens = EstimateTokens(texts...)
+	}
+	return res, nil
+}
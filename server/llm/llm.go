@@ -0,0 +1,255 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Provider is a backend that can produce structured output and embeddings.
+// Pick one with LLM_PROVIDER, see FromEnv.
+type Provider interface {
+	// Name of the provider. eg. "gemini"
+	Name() string
+	// the model used when a request doesn't pick one
+	Model() string
+	// the embedding model used when a request doesn't pick one
+	EmbedModel() string
+	// generates a JSON document that matches req.Schema
+	GenerateJSON(ctx context.Context, req JSONRequest) (*JSONResponse, error)
+	// embeds each text. The vectors are in the same order as texts
+	Embed(ctx context.Context, texts []string, opts EmbedOptions) (*EmbedResponse, error)
+}
+
+// BatchProvider is a Provider that can also run many requests as one job.
+// Slower, but cheaper and without the per-minute limits. Check for it with a type assertion
+type BatchProvider interface {
+	Provider
+	// starts a job for the requests. Returns the job's name, for GetBatch
+	CreateBatch(ctx context.Context, displayName string, reqs []JSONRequest) (string, error)
+	// the job's progress. Responses are filled in once it succeeded
+	GetBatch(ctx context.Context, name string) (*BatchJob, error)
+}
+
+const (
+	BatchPending   = "pending"
+	BatchSucceeded = "succeeded"
+	// failed, cancelled or expired. None of the requests were run
+	BatchFailed = "failed"
+)
+
+type BatchJob struct {
+	Name string
+	// pending, succeeded or failed
+	State string
+	// why it failed
+	Error string
+	// in the order of the requests
+	Responses []BatchResponse
+}
+
+type BatchResponse struct {
+	// nil if this request failed
+	Response *JSONResponse
+	Error    string
+}
+
+type JSONRequest struct {
+	// empty uses the provider's default model
+	Model  string
+	System string
+	Prompt string
+	// JSON schema of the expected result
+	Schema map[string]any
+}
+
+type JSONResponse struct {
+	Text  string
+	Usage Usage
+}
+
+type Usage struct {
+	PromptTokens int64
+	OutputTokens int64
+}
+
+type EmbedResponse struct {
+	Vectors [][]float32
+	// the model that made the embeddings
+	Model string
+	// only PromptTokens is filled in. Estimated when the provider doesn't report it
+	Usage Usage
+}
+
+// a rough token count, for providers that don't report usage
+func EstimateTokens(texts ...string) int64 {
+	var chars int64
+	for _, text := range texts {
+		chars += int64(len(text))
+	}
+	// ~4 characters per token for english
+	return (chars + 3) / 4
+}
+
+type EmbedOptions struct {
+	// empty uses the provider's default embedding model
+	Model string
+	// a hint for providers that support it. eg. "CLUSTERING"
+	TaskType string
+	// 0 uses the model's default
+	Dimensions int32
+}
+
+type Config struct {
+	// "gemini", "openai" or "ollama"
+	Provider   string
+	BaseUrl    string
+	ApiKey     string
+	Model      string
+	EmbedModel string
+	Timeout    time.Duration
+	// tries per call, counting the first. Only transient errors are retried, see Retryable
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// the longest wait a server can ask for, eg. with Retry-After. Longer gives up, so the caller can try later
+	RetryAfterMaxDelay time.Duration
+	// calls per second across the process. 0 is unlimited
+	RequestsPerSecond float64
+	// calls allowed at once after being idle
+	Burst int
+	// the dimensions of stored embeddings. Must match the vs_message_summaries index
+	EmbedDimensions int32
+}
+
+// reads the config from env:
+//
+//	LLM_PROVIDER - gemini (default), openai or ollama
+//	LLM_BASE_URL - the api root. eg. http://localhost:11434 for ollama
+//	LLM_API_KEY - sent as a bearer token to openai compatible apis
+//	LLM_MODEL - model used for generation
+//	LLM_EMBED_MODEL - model used for embeddings
+//	LLM_TIMEOUT_SECONDS - http timeout, defaults to 120
+//	LLM_MAX_ATTEMPTS - tries per call on quota, overloaded and timeout errors, defaults to 5
+//	LLM_MAX_RETRY_AFTER_SECONDS - longest wait a server can ask for before a retry, defaults to 300
+//	LLM_REQUESTS_PER_SECOND - limits calls from the process, defaults to unlimited
+//	LLM_BURST - calls allowed at once under the limit, defaults to 1
+//	LLM_EMBED_DIMENSIONS - dimensions of stored embeddings, defaults to 3072. Must match the vector search index
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Provider:           os.Getenv("LLM_PROVIDER"),
+		BaseUrl:            os.Getenv("LLM_BASE_URL"),
+		ApiKey:             os.Getenv("LLM_API_KEY"),
+		Model:              os.Getenv("LLM_MODEL"),
+		EmbedModel:         os.Getenv("LLM_EMBED_MODEL"),
+		Timeout:            120 * time.Second,
+		MaxAttempts:        5,
+		RetryBaseDelay:     time.Second,
+		RetryMaxDelay:      time.Minute,
+		RetryAfterMaxDelay: 5 * time.Minute,
+		Burst:              1,
+		EmbedDimensions:    3072,
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = "gemini"
+	}
+	if secs, err := strconv.Atoi(os.Getenv("LLM_TIMEOUT_SECONDS")); err == nil && secs > 0 {
+		cfg.Timeout = time.Duration(secs) * time.Second
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("LLM_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		cfg.MaxAttempts = attempts
+	}
+	if secs, err := strconv.Atoi(os.Getenv("LLM_MAX_RETRY_AFTER_SECONDS")); err == nil && secs > 0 {
+		cfg.RetryAfterMaxDelay = time.Duration(secs) * time.Second
+	}
+	if rate, err := strconv.ParseFloat(os.Getenv("LLM_REQUESTS_PER_SECOND"), 64); err == nil && rate > 0 {
+		cfg.RequestsPerSecond = rate
+	}
+	if burst, err := strconv.Atoi(os.Getenv("LLM_BURST")); err == nil && burst > 0 {
+		cfg.Burst = burst
+	}
+	if dims, err := strconv.ParseInt(os.Getenv("LLM_EMBED_DIMENSIONS"), 10, 32); err == nil && dims > 0 {
+		cfg.EmbedDimensions = int32(dims)
+	}
+	return cfg
+}
+
+var ErrNoModel = errors.New("llm: no model configured")
+
+// the model returned embeddings of another size than asked for. eg. it can't change its output size
+var ErrDimensions = errors.New("llm: embedding dimensions do not match")
+
+// errors unless every vector has the dimensions asked for. 0 accepts any
+func checkDimensions(vectors [][]float32, dimensions int32) error {
+	if dimensions <= 0 {
+		return nil
+	}
+	for _, vec := range vectors {
+		if len(vec) != int(dimensions) {
+			return fmt.Errorf("%w: got %d, want %d", ErrDimensions, len(vec), dimensions)
+		}
+	}
+	return nil
+}
+
+// returns the model to use, falling back to the configured, then the provider's default
+func pickModel(requested string, configured string, fallback string) (string, error) {
+	if requested != "" {
+		return requested, nil
+	}
+	if configured != "" {
+		return configured, nil
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "", ErrNoModel
+}
+
+// posts body as json to url, and decodes the json response into out
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		// keep the error readable if the server sends back a page of html
+		if len(raw) > 1024 {
+			raw = raw[:1024]
+		}
+		return &StatusError{
+			Url:        url,
+			Code:       res.StatusCode,
+			Body:       string(raw),
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+	return json.Unmarshal(raw, out)
+}
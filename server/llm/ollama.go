@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const ollamaDefaultBaseUrl = "http://localhost:11434"
+
+// talks to a local ollama server. https://github.com/ollama/ollama/blob/main/docs/api.md
+type ollamaProvider struct {
+	cfg    Config
+	client *http.Client
+}
+
+func NewOllama(cfg Config) Provider {
+	if cfg.BaseUrl == "" {
+		cfg.BaseUrl = ollamaDefaultBaseUrl
+	}
+	cfg.BaseUrl = strings.TrimRight(cfg.BaseUrl, "/")
+	return &ollamaProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAiMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	// either "json" or a json schema
+	Format any `json:"format,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message         openAiMessage `json:"message"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+}
+
+type ollamaEmbedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int32    `json:"dimensions,omitempty"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int64       `json:"prompt_eval_count"`
+}
+
+func (o *ollamaProvider) Name() string {
+	return "ollama"
+}
+
+func (o *ollamaProvider) Model() string {
+	return o.cfg.Model
+}
+
+func (o *ollamaProvider) EmbedModel() string {
+	return o.cfg.EmbedModel
+}
+
+func (o *ollamaProvider) GenerateJSON(ctx context.Context, req JSONRequest) (*JSONResponse, error) {
+	model, err := pickModel(req.Model, o.cfg.Model, "")
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]openAiMessage, 0, 2)
+	if req.System != "" {
+		messages = append(messages, openAiMessage{Role: "system", Content: req.System})
+	}
+	messages = append(messages, openAiMessage{Role: "user", Content: req.Prompt})
+	body := ollamaChatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   false,
+		Format:   "json",
+	}
+	if req.Schema != nil {
+		body.Format = req.Schema
+	}
+	var res ollamaChatResponse
+	if err := postJSON(ctx, o.client, o.cfg.BaseUrl+"/api/chat", nil, body, &res); err != nil {
+		return nil, err
+	}
+	return &JSONResponse{
+		Text: res.Message.Content,
+		Usage: Usage{
+			PromptTokens: res.PromptEvalCount,
+			OutputTokens: res.EvalCount,
+		},
+	}, nil
+}
+
+func (o *ollamaProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) (*EmbedResponse, error) {
+	model, err := pickModel(opts.Model, o.cfg.EmbedModel, "")
+	if err != nil {
+		return nil, err
+	}
+	var res ollamaEmbedResponse
+	body := ollamaEmbedRequest{
+		Model:      model,
+		Input:      texts,
+		Dimensions: opts.Dimensions,
+	}
+	if err := postJSON(ctx, o.client, o.cfg.BaseUrl+"/api/embed", nil, body, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Embeddings) != len(texts) {
+		return nil, errors.New("llm: embedding count does not match input")
+	}
+	if err := checkDimensions(res.Embeddings, opts.Dimensions); err != nil {
+		return nil, err
+	}
+	tokens := res.PromptEvalCount
+	if tokens == 0 {
+		tokens = EstimateTokens(texts...)
+	}
+	return &EmbedResponse{
+		Vectors: res.Embeddings,
+		Model:   model,
+		Usage:   Usage{PromptTokens: tokens},
+	}, nil
+}
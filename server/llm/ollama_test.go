@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestOllamaGenerateJSON(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/api/chat": `{
+			"message": {"role": "assistant", "content": "{\"Summary\":\"Invoice is due\",\"Tags\":[\"bills\",\"urgent\"]}"},
+			"done": true,
+			"prompt_eval_count": 80,
+			"eval_count": 12
+		}`,
+	})
+	p := NewOllama(Config{BaseUrl: s.URL + "/", Model: "llama3", ApiKey: "ignored"})
+
+	res, err := p.GenerateJSON(context.Background(), JSONRequest{
+		System: "summarize",
+		Prompt: "your invoice is due tomorrow",
+		Schema: testSchema,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.path != "/api/chat" {
+		t.Errorf("posted to %s", s.path)
+	}
+	if got := s.headers.Get("Authorization"); got != "" {
+		t.Errorf("sent authorization %q", got)
+	}
+	var req struct {
+		Model    string
+		Messages []openAiMessage
+		Stream   bool
+		Format   map[string]any
+	}
+	s.request(t, &req)
+	if req.Model != "llama3" || req.Stream {
+		t.Errorf("request %+v", req)
+	}
+	want := []openAiMessage{{Role: "system", Content: "summarize"}, {Role: "user", Content: "your invoice is due tomorrow"}}
+	if !slices.Equal(req.Messages, want) {
+		t.Errorf("messages %+v, want %+v", req.Messages, want)
+	}
+	if req.Format["type"] != "object" || req.Format["properties"] == nil {
+		t.Errorf("format %v, want the schema", req.Format)
+	}
+
+	var result testResult
+	if err := json.Unmarshal([]byte(res.Text), &result); err != nil {
+		t.Fatalf("result isn't json: %v", err)
+	}
+	if result.Summary != "Invoice is due" || !slices.Equal(result.Tags, []string{"bills", "urgent"}) {
+		t.Errorf("result %+v", result)
+	}
+	if res.Usage != (Usage{PromptTokens: 80, OutputTokens: 12}) {
+		t.Errorf("usage %+v", res.Usage)
+	}
+}
+
+func TestOllamaWithoutSchema(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/api/chat": `{"message": {"role": "assistant", "content": "{}"}}`,
+	})
+	p := NewOllama(Config{BaseUrl: s.URL, Model: "llama3"})
+	if _, err := p.GenerateJSON(context.Background(), JSONRequest{Prompt: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	var req ollamaChatRequest
+	s.request(t, &req)
+	if req.Format != "json" {
+		t.Errorf("format %v, want json", req.Format)
+	}
+}
+
+func TestOllamaEmbed(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/api/embed": `{"embeddings": [[1, 0], [0, 1]], "prompt_eval_count": 6}`,
+	})
+	p := NewOllama(Config{BaseUrl: s.URL, EmbedModel: "nomic-embed-text"})
+
+	res, err := p.Embed(context.Background(), []string{"first", "second"}, EmbedOptions{Model: "picked", Dimensions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req ollamaEmbedRequest
+	s.request(t, &req)
+	if req.Model != "picked" || req.Dimensions != 2 || !slices.Equal(req.Input, []string{"first", "second"}) {
+		t.Errorf("request %+v", req)
+	}
+	if len(res.Vectors) != 2 || !slices.Equal(res.Vectors[0], []float32{1, 0}) || !slices.Equal(res.Vectors[1], []float32{0, 1}) {
+		t.Errorf("vectors %v", res.Vectors)
+	}
+	if res.Model != "picked" {
+		t.Errorf("model %q", res.Model)
+	}
+	if res.Usage != (Usage{PromptTokens: 6}) {
+		t.Errorf("usage %+v", res.Usage)
+	}
+}
+
+func TestOllamaEmbedEstimatesUsage(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/api/embed": `{"embeddings": [[1, 0]]}`,
+	})
+	p := NewOllama(Config{BaseUrl: s.URL, EmbedModel: "nomic-embed-text"})
+
+	res, err := p.Embed(context.Background(), []string{"twelve chars"}, EmbedOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Usage.PromptTokens != EstimateTokens("twelve chars") {
+		t.Errorf("usage %+v, want the estimate", res.Usage)
+	}
+}
+
+func TestOllamaEmbedMismatch(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/api/embed": `{"embeddings": [[1, 0]]}`,
+	})
+	p := NewOllama(Config{BaseUrl: s.URL, EmbedModel: "nomic-embed-text"})
+	if _, err := p.Embed(context.Background(), []string{"a", "b"}, EmbedOptions{}); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestOllamaEmbedWrongDimensions(t *testing.T) {
+	// eg. a 768 dimension model, that ignores the dimensions asked for
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/api/embed": `{"embeddings": [[1, 0], [0, 1]]}`,
+	})
+	p := NewOllama(Config{BaseUrl: s.URL, EmbedModel: "nomic-embed-text"})
+	if _, err := p.Embed(context.Background(), []string{"a", "b"}, EmbedOptions{Dimensions: 3}); !errors.Is(err, ErrDimensions) {
+		t.Errorf("got %v, want ErrDimensions", err)
+	}
+	if _, err := p.Embed(context.Background(), []string{"a", "b"}, EmbedOptions{}); err != nil {
+		t.Errorf("got %v without dimensions, want any accepted", err)
+	}
+}
+
+func TestOllamaStatusError(t *testing.T) {
+	s := newStandIn(t, http.StatusNotFound, map[string]string{
+		"/api/chat": `{"error": "model \"llama3\" not found"}`,
+	})
+	p := NewOllama(Config{BaseUrl: s.URL, Model: "llama3"})
+
+	_, err := p.GenerateJSON(context.Background(), JSONRequest{Prompt: "hi"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("got %v, want a StatusError", err)
+	}
+	if statusErr.Code != http.StatusNotFound || statusErr.Body == "" {
+		t.Errorf("status error %+v", statusErr)
+	}
+	if retry, _ := Retryable(err); retry {
+		t.Error("a missing model is retryable")
+	}
+}
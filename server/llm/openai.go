@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const openAiDefaultBaseUrl = "https://api.openai.com/v1"
+
+// talks to any api that implements OpenAI's chat completions and embeddings endpoints.
+// eg. vLLM, llama.cpp server, LM Studio, or a stand-in server for testing.
+type openAiProvider struct {
+	cfg    Config
+	client *http.Client
+}
+
+func NewOpenAi(cfg Config) Provider {
+	if cfg.BaseUrl == "" {
+		cfg.BaseUrl = openAiDefaultBaseUrl
+	}
+	cfg.BaseUrl = strings.TrimRight(cfg.BaseUrl, "/")
+	return &openAiProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+type openAiMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAiChatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []openAiMessage `json:"messages"`
+	ResponseFormat map[string]any  `json:"response_format,omitempty"`
+}
+
+type openAiChatResponse struct {
+	Choices []struct {
+		Message openAiMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int64 `json:"prompt_tokens"`
+		CompletionTokens int64 `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+type openAiEmbedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int32    `json:"dimensions,omitempty"`
+}
+
+type openAiEmbedResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int64 `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+func (o *openAiProvider) Name() string {
+	return "openai"
+}
+
+func (o *openAiProvider) Model() string {
+	return o.cfg.Model
+}
+
+func (o *openAiProvider) EmbedModel() string {
+	return o.cfg.EmbedModel
+}
+
+func (o *openAiProvider) headers() map[string]string {
+	if o.cfg.ApiKey == "" {
+		return nil // local servers usually don't need one
+	}
+	return map[string]string{"Authorization": "Bearer " + o.cfg.ApiKey}
+}
+
+func (o *openAiProvider) GenerateJSON(ctx context.Context, req JSONRequest) (*JSONResponse, error) {
+	model, err := pickModel(req.Model, o.cfg.Model, "")
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]openAiMessage, 0, 2)
+	if req.System != "" {
+		messages = append(messages, openAiMessage{Role: "system", Content: req.System})
+	}
+	messages = append(messages, openAiMessage{Role: "user", Content: req.Prompt})
+	body := openAiChatRequest{
+		Model:    model,
+		Messages: messages,
+		ResponseFormat: map[string]any{
+			"type": "json_object",
+		},
+	}
+	if req.Schema != nil {
+		body.ResponseFormat = map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":   "result",
+				"schema": req.Schema,
+			},
+		}
+	}
+	var res openAiChatResponse
+	if err := postJSON(ctx, o.client, o.cfg.BaseUrl+"/chat/completions", o.headers(), body, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Choices) == 0 {
+		return nil, errors.New("llm: no choices returned")
+	}
+	return &JSONResponse{
+		Text: res.Choices[0].Message.Content,
+		Usage: Usage{
+			PromptTokens: res.Usage.PromptTokens,
+			OutputTokens: res.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+func (o *openAiProvider) Embed(ctx context.Context, texts []string, opts EmbedOptions) (*EmbedResponse, error) {
+	model, err := pickModel(opts.Model, o.cfg.EmbedModel, "")
+	if err != nil {
+		return nil, err
+	}
+	var res openAiEmbedResponse
+	body := openAiEmbedRequest{
+		Model:      model,
+		Input:      texts,
+		Dimensions: opts.Dimensions,
+	}
+	if err := postJSON(ctx, o.client, o.cfg.BaseUrl+"/embeddings", o.headers(), body, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Data) != len(texts) {
+		return nil, errors.New("llm: embedding count does not match input")
+	}
+	// the api includes the index, so don't rely on the order
+	vectors := make([][]float32, len(texts))
+	for _, d := range res.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, errors.New("llm: embedding index out of range")
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	if err := checkDimensions(vectors, opts.Dimensions); err != nil {
+		return nil, err
+	}
+	tokens := res.Usage.PromptTokens
+	if tokens == 0 {
+		tokens = EstimateTokens(texts...)
+	}
+	return &EmbedResponse{
+		Vectors: vectors,
+		Model:   model,
+		Usage:   Usage{PromptTokens: tokens},
+	}, nil
+}
@@ -0,0 +1,241 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// a stand-in server that records the last request and answers each path with a fixed body
+type standIn struct {
+	*httptest.Server
+	path    string
+	headers http.Header
+	body    []byte
+}
+
+func newStandIn(t *testing.T, status int, responses map[string]string) *standIn {
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.path = r.URL.Path
+		s.headers = r.Header.Clone()
+		s.body, _ = io.ReadAll(r.Body)
+		res, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, res)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// decodes the request the stand-in last received
+func (s *standIn) request(t *testing.T, out any) {
+	t.Helper()
+	if err := json.Unmarshal(s.body, out); err != nil {
+		t.Fatalf("request isn't json: %v: %s", err, s.body)
+	}
+}
+
+var testSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"Summary": map[string]any{"type": "string"},
+		"Tags":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+	},
+	"required": []string{"Summary", "Tags"},
+}
+
+type testResult struct {
+	Summary string
+	Tags    []string
+}
+
+func TestOpenAiGenerateJSON(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/v1/chat/completions": `{
+			"choices": [{"message": {"role": "assistant", "content": "{\"Summary\":\"Lunch on friday\",\"Tags\":[\"social\"]}"}}],
+			"usage": {"prompt_tokens": 120, "completion_tokens": 15}
+		}`,
+	})
+	p := NewOpenAi(Config{BaseUrl: s.URL + "/v1/", ApiKey: "secret", Model: "test-model"})
+
+	res, err := p.GenerateJSON(context.Background(), JSONRequest{
+		System: "summarize",
+		Prompt: "are you free for lunch on friday?",
+		Schema: testSchema,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.path != "/v1/chat/completions" {
+		t.Errorf("posted to %s", s.path)
+	}
+	if got := s.headers.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("authorization %q", got)
+	}
+	var req struct {
+		Model          string
+		Messages       []openAiMessage
+		ResponseFormat struct {
+			Type       string
+			JsonSchema struct {
+				Name   string
+				Schema map[string]any
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	s.request(t, &req)
+	if req.Model != "test-model" {
+		t.Errorf("model %q", req.Model)
+	}
+	want := []openAiMessage{{Role: "system", Content: "summarize"}, {Role: "user", Content: "are you free for lunch on friday?"}}
+	if !slices.Equal(req.Messages, want) {
+		t.Errorf("messages %+v, want %+v", req.Messages, want)
+	}
+	if req.ResponseFormat.Type != "json_schema" || req.ResponseFormat.JsonSchema.Name == "" {
+		t.Errorf("response format %+v", req.ResponseFormat)
+	}
+	if got := req.ResponseFormat.JsonSchema.Schema["required"]; len(got.([]any)) != 2 {
+		t.Errorf("schema not sent: %v", req.ResponseFormat.JsonSchema.Schema)
+	}
+
+	var result testResult
+	if err := json.Unmarshal([]byte(res.Text), &result); err != nil {
+		t.Fatalf("result isn't json: %v", err)
+	}
+	if result.Summary != "Lunch on friday" || !slices.Equal(result.Tags, []string{"social"}) {
+		t.Errorf("result %+v", result)
+	}
+	if res.Usage != (Usage{PromptTokens: 120, OutputTokens: 15}) {
+		t.Errorf("usage %+v", res.Usage)
+	}
+}
+
+func TestOpenAiWithoutSchemaOrKey(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/chat/completions": `{"choices": [{"message": {"role": "assistant", "content": "{}"}}]}`,
+	})
+	p := NewOpenAi(Config{BaseUrl: s.URL})
+
+	if _, err := p.GenerateJSON(context.Background(), JSONRequest{Prompt: "hi"}); !errors.Is(err, ErrNoModel) {
+		t.Fatalf("got %v without a model, want ErrNoModel", err)
+	}
+	if _, err := p.GenerateJSON(context.Background(), JSONRequest{Model: "picked", Prompt: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.headers.Get("Authorization"); got != "" {
+		t.Errorf("sent authorization %q without a key", got)
+	}
+	var req openAiChatRequest
+	s.request(t, &req)
+	if req.Model != "picked" {
+		t.Errorf("model %q, want the request's", req.Model)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Role != "user" {
+		t.Errorf("messages %+v, want just the prompt", req.Messages)
+	}
+	if req.ResponseFormat["type"] != "json_object" {
+		t.Errorf("response format %v, want json_object", req.ResponseFormat)
+	}
+}
+
+func TestOpenAiNoChoices(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/chat/completions": `{"choices": []}`,
+	})
+	p := NewOpenAi(Config{BaseUrl: s.URL, Model: "test-model"})
+	if _, err := p.GenerateJSON(context.Background(), JSONRequest{Prompt: "hi"}); err == nil {
+		t.Error("no error without choices")
+	}
+}
+
+func TestOpenAiEmbed(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		// out of order, the index says where each goes
+		"/embeddings": `{
+			"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}],
+			"usage": {"prompt_tokens": 9}
+		}`,
+	})
+	p := NewOpenAi(Config{BaseUrl: s.URL, EmbedModel: "test-embed"})
+
+	res, err := p.Embed(context.Background(), []string{"first", "second"}, EmbedOptions{Dimensions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req openAiEmbedRequest
+	s.request(t, &req)
+	if req.Model != "test-embed" || req.Dimensions != 2 || !slices.Equal(req.Input, []string{"first", "second"}) {
+		t.Errorf("request %+v", req)
+	}
+	if len(res.Vectors) != 2 || !slices.Equal(res.Vectors[0], []float32{1, 0}) || !slices.Equal(res.Vectors[1], []float32{0, 1}) {
+		t.Errorf("vectors %v", res.Vectors)
+	}
+	if res.Model != "test-embed" {
+		t.Errorf("model %q", res.Model)
+	}
+	if res.Usage != (Usage{PromptTokens: 9}) {
+		t.Errorf("usage %+v", res.Usage)
+	}
+}
+
+func TestOpenAiEmbedMismatch(t *testing.T) {
+	for name, body := range map[string]string{
+		"count": `{"data": [{"index": 0, "embedding": [1]}]}`,
+		"index": `{"data": [{"index": 0, "embedding": [1]}, {"index": 2, "embedding": [1]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newStandIn(t, http.StatusOK, map[string]string{"/embeddings": body})
+			p := NewOpenAi(Config{BaseUrl: s.URL, EmbedModel: "test-embed"})
+			if _, err := p.Embed(context.Background(), []string{"a", "b"}, EmbedOptions{}); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestOpenAiEmbedWrongDimensions(t *testing.T) {
+	s := newStandIn(t, http.StatusOK, map[string]string{
+		"/embeddings": `{"data": [{"index": 0, "embedding": [1, 0]}, {"index": 1, "embedding": [0, 1, 0]}]}`,
+	})
+	p := NewOpenAi(Config{BaseUrl: s.URL, EmbedModel: "test-embed"})
+	_, err := p.Embed(context.Background(), []string{"a", "b"}, EmbedOptions{Dimensions: 2})
+	if !errors.Is(err, ErrDimensions) {
+		t.Fatalf("got %v, want ErrDimensions", err)
+	}
+	if retry, _ := Retryable(err); retry {
+		t.Error("asking again won't change the dimensions, but it's retryable")
+	}
+}
+
+func TestOpenAiStatusError(t *testing.T) {
+	s := newStandIn(t, http.StatusTooManyRequests, map[string]string{
+		"/chat/completions": `{"error": {"message": "slow down"}}`,
+	})
+	p := NewOpenAi(Config{BaseUrl: s.URL, Model: "test-model"})
+
+	_, err := p.GenerateJSON(context.Background(), JSONRequest{Prompt: "hi"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("got %v, want a StatusError", err)
+	}
+	if statusErr.Code != http.StatusTooManyRequests || statusErr.RetryAfter.Seconds() != 7 {
+		t.Errorf("status error %+v", statusErr)
+	}
+	if retry, _ := Retryable(err); !retry {
+		t.Error("a 429 isn't retryable")
+	}
+}
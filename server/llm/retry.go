@@ -0,0 +1,253 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// an error status from an http api, with what's needed to decide on a retry
+type StatusError struct {
+	Url  string
+	Code int
+	Body string
+	// from the Retry-After header. 0 if it wasn't sent
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("llm: %s returned %d: %s", e.Url, e.Code, e.Body)
+}
+
+// statuses worth trying again. Quota, overloaded and timeouts
+var retryableStatus = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// the error is worth trying again, eg. a 429, 503 or timeout. Also returns how long the server asked us to wait, if it did.
+// Schema and parse errors aren't
+func Retryable(err error) (bool, time.Duration) {
+	if err == nil {
+		return false, 0
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return retryableStatus[status.Code], status.RetryAfter
+	}
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus[apiErr.Code], geminiRetryDelay(apiErr)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+	return false, 0
+}
+
+// gemini says how long to wait in a google.rpc.RetryInfo detail. eg. {"retryDelay": "23s"}
+func geminiRetryDelay(err genai.APIError) time.Duration {
+	for _, detail := range err.Details {
+		if delay, ok := detail["retryDelay"].(string); ok {
+			if d, err := time.ParseDuration(delay); err == nil {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+// the Retry-After header, in seconds or as a date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(0, time.Until(at))
+	}
+	return 0
+}
+
+// a token bucket shared by every call in the process. A quota error pauses all of them,
+// so one worker hitting it doesn't fail the calls of the others
+type limiter struct {
+	mu sync.Mutex
+	// tokens added per second. 0 is unlimited
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// nothing is let through until then
+	pausedUntil time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// waits for a token, or the context to end
+func (l *limiter) wait(ctx context.Context) error {
+	for {
+		delay := l.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// takes a token if there is one. Otherwise how long until there might be
+func (l *limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	if l.rate <= 0 {
+		return 0
+	}
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// holds every call back for d. Only ever extends the pause
+func (l *limiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// retries transient errors with exponential backoff and jitter, and limits the call rate
+type retrying struct {
+	Provider
+	cfg     Config
+	limiter *limiter
+}
+
+// retries the provider's transient errors, and limits the calls it makes. See Config.
+// Keeps batch support, if the provider has it
+func WithRetry(p Provider, cfg Config) Provider {
+	r := &retrying{
+		Provider: p,
+		cfg:      cfg,
+		limiter:  newLimiter(cfg.RequestsPerSecond, cfg.Burst),
+	}
+	if batch, ok := p.(BatchProvider); ok {
+		return &retryingBatch{retrying: r, batch: batch}
+	}
+	return r
+}
+
+// calls fn until it succeeds, fails for good, or runs out of attempts
+func retry[T any](ctx context.Context, r *retrying, fn func() (T, error)) (T, error) {
+	var zero T
+	attempts := max(1, r.cfg.MaxAttempts)
+	for attempt := 0; ; attempt++ {
+		if err := r.limiter.wait(ctx); err != nil {
+			return zero, err
+		}
+		res, err := fn()
+		if err == nil {
+			return res, nil
+		}
+		ok, retryAfter := Retryable(err)
+		if !ok || attempt+1 >= attempts || ctx.Err() != nil {
+			return zero, err
+		}
+		if retryAfter > max(r.cfg.RetryAfterMaxDelay, r.cfg.RetryMaxDelay) {
+			// too long to hold the caller. Retrying sooner would only burn attempts
+			return zero, err
+		}
+		// the server knows best
+		delay := max(retryAfter, backoff(attempt, r.cfg.RetryBaseDelay, r.cfg.RetryMaxDelay))
+		var status *StatusError
+		var apiErr genai.APIError
+		if (errors.As(err, &status) && status.Code == http.StatusTooManyRequests) ||
+			(errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests) {
+			// out of quota, so everyone waits
+			r.limiter.pause(delay)
+		}
+		select {
+		case <-ctx.Done():
+			return zero, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// exponential, with jitter so retries from many workers don't line up. Between half and all of base*2^attempt
+func backoff(attempt int, base time.Duration, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if attempt < 30 {
+		delay = min(maxDelay, base*time.Duration(1<<attempt))
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (r *retrying) GenerateJSON(ctx context.Context, req JSONRequest) (*JSONResponse, error) {
+	return retry(ctx, r, func() (*JSONResponse, error) {
+		return r.Provider.GenerateJSON(ctx, req)
+	})
+}
+
+func (r *retrying) Embed(ctx context.Context, texts []string, opts EmbedOptions) (*EmbedResponse, error) {
+	return retry(ctx, r, func() (*EmbedResponse, error) {
+		return r.Provider.Embed(ctx, texts, opts)
+	})
+}
+
+type retryingBatch struct {
+	*retrying
+	batch BatchProvider
+}
+
+func (r *retryingBatch) CreateBatch(ctx context.Context, displayName string, reqs []JSONRequest) (string, error) {
+	return retry(ctx, r.retrying, func() (string, error) {
+		return r.batch.CreateBatch(ctx, displayName, reqs)
+	})
+}
+
+func (r *retryingBatch) GetBatch(ctx context.Context, name string) (*BatchJob, error) {
+	return retry(ctx, r.retrying, func() (*BatchJob, error) {
+		return r.batch.GetBatch(ctx, name)
+	})
+}
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fails with each error in turn, then succeeds
+type flakyProvider struct {
+	Provider
+	errs  []error
+	calls []time.Time
+}
+
+func (f *flakyProvider) GenerateJSON(ctx context.Context, req JSONRequest) (*JSONResponse, error) {
+	f.calls = append(f.calls, time.Now())
+	if len(f.calls) <= len(f.errs) {
+		return nil, f.errs[len(f.calls)-1]
+	}
+	return &JSONResponse{Text: "{}"}, nil
+}
+
+func quotaError(retryAfter time.Duration) error {
+	return &StatusError{Url: "test", Code: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+func retryConfig() Config {
+	return Config{
+		MaxAttempts:        3,
+		RetryBaseDelay:     time.Millisecond,
+		RetryMaxDelay:      2 * time.Millisecond,
+		RetryAfterMaxDelay: 100 * time.Millisecond,
+	}
+}
+
+func TestRetryWaitsAsLongAsAsked(t *testing.T) {
+	f := &flakyProvider{errs: []error{quotaError(30 * time.Millisecond)}}
+	p := WithRetry(f, retryConfig())
+
+	if _, err := p.GenerateJSON(context.Background(), JSONRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) != 2 {
+		t.Fatalf("%d calls, want 2", len(f.calls))
+	}
+	// longer than RetryMaxDelay, as the server asked
+	if waited := f.calls[1].Sub(f.calls[0]); waited < 30*time.Millisecond {
+		t.Errorf("retried after %s, before the Retry-After", waited)
+	}
+}
+
+func TestRetryGivesUpOnLongRetryAfter(t *testing.T) {
+	long := quotaError(time.Hour)
+	f := &flakyProvider{errs: []error{long}}
+	p := WithRetry(f, retryConfig())
+
+	start := time.Now()
+	_, err := p.GenerateJSON(context.Background(), JSONRequest{})
+	if !errors.Is(err, long) {
+		t.Fatalf("got %v, want the quota error", err)
+	}
+	if len(f.calls) != 1 {
+		t.Errorf("%d calls, want 1", len(f.calls))
+	}
+	if waited := time.Since(start); waited > 50*time.Millisecond {
+		t.Errorf("waited %s before giving up", waited)
+	}
+	if retry, _ := Retryable(err); !retry {
+		t.Error("the caller can't tell to try it later")
+	}
+}
+
+func TestRetryRunsOutOfAttempts(t *testing.T) {
+	f := &flakyProvider{errs: []error{quotaError(0), quotaError(0), quotaError(0)}}
+	p := WithRetry(f, retryConfig())
+
+	if _, err := p.GenerateJSON(context.Background(), JSONRequest{}); err == nil {
+		t.Fatal("no error")
+	}
+	if len(f.calls) != 3 {
+		t.Errorf("%d calls, want 3", len(f.calls))
+	}
+}
+
+func TestRetrySkipsPermanentErrors(t *testing.T) {
+	f := &flakyProvider{errs: []error{&StatusError{Url: "test", Code: http.StatusBadRequest}}}
+	p := WithRetry(f, retryConfig())
+
+	if _, err := p.GenerateJSON(context.Background(), JSONRequest{}); err == nil {
+		t.Fatal("no error")
+	}
+	if len(f.calls) != 1 {
+		t.Errorf("%d calls, want 1", len(f.calls))
+	}
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"context"
+	"fromkeith/my-desktop-server/assistant"
+	"fromkeith/my-desktop-server/backfill"
+	"fromkeith/my-desktop-server/deadletter"
+	"fromkeith/my-desktop-server/digest"
+	"fromkeith/my-desktop-server/entities"
 	"fromkeith/my-desktop-server/globals"
 	_ "fromkeith/my-desktop-server/globals"
 	"fromkeith/my-desktop-server/gmail/client"
@@ -10,7 +15,12 @@ import (
 	"fromkeith/my-desktop-server/messages/aggregate"
 	"fromkeith/my-desktop-server/middleware"
 	"fromkeith/my-desktop-server/people"
+	"fromkeith/my-desktop-server/savedsearches"
+	"fromkeith/my-desktop-server/settings"
+	"fromkeith/my-desktop-server/taxonomy"
 	"fromkeith/my-desktop-server/threads"
+	"fromkeith/my-desktop-server/topics"
+	"fromkeith/my-desktop-server/usage"
 
 	"github.com/rs/zerolog/log"
 
@@ -69,6 +79,10 @@ func main() {
 	r.GET("/api/messages/categories", aggregate.CountCategories)
 	r.GET("/api/messages/aggregate/pullCategories", aggregate.PullCategories)
 	r.GET("/api/messages/aggregate/pullTags", aggregate.PullTags)
+	r.GET("/api/messages/priority", messages.PriorityMessages)
+	r.GET("/api/messages/:messageId/similar", messages.SimilarMessages)
+	r.POST("/api/messages/:messageId/feedback", messages.ImportanceFeedback)
+	r.POST("/api/messages/:messageId/translate", messages.TranslateMessage)
 	// THIS IS A DEBUG ENDPOINT
 	r.POST("/api/messages/:messageId/redo/:userId", messages.ReInjest)
 	r.POST("/api/messages/sync", messages.ForceSyncMessages)
@@ -76,10 +90,50 @@ func main() {
 
 	r.GET("/api/threads/pull", threads.PullThread)
 	r.GET("/api/threads/pullStream", middleware.StreamHeaders(), threads.PullStream)
+	r.POST("/api/threads/:threadId/draftReply", threads.DraftReply)
+
+	r.GET("/api/topics/pull", topics.PullTopics)
+	r.GET("/api/topics/pullStream", middleware.StreamHeaders(), topics.PullStream)
+	r.GET("/api/topics/:topicId/messages", messages.TopicMessages)
+
+	r.GET("/api/entities", entities.QueryEntities)
+	r.GET("/api/entities/pull", entities.PullEntities)
+	r.GET("/api/entities/pullStream", middleware.StreamHeaders(), entities.PullStream)
+
+	r.POST("/api/savedSearches", savedsearches.CreateSavedSearch)
+	r.PUT("/api/savedSearches/:searchId", savedsearches.UpdateSavedSearch)
+	r.DELETE("/api/savedSearches/:searchId", savedsearches.DeleteSavedSearch)
+	r.GET("/api/savedSearches/pull", savedsearches.PullSavedSearches)
+	r.GET("/api/savedSearches/pullStream", middleware.StreamHeaders(), savedsearches.PullStream)
+
+	r.GET("/api/taxonomy", taxonomy.GetTaxonomy)
+	r.PUT("/api/taxonomy", taxonomy.SaveTaxonomy)
+	r.POST("/api/taxonomy/reset", taxonomy.ResetTaxonomy)
+	r.POST("/api/taxonomy/categories", taxonomy.AddCategory)
+	r.PUT("/api/taxonomy/categories/:name", taxonomy.UpdateCategory)
+	r.DELETE("/api/taxonomy/categories/:name", taxonomy.DeleteCategory)
+
+	r.POST("/api/ai/backfill", backfill.StartBackfill)
+	r.GET("/api/ai/backfill", backfill.ListBackfills)
+	r.GET("/api/ai/backfill/:jobId", backfill.GetBackfill)
+	r.POST("/api/ai/backfill/:jobId/cancel", backfill.CancelBackfill)
+	r.GET("/api/ai/usage", usage.GetUsage)
+	r.PUT("/api/ai/budget", usage.SaveAiBudget)
+	r.POST("/api/assistant/ask", middleware.StreamHeaders(), assistant.Ask)
+
+	r.GET("/api/settings", settings.GetSettings)
+	r.PUT("/api/settings", settings.SaveSettings)
+
+	r.GET("/api/digests", digest.ListDigests)
+	r.POST("/api/digests/generate", digest.GenerateDigest)
+	r.GET("/api/digests/:digestId", digest.GetDigest)
 
 	r.GET("/api/people/sync", people.ForceSyncPeople)
 	r.GET("/api/people/pull", people.PullPeople)
 
+	r.GET("/api/admin/deadLetters", middleware.RequireAdmin(), deadletter.ListDeadLetters)
+	r.POST("/api/admin/deadLetters/replay", middleware.RequireAdmin(), deadletter.ReplayDeadLetters)
+
 	// Start server on port 8080 (default)
 	// Server will listen on 0.0.0.0:8080 (localhost:8080 on Windows)
 	r.Run()
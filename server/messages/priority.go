@@ -0,0 +1,128 @@
+package messages
+
+import (
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/importance"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type PriorityMessagesResponse struct {
+	Messages []data.GmailEntry `validate:"required" json:"messages"`
+} // @name PriorityMessagesResponse
+
+type ImportanceFeedbackRequest struct {
+	// replied, opened or archivedUnread
+	Action string `validate:"required" json:"action"`
+} // @name ImportanceFeedbackRequest
+
+// PriorityMessages godoc
+// @Summary      Priority inbox
+// @Description  Inbox messages sorted by importance, most important first.
+// @Tags         email
+// @Produce      json
+// @Param        limit query int false "Number of messages to return"
+// @Param        unreadOnly query bool false "Only unread messages"
+// @Success      200  {object}  PriorityMessagesResponse
+// @Router       /messages/priority [get]
+func PriorityMessages(r *gin.Context) {
+	limit, _ := strconv.ParseInt(r.Query("limit"), 10, 64)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	labels := []string{"INBOX"}
+	if unreadOnly, _ := strconv.ParseBool(r.Query("unreadOnly")); unreadOnly {
+		labels = append(labels, "UNREAD")
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"importance", -1}, {"internalDate", -1}}).
+		SetLimit(limit)
+	cursor, err := globals.DocDb().Collection("Messages").Find(
+		r,
+		bson.M{
+			"accountId":  r.GetString("accountId"),
+			"isDeleted":  bson.M{"$ne": true},
+			"labels":     bson.M{"$all": labels},
+			"importance": bson.M{"$exists": true},
+		},
+		opts,
+	)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to load priority messages")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		return
+	}
+	defer cursor.Close(r)
+	var messages []data.GmailEntry
+	if err := cursor.All(r, &messages); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to decode priority messages")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		return
+	}
+	for i := range messages {
+		ensureJsonEntry(&messages[i])
+	}
+	if messages == nil {
+		messages = make([]data.GmailEntry, 0)
+	}
+	r.JSON(http.StatusOK, PriorityMessagesResponse{Messages: messages})
+}
+
+// ImportanceFeedback godoc
+// @Summary      Importance feedback
+// @Description  Tells us what the user did with a message, so future importance scores learn from it.
+// @Tags         email
+// @Accept       json
+// @Param        messageId path string true "messageId"
+// @Param        request body ImportanceFeedbackRequest true "Feedback"
+// @Success      200
+// @Router       /messages/{messageId}/feedback [post]
+func ImportanceFeedback(r *gin.Context) {
+	var req ImportanceFeedbackRequest
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var entry data.GmailEntry
+	err := globals.DocDb().Collection("Messages").FindOne(
+		r,
+		bson.M{"_id": toDocumentIdRequest(r, r.Param("messageId"))},
+	).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	} else if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to load message for importance feedback")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feedback"})
+		return
+	}
+	if err := importance.Learn(r, entry, req.Action); errors.Is(err, importance.ErrUnknownFeedback) {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to learn from importance feedback")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save feedback"})
+		return
+	}
+	r.JSON(http.StatusOK, gin.H{})
+}
@@ -18,9 +18,18 @@ import (
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// sent on the pull stream, as a "warning" event, when a message is found to be risky
+type PhishingWarning struct {
+	MessageId string            `validate:"required" json:"messageId"`
+	ThreadId  string            `validate:"required" json:"threadId"`
+	Subject   string            `validate:"required" json:"subject"`
+	Sender    data.PersonInfo   `validate:"required" json:"sender"`
+	Phishing  data.PhishingRisk `validate:"required" json:"phishing"`
+} // @name PhishingWarning
+
 // PullStream godoc
 // @Summary      Stream Messages
-// @Description  Sync endpoint to allow for for push from server to client of changes to messages.
+// @Description  Sync endpoint to allow for for push from server to client of changes to messages. Also sends a "warning" event, with a PhishingWarning, when a message is found to be risky.
 // @Tags         email
 // @Produce      event-stream
 // @Router       /messages/pullStream [get]
@@ -60,6 +69,7 @@ func PullStream(r *gin.Context) {
 			}
 		case batch := <-batchChan:
 			payloads := make([]data.GmailEntry, 0, len(batch))
+			warnings := make([]PhishingWarning, 0)
 			chkPoint := SyncCheckpoint{}
 			for _, ev := range batch {
 				full, ok := ev["fullDocument"]
@@ -78,6 +88,15 @@ func PullStream(r *gin.Context) {
 				}
 				ensureJsonEntry(&email)
 				payloads = append(payloads, email)
+				if email.Phishing != nil && email.Phishing.Warning && setsPhishing(ev) {
+					warnings = append(warnings, PhishingWarning{
+						MessageId: email.MessageId,
+						ThreadId:  email.ThreadId,
+						Subject:   email.Subject,
+						Sender:    email.Sender,
+						Phishing:  *email.Phishing,
+					})
+				}
 				at := email.UpdatedAt.Format(time.RFC3339Nano)
 				if at > chkPoint.UpdatedAt {
 					chkPoint = SyncCheckpoint{MessageId: email.MessageId, UpdatedAt: at}
@@ -90,6 +109,10 @@ func PullStream(r *gin.Context) {
 				Checkpoint: chkPoint,
 			})
 			r.SSEvent("message", payload)
+			for _, warning := range warnings {
+				payload, _ := json.Marshal(warning)
+				r.SSEvent("warning", payload)
+			}
 			return true
 		case <-time.After(time.Second):
 			return true // allow the request to check its status or end
@@ -98,3 +121,17 @@ func PullStream(r *gin.Context) {
 	})
 
 }
+
+// the change is the phishing risk being saved, so it's only warned about once
+func setsPhishing(ev bson.M) bool {
+	if ev["operationType"] != "update" {
+		return false
+	}
+	desc, ok := ev["updateDescription"].(bson.M)
+	if !ok {
+		return false
+	}
+	updated, _ := desc["updatedFields"].(bson.M)
+	_, ok = updated["phishing"]
+	return ok
+}
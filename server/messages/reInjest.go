@@ -22,6 +22,7 @@ func ReInjest(r *gin.Context) {
 		MessageId: messageId,
 		AccountId: accountId,
 		UserId:    userId,
+		Force:     true,
 	})
 	msg := kafka.Message{
 		Key:   []byte(accountId + ";" + messageId),
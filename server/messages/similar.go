@@ -0,0 +1,182 @@
+package messages
+
+import (
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type SimilarMessage struct {
+	Message data.GmailEntry `validate:"required" json:"message"`
+	Score   float64         `validate:"required" json:"score"`
+} // @name SimilarMessage
+
+type SimilarMessagesResponse struct {
+	Messages []SimilarMessage `validate:"required" json:"messages"`
+} // @name SimilarMessagesResponse
+
+type similarHit struct {
+	MessageId string  `bson:"messageId"`
+	Score     float64 `bson:"score"`
+}
+
+// SimilarMessages godoc
+// @Summary      More like this
+// @Description  Finds the messages most similar to the given message, using its summary embedding.
+// @Tags         email
+// @Produce      json
+// @Param        messageId path string true "messageId"
+// @Param        limit query int false "Number of messages to return"
+// @Param        since query string false "Only messages received after this time (RFC3339)"
+// @Param        until query string false "Only messages received before this time (RFC3339)"
+// @Param        sameSender query bool false "Only messages from the same sender"
+// @Success      200  {object}  SimilarMessagesResponse
+// @Router       /messages/{messageId}/similar [get]
+func SimilarMessages(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	messageId := r.Param("messageId")
+
+	limit, _ := strconv.ParseInt(r.Query("limit"), 10, 64)
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	var source data.EmailSummaryEmbedding
+	err := globals.DocDb().Collection("MessageSummaries").FindOne(
+		r,
+		bson.M{"_id": toDocumentIdRequest(r, messageId)},
+	).Decode(&source)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Message has no summary yet"})
+		return
+	} else if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("messageId", messageId).
+			Msg("failed to load message summary")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message summary"})
+		return
+	}
+
+	filter := bson.M{"accountId": accountId}
+	if sameSender, _ := strconv.ParseBool(r.Query("sameSender")); sameSender && source.Sender.Email != "" {
+		filter["sender.email"] = source.Sender.Email
+	}
+	dateRange := bson.M{}
+	if since, err := time.Parse(time.RFC3339Nano, r.Query("since")); err == nil {
+		dateRange["$gte"] = since.UnixMilli()
+	}
+	if until, err := time.Parse(time.RFC3339Nano, r.Query("until")); err == nil {
+		dateRange["$lte"] = until.UnixMilli()
+	}
+	if len(dateRange) > 0 {
+		filter["internalDate"] = dateRange
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$vectorSearch", Value: bson.M{
+			"index":         "vs_message_summaries",
+			"path":          "embedding",
+			"queryVector":   source.Embedding,
+			"numCandidates": (limit + 1) * 20,
+			// +1 as we will find ourselves
+			"limit":  limit + 1,
+			"filter": filter,
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":       0,
+			"messageId": 1,
+			"score":     bson.M{"$meta": "vectorSearchScore"},
+		}}},
+	}
+	cursor, err := globals.DocDb().Collection("MessageSummaries").Aggregate(r, pipeline)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("messageId", messageId).
+			Msg("failed to vector search message summaries")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+	defer cursor.Close(r)
+	var hits []similarHit
+	if err := cursor.All(r, &hits); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("messageId", messageId).
+			Msg("failed to decode vector search results")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+
+	ids := make([]string, 0, len(hits))
+	scores := make(map[string]float64, len(hits))
+	for _, hit := range hits {
+		if hit.MessageId == messageId {
+			continue
+		}
+		ids = append(ids, data.ToDocumentId(accountId, hit.MessageId))
+		scores[hit.MessageId] = hit.Score
+	}
+	if int64(len(ids)) > limit {
+		ids = ids[:limit]
+	}
+
+	found := make(map[string]data.GmailEntry, len(ids))
+	if len(ids) > 0 {
+		msgCursor, err := globals.DocDb().Collection("Messages").Find(
+			r,
+			bson.M{"_id": bson.M{"$in": ids}, "isDeleted": bson.M{"$ne": true}},
+			options.Find().SetLimit(limit),
+		)
+		if err != nil {
+			log.Error().
+				Ctx(r).
+				Err(err).
+				Str("messageId", messageId).
+				Msg("failed to load similar messages")
+			r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load similar messages"})
+			return
+		}
+		defer msgCursor.Close(r)
+		var entries []data.GmailEntry
+		if err := msgCursor.All(r, &entries); err != nil {
+			log.Error().
+				Ctx(r).
+				Err(err).
+				Str("messageId", messageId).
+				Msg("failed to decode similar messages")
+			r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load similar messages"})
+			return
+		}
+		for _, e := range entries {
+			found[e.MessageId] = ensureJsonEntry(&e)
+		}
+	}
+
+	// keep the order from the vector search
+	res := make([]SimilarMessage, 0, len(found))
+	for _, hit := range hits {
+		entry, ok := found[hit.MessageId]
+		if !ok {
+			continue
+		}
+		res = append(res, SimilarMessage{
+			Message: entry,
+			Score:   scores[hit.MessageId],
+		})
+	}
+	r.JSON(http.StatusOK, SimilarMessagesResponse{Messages: res})
+}
@@ -0,0 +1,62 @@
+package messages
+
+import (
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type TopicMessagesResponse struct {
+	Messages []data.GmailEntry `validate:"required" json:"messages"`
+} // @name TopicMessagesResponse
+
+// TopicMessages godoc
+// @Summary      Topic messages
+// @Description  The messages in a topic, newest first. Only recent messages are clustered, so older ones have no topic.
+// @Tags         email
+// @Produce      json
+// @Param        topicId path string true "topicId"
+// @Param        before query int false "Only messages received before this internalDate, to page through them"
+// @Param        limit query int false "Number of messages to return"
+// @Success      200  {object}  TopicMessagesResponse
+// @Router       /topics/{topicId}/messages [get]
+func TopicMessages(r *gin.Context) {
+	limit, _ := strconv.ParseInt(r.Query("limit"), 10, 64)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	filter := bson.M{
+		"accountId": r.GetString("accountId"),
+		"topicId":   r.Param("topicId"),
+		"isDeleted": bson.M{"$ne": true},
+	}
+	if before, err := strconv.ParseInt(r.Query("before"), 10, 64); err == nil && before > 0 {
+		filter["internalDate"] = bson.M{"$lt": before}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"internalDate", -1}}).
+		SetLimit(limit)
+	cursor, err := globals.DocDb().Collection("Messages").Find(r, filter, opts)
+	if err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(r)
+	var messages []data.GmailEntry
+	if err := cursor.All(r, &messages); err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range messages {
+		ensureJsonEntry(&messages[i])
+	}
+	if messages == nil {
+		messages = make([]data.GmailEntry, 0)
+	}
+	r.JSON(http.StatusOK, TopicMessagesResponse{Messages: messages})
+}
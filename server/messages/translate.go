@@ -0,0 +1,137 @@
+package messages
+
+import (
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/quoted"
+	"fromkeith/my-desktop-server/redact"
+	"fromkeith/my-desktop-server/settings"
+	"fromkeith/my-desktop-server/translation"
+	"fromkeith/my-desktop-server/usage"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type TranslateMessageResponse struct {
+	Translation data.MessageTranslation `validate:"required" json:"translation"`
+	// it was translated before
+	Cached bool `validate:"required" json:"cached"`
+} // @name TranslateMessageResponse
+
+// TranslateMessage godoc
+// @Summary      Translate a message
+// @Description  Translates the message's body, without quoted replies, as markdown. Sensitive values are masked first, per the account's redaction level. Translations are cached per message and language.
+// @Tags         email
+// @Produce      json
+// @Param        messageId path string true "messageId"
+// @Param        language query string false "Language to translate to. Defaults to the account's language"
+// @Success      200  {object}  TranslateMessageResponse
+// @Failure      404  "Message not found"
+// @Failure      429  "AI budget exceeded"
+// @Router       /messages/{messageId}/translate [post]
+func TranslateMessage(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	messageId := r.Param("messageId")
+	fail := func(err error, message string) {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("messageId", messageId).
+			Msg(message)
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to translate message"})
+	}
+
+	accountSettings, err := settings.Get(r, accountId)
+	if err != nil {
+		fail(err, "failed to get settings")
+		return
+	}
+	lang := r.Query("language")
+	if lang == "" {
+		lang = accountSettings.Language
+	}
+	lang, err = translation.NormalizeLanguage(lang)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cached, err := translation.GetCached(r, accountId, messageId, lang)
+	if err != nil {
+		fail(err, "failed to read cached translation")
+		return
+	}
+	if cached != nil {
+		r.JSON(http.StatusOK, TranslateMessageResponse{Translation: *cached, Cached: true})
+		return
+	}
+
+	var body data.GmailEntryBody
+	err = globals.DocDb().Collection("MessageBodies").FindOne(r, bson.M{"_id": toDocumentIdRequest(r, messageId)}).Decode(&body)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	} else if err != nil {
+		fail(err, "failed to load message body")
+		return
+	}
+	text := body.NewContent
+	if text == "" {
+		// bodies fetched before we stored the new content
+		text, err = quoted.NewContent(body.PlainText, body.Html)
+		if err != nil {
+			fail(err, "failed to strip message body")
+			return
+		}
+	}
+	if text == "" {
+		r.JSON(http.StatusOK, TranslateMessageResponse{Translation: data.MessageTranslation{
+			AccountId: accountId,
+			MessageId: messageId,
+			Language:  lang,
+		}})
+		return
+	}
+
+	over, err := usage.OverBudget(r, accountId)
+	if err != nil {
+		fail(err, "failed to check budget")
+		return
+	}
+	if over {
+		r.JSON(http.StatusTooManyRequests, gin.H{"error": "AI budget exceeded"})
+		return
+	}
+	level, err := redact.ParseLevel(accountSettings.RedactionLevel)
+	if err != nil {
+		level = redact.LevelStrict
+	}
+	text, _ = redact.Redact(text, level)
+	translated, tokens, err := translation.Translate(r, accountId, messageId, text, lang)
+	if tokens != nil {
+		if err := usage.Save(r, usage.Generated(globals.LLM(), accountId, messageId, usage.OperationTranslate, *tokens)); err != nil {
+			log.Error().
+				Ctx(r).
+				Err(err).
+				Msg("failed to save usage")
+		}
+	}
+	if err != nil {
+		fail(err, "failed to translate message")
+		return
+	}
+	if err := translation.SaveCached(r, *translated); err != nil {
+		// they still get this translation
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("messageId", messageId).
+			Msg("failed to cache translation")
+	}
+	r.JSON(http.StatusOK, TranslateMessageResponse{Translation: *translated})
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"fromkeith/my-desktop-server/auth"
 	"net/http"
+	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -42,3 +43,20 @@ func RequireAuth() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// only the accounts in ADMIN_ACCOUNT_IDS, comma separated
+func RequireAdmin() gin.HandlerFunc {
+	admins := make(map[string]bool)
+	for _, id := range strings.Split(os.Getenv("ADMIN_ACCOUNT_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			admins[id] = true
+		}
+	}
+	return func(c *gin.Context) {
+		if !c.GetBool("isAuthed") || !admins[c.GetString("accountId")] {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,32 @@
+-- migrate:up
+
+-- re-queues messages enriched with an older AI version
+CREATE TABLE AiBackfillJobs (
+    jobId varchar NOT NULL PRIMARY KEY,
+    accountId varchar NOT NULL,
+    -- running, completed, cancelled
+    status varchar NOT NULL,
+    -- the version messages are brought up to
+    promptHash varchar NOT NULL,
+    model varchar NOT NULL,
+    taxonomyVersion bigint NOT NULL,
+    -- messages queued per minute
+    ratePerMinute int NOT NULL,
+    -- stale messages when the job started
+    total bigint NOT NULL DEFAULT 0,
+    queued bigint NOT NULL DEFAULT 0,
+    -- the last message id queued. Messages are queued in _id order
+    lastMessageId varchar NOT NULL DEFAULT '',
+    error varchar NOT NULL DEFAULT '',
+    createdAt timestamp without time zone NOT NULL,
+    updatedAt timestamp without time zone NOT NULL,
+    lastRunAt timestamp without time zone NOT NULL DEFAULT '0001-01-01T00:00:00Z'
+);
+CREATE INDEX idx_backfill_account ON AiBackfillJobs (accountId, createdAt);
+CREATE INDEX idx_backfill_status ON AiBackfillJobs (status);
+-- one running job per account
+CREATE UNIQUE INDEX idx_backfill_running ON AiBackfillJobs (accountId) WHERE status = 'running';
+
+-- migrate:down
+
+DROP TABLE AiBackfillJobs;
@@ -0,0 +1,42 @@
+-- migrate:up
+
+-- tokens used by each LLM call
+CREATE TABLE AiUsage (
+    id bigserial NOT NULL PRIMARY KEY,
+    accountId varchar NOT NULL,
+    -- empty when the call wasn't for a single message. eg. a thread summary
+    messageId varchar NOT NULL DEFAULT '',
+    provider varchar NOT NULL,
+    model varchar NOT NULL,
+    -- analyze, embed, threadSummary, draftReply
+    operation varchar NOT NULL,
+    promptTokens bigint NOT NULL DEFAULT 0,
+    outputTokens bigint NOT NULL DEFAULT 0,
+    embeddingTokens bigint NOT NULL DEFAULT 0,
+    createdAt timestamp without time zone NOT NULL
+);
+CREATE INDEX idx_usage_account ON AiUsage (accountId, createdAt);
+
+-- token limits per account. 0 is unlimited
+CREATE TABLE AiBudgets (
+    accountId varchar NOT NULL PRIMARY KEY,
+    dailyTokens bigint NOT NULL DEFAULT 0,
+    monthlyTokens bigint NOT NULL DEFAULT 0,
+    updatedAt timestamp without time zone NOT NULL
+);
+
+-- messages held back while the account is over budget
+CREATE TABLE AiDeferredMessages (
+    accountId varchar NOT NULL,
+    messageId varchar NOT NULL,
+    -- the original email_injest_available payload
+    payload bytea NOT NULL,
+    createdAt timestamp without time zone NOT NULL,
+    PRIMARY KEY (accountId, messageId)
+);
+
+-- migrate:down
+
+DROP TABLE AiDeferredMessages;
+DROP TABLE AiBudgets;
+DROP TABLE AiUsage;
@@ -0,0 +1,89 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        // copy the message date onto existing summaries
+        await db
+            .collection("MessageSummaries")
+            .aggregate([
+                {
+                    $lookup: {
+                        from: "Messages",
+                        localField: "_id",
+                        foreignField: "_id",
+                        as: "message",
+                    },
+                },
+                { $unwind: "$message" },
+                {
+                    $project: {
+                        internalDate: "$message.internalDate",
+                    },
+                },
+                {
+                    $merge: {
+                        into: "MessageSummaries",
+                        on: "_id",
+                        whenMatched: "merge",
+                        whenNotMatched: "discard",
+                    },
+                },
+            ])
+            .toArray();
+
+        // allow "more like this" to filter by sender and date
+        await db.collection("MessageSummaries").updateSearchIndex(
+            "vs_message_summaries",
+            {
+                fields: [
+                    {
+                        type: "vector",
+                        path: "embedding",
+                        numDimensions: 3072,
+                        similarity: "cosine",
+                    },
+                    {
+                        type: "filter",
+                        path: "accountId",
+                    },
+                    {
+                        type: "filter",
+                        path: "sender.email",
+                    },
+                    {
+                        type: "filter",
+                        path: "internalDate",
+                    },
+                ],
+            },
+        );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("MessageSummaries").updateSearchIndex(
+            "vs_message_summaries",
+            {
+                fields: [
+                    {
+                        type: "vector",
+                        path: "embedding",
+                        numDimensions: 3072,
+                        similarity: "cosine",
+                    },
+                    {
+                        type: "filter",
+                        path: "accountId",
+                    },
+                ],
+            },
+        );
+    },
+};
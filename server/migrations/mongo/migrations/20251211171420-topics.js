@@ -0,0 +1,68 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        await db.createCollection("Topics", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "topicId", "updatedAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id + Topic Id",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        topicId: {
+                            bsonType: "string",
+                            description: "Topic Id",
+                        },
+                        label: {
+                            bsonType: "string",
+                            description: "Generated label",
+                        },
+                        updatedAt: {
+                            bsonType: "date",
+                            description: "Updated At",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        await db
+            .collection("Topics")
+            .createIndex(
+                { accountId: 1, updatedAt: 1, _id: 1 },
+                { name: "idx_sync" },
+            );
+        await db
+            .collection("Messages")
+            .createIndex(
+                { accountId: 1, topicId: 1, _id: 1 },
+                { name: "idx_topics" },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("Messages").dropIndex("idx_topics");
+        await db.collection("Topics").drop();
+    },
+};
@@ -0,0 +1,71 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        await db.createCollection("SavedSearches", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "searchId", "name", "query", "updatedAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id + Search Id",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        searchId: {
+                            bsonType: "string",
+                            description: "Search Id",
+                        },
+                        name: {
+                            bsonType: "string",
+                            description: "User given name",
+                        },
+                        query: {
+                            bsonType: "object",
+                            description: "The search criteria",
+                        },
+                        updatedAt: {
+                            bsonType: "date",
+                            description: "Updated At",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        await db
+            .collection("SavedSearches")
+            .createIndex(
+                { accountId: 1, updatedAt: 1, _id: 1 },
+                { name: "idx_sync" },
+            );
+        await db
+            .collection("SavedSearches")
+            .createIndex(
+                { "query.withinDays": 1 },
+                { name: "idx_relative" },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("SavedSearches").drop();
+    },
+};
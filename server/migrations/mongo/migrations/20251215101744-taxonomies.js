@@ -0,0 +1,67 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        // seeded from the default list on first read
+        await db.createCollection("AccountTaxonomies", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "categories", "version"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        categories: {
+                            bsonType: "array",
+                            description: "L1 categories, each with their L2 subcategories",
+                            items: {
+                                bsonType: "object",
+                                required: ["name", "subcategories"],
+                                properties: {
+                                    name: { bsonType: "string" },
+                                    subcategories: {
+                                        bsonType: "array",
+                                        items: { bsonType: "string" },
+                                    },
+                                },
+                            },
+                        },
+                        version: {
+                            bsonType: ["int", "long"],
+                            description: "Bumped on every change",
+                        },
+                        updatedAt: {
+                            bsonType: "date",
+                            description: "Updated At",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("AccountTaxonomies").drop();
+    },
+};
@@ -0,0 +1,56 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        // missing docs fall back to the defaults in code
+        await db.createCollection("AccountSettings", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "updatedAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        replyTone: {
+                            bsonType: "string",
+                            description: "Tone of AI drafted replies",
+                        },
+                        signature: {
+                            bsonType: "string",
+                            description: "Appended to AI drafted replies",
+                        },
+                        updatedAt: {
+                            bsonType: "date",
+                            description: "Updated At",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("AccountSettings").drop();
+    },
+};
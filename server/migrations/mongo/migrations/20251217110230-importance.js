@@ -0,0 +1,50 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        // missing docs fall back to the default weights in code
+        await db.createCollection("ImportanceWeights");
+        // priority inbox
+        await db
+            .collection("Messages")
+            .createIndex(
+                { accountId: 1, importance: -1, internalDate: -1 },
+                { name: "idx_importance" },
+            );
+        // correspondence counts
+        await db
+            .collection("Messages")
+            .createIndex(
+                { accountId: 1, "sender.email": 1 },
+                { name: "idx_sender" },
+            );
+        await db
+            .collection("Messages")
+            .createIndex(
+                { accountId: 1, "receiver.email": 1, labels: 1 },
+                { name: "idx_receiver" },
+            );
+        await db
+            .collection("People")
+            .createIndex(
+                { accountId: 1, "person.emailaddresses.value": 1 },
+                { name: "idx_email" },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("People").dropIndex("idx_email");
+        await db.collection("Messages").dropIndex("idx_receiver");
+        await db.collection("Messages").dropIndex("idx_sender");
+        await db.collection("Messages").dropIndex("idx_importance");
+        await db.collection("ImportanceWeights").drop();
+    },
+};
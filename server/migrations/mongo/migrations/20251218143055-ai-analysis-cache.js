@@ -0,0 +1,67 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        // shared by all accounts. keyed by the hash of the content + prompt + models
+        await db.createCollection("AiAnalysisCache", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "result", "embedding", "lastUsedAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Cache key",
+                        },
+                        result: {
+                            bsonType: "object",
+                            description: "The analysis",
+                        },
+                        embeddingText: {
+                            bsonType: "string",
+                            description: "What was embedded",
+                        },
+                        embedding: {
+                            bsonType: "array",
+                            description: "Embedding of the analysis",
+                        },
+                        hits: {
+                            bsonType: ["int", "long"],
+                            description: "Times the analysis was reused",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                        lastUsedAt: {
+                            bsonType: "date",
+                            description: "Last Used At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        // drop analyses that haven't been used in 30 days
+        await db
+            .collection("AiAnalysisCache")
+            .createIndex(
+                { lastUsedAt: 1 },
+                { name: "idx_ttl", expireAfterSeconds: 60 * 60 * 24 * 30 },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("AiAnalysisCache").drop();
+    },
+};
@@ -0,0 +1,85 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        await db.createCollection("ExtractedEntities", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "entityId", "messageId", "type", "updatedAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id + Entity Id",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        entityId: {
+                            bsonType: "string",
+                            description: "Message Id + Type",
+                        },
+                        messageId: {
+                            bsonType: "string",
+                            description: "Message it was extracted from",
+                        },
+                        type: {
+                            enum: ["order", "shipment", "flight", "bill"],
+                            description: "The kind of record",
+                        },
+                        key: {
+                            bsonType: "string",
+                            description: "Order number, tracking number, PNR or biller",
+                        },
+                        date: {
+                            bsonType: "string",
+                            description: "YYYY-MM-DD. Empty if unknown",
+                        },
+                        updatedAt: {
+                            bsonType: "date",
+                            description: "Updated At",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        await db
+            .collection("ExtractedEntities")
+            .createIndex(
+                { accountId: 1, updatedAt: 1, _id: 1 },
+                { name: "idx_sync" },
+            );
+        await db
+            .collection("ExtractedEntities")
+            .createIndex(
+                { accountId: 1, type: 1, date: -1 },
+                { name: "idx_query" },
+            );
+        await db
+            .collection("ExtractedEntities")
+            .createIndex(
+                { accountId: 1, key: 1 },
+                { name: "idx_key" },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("ExtractedEntities").drop();
+    },
+};
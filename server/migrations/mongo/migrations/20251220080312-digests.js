@@ -0,0 +1,89 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        await db.createCollection("Digests", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "digestId", "from", "to", "groups", "updatedAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id + Digest Id",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        digestId: {
+                            bsonType: "string",
+                            description: "YYYY-MM-DD it was delivered, in the account's time zone",
+                        },
+                        from: {
+                            bsonType: "date",
+                            description: "Start of the messages included",
+                        },
+                        to: {
+                            bsonType: "date",
+                            description: "End of the messages included",
+                        },
+                        groups: {
+                            bsonType: "array",
+                            description: "Messages by category",
+                        },
+                        sentAt: {
+                            bsonType: "date",
+                            description: "When it was emailed",
+                        },
+                        updatedAt: {
+                            bsonType: "date",
+                            description: "Updated At",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        await db
+            .collection("Digests")
+            .createIndex(
+                { accountId: 1, digestId: -1 },
+                { name: "idx_recent" },
+            );
+        // the dailyDigest service looks for these
+        await db
+            .collection("AccountSettings")
+            .createIndex(
+                { "digest.enabled": 1 },
+                { name: "idx_digest" },
+            );
+        // digests cover a time range
+        await db
+            .collection("Messages")
+            .createIndex(
+                { accountId: 1, internalDate: -1 },
+                { name: "idx_received" },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("Messages").dropIndex("idx_received");
+        await db.collection("AccountSettings").dropIndex("idx_digest");
+        await db.collection("Digests").drop();
+    },
+};
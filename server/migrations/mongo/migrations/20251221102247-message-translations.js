@@ -0,0 +1,65 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        await db.createCollection("MessageTranslations", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "messageId", "language", "text", "createdAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id + Message Id + Language",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        messageId: {
+                            bsonType: "string",
+                            description: "Message Id",
+                        },
+                        language: {
+                            bsonType: "string",
+                            description: "ISO 639-1 code it was translated to",
+                        },
+                        sourceLanguage: {
+                            bsonType: "string",
+                            description: "ISO 639-1 code it was written in",
+                        },
+                        text: {
+                            bsonType: "string",
+                            description: "The translated body, as markdown",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "Created At",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        await db
+            .collection("MessageTranslations")
+            .createIndex(
+                { accountId: 1, messageId: 1 },
+                { name: "idx_message" },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("MessageTranslations").drop();
+    },
+};
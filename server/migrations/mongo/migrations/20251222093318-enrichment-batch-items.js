@@ -0,0 +1,81 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        await db.createCollection("EnrichmentBatchItems", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "accountId", "state", "payload", "entry", "createdAt"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Account Id + Message Id",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id",
+                        },
+                        state: {
+                            enum: ["queued", "claimed", "submitted"],
+                            description: "Where it is on its way through a batch job",
+                        },
+                        jobName: {
+                            bsonType: "string",
+                            description: "The batch job it was sent in",
+                        },
+                        jobIndex: {
+                            bsonType: ["int", "long"],
+                            description: "Its place in the batch job",
+                        },
+                        payload: {
+                            bsonType: "binData",
+                            description: "The email_injest_available message, to dead letter",
+                        },
+                        entry: {
+                            bsonType: "object",
+                            description: "The message",
+                        },
+                        createdAt: {
+                            bsonType: "date",
+                            description: "When it was queued",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        await db
+            .collection("EnrichmentBatchItems")
+            .createIndex(
+                { state: 1, createdAt: 1 },
+                { name: "idx_queue" },
+            );
+        await db
+            .collection("EnrichmentBatchItems")
+            .createIndex(
+                { jobName: 1 },
+                { name: "idx_job", sparse: true },
+            );
+        await db
+            .collection("EnrichmentBatchItems")
+            .createIndex(
+                { claim: 1 },
+                { name: "idx_claim", sparse: true },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("EnrichmentBatchItems").drop();
+    },
+};
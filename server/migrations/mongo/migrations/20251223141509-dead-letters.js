@@ -0,0 +1,101 @@
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        await db.createCollection("DeadLetters", {
+            validator: {
+                $jsonSchema: {
+                    bsonType: "object",
+                    required: ["_id", "topic", "sourceTopic", "error", "attempts", "parked", "state", "failedAt", "payload"],
+                    properties: {
+                        _id: {
+                            bsonType: "string",
+                            description: "Topic:Partition:Offset",
+                        },
+                        topic: {
+                            bsonType: "string",
+                            description: "The DLQ, or its parked topic",
+                        },
+                        sourceTopic: {
+                            bsonType: "string",
+                            description: "The topic it failed on. Where a replay sends it",
+                        },
+                        key: {
+                            bsonType: "string",
+                            description: "The Kafka key",
+                        },
+                        accountId: {
+                            bsonType: "string",
+                            description: "Account Id, from the payload",
+                        },
+                        error: {
+                            bsonType: "string",
+                            description: "Why it failed",
+                        },
+                        attempts: {
+                            bsonType: ["int", "long"],
+                            description: "Times it has been dead lettered",
+                        },
+                        parked: {
+                            bsonType: "bool",
+                            description: "Failed too many times to replay",
+                        },
+                        state: {
+                            enum: ["dead", "replayed"],
+                            description: "If it has been replayed",
+                        },
+                        failedAt: {
+                            bsonType: "date",
+                            description: "When it was dead lettered",
+                        },
+                        replayedAt: {
+                            bsonType: "date",
+                            description: "When it was replayed",
+                        },
+                        payload: {
+                            bsonType: "string",
+                            description: "The Kafka message",
+                        },
+                        headers: {
+                            bsonType: ["array", "null"],
+                            description: "The Kafka headers, sent back on replay",
+                        },
+                    },
+                    additionalProperties: true,
+                },
+            },
+            validationLevel: "strict",
+            validationAction: "error",
+        });
+        await db
+            .collection("DeadLetters")
+            .createIndex(
+                { state: 1, failedAt: -1 },
+                { name: "idx_state" },
+            );
+        await db
+            .collection("DeadLetters")
+            .createIndex(
+                { accountId: 1, failedAt: -1 },
+                { name: "idx_account", sparse: true },
+            );
+        await db
+            .collection("DeadLetters")
+            .createIndex(
+                { topic: 1, failedAt: -1 },
+                { name: "idx_topic" },
+            );
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await db.collection("DeadLetters").drop();
+    },
+};
@@ -0,0 +1,59 @@
+// the same as the server, see LLM_EMBED_DIMENSIONS
+const dimensions = Number(process.env.LLM_EMBED_DIMENSIONS) || 3072;
+
+async function setDimensions(db, numDimensions) {
+    const [info] = await db
+        .listCollections({ name: "MessageSummaries" })
+        .toArray();
+    const validator = info.options.validator;
+    validator.$jsonSchema.properties.embedding.minItems = numDimensions;
+    validator.$jsonSchema.properties.embedding.maxItems = numDimensions;
+    await db.command({ collMod: "MessageSummaries", validator });
+
+    await db.collection("MessageSummaries").updateSearchIndex(
+        "vs_message_summaries",
+        {
+            fields: [
+                {
+                    type: "vector",
+                    path: "embedding",
+                    numDimensions,
+                    similarity: "cosine",
+                },
+                {
+                    type: "filter",
+                    path: "accountId",
+                },
+                {
+                    type: "filter",
+                    path: "sender.email",
+                },
+                {
+                    type: "filter",
+                    path: "internalDate",
+                },
+            ],
+        },
+    );
+}
+
+module.exports = {
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async up(db, client) {
+        // embeddings of another size can't be searched, so size the index to the configured embed model
+        await setDimensions(db, dimensions);
+    },
+
+    /**
+     * @param db {import('mongodb').Db}
+     * @param client {import('mongodb').MongoClient}
+     * @returns {Promise<void>}
+     */
+    async down(db, client) {
+        await setDimensions(db, 3072);
+    },
+};
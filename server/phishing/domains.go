@@ -0,0 +1,95 @@
+package phishing
+
+import (
+	"fmt"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/senderauth"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// shorter domains are too often a letter off another by chance
+const minLookalikeLength = 6
+
+// characters that look like latin letters, mostly cyrillic and greek
+var homoglyphs = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'α': 'a', 'ο': 'o', 'ρ': 'p', 'ν': 'v', 'τ': 't', 'ι': 'i', 'κ': 'k',
+	'0': 'o', '1': 'l', '3': 'e', '5': 's', 'ı': 'i', 'ɡ': 'g',
+}
+
+// letter pairs that look like one letter
+var digraphs = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// the sender's domain imitates one we correspond with
+func lookalikeDomain(domain string, known Known) (data.PhishingReason, bool) {
+	org := senderauth.OrganizationalDomain(domain)
+	if org == "" || known.Domains[org] {
+		return data.PhishingReason{}, false
+	}
+	unicodeOrg, err := idna.ToUnicode(org)
+	if err != nil {
+		unicodeOrg = org
+	}
+	orgSkeleton := skeleton(unicodeOrg)
+	for k := range known.Domains {
+		if skeleton(k) == orgSkeleton {
+			return data.PhishingReason{
+				Type:   data.PhishingHomoglyph,
+				Detail: fmt.Sprintf("%s looks like %s", unicodeOrg, k),
+			}, true
+		}
+	}
+	if len(org) < minLookalikeLength {
+		return data.PhishingReason{}, false
+	}
+	for k := range known.Domains {
+		if len(k) >= minLookalikeLength && editDistance(org, k) == 1 {
+			return data.PhishingReason{
+				Type:   data.PhishingLookalike,
+				Detail: fmt.Sprintf("%s is a letter off %s", org, k),
+			}, true
+		}
+	}
+	return data.PhishingReason{}, false
+}
+
+// the domain with look-alike characters replaced by what they imitate
+func skeleton(domain string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(domain) {
+		if latin, ok := homoglyphs[r]; ok {
+			r = latin
+		}
+		sb.WriteRune(r)
+	}
+	return digraphs.Replace(sb.String())
+}
+
+// the edit distance, counting swapped neighbouring letters as one edit. eg. exampel.com is 1 from example.com
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	// the last three rows
+	prev2 := make([]int, len(br)+1)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ar[i-1] == br[j-2] && ar[i-2] == br[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(br)]
+}
@@ -0,0 +1,86 @@
+package phishing
+
+import (
+	"fmt"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/senderauth"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// naive limit of body size
+const maxHtmlLength = 1024 * 1024 * 2
+
+// link text that is itself an address. eg. "www.example.com/login" or "https://example.com"
+var urlText = regexp.MustCompile(`(?i)^(https?://)?([a-z0-9-]+\.)+[a-z]{2,}(:\d+)?([/?#]\S*)?$`)
+
+// the first link whose text shows one site, but goes to another
+func linkMismatch(body string) (data.PhishingReason, bool) {
+	if body == "" || len(body) > maxHtmlLength {
+		return data.PhishingReason{}, false
+	}
+	tokens := html.NewTokenizer(strings.NewReader(body))
+	href := ""
+	inLink := false
+	var text strings.Builder
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			return data.PhishingReason{}, false
+		case html.StartTagToken:
+			tag := tokens.Token()
+			if tag.Data != "a" {
+				continue
+			}
+			inLink = true
+			href = ""
+			text.Reset()
+			for _, attr := range tag.Attr {
+				if attr.Key == "href" {
+					href = strings.TrimSpace(attr.Val)
+				}
+			}
+		case html.TextToken:
+			if inLink {
+				text.Write(tokens.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := tokens.TagName(); string(name) != "a" || !inLink {
+				continue
+			}
+			inLink = false
+			shown := strings.TrimSpace(text.String())
+			if !urlText.MatchString(shown) {
+				continue
+			}
+			shownHost := hostOf(shown)
+			goesTo := hostOf(href)
+			if shownHost == "" || goesTo == "" {
+				continue
+			}
+			shownOrg := senderauth.OrganizationalDomain(shownHost)
+			if shownOrg != "" && shownOrg != senderauth.OrganizationalDomain(goesTo) {
+				return data.PhishingReason{
+					Type:   data.PhishingLinkMismatch,
+					Detail: fmt.Sprintf("a link shows %s, but goes to %s", shownHost, goesTo),
+				}, true
+			}
+		}
+	}
+}
+
+// the host of a http(s) link. Empty for anything else, eg. mailto:
+func hostOf(link string) string {
+	u, err := url.Parse(link)
+	if err == nil && u.Scheme == "" {
+		// link text often leaves it off
+		u, err = url.Parse("http://" + link)
+	}
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,163 @@
+package phishing
+
+import (
+	"context"
+	"fmt"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/senderauth"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// scores at or over this are warned about
+const WarningThreshold = 0.5
+
+// how much each reason adds to the score
+var weights = map[string]float64{
+	data.PhishingDisplayName:  0.5,
+	data.PhishingLookalike:    0.5,
+	data.PhishingHomoglyph:    0.7,
+	data.PhishingLinkMismatch: 0.3,
+	data.PhishingDmarcFailed:  0.5,
+}
+
+// who the account corresponds with, that phishing pretends to be
+type Known struct {
+	// lower case display name -> their lower case addresses
+	Contacts map[string][]string
+	// organizational domains of contacts and people we've sent to
+	Domains map[string]bool
+}
+
+// the account's contacts, and the domains of everyone they've emailed
+func LoadKnown(ctx context.Context, accountId string) (*Known, error) {
+	known := Known{
+		Contacts: make(map[string][]string),
+		Domains:  make(map[string]bool),
+	}
+	cursor, err := globals.DocDb().Collection("People").Find(
+		ctx,
+		bson.M{"accountId": accountId},
+		options.Find().SetProjection(bson.M{
+			"person.names.displayname":    1,
+			"person.emailaddresses.value": 1,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var p data.GooglePerson
+		if err := cursor.Decode(&p); err != nil {
+			return nil, err
+		}
+		emails := make([]string, 0, len(p.Person.EmailAddresses))
+		for _, e := range p.Person.EmailAddresses {
+			email := strings.ToLower(e.Value)
+			emails = append(emails, email)
+			if org := senderauth.OrganizationalDomain(domainOf(email)); org != "" {
+				known.Domains[org] = true
+			}
+		}
+		for _, n := range p.Person.Names {
+			name := normalizeName(n.DisplayName)
+			if name != "" {
+				known.Contacts[name] = append(known.Contacts[name], emails...)
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	var sentTo []string
+	err = globals.DocDb().Collection("Messages").
+		Distinct(ctx, "receiver.email", bson.M{"accountId": accountId, "labels": "SENT"}).
+		Decode(&sentTo)
+	if err != nil {
+		return nil, err
+	}
+	for _, email := range sentTo {
+		if org := senderauth.OrganizationalDomain(domainOf(email)); org != "" {
+			known.Domains[org] = true
+		}
+	}
+	return &known, nil
+}
+
+// the message's phishing risk, from its sender, authentication and html body
+func Analyze(entry data.GmailEntry, html string, known Known) data.PhishingRisk {
+	reasons := make([]data.PhishingReason, 0)
+	if reason, ok := displayNameMismatch(entry.Sender, known); ok {
+		reasons = append(reasons, reason)
+	}
+	if reason, ok := lookalikeDomain(domainOf(entry.Sender.Email), known); ok {
+		reasons = append(reasons, reason)
+	}
+	if reason, ok := linkMismatch(html); ok {
+		reasons = append(reasons, reason)
+	}
+	if entry.SenderAuth != nil && entry.SenderAuth.Dmarc == "fail" {
+		reasons = append(reasons, data.PhishingReason{
+			Type:   data.PhishingDmarcFailed,
+			Detail: fmt.Sprintf("%s failed DMARC", entry.SenderAuth.FromDomain),
+		})
+	}
+	score := 0.0
+	for _, r := range reasons {
+		score += weights[r.Type]
+	}
+	score = math.Min(1, score)
+	return data.PhishingRisk{
+		Score:      score,
+		Reasons:    reasons,
+		Warning:    score >= WarningThreshold,
+		AnalyzedAt: time.Now().UTC(),
+	}
+}
+
+// the sender uses a contact's name, from an address that isn't theirs
+func displayNameMismatch(sender data.PersonInfo, known Known) (data.PhishingReason, bool) {
+	emails, ok := known.Contacts[normalizeName(sender.Name)]
+	if !ok || len(emails) == 0 {
+		return data.PhishingReason{}, false
+	}
+	email := strings.ToLower(sender.Email)
+	if slices.Contains(emails, email) {
+		return data.PhishingReason{}, false
+	}
+	return data.PhishingReason{
+		Type:   data.PhishingDisplayName,
+		Detail: fmt.Sprintf("%q is a contact, but %s isn't one of their addresses", sender.Name, email),
+	}, true
+}
+
+// lower case, with quotes and repeated spaces removed. eg. `"Jane  Doe"` is "jane doe"
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.Trim(name, `"' `))), " ")
+}
+
+func domainOf(email string) string {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(domain, "<> "))
+}
+
+// saves the risk on the message. Bumps the revision so clients pull it
+func Save(ctx context.Context, entry data.GmailEntry, risk data.PhishingRisk) error {
+	_, err := globals.DocDb().Collection("Messages").UpdateOne(ctx, bson.M{"_id": entry.ToDocumentId()}, bson.M{
+		"$set":         bson.M{"phishing": risk},
+		"$currentDate": bson.M{"updatedAt": true},
+		"$inc":         bson.M{"revisionCount": 1},
+	})
+	return err
+}
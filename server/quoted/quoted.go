@@ -0,0 +1,211 @@
+package quoted
+
+import (
+	"bytes"
+	"regexp"
+	"slices"
+	"strings"
+
+	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
+	"golang.org/x/net/html"
+)
+
+// niave limit of body size
+const maxHtmlLength = 1024 * 1024 * 2
+
+var (
+	// Gmail and Apple: "On Mon, Jan 1, 2024 at 10:00 AM Jane <jane@example.com> wrote:"
+	// plus a few other languages
+	wroteHeader = regexp.MustCompile(`(?i)^\s*(on|le|am|el|il|op|em)\s.*(wrote|a écrit|schrieb|escribió|ha scritto|schreef|escreveu)\s*:\s*$`)
+	// Outlook: "-----Original Message-----" or a line of underscores before the From: block
+	originalMessage = regexp.MustCompile(`(?i)^\s*-{2,}\s*original message\s*-{2,}\s*$`)
+	underscores     = regexp.MustCompile(`^\s*_{10,}\s*$`)
+	// the headers Outlook puts above the quoted message
+	outlookHeader = regexp.MustCompile(`(?i)^\s*\**(from|sent|date|to|cc|subject)\s*:\**\s`)
+	// "-- " is the standard delimiter. Some clients drop the trailing space
+	signatureDelimiter = regexp.MustCompile(`^-- ?$`)
+	mobileSignature    = regexp.MustCompile(`(?i)^\s*(sent from my \w+|sent from (mail|outlook) for \w+|get outlook for \w+|sent from yahoo mail)`)
+)
+
+// the message without quoted replies or the sender's signature.
+// Uses the html if there is one, as it marks quotes more reliably than the plain text.
+// Falls back to the whole message, if that would leave nothing.
+func NewContent(plainText, htmlBody string) (string, error) {
+	text := strings.TrimSpace(plainText)
+	// > quoting only means a reply in plain text. In html it's already handled,
+	// and any blockquote left is part of the message
+	dropQuotedLines := true
+	if htmlBody != "" {
+		dropQuotedLines = false
+		if len(htmlBody) > maxHtmlLength {
+			htmlBody = htmlBody[:maxHtmlLength]
+		}
+		stripped, err := stripHtmlQuotes(htmlBody)
+		if err != nil {
+			return "", err
+		}
+		md, err := htmltomarkdown.ConvertString(stripped)
+		if err != nil {
+			return "", err
+		}
+		if md = strings.TrimSpace(md); md != "" {
+			text = md
+		} else if text == "" {
+			// everything was quoted, so keep it all
+			md, err = htmltomarkdown.ConvertString(htmlBody)
+			if err != nil {
+				return "", err
+			}
+			text = strings.TrimSpace(md)
+		}
+	}
+	if stripped := stripLines(text, dropQuotedLines); stripped != "" {
+		return stripped, nil
+	}
+	return text, nil
+}
+
+// removes the quoted replies and signature from plain text
+func StripText(text string) string {
+	return stripLines(text, true)
+}
+
+func stripLines(text string, dropQuotedLines bool) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isQuoteHeader(lines, i) || signatureDelimiter.MatchString(line) || mobileSignature.MatchString(line) {
+			break // everything after is the quote or signature
+		}
+		if dropQuotedLines && strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue // inline replies keep the lines around the quote
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func isQuoteHeader(lines []string, i int) bool {
+	line := lines[i]
+	if wroteHeader.MatchString(line) || originalMessage.MatchString(line) {
+		return true
+	}
+	// gmail wraps long headers over 2 lines
+	if i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(strings.ToLower(line)), "on ") && wroteHeader.MatchString(line+" "+lines[i+1]) {
+		return true
+	}
+	if underscores.MatchString(line) {
+		// only when it's above outlook's headers. Otherwise it's decoration
+		for j := i + 1; j < len(lines) && j <= i+2; j++ {
+			if outlookHeader.MatchString(lines[j]) {
+				return true
+			}
+		}
+		return false
+	}
+	// outlook's From:, Sent:, To:, Subject: block
+	if outlookHeader.MatchString(line) && strings.HasPrefix(strings.ToLower(strings.TrimLeft(strings.TrimSpace(line), "*")), "from") {
+		headers := 0
+		for j := i + 1; j < len(lines) && j <= i+4; j++ {
+			if outlookHeader.MatchString(lines[j]) {
+				headers++
+			}
+		}
+		return headers >= 2
+	}
+	return false
+}
+
+// removes the quote and signature blocks that mail clients mark in their html
+func stripHtmlQuotes(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	removeQuotes(doc)
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func removeQuotes(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case isQuoteStart(c):
+			// outlook separates the quote with a line
+			prev := c.PrevSibling
+			for prev != nil && prev.Type == html.TextNode && strings.TrimSpace(prev.Data) == "" {
+				prev = prev.PrevSibling
+			}
+			if prev != nil && prev.Type == html.ElementNode && prev.Data == "hr" {
+				n.RemoveChild(prev)
+			}
+			// outlook puts the quoted message in the siblings after its header
+			for r := c; r != nil; {
+				rn := r.NextSibling
+				n.RemoveChild(r)
+				r = rn
+			}
+			return
+		case isQuoteBlock(c):
+			n.RemoveChild(c)
+		default:
+			removeQuotes(c)
+		}
+		c = next
+	}
+}
+
+// blocks that are only the quote or signature
+func isQuoteBlock(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	switch {
+	// gmail
+	case slices.ContainsFunc(classes, func(c string) bool { return strings.HasPrefix(c, "gmail_quote") }):
+		return true
+	case slices.Contains(classes, "gmail_signature") || attr(n, "data-smartmail") == "gmail_signature":
+		return true
+	// apple mail and thunderbird
+	case n.Data == "blockquote" && attr(n, "type") == "cite":
+		return true
+	case slices.Contains(classes, "moz-cite-prefix") || slices.Contains(classes, "moz-signature"):
+		return true
+	case attr(n, "id") == "AppleMailSignature":
+		return true
+	// outlook
+	case attr(n, "id") == "Signature":
+		return true
+	// yahoo
+	case slices.Contains(classes, "yahoo_quoted"):
+		return true
+	}
+	return false
+}
+
+// elements that start the quote. They, and everything after them, are removed
+func isQuoteStart(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch attr(n, "id") {
+	case "divRplyFwdMsg", "appendonsend", "stopSpelling":
+		return true
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package redact
+
+import (
+	"errors"
+	"fromkeith/my-desktop-server/gmail/data"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// how aggressively to mask. Set per account in their settings
+type Level string
+
+const (
+	// send the email as is
+	LevelOff Level = "off"
+	// values that pass a checksum, or are labelled. eg. "account #"
+	LevelStandard Level = "standard"
+	// also any long run of digits, checksum or not
+	LevelStrict Level = "strict"
+)
+
+const (
+	TypeCreditCard      = "creditCard"
+	TypeGovernmentId    = "governmentId"
+	TypePhone           = "phone"
+	TypeAccountNumber   = "accountNumber"
+	TypeOneTimePasscode = "oneTimePasscode"
+	TypeNumber          = "number"
+)
+
+var ErrUnknownLevel = errors.New("unknown redaction level")
+
+func ParseLevel(s string) (Level, error) {
+	switch l := Level(strings.ToLower(strings.TrimSpace(s))); l {
+	case LevelOff, LevelStandard, LevelStrict:
+		return l, nil
+	case "":
+		return LevelStandard, nil
+	}
+	return "", ErrUnknownLevel
+}
+
+type detector struct {
+	kind string
+	re   *regexp.Regexp
+	// the submatch to mask. 0 masks the whole match
+	group int
+	// extra check on the matched value, eg. a checksum
+	valid func(value string) bool
+	// only applied at the strict level
+	strictOnly bool
+}
+
+// order matters: earlier detectors claim the digits before later, looser, ones see them.
+// Masks contain no digits, so nothing is redacted twice.
+var detectors = []detector{
+	{
+		kind:  TypeCreditCard,
+		re:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid: func(v string) bool { return luhn(digitsOf(v)) },
+	},
+	{
+		// US SSN
+		kind:  TypeGovernmentId,
+		re:    regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+		valid: func(v string) bool { return validSsn(digitsOf(v)) },
+	},
+	{
+		// Canadian SIN. Unformatted ones are too easy to confuse with other numbers,
+		// so they're left to the strict level
+		kind: TypeGovernmentId,
+		re:   regexp.MustCompile(`\b\d{3}[ -]\d{3}[ -]\d{3}\b`),
+		valid: func(v string) bool {
+			d := digitsOf(v)
+			return d[0] != '0' && d[0] != '8' && luhn(d)
+		},
+	},
+	{
+		kind:  TypeAccountNumber,
+		re:    regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`),
+		valid: validIban,
+	},
+	{
+		kind:  TypeAccountNumber,
+		re:    regexp.MustCompile(`(?i)\b(?:account|acct|a/c|routing|transit|member|policy|customer)\.?(?:\s*(?:number|num|no\.?|#))?\s*(?:is|:|#)?\s*(\d[\d -]{3,}\d)`),
+		group: 1,
+	},
+	{
+		kind:  TypeOneTimePasscode,
+		re:    regexp.MustCompile(`(?i)\b(?:code|otp|passcode|password|pin|verification|one[- ]time)\b[^\d\n]{0,25}?\b(\d{4,8}|\d{3}[ -]\d{3})\b`),
+		group: 1,
+	},
+	{
+		kind: TypePhone,
+		re:   regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{3}\)|\b\d{3})[\s.-]?\d{3}[\s.-]\d{4}\b`),
+	},
+	{
+		// international numbers that aren't in the north american format
+		kind: TypePhone,
+		re:   regexp.MustCompile(`\+\d{1,3}(?:[\s.-]?\d{2,4}){2,5}\b`),
+	},
+	{
+		kind:       TypeNumber,
+		re:         regexp.MustCompile(`\b\d{6,}\b`),
+		strictOnly: true,
+	},
+}
+
+// masks sensitive values in the text, and reports what was masked
+func Redact(text string, level Level) (string, []data.Redaction) {
+	if level == LevelOff || text == "" {
+		return text, nil
+	}
+	counts := make(map[string]int)
+	order := make([]string, 0)
+	for _, d := range detectors {
+		if d.strictOnly && level != LevelStrict {
+			continue
+		}
+		text = d.apply(text, func() {
+			if counts[d.kind] == 0 {
+				order = append(order, d.kind)
+			}
+			counts[d.kind]++
+		})
+	}
+	if len(order) == 0 {
+		return text, nil
+	}
+	redactions := make([]data.Redaction, 0, len(order))
+	for _, kind := range order {
+		redactions = append(redactions, data.Redaction{Type: kind, Count: counts[kind]})
+	}
+	return text, redactions
+}
+
+// merges redactions from several fields of the same message
+func Merge(a, b []data.Redaction) []data.Redaction {
+	out := append([]data.Redaction{}, a...)
+outer:
+	for _, r := range b {
+		for i := range out {
+			if out[i].Type == r.Type {
+				out[i].Count += r.Count
+				continue outer
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// a match that fails valid is scanned again from its next character, so a value overlapping it,
+// eg. a card number after a stray digit, is still found
+func (d detector) apply(text string, found func()) string {
+	mask := "[REDACTED " + d.kind + "]"
+	var sb strings.Builder
+	last := 0
+	for pos := 0; pos < len(text); {
+		m := d.re.FindStringSubmatchIndex(text[pos:])
+		if m == nil {
+			break
+		}
+		matchStart, matchEnd := pos+m[0], pos+m[1]
+		// \b always matches at the start of text[pos:], even in the middle of a word
+		if matchStart == pos && pos > 0 && isWordChar(text[pos-1]) && isWordChar(text[pos]) {
+			pos++
+			continue
+		}
+		if m[2*d.group] < 0 {
+			pos = matchEnd
+			continue
+		}
+		start, end := pos+m[2*d.group], pos+m[2*d.group+1]
+		if d.valid != nil && !d.valid(text[start:end]) {
+			pos = matchStart + 1
+			continue
+		}
+		sb.WriteString(text[last:start])
+		sb.WriteString(mask)
+		last = end
+		pos = matchEnd
+		found()
+	}
+	if last == 0 {
+		return text
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func digitsOf(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// the checksum used by credit cards and SINs
+func luhn(digits string) bool {
+	if digits == "" {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		n := int(digits[i] - '0')
+		if double {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// SSNs have no checksum, but some ranges are never issued
+func validSsn(digits string) bool {
+	area, group, serial := digits[:3], digits[3:5], digits[5:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// mod 97 check from ISO 13616
+func validIban(value string) bool {
+	iban := strings.ReplaceAll(value, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	rearranged := iban[4:] + iban[:4]
+	var sb strings.Builder
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			sb.WriteString(strconv.Itoa(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(sb.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
@@ -0,0 +1,144 @@
+package redact
+
+import (
+	"fromkeith/my-desktop-server/gmail/data"
+	"slices"
+	"testing"
+)
+
+func TestLuhn(t *testing.T) {
+	for digits, want := range map[string]bool{
+		"4111111111111111": true,
+		"4111111111111112": false,
+		"79927398713":      true,
+		"79927398710":      false,
+		"130692544":        true,
+		"":                 false,
+	} {
+		if got := luhn(digits); got != want {
+			t.Errorf("luhn(%q) = %v, want %v", digits, got, want)
+		}
+	}
+}
+
+func TestValidSsn(t *testing.T) {
+	for digits, want := range map[string]bool{
+		"123456789": true,
+		"000456789": false,
+		"666456789": false,
+		"912456789": false,
+		"123006789": false,
+		"123450000": false,
+	} {
+		if got := validSsn(digits); got != want {
+			t.Errorf("validSsn(%q) = %v, want %v", digits, got, want)
+		}
+	}
+}
+
+func TestSin(t *testing.T) {
+	for text, want := range map[string]string{
+		"sin 130 692 544": "sin [REDACTED governmentId]",
+		"sin 130-692-544": "sin [REDACTED governmentId]",
+		// fails the checksum
+		"sin 130 692 545": "sin 130 692 545",
+		// 0 and 8 aren't issued
+		"sin 046 454 286": "sin 046 454 286",
+	} {
+		if got, _ := Redact(text, LevelStandard); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestValidIban(t *testing.T) {
+	for value, want := range map[string]bool{
+		"GB82 WEST 1234 5698 7654 32": true,
+		"GB82WEST12345698765432":      true,
+		"DE89 3704 0044 0532 0130 00": true,
+		"GB82 WEST 1234 5698 7654 33": false,
+		"GB82 WEST 1234":              false,
+		"GB82 west 1234 5698 7654 32": false,
+	} {
+		if got := validIban(value); got != want {
+			t.Errorf("validIban(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for name, tc := range map[string]struct {
+		text       string
+		level      Level
+		want       string
+		redactions []data.Redaction
+	}{
+		"off": {
+			text:  "card 4111 1111 1111 1111",
+			level: LevelOff,
+			want:  "card 4111 1111 1111 1111",
+		},
+		// the card detector runs before the looser number one
+		"card before number": {
+			text:       "4111111111111111",
+			level:      LevelStrict,
+			want:       "[REDACTED creditCard]",
+			redactions: []data.Redaction{{Type: TypeCreditCard, Count: 1}},
+		},
+		"card before account label": {
+			text:       "account # 4111 1111 1111 1111",
+			level:      LevelStandard,
+			want:       "account # [REDACTED creditCard]",
+			redactions: []data.Redaction{{Type: TypeCreditCard, Count: 1}},
+		},
+		"sin before passcode": {
+			text:       "your code is 130 692 544",
+			level:      LevelStandard,
+			want:       "your code is [REDACTED governmentId]",
+			redactions: []data.Redaction{{Type: TypeGovernmentId, Count: 1}},
+		},
+		"reported in the order found": {
+			text:  "call 416-555-0199 about card 4111 1111 1111 1111",
+			level: LevelStandard,
+			want:  "call [REDACTED phone] about card [REDACTED creditCard]",
+			redactions: []data.Redaction{
+				{Type: TypeCreditCard, Count: 1},
+				{Type: TypePhone, Count: 1},
+			},
+		},
+		// the stray digit makes the match fail its checksum, but the card after it is still found
+		"card after a stray digit": {
+			text:       "ref 7 4111 1111 1111 1111",
+			level:      LevelStandard,
+			want:       "ref 7 [REDACTED creditCard]",
+			redactions: []data.Redaction{{Type: TypeCreditCard, Count: 1}},
+		},
+		// the card's digits are in there, but not on their own
+		"digits within a longer number": {
+			text:  "ref 74111111111111111",
+			level: LevelStandard,
+			want:  "ref 74111111111111111",
+		},
+		"strict masks long numbers": {
+			text:       "order 12345678",
+			level:      LevelStrict,
+			want:       "order [REDACTED number]",
+			redactions: []data.Redaction{{Type: TypeNumber, Count: 1}},
+		},
+		"standard leaves long numbers": {
+			text:  "order 12345678",
+			level: LevelStandard,
+			want:  "order 12345678",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, redactions := Redact(tc.text, tc.level)
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			if !slices.Equal(redactions, tc.redactions) {
+				t.Errorf("redactions %+v, want %+v", redactions, tc.redactions)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package savedsearches
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"regexp"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// converts the saved search into a filter on the Messages collection
+func BuildFilter(accountId string, q data.SavedSearchQuery) bson.M {
+	filter := bson.M{
+		"accountId": accountId,
+		"isDeleted": bson.M{"$ne": true},
+	}
+	if len(q.Categories) > 0 {
+		filter["categories"] = bson.M{"$in": q.Categories}
+	}
+	if len(q.Tags) > 0 {
+		filter["tags"] = bson.M{"$in": q.Tags}
+	}
+	labels := slices.Clone(q.Labels)
+	if q.UnreadOnly {
+		labels = append(labels, "UNREAD")
+	}
+	if len(labels) > 0 {
+		filter["labels"] = bson.M{"$all": labels}
+	}
+	if len(q.Senders) > 0 {
+		filter["sender.email"] = bson.M{"$in": q.Senders}
+	}
+	if q.TopicId != "" {
+		filter["topicId"] = q.TopicId
+	}
+	if q.Text != "" {
+		pattern := bson.Regex{Pattern: regexp.QuoteMeta(q.Text), Options: "i"}
+		filter["$or"] = []bson.M{
+			{"subject": pattern},
+			{"snippet": pattern},
+		}
+	}
+	if q.WithinDays > 0 {
+		filter["internalDate"] = bson.M{
+			"$gte": time.Now().AddDate(0, 0, -q.WithinDays).UnixMilli(),
+		}
+	}
+	return filter
+}
+
+func CountSearch(ctx context.Context, search data.SavedSearch) (total int64, unread int64, err error) {
+	col := globals.DocDb().Collection("Messages")
+	filter := BuildFilter(search.AccountId, search.Query)
+	total, err = col.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, 0, err
+	}
+	if search.Query.UnreadOnly {
+		return total, total, nil
+	}
+	unread, err = col.CountDocuments(ctx, bson.M{
+		"$and": []bson.M{
+			filter,
+			{"labels": "UNREAD"},
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return total, unread, nil
+}
+
+// recounts the given saved searches. Only touches the ones whose counts changed,
+// so clients aren't sent updates for nothing.
+func WriteCounts(ctx context.Context, searches []data.SavedSearch) error {
+	toWrite := make([]mongo.WriteModel, 0, len(searches))
+	for _, search := range searches {
+		total, unread, err := CountSearch(ctx, search)
+		if err != nil {
+			return err
+		}
+		if total == search.TotalCount && unread == search.UnreadCount {
+			continue
+		}
+		toWrite = append(toWrite, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": search.ToDocumentId()}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"totalCount":  total,
+					"unreadCount": unread,
+					"countedAt":   time.Now().UTC(),
+				},
+				"$currentDate": bson.M{"updatedAt": true},
+			}).
+			SetUpsert(false),
+		)
+	}
+	if len(toWrite) == 0 {
+		return nil
+	}
+	_, err := globals.DocDb().Collection("SavedSearches").BulkWrite(ctx, toWrite)
+	return err
+}
+
+// recounts every saved search in the account
+func RecountAccount(ctx context.Context, accountId string) error {
+	return recount(ctx, bson.M{"accountId": accountId, "isDeleted": false})
+}
+
+// recounts searches that use a relative date, as their counts drift over time
+// even when no messages change.
+func RecountRelative(ctx context.Context) error {
+	return recount(ctx, bson.M{"query.withinDays": bson.M{"$gt": 0}, "isDeleted": false})
+}
+
+func recount(ctx context.Context, filter bson.M) error {
+	cur, err := globals.DocDb().Collection("SavedSearches").Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	var searches []data.SavedSearch
+	if err := cur.All(ctx, &searches); err != nil {
+		return err
+	}
+	return WriteCounts(ctx, searches)
+}
@@ -0,0 +1,200 @@
+package savedsearches
+
+import (
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type SaveSearchRequest struct {
+	Name  string                `validate:"required" json:"name"`
+	Query data.SavedSearchQuery `validate:"required" json:"query"`
+} // @name SaveSearchRequest
+
+func normalizeRequest(req *SaveSearchRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("Missing name")
+	}
+	// match how the gemini service normalizes
+	for i, c := range req.Query.Categories {
+		req.Query.Categories[i] = strings.TrimSpace(strings.ToLower(c))
+	}
+	for i, t := range req.Query.Tags {
+		req.Query.Tags[i] = strings.TrimSpace(strings.ToLower(t))
+	}
+	for i, s := range req.Query.Senders {
+		req.Query.Senders[i] = strings.TrimSpace(s)
+	}
+	if req.Query.WithinDays < 0 {
+		req.Query.WithinDays = 0
+	}
+	return nil
+}
+
+// CreateSavedSearch godoc
+// @Summary      Create a saved search
+// @Description  Saves a named search for this account. Its counts are kept up to date as messages change.
+// @Tags         search
+// @Accept       json
+// @Param        request body SaveSearchRequest true "Saved search"
+// @Produce      json
+// @Success      200  {object}  data.SavedSearch
+// @Router       /savedSearches [post]
+func CreateSavedSearch(r *gin.Context) {
+	var req SaveSearchRequest
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeRequest(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	search := data.SavedSearch{
+		AccountId: r.GetString("accountId"),
+		SearchId:  "search_" + uuid.New().String(),
+		Name:      req.Name,
+		Query:     req.Query,
+		CountedAt: time.Now().UTC(),
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+	}
+	total, unread, err := CountSearch(r, search)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to count new saved search")
+		r.JSON(http.StatusBadRequest, gin.H{"error": "Failed to run search"})
+		return
+	}
+	search.TotalCount = total
+	search.UnreadCount = unread
+
+	doc := bson.M{}
+	b, _ := bson.Marshal(search)
+	_ = bson.Unmarshal(b, &doc)
+	doc["_id"] = search.ToDocumentId()
+	if _, err := globals.DocDb().Collection("SavedSearches").InsertOne(r, doc); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to save search")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save search"})
+		return
+	}
+	r.JSON(http.StatusOK, search)
+}
+
+// UpdateSavedSearch godoc
+// @Summary      Update a saved search
+// @Tags         search
+// @Accept       json
+// @Param        searchId path string true "searchId"
+// @Param        request body SaveSearchRequest true "Saved search"
+// @Produce      json
+// @Success      200  {object}  data.SavedSearch
+// @Router       /savedSearches/{searchId} [put]
+func UpdateSavedSearch(r *gin.Context) {
+	var req SaveSearchRequest
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeRequest(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	search := data.SavedSearch{
+		AccountId: r.GetString("accountId"),
+		SearchId:  r.Param("searchId"),
+		Query:     req.Query,
+	}
+	total, unread, err := CountSearch(r, search)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to count updated saved search")
+		r.JSON(http.StatusBadRequest, gin.H{"error": "Failed to run search"})
+		return
+	}
+	query := bson.M{}
+	b, _ := bson.Marshal(req.Query)
+	_ = bson.Unmarshal(b, &query)
+
+	res := globals.DocDb().Collection("SavedSearches").FindOneAndUpdate(
+		r,
+		bson.M{"_id": search.ToDocumentId(), "isDeleted": false},
+		bson.M{
+			"$set": bson.M{
+				"name":        req.Name,
+				"query":       query,
+				"totalCount":  total,
+				"unreadCount": unread,
+				"countedAt":   time.Now().UTC(),
+			},
+			"$currentDate": bson.M{"updatedAt": true},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	var updated data.SavedSearch
+	if err := res.Decode(&updated); errors.Is(err, mongo.ErrNoDocuments) {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+		return
+	} else if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to update saved search")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save search"})
+		return
+	}
+	r.JSON(http.StatusOK, updated)
+}
+
+// DeleteSavedSearch godoc
+// @Summary      Delete a saved search
+// @Tags         search
+// @Param        searchId path string true "searchId"
+// @Success      200
+// @Router       /savedSearches/{searchId} [delete]
+func DeleteSavedSearch(r *gin.Context) {
+	search := data.SavedSearch{
+		AccountId: r.GetString("accountId"),
+		SearchId:  r.Param("searchId"),
+	}
+	// soft delete so it replicates to clients
+	res, err := globals.DocDb().Collection("SavedSearches").UpdateOne(
+		r,
+		bson.M{"_id": search.ToDocumentId(), "isDeleted": false},
+		bson.M{
+			"$set":         bson.M{"isDeleted": true},
+			"$currentDate": bson.M{"updatedAt": true},
+		},
+	)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to delete saved search")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete search"})
+		return
+	}
+	if res.MatchedCount == 0 {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+		return
+	}
+	r.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,111 @@
+package savedsearches
+
+import (
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type SyncCheckpoint struct {
+	SearchId  string `validate:"required" json:"searchId"`
+	UpdatedAt string `validate:"required" json:"updatedAt"`
+} // @name CheckpointSavedSearch
+
+type PullSavedSearchesResponse struct {
+	Searches   []data.SavedSearch `validate:"required" json:"searches"`
+	Checkpoint SyncCheckpoint     `validate:"required" json:"checkpoint"`
+} // @name PullSavedSearchesResponse
+
+func ensureJsonSearch(s *data.SavedSearch) {
+	emptyArray := make([]string, 0)
+	if s.Query.Categories == nil {
+		s.Query.Categories = emptyArray
+	}
+	if s.Query.Tags == nil {
+		s.Query.Tags = emptyArray
+	}
+	if s.Query.Labels == nil {
+		s.Query.Labels = emptyArray
+	}
+	if s.Query.Senders == nil {
+		s.Query.Senders = emptyArray
+	}
+}
+
+// PullSavedSearches godoc
+// @Summary      Get Saved Searches
+// @Description  Sync endpoint to pull all changes to saved searches, and their counts, for this account.
+// @Tags         search
+// @Produce      json
+// @Param        searchId query string true "searchId"
+// @Param        updatedAt query string true "Last updated time"
+// @Param        limit query int true "Batch size"
+// @Success      200  {object}  PullSavedSearchesResponse
+// @Router       /savedSearches/pull [get]
+func PullSavedSearches(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	searchId := r.Query("searchId")
+	lastId := data.ToDocumentId(accountId, searchId)
+	updatedAtStr := r.Query("updatedAt")
+	updatedAt, _ := time.Parse(time.RFC3339Nano, updatedAtStr)
+
+	batchSizeStr := r.Query("limit")
+	batchSize, _ := strconv.ParseInt(batchSizeStr, 10, 64)
+	if batchSize <= 0 || batchSize > 100 {
+		batchSize = 10
+	}
+
+	opts := options.Find().SetSort(bson.D{{"updatedAt", 1}, {"_id", 1}}).SetLimit(batchSize)
+	cursor, err := globals.DocDb().Collection("SavedSearches").Find(
+		r,
+		bson.M{
+			"$or": []bson.M{
+				bson.M{"updatedAt": bson.M{"$gt": updatedAt}},
+				bson.M{
+					"updatedAt": updatedAt,
+					"_id":       bson.M{"$gt": lastId},
+				},
+			},
+			"accountId": accountId,
+		},
+		opts,
+	)
+	if err != nil {
+		r.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(r)
+
+	var searches []data.SavedSearch
+	if err := cursor.All(r, &searches); err != nil {
+		r.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range searches {
+		ensureJsonSearch(&searches[i])
+	}
+
+	var nextId string
+	var nextUpdatedAt string
+	if len(searches) > 0 {
+		last := searches[len(searches)-1]
+		nextId = last.SearchId
+		nextUpdatedAt = last.UpdatedAt.Format(time.RFC3339Nano)
+	} else {
+		nextId = searchId
+		nextUpdatedAt = updatedAtStr
+	}
+
+	r.JSON(200, PullSavedSearchesResponse{
+		Searches:   searches,
+		Checkpoint: SyncCheckpoint{SearchId: nextId, UpdatedAt: nextUpdatedAt},
+	})
+}
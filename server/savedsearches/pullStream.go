@@ -0,0 +1,97 @@
+package savedsearches
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/utils"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// PullStream godoc
+// @Summary      Stream Saved Searches
+// @Description  Sync endpoint to allow for for push from server to client of changes to saved searches and their counts.
+// @Tags         search
+// @Produce      event-stream
+// @Router       /savedSearches/pullStream [get]
+func PullStream(r *gin.Context) {
+	accountId := r.GetString("accountId")
+
+	matchStage := bson.D{{
+		"$match", bson.D{
+			{"fullDocument.accountId", accountId},
+		},
+	}}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(10 * time.Second)
+	stream, err := globals.DocDb().Collection("SavedSearches").Watch(r, mongo.Pipeline{matchStage}, opts)
+	if err != nil {
+		r.Error(err)
+		return
+	}
+	defer stream.Close(r)
+
+	streamCtx, cancel := context.WithCancel(r.Request.Context())
+	defer cancel()
+
+	batchChan, batchErr := utils.BatchMongoStreamChannel(streamCtx, stream, 10, time.Second)
+
+	r.Set("Content-Type", "text/event-stream")
+	r.Stream(func(w io.Writer) bool {
+		select {
+		case err := <-batchErr:
+			if err != nil {
+				log.Error().
+					Ctx(r).
+					Err(err).
+					Msg("failed to batch saved searches in stream")
+				return false
+			}
+		case batch := <-batchChan:
+			payloads := make([]data.SavedSearch, 0, len(batch))
+			chkPoint := SyncCheckpoint{}
+			for _, ev := range batch {
+				full, ok := ev["fullDocument"]
+				if !ok {
+					return true
+				}
+				raw, _ := bson.Marshal(full)
+				var search data.SavedSearch
+				if err := bson.Unmarshal(raw, &search); err != nil {
+					log.Error().
+						Ctx(r).
+						Err(err).
+						Any("full", full).
+						Msg("failed to unmarshal saved search in stream")
+					return true
+				}
+				ensureJsonSearch(&search)
+				payloads = append(payloads, search)
+				at := search.UpdatedAt.Format(time.RFC3339Nano)
+				if at > chkPoint.UpdatedAt {
+					chkPoint = SyncCheckpoint{SearchId: search.SearchId, UpdatedAt: at}
+				} else if at == chkPoint.UpdatedAt && search.SearchId > chkPoint.SearchId {
+					chkPoint = SyncCheckpoint{SearchId: search.SearchId, UpdatedAt: at}
+				}
+			}
+			payload, _ := json.Marshal(PullSavedSearchesResponse{
+				Searches:   payloads,
+				Checkpoint: chkPoint,
+			})
+			r.SSEvent("message", payload)
+			return true
+		case <-time.After(time.Second):
+			return true // allow the request to check its status or end
+		}
+		return true
+	})
+
+}
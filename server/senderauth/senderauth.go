@@ -0,0 +1,282 @@
+package senderauth
+
+import (
+	"fromkeith/my-desktop-server/gmail/data"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// the results Gmail adds on receiving. Results from earlier hops could be forged by the sender
+const trustedAuthServId = "mx.google.com"
+
+// p=REJECT in the comment Gmail puts after the dmarc result
+var dmarcPolicy = regexp.MustCompile(`(?i)\bp=(\w+)`)
+
+// the headers a message's authentication is read from, in the order they appear
+type Headers struct {
+	AuthenticationResults []string
+	ReceivedSpf           []string
+	DkimSignatures        []string
+}
+
+// a method's result. eg. spf=pass smtp.mailfrom=a@example.com
+type result struct {
+	method string
+	result string
+	// eg. smtp.mailfrom, header.d
+	props map[string]string
+	// what's in the brackets
+	comment string
+}
+
+// the sender's authentication, for a message from the address. Nil if the message has no results, eg. mail we sent
+func Parse(headers Headers, from string) *data.SenderAuth {
+	authResults := pickAuthenticationResults(headers.AuthenticationResults)
+	if authResults == "" && len(headers.ReceivedSpf) == 0 {
+		return nil
+	}
+	auth := data.SenderAuth{FromDomain: domainOf(from)}
+
+	_, results := parseResults(authResults)
+	var dkims []result
+	for _, res := range results {
+		switch res.method {
+		case "spf":
+			if auth.Spf == "" {
+				auth.Spf = res.result
+				auth.SpfDomain = domainOf(res.props["smtp.mailfrom"])
+				if auth.SpfDomain == "" {
+					auth.SpfDomain = domainOf(res.props["smtp.helo"])
+				}
+			}
+		case "dkim":
+			dkims = append(dkims, res)
+		case "dmarc":
+			if auth.Dmarc == "" {
+				auth.Dmarc = res.result
+				if m := dmarcPolicy.FindStringSubmatch(res.comment); m != nil {
+					auth.DmarcPolicy = strings.ToLower(m[1])
+				}
+				// the From domain as the receiving server saw it
+				if d := res.props["header.from"]; d != "" {
+					auth.FromDomain = strings.ToLower(d)
+				}
+			}
+		case "arc":
+			if auth.Arc == "" {
+				auth.Arc = res.result
+			}
+		}
+	}
+	if auth.Spf == "" && len(headers.ReceivedSpf) > 0 {
+		auth.Spf, auth.SpfDomain = parseReceivedSpf(headers.ReceivedSpf[0])
+	}
+	auth.Dkim, auth.DkimDomain = bestDkim(dkims, auth.FromDomain)
+	if auth.Dkim == "" && len(headers.DkimSignatures) > 0 {
+		// signed, but not checked. So only who claims to have signed it
+		auth.DkimDomain = signatureDomain(headers.DkimSignatures[0])
+	}
+
+	auth.Aligned = (auth.Spf == "pass" && aligned(auth.SpfDomain, auth.FromDomain)) ||
+		(auth.Dkim == "pass" && aligned(auth.DkimDomain, auth.FromDomain))
+	auth.Verdict = verdict(auth)
+	return &auth
+}
+
+func verdict(auth data.SenderAuth) string {
+	switch auth.Dmarc {
+	case "pass":
+		return data.SenderAuthVerified
+	case "fail":
+		// forwarders break SPF and often DKIM, but vouch for what they received
+		if auth.Arc == "pass" {
+			return data.SenderAuthUnverified
+		}
+		return data.SenderAuthFailed
+	}
+	if auth.Aligned {
+		return data.SenderAuthVerified
+	}
+	if (auth.Spf == "fail" || auth.Dkim == "fail") && auth.Spf != "pass" && auth.Dkim != "pass" {
+		return data.SenderAuthFailed
+	}
+	return data.SenderAuthUnverified
+}
+
+// the first results added by Gmail. Falls back to the topmost, as it was added last
+func pickAuthenticationResults(headers []string) string {
+	for _, h := range headers {
+		if id, _ := parseResults(h); strings.EqualFold(id, trustedAuthServId) {
+			return h
+		}
+	}
+	if len(headers) > 0 {
+		return headers[0]
+	}
+	return ""
+}
+
+// splits an Authentication-Results header (RFC 8601) into the authserv-id and its results
+func parseResults(header string) (string, []result) {
+	type segment struct {
+		text    strings.Builder
+		comment strings.Builder
+	}
+	segments := []*segment{{}}
+	depth := 0
+	quoted := false
+	for _, r := range header {
+		cur := segments[len(segments)-1]
+		switch {
+		case quoted:
+			if r == '"' {
+				quoted = false
+			}
+			cur.text.WriteRune(r)
+		case r == '(':
+			if depth > 0 {
+				cur.comment.WriteRune(r)
+			}
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+			if depth > 0 {
+				cur.comment.WriteRune(r)
+			} else {
+				// keep the words either side apart
+				cur.comment.WriteRune(' ')
+				cur.text.WriteRune(' ')
+			}
+		case depth > 0:
+			cur.comment.WriteRune(r)
+		case r == '"':
+			quoted = true
+			cur.text.WriteRune(r)
+		case r == ';':
+			segments = append(segments, &segment{})
+		default:
+			cur.text.WriteRune(r)
+		}
+	}
+
+	// the authserv-id can be followed by a version
+	id := ""
+	if fields := strings.Fields(segments[0].text.String()); len(fields) > 0 {
+		id = fields[0]
+	}
+	results := make([]result, 0, len(segments)-1)
+	for _, s := range segments[1:] {
+		fields := strings.Fields(s.text.String())
+		if len(fields) == 0 {
+			continue
+		}
+		method, value, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			// "none" means nothing was checked
+			continue
+		}
+		method, _, _ = strings.Cut(method, "/")
+		res := result{
+			method:  strings.ToLower(method),
+			result:  strings.ToLower(value),
+			props:   make(map[string]string),
+			comment: strings.TrimSpace(s.comment.String()),
+		}
+		for _, f := range fields[1:] {
+			if k, v, ok := strings.Cut(f, "="); ok {
+				res.props[strings.ToLower(k)] = strings.Trim(v, `"`)
+			}
+		}
+		results = append(results, res)
+	}
+	return id, results
+}
+
+// the passing signature aligned with the From domain, or else any pass, or else the first result
+func bestDkim(dkims []result, fromDomain string) (string, string) {
+	best := -1
+	for i, d := range dkims {
+		if d.result != "pass" {
+			continue
+		}
+		if aligned(dkimDomain(d), fromDomain) {
+			best = i
+			break
+		}
+		if best < 0 {
+			best = i
+		}
+	}
+	if best < 0 && len(dkims) > 0 {
+		best = 0
+	}
+	if best < 0 {
+		return "", ""
+	}
+	return dkims[best].result, dkimDomain(dkims[best])
+}
+
+func dkimDomain(d result) string {
+	if domain := d.props["header.d"]; domain != "" {
+		return strings.ToLower(domain)
+	}
+	return domainOf(d.props["header.i"])
+}
+
+// eg. "pass (google.com: domain of a@example.com designates ...) client-ip=...; envelope-from=a@example.com;"
+func parseReceivedSpf(header string) (string, string) {
+	fields := strings.Fields(header)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	domain := ""
+	for _, f := range strings.FieldsFunc(header, func(r rune) bool { return r == ';' || r == ' ' }) {
+		if k, v, ok := strings.Cut(f, "="); ok && strings.EqualFold(k, "envelope-from") {
+			domain = domainOf(strings.Trim(v, `"<>`))
+			break
+		}
+	}
+	return strings.ToLower(fields[0]), domain
+}
+
+// the d= tag of a DKIM-Signature
+func signatureDomain(header string) string {
+	for _, tag := range strings.Split(header, ";") {
+		if k, v, ok := strings.Cut(tag, "="); ok && strings.TrimSpace(k) == "d" {
+			return strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	return ""
+}
+
+// the domain of an address or domain. eg. "a@example.com", "@example.com" and "example.com" are "example.com"
+func domainOf(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		address = address[i+1:]
+	}
+	return strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>."))
+}
+
+// relaxed alignment. The domains share an organizational domain, eg. mail.example.com and example.com
+func aligned(domain, fromDomain string) bool {
+	if domain == "" || fromDomain == "" {
+		return false
+	}
+	if domain == fromDomain {
+		return true
+	}
+	org := OrganizationalDomain(domain)
+	return org != "" && org == OrganizationalDomain(fromDomain)
+}
+
+// the registered domain, below the public suffix. eg. mail.example.co.uk is example.co.uk.
+// Empty if it isn't a valid domain
+func OrganizationalDomain(domain string) string {
+	org, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(domain))
+	if err != nil {
+		return ""
+	}
+	return org
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/backfill"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/usage"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// how often each running job queues a batch
+	runInterval = 10 * time.Second
+	// deferred messages re-queued per account, per run
+	deferredBatchSize = 100
+)
+
+func main() {
+	log.Info().
+		Msg("Starting up aiBackfill")
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "aiBackfill"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	// same topic as the email-injestor, so the gemini service picks them up
+	available := globals.KafkaWriter("email_injest_available")
+	defer available.Close()
+
+	ticker := time.NewTicker(runInterval)
+	defer ticker.Stop()
+	for {
+		if err := backfill.RunDue(ctx, available, runInterval); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Msg("failed to run backfill jobs")
+		}
+		// messages held back while their account was over budget
+		if err := usage.RequeueDeferred(ctx, available, deferredBatchSize); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Msg("failed to re-queue deferred messages")
+		}
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Exiting")
+			return
+		case <-ticker.C:
+		}
+	}
+}
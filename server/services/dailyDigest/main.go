@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/digest"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func main() {
+	log.Info().
+		Msg("Starting up dailyDigest")
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "dailyDigest"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	// how often to check for digests that are due
+	interval, err := time.ParseDuration(os.Getenv("DIGEST_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	for {
+		deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Exiting")
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func deliverDue(ctx context.Context) {
+	cursor, err := globals.DocDb().Collection("AccountSettings").Find(ctx, bson.M{"digest.enabled": true})
+	if err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Msg("failed to list accounts with digests")
+		return
+	}
+	defer cursor.Close(ctx)
+	var accounts []data.AccountSettings
+	if err := cursor.All(ctx, &accounts); err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Msg("failed to read accounts with digests")
+		return
+	}
+	now := time.Now()
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			return
+		}
+		digestId, due := digest.Due(account.Digest, now)
+		if !due {
+			continue
+		}
+		accountCtx := context.WithValue(ctx, "accountId", account.AccountId)
+		if err := deliver(accountCtx, account, digestId, now); err != nil {
+			log.Error().
+				Ctx(accountCtx).
+				Err(err).
+				Str("digestId", digestId).
+				Msg("failed to deliver digest")
+		}
+	}
+}
+
+// builds today's digest, if it hasn't been already, and emails it if the account wants it
+func deliver(ctx context.Context, account data.AccountSettings, digestId string, now time.Time) error {
+	existing, err := digest.Get(ctx, account.AccountId, digestId)
+	if err != nil && !errors.Is(err, digest.ErrNotFound) {
+		return err
+	}
+	if existing == nil {
+		existing, err = digest.Generate(ctx, account.AccountId, digestId, account.Digest, now)
+		if err != nil {
+			return err
+		}
+		if err := digest.Save(ctx, existing); err != nil {
+			return err
+		}
+		log.Info().
+			Ctx(ctx).
+			Str("digestId", digestId).
+			Int64("messageCount", existing.MessageCount).
+			Msg("generated digest")
+	}
+	if !account.Digest.SendEmail || existing.SentAt != nil {
+		return nil
+	}
+	// a failed send is retried on the next check
+	return digest.Send(ctx, existing)
+}
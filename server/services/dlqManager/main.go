@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"fromkeith/my-desktop-server/deadletter"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/services/helpers"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const recordRetryDelay = 5 * time.Second
+
+const usageText = `usage:
+  dlqManager                 read the DLQs into "DeadLetters"
+  dlqManager list [flags]    list dead lettered messages
+  dlqManager replay id...    send messages back to the topic they failed on
+`
+
+func main() {
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "dlqManager"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	if len(os.Args) < 2 {
+		consume(ctx)
+		return
+	}
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = list(ctx, os.Args[2:])
+	case "replay":
+		err = replay(ctx, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usageText)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// reads each DLQ, and its parked topic, until shutdown
+func consume(ctx context.Context) {
+	log.Info().
+		Msg("Starting up dlqManager")
+	wg := sync.WaitGroup{}
+	for _, topic := range deadletter.Topics {
+		for _, t := range []string{topic, deadletter.ParkedTopic(topic)} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				record(ctx, t)
+			}()
+		}
+	}
+	wg.Wait()
+	log.Info().Msg("Exiting")
+}
+
+func record(ctx context.Context, topic string) {
+	r := globals.KafkaConsumerGroup(topic, "dlqManager")
+	defer r.Close()
+	for {
+		msgs, err := helpers.FetchBatch(ctx, r, 100, time.Second)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, io.EOF) {
+				return
+			}
+			log.Error().Ctx(ctx).Err(err).Str("topic", topic).Msg("fetch error")
+			continue
+		}
+		for _, msg := range msgs {
+			// committing a later offset would skip it, so keep at it
+			for {
+				err := deadletter.Record(ctx, msg)
+				if err == nil {
+					break
+				}
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("topic", topic).
+					Int64("offset", msg.Offset).
+					Msg("failed to record dead letter")
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(recordRetryDelay):
+				}
+			}
+		}
+		if err := r.CommitMessages(ctx, msgs...); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("topic", topic).
+				Msg("Failed to commit messages")
+		}
+	}
+}
+
+func list(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	topic := flags.String("topic", "", "the DLQ. eg. gemini_dlq or gemini_dlq_parked")
+	accountId := flags.String("account", "", "only this account's messages")
+	errorText := flags.String("error", "", "only messages whose error contains this")
+	state := flags.String("state", deadletter.StateDead, "dead or replayed. Empty for both")
+	limit := flags.Int64("limit", 50, "max entries to list")
+	flags.Parse(args)
+
+	entries, err := deadletter.List(ctx, deadletter.Filter{
+		Topic:     *topic,
+		AccountId: *accountId,
+		Error:     *errorText,
+		State:     *state,
+		Limit:     *limit,
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		parked := ""
+		if entry.Parked {
+			parked = " parked"
+		}
+		fmt.Printf("%s\t%s\t%s\tattempts=%d%s\t%s\t%s\n",
+			entry.Id,
+			entry.FailedAt.Format(time.RFC3339),
+			entry.AccountId,
+			entry.Attempts,
+			parked,
+			entry.State,
+			entry.Error,
+		)
+	}
+	return nil
+}
+
+func replay(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return errors.New("no ids to replay. See dlqManager list")
+	}
+	replayed, err := deadletter.Replay(ctx, ids)
+	for _, id := range replayed {
+		fmt.Println("replayed", id)
+	}
+	if err != nil {
+		return err
+	}
+	if skipped := len(ids) - len(replayed); skipped > 0 {
+		fmt.Printf("skipped %d parked, already replayed or unknown\n", skipped)
+	}
+	return nil
+}
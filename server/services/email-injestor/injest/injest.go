@@ -0,0 +1,122 @@
+// fetches the messages queued on "email_injest" from Gmail, and saves them
+package injest
+
+import (
+	"context"
+	"fmt"
+	"fromkeith/my-desktop-server/deadletter"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/services/kafkaservice"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// fetches the message from Gmail. Passed in, so this package doesn't need the Gmail client
+type Fetcher func(ctx context.Context, accountId string, messageId string) (*data.GmailEntry, *data.GmailEntryBody, error)
+
+// how work writes to MongoDB. Tests swap them for stand-ins
+var (
+	writeBodies  = data.BulkWriteEmailBodies
+	writeEntries = data.BulkWriteEmails
+)
+
+func Service(available kafkaservice.Writer, fetch Fetcher) kafkaservice.KafkaService {
+	return kafkaservice.KafkaService{
+		Name:        "email-injestor",
+		Topic:       "email_injest",
+		Group:       "fetch",
+		NumMessages: 10,
+		MaxWait:     time.Second,
+		NumWorkers:  1,
+		Worker: func(ctx context.Context, msgs []kafka.Message) (dlq []kafka.Message, retry []kafka.Message, err error) {
+			return work(ctx, msgs, available, fetch)
+		},
+		Dlq: "email_inject_dlq",
+	}
+}
+
+// fetches each message from Gmail, and passes it on. The writes are keyed by the message's revision (historyId),
+// so a batch that is read again after a failed write doesn't undo newer changes. Forced messages, eg. a re-injest,
+// are written over whatever is stored
+func work(ctx context.Context, msgs []kafka.Message, available kafkaservice.Writer, fetch Fetcher) (dlq []kafka.Message, retry []kafka.Message, err error) {
+	failed := make([]kafka.Message, 0)
+	entries := make(map[data.WriteMode][]data.GmailEntry)
+	bodies := make(map[data.WriteMode][]data.GmailEntryBody)
+	bootstrapped := make(map[string]bool)
+	fetched := make([]data.GmailEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		log.Info().
+			Ctx(ctx).
+			Str("taskId", string(msg.Key)).
+			Msg("processing message")
+
+		entry, body, payload, err := fetchEmail(ctx, msg, fetch)
+		if err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("taskId", string(msg.Key)).
+				Msg("failed to fetch message")
+			failed = append(failed, deadletter.WithError(msg, err))
+			continue
+		}
+		mode := data.WriteNewer
+		if payload.Force {
+			mode = data.WriteOver
+		}
+		entries[mode] = append(entries[mode], *entry)
+		bodies[mode] = append(bodies[mode], *body)
+		fetched = append(fetched, *entry)
+		if payload.Bootstrap {
+			bootstrapped[entry.ToDocumentId()] = true
+		}
+	}
+	if len(fetched) == 0 {
+		return failed, nil, nil
+	}
+	// the body first, so it's there by the time anything hears about the entry
+	for mode, modeBodies := range bodies {
+		if err := writeBodies(ctx, modeBodies, mode); err != nil {
+			return nil, nil, fmt.Errorf("writing bodies: %w", err)
+		}
+	}
+	for mode, modeEntries := range entries {
+		if err := writeEntries(ctx, modeEntries, mode); err != nil {
+			return nil, nil, fmt.Errorf("writing entries: %w", err)
+		}
+	}
+	nextStep := make([]kafka.Message, 0, len(fetched))
+	for _, entry := range fetched {
+		entryBytes, _ := json.Marshal(data.EmailInjestedPayload{
+			MessageId: entry.MessageId,
+			AccountId: entry.AccountId,
+			Entry:     entry,
+			Bootstrap: bootstrapped[entry.ToDocumentId()],
+		})
+		nextStep = append(nextStep, kafka.Message{
+			Key:   []byte(entry.ToDocumentId()),
+			Value: entryBytes,
+		})
+	}
+	// make it available to downstream services
+	if err := available.WriteMessages(ctx, nextStep...); err != nil {
+		return nil, nil, fmt.Errorf("writing to available topic: %w", err)
+	}
+	return failed, nil, nil
+}
+
+// also returns the payload, eg. if it was loaded by a bootstrap
+func fetchEmail(ctx context.Context, msg kafka.Message, fetch Fetcher) (*data.GmailEntry, *data.GmailEntryBody, data.EmailInjestPayload, error) {
+	// log.Debug().Str("payload", string(msg.Value)).Msg("kafka payload")
+	var payload data.EmailInjestPayload
+	if err := json.Unmarshal(msg.Value, &payload); err != nil {
+		return nil, nil, payload, err
+	}
+	entry, body, err := fetch(ctx, payload.AccountId, payload.MessageId)
+	return entry, body, payload, err
+}
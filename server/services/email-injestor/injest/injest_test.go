@@ -0,0 +1,251 @@
+package injest
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/deadletter"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/services/kafkaservice/kafkatest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	sourceTopic    = "email_injest"
+	availableTopic = "email_injest_available"
+	dlqTopic       = "email_inject_dlq"
+)
+
+// stands in for Gmail and MongoDB, recording what work fetched and wrote
+type standIn struct {
+	mu      sync.Mutex
+	fetched map[string]int
+	// in the order they were written. eg. "body a;1 newer"
+	writes []string
+	// fetching these fails
+	missing map[string]bool
+	// writing entries fails this many more times
+	failEntries int
+}
+
+func (s *standIn) fetch(ctx context.Context, accountId string, messageId string) (*data.GmailEntry, *data.GmailEntryBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := data.ToDocumentId(accountId, messageId)
+	s.fetched[id]++
+	if s.missing[id] {
+		return nil, nil, errors.New("not found")
+	}
+	entry := data.GmailEntry{AccountId: accountId, MessageId: messageId, HistoryId: 100}
+	body := data.GmailEntryBody{AccountId: accountId, MessageId: messageId, HistoryId: 100}
+	return &entry, &body, nil
+}
+
+func (s *standIn) install(t *testing.T) {
+	prevBodies, prevEntries := writeBodies, writeEntries
+	writeBodies = func(ctx context.Context, bodies []data.GmailEntryBody, mode data.WriteMode) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, body := range bodies {
+			s.writes = append(s.writes, "body "+body.ToDocumentId()+" "+modeName(mode))
+		}
+		return nil
+	}
+	writeEntries = func(ctx context.Context, entries []data.GmailEntry, mode data.WriteMode) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failEntries > 0 {
+			s.failEntries--
+			return kafkatest.ErrInjected
+		}
+		for _, entry := range entries {
+			s.writes = append(s.writes, "entry "+entry.ToDocumentId()+" "+modeName(mode))
+		}
+		return nil
+	}
+	t.Cleanup(func() {
+		writeBodies, writeEntries = prevBodies, prevEntries
+	})
+}
+
+func (s *standIn) fetchCount(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetched[id]
+}
+
+func (s *standIn) written() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.writes)
+}
+
+func modeName(mode data.WriteMode) string {
+	if mode == data.WriteNewer {
+		return "newer"
+	}
+	return "over"
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{fetched: make(map[string]int), missing: make(map[string]bool)}
+	s.install(t)
+	return s
+}
+
+func startService(t *testing.T, b *kafkatest.Broker, s *standIn) {
+	opt := Service(b.Writer(availableTopic), s.fetch)
+	opt.MaxWait = 5 * time.Millisecond
+	kafkatest.Start(t, opt, sourceTopic)
+}
+
+func produce(t *testing.T, b *kafkatest.Broker, payloads ...data.EmailInjestPayload) {
+	t.Helper()
+	msgs := make([]kafka.Message, 0, len(payloads))
+	for _, payload := range payloads {
+		value, _ := json.Marshal(payload)
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(data.ToDocumentId(payload.AccountId, payload.MessageId)),
+			Value: value,
+		})
+	}
+	if err := b.Produce(sourceTopic, msgs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func passedOn(t *testing.T, b *kafkatest.Broker) map[string]data.EmailInjestedPayload {
+	t.Helper()
+	res := make(map[string]data.EmailInjestedPayload)
+	for _, msg := range b.Messages(availableTopic) {
+		var payload data.EmailInjestedPayload
+		if err := json.Unmarshal(msg.Value, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := res[string(msg.Key)]; ok {
+			t.Errorf("%s passed on more than once", msg.Key)
+		}
+		res[string(msg.Key)] = payload
+	}
+	return res
+}
+
+func TestWorkWritesAndPassesOn(t *testing.T) {
+	b := kafkatest.NewBroker()
+	b.Install(t)
+	s := newStandIn(t)
+	startService(t, b, s)
+
+	produce(t, b,
+		data.EmailInjestPayload{AccountId: "a", MessageId: "1", Bootstrap: true},
+		data.EmailInjestPayload{AccountId: "a", MessageId: "2", Force: true},
+	)
+	kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(sourceTopic, "fetch") == 2 })
+
+	written := s.written()
+	for _, want := range []string{"body a;1 newer", "entry a;1 newer", "body a;2 over", "entry a;2 over"} {
+		if !slices.Contains(written, want) {
+			t.Errorf("missing write %q in %v", want, written)
+		}
+	}
+	// the bodies are there by the time anything hears about the entries
+	if first := slices.IndexFunc(written, isEntry); first < 0 || slices.ContainsFunc(written[first:], isBody) {
+		t.Errorf("entries written before their bodies: %v", written)
+	}
+
+	next := passedOn(t, b)
+	if len(next) != 2 {
+		t.Fatalf("%d passed on, want 2", len(next))
+	}
+	if got := next["a;1"]; !got.Bootstrap || got.Entry.MessageId != "1" {
+		t.Errorf("passed on %+v, want the bootstrapped entry", got)
+	}
+	if got := next["a;2"]; got.Bootstrap {
+		t.Errorf("passed on %+v as bootstrapped", got)
+	}
+}
+
+func TestWorkReadsAgainAfterFailedWrite(t *testing.T) {
+	for name, fail := range map[string]func(*kafkatest.Broker, *standIn){
+		"entries": func(b *kafkatest.Broker, s *standIn) {
+			s.failEntries = 1
+		},
+		"available topic": func(b *kafkatest.Broker, s *standIn) {
+			b.FailNextWrite(availableTopic)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := kafkatest.NewBroker()
+			b.Install(t)
+			s := newStandIn(t)
+			fail(b, s)
+			startService(t, b, s)
+
+			produce(t, b,
+				data.EmailInjestPayload{AccountId: "a", MessageId: "1"},
+				data.EmailInjestPayload{AccountId: "a", MessageId: "2"},
+			)
+			kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(sourceTopic, "fetch") == 2 })
+
+			for _, id := range []string{"a;1", "a;2"} {
+				if got := s.fetchCount(id); got != 2 {
+					t.Errorf("%s fetched %d times, want 2", id, got)
+				}
+			}
+			if got := b.ReadersOpened(sourceTopic); got != 2 {
+				t.Errorf("%d readers opened, want 2", got)
+			}
+			// written again keyed by the revision, so the repeat doesn't undo newer changes
+			for _, write := range s.written() {
+				if !slices.Contains([]string{"body a;1 newer", "body a;2 newer", "entry a;1 newer", "entry a;2 newer"}, write) {
+					t.Errorf("unexpected write %q", write)
+				}
+			}
+			if got := len(passedOn(t, b)); got != 2 {
+				t.Errorf("%d passed on, want 2", got)
+			}
+			if got := len(b.Messages(dlqTopic)); got != 0 {
+				t.Errorf("%d dead lettered, want 0", got)
+			}
+		})
+	}
+}
+
+func TestWorkDeadLettersFailedFetch(t *testing.T) {
+	b := kafkatest.NewBroker()
+	b.Install(t)
+	s := newStandIn(t)
+	s.missing["a;1"] = true
+	startService(t, b, s)
+
+	produce(t, b,
+		data.EmailInjestPayload{AccountId: "a", MessageId: "1"},
+		data.EmailInjestPayload{AccountId: "a", MessageId: "2"},
+	)
+	kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(sourceTopic, "fetch") == 2 })
+
+	dead := b.Messages(dlqTopic)
+	if len(dead) != 1 {
+		t.Fatalf("%d dead lettered, want 1", len(dead))
+	}
+	if string(dead[0].Key) != "a;1" || kafkatest.Header(dead[0], deadletter.HeaderError) != "not found" {
+		t.Errorf("dead lettered %s with %q", dead[0].Key, kafkatest.Header(dead[0], deadletter.HeaderError))
+	}
+	next := passedOn(t, b)
+	if _, ok := next["a;2"]; len(next) != 1 || !ok {
+		t.Errorf("passed on %v, want just a;2", next)
+	}
+}
+
+func isBody(write string) bool {
+	return strings.HasPrefix(write, "body ")
+}
+
+func isEntry(write string) bool {
+	return strings.HasPrefix(write, "entry ")
+}
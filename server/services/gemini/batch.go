@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/deadletter"
+	"fromkeith/my-desktop-server/enrichment"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/services/kafkaservice"
+	"fromkeith/my-desktop-server/usage"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Backfilled and bootstrapped messages are analyzed in batch jobs, rather than one call each.
+// They wait in "EnrichmentBatchItems" until enough build up, are sent as one job,
+// and are written back like live mail once it's done.
+
+const (
+	// waiting for enough to send a job
+	itemQueued = "queued"
+	// picked for a job that is being created
+	itemClaimed = "claimed"
+	// in a job, waiting for it to finish
+	itemSubmitted = "submitted"
+
+	// requests are sent inline, which is limited to 20MB a job
+	maxBatchBytes = 15 * 1024 * 1024
+	// claimed items are put back after this, in case we died creating the job
+	claimTimeout = 10 * time.Minute
+)
+
+// set when the provider supports batch jobs, and they aren't turned off
+var batchMode = false
+
+type batchConfig struct {
+	// most requests in a job. 0 turns batching off
+	size int
+	// how often to send and check on jobs
+	interval time.Duration
+	// a smaller job is sent once its oldest message has waited this long
+	maxWait time.Duration
+}
+
+// reads GEMINI_BATCH_SIZE (default 500), GEMINI_BATCH_INTERVAL (default 1m) and GEMINI_BATCH_MAX_WAIT (default 5m)
+func batchConfigFromEnv() batchConfig {
+	cfg := batchConfig{
+		size:     500,
+		interval: time.Minute,
+		maxWait:  5 * time.Minute,
+	}
+	if size, err := strconv.Atoi(os.Getenv("GEMINI_BATCH_SIZE")); err == nil && size >= 0 {
+		cfg.size = size
+	}
+	if interval, err := time.ParseDuration(os.Getenv("GEMINI_BATCH_INTERVAL")); err == nil && interval > 0 {
+		cfg.interval = interval
+	}
+	if maxWait, err := time.ParseDuration(os.Getenv("GEMINI_BATCH_MAX_WAIT")); err == nil && maxWait >= 0 {
+		cfg.maxWait = maxWait
+	}
+	return cfg
+}
+
+// a message waiting on, or in, a batch job. Holds what's needed to write it back
+type batchItem struct {
+	// the message's document id
+	Id        string `bson:"_id"`
+	AccountId string `bson:"accountId"`
+	// queued, claimed or submitted
+	State string `bson:"state"`
+	// who claimed it, and when
+	Claim     string    `bson:"claim,omitempty"`
+	ClaimedAt time.Time `bson:"claimedAt,omitempty"`
+	// the job it was sent in, and its place in the job
+	JobName  string `bson:"jobName,omitempty"`
+	JobIndex int    `bson:"jobIndex"`
+	// the kafka message, to dead letter it if the job fails
+	Key          []byte          `bson:"key"`
+	Payload      []byte          `bson:"payload"`
+	Headers      []kafka.Header  `bson:"headers,omitempty"`
+	Instructions string          `bson:"instructions"`
+	Entry        data.GmailEntry `bson:"entry"`
+	// already redacted
+	Subject     string           `bson:"subject"`
+	Body        string           `bson:"body"`
+	Attachments string           `bson:"attachments"`
+	SenderAuth  string           `bson:"senderAuth"`
+	Version     data.AiVersion   `bson:"version"`
+	Redactions  []data.Redaction `bson:"redactions"`
+	CacheKey    string           `bson:"cacheKey"`
+	Backfill    bool             `bson:"backfill"`
+	CreatedAt   time.Time        `bson:"createdAt"`
+}
+
+func toBatchItem(msg messageBody) batchItem {
+	return batchItem{
+		Id:           msg.entry.ToDocumentId(),
+		AccountId:    msg.entry.AccountId,
+		State:        itemQueued,
+		Key:          msg.src.Key,
+		Payload:      msg.src.Value,
+		Headers:      msg.src.Headers,
+		Instructions: msg.instructions,
+		Entry:        msg.entry,
+		Subject:      msg.subject,
+		Body:         msg.body,
+		Attachments:  msg.attachments,
+		SenderAuth:   msg.senderAuth,
+		Version:      msg.version,
+		Redactions:   msg.redactions,
+		CacheKey:     msg.cacheKey,
+		Backfill:     msg.backfill,
+		CreatedAt:    time.Now().UTC(),
+	}
+}
+
+func (item batchItem) toBody() messageBody {
+	return messageBody{
+		entry:        item.Entry,
+		subject:      item.Subject,
+		body:         item.Body,
+		attachments:  item.Attachments,
+		senderAuth:   item.SenderAuth,
+		version:      item.Version,
+		redactions:   item.Redactions,
+		cacheKey:     item.CacheKey,
+		backfill:     item.Backfill,
+		batch:        true,
+		instructions: item.Instructions,
+		src:          kafka.Message{Key: item.Key, Value: item.Payload, Headers: item.Headers},
+	}
+}
+
+func batchItems() *mongo.Collection {
+	return globals.DocDb().Collection("EnrichmentBatchItems")
+}
+
+// queues the messages for the next job. A message already queued or in a job is replaced,
+// so only its latest version is written back
+func queueBatch(ctx context.Context, msgs []messageBody) error {
+	writes := make([]mongo.WriteModel, 0, len(msgs))
+	for _, msg := range msgs {
+		item := toBatchItem(msg)
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": item.Id}).
+			SetReplacement(item).
+			SetUpsert(true),
+		)
+	}
+	_, err := batchItems().BulkWrite(ctx, writes)
+	return err
+}
+
+// sends queued messages as jobs, and writes back the ones that are done
+func runBatches(ctx context.Context, provider llm.BatchProvider, cfg batchConfig, available kafkaservice.Writer) {
+	dead := deadletter.NewWriter(deadTopic, sourceTopic)
+	defer dead.Close()
+	for {
+		if err := submitQueued(ctx, provider, cfg); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Msg("failed to submit batch job")
+		}
+		if err := pollSubmitted(ctx, provider, available, dead); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Msg("failed to check batch jobs")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.interval):
+		}
+	}
+}
+
+// sends jobs while there are enough queued, or the oldest has waited long enough
+func submitQueued(ctx context.Context, provider llm.BatchProvider, cfg batchConfig) error {
+	col := batchItems()
+	// put back anything claimed by a job that was never created
+	_, err := col.UpdateMany(ctx,
+		bson.M{"state": itemClaimed, "claimedAt": bson.M{"$lt": time.Now().Add(-claimTimeout)}},
+		bson.M{"$set": bson.M{"state": itemQueued}, "$unset": bson.M{"claim": "", "claimedAt": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	for {
+		var oldest batchItem
+		err := col.FindOne(ctx,
+			bson.M{"state": itemQueued},
+			options.FindOne().SetSort(bson.D{{"createdAt", 1}}).SetProjection(bson.M{"createdAt": 1}),
+		).Decode(&oldest)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		queued, err := col.CountDocuments(ctx, bson.M{"state": itemQueued})
+		if err != nil {
+			return err
+		}
+		if queued < int64(cfg.size) && time.Since(oldest.CreatedAt) < cfg.maxWait {
+			return nil
+		}
+		if err := submitJob(ctx, provider, cfg.size); err != nil {
+			return err
+		}
+	}
+}
+
+// claims up to size of the oldest queued items, and sends them as one job
+func submitJob(ctx context.Context, provider llm.BatchProvider, size int) error {
+	col := batchItems()
+	cursor, err := col.Find(ctx,
+		bson.M{"state": itemQueued},
+		options.Find().SetSort(bson.D{{"createdAt", 1}}).SetLimit(int64(size)).SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var oldest []batchItem
+	if err := cursor.All(ctx, &oldest); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(oldest))
+	for _, item := range oldest {
+		ids = append(ids, item.Id)
+	}
+	// another instance may have claimed some, so only send what we got
+	claim := uuid.New().String()
+	_, err = col.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "state": itemQueued},
+		bson.M{"$set": bson.M{"state": itemClaimed, "claim": claim, "claimedAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		return err
+	}
+	cursor, err = col.Find(ctx, bson.M{"claim": claim}, options.Find().SetSort(bson.D{{"createdAt", 1}}))
+	if err != nil {
+		return err
+	}
+	var items []batchItem
+	if err := cursor.All(ctx, &items); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	reqs := make([]llm.JSONRequest, 0, len(items))
+	var bytes int
+	for _, item := range items {
+		req := llm.JSONRequest{
+			System: item.Instructions,
+			Prompt: promptText(item.toBody()),
+			Schema: enrichment.ResponseSchema,
+		}
+		bytes += len(req.System) + len(req.Prompt)
+		if bytes > maxBatchBytes && len(reqs) > 0 {
+			break
+		}
+		reqs = append(reqs, req)
+	}
+	unclaim := func(items []batchItem) error {
+		ids := make([]string, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.Id)
+		}
+		_, err := col.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}, "claim": claim},
+			bson.M{"$set": bson.M{"state": itemQueued}, "$unset": bson.M{"claim": "", "claimedAt": ""}},
+		)
+		return err
+	}
+	// too big for one job, so the rest wait for the next
+	if len(reqs) < len(items) {
+		if err := unclaim(items[len(reqs):]); err != nil {
+			return err
+		}
+		items = items[:len(reqs)]
+	}
+
+	name, err := provider.CreateBatch(ctx, "enrichment-"+claim, reqs)
+	if err != nil {
+		if err := unclaim(items); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Msg("failed to put back claimed batch items")
+		}
+		return err
+	}
+	log.Info().
+		Ctx(ctx).
+		Str("jobName", name).
+		Int("count", len(items)).
+		Msg("submitted batch job")
+	writes := make([]mongo.WriteModel, 0, len(items))
+	for i, item := range items {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": item.Id, "claim": claim}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"state":    itemSubmitted,
+				"jobName":  name,
+				"jobIndex": i,
+			}}),
+		)
+	}
+	_, err = col.BulkWrite(ctx, writes)
+	return err
+}
+
+// writes back the jobs that are done
+func pollSubmitted(ctx context.Context, provider llm.BatchProvider, available kafkaservice.Writer, dead *deadletter.Writer) error {
+	var jobNames []string
+	err := batchItems().
+		Distinct(ctx, "jobName", bson.M{"state": itemSubmitted}).
+		Decode(&jobNames)
+	if err != nil {
+		return err
+	}
+	for _, name := range jobNames {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		job, err := provider.GetBatch(ctx, name)
+		if err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("jobName", name).
+				Msg("failed to get batch job")
+			continue
+		}
+		if job.State == llm.BatchPending {
+			continue
+		}
+		if err := finishJob(ctx, provider, *job, available, dead); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("jobName", name).
+				Msg("failed to write back batch job")
+		}
+	}
+	return nil
+}
+
+// writes back a finished job's results. Messages that failed are dead lettered, those in a failed job are deferred
+func finishJob(ctx context.Context, provider llm.BatchProvider, job llm.BatchJob, available kafkaservice.Writer, dead *deadletter.Writer) error {
+	col := batchItems()
+	// items re-queued since the job was sent have lost their jobName, and are left for a later job
+	cursor, err := col.Find(ctx, bson.M{"jobName": job.Name, "state": itemSubmitted})
+	if err != nil {
+		return err
+	}
+	var items []batchItem
+	if err := cursor.All(ctx, &items); err != nil {
+		return err
+	}
+
+	failed := make([]kafka.Message, 0)
+	transient := make([]messageBody, 0)
+	bodies := make([]messageBody, 0, len(items))
+	usageRecords := make([]usage.Record, 0, len(items)*2)
+	for _, item := range items {
+		msg := item.toBody()
+		if job.State == llm.BatchFailed {
+			// eg. expired before it ran. Nothing wrong with the messages themselves
+			transient = append(transient, msg)
+			continue
+		}
+		if item.JobIndex >= len(job.Responses) {
+			failed = append(failed, deadletter.WithError(msg.src, errors.New("missing from the batch job")))
+			continue
+		}
+		res := job.Responses[item.JobIndex]
+		if res.Response == nil {
+			log.Error().
+				Ctx(ctx).
+				Str("taskId", item.Id).
+				Str("error", res.Error).
+				Msg("batch request failed")
+			failed = append(failed, deadletter.WithError(msg.src, errors.New(res.Error)))
+			continue
+		}
+		usageRecords = append(usageRecords, usage.Generated(provider, item.AccountId, item.Entry.MessageId, usage.OperationAnalyze, res.Response.Usage))
+		var result enrichment.Result
+		if err := json.Unmarshal([]byte(res.Response.Text), &result); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("taskId", item.Id).
+				Msg("failed to unmarshal analyze result")
+			failed = append(failed, deadletter.WithError(msg.src, err))
+			continue
+		}
+		msg.result = &result
+		bodies = append(bodies, msg)
+	}
+	if job.State == llm.BatchFailed {
+		log.Error().
+			Ctx(ctx).
+			Str("jobName", job.Name).
+			Str("error", job.Error).
+			Msg("batch job failed")
+	}
+	// written back before, but a write failed. Reuse the entities and embeddings from then
+	cacheKeys := make([]string, 0, len(bodies))
+	for _, msg := range bodies {
+		cacheKeys = append(cacheKeys, msg.cacheKey)
+	}
+	cache, err := enrichment.GetCached(ctx, cacheKeys)
+	if err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Msg("failed to read analysis cache")
+		cache = make(map[string]enrichment.CachedAnalysis)
+	}
+	for i, msg := range bodies {
+		if hit, ok := cache[msg.cacheKey]; ok {
+			bodies[i].embeddingText = hit.EmbeddingText
+			bodies[i].embedding = hit.Embedding
+			bodies[i].entities = hit.Entities
+			bodies[i].cached = true
+		}
+	}
+
+	// the job's usage is saved once its items are deleted, below, so writing it back again doesn't bill it twice
+	finishFailed, finishTransient, err := finish(ctx, bodies, nil, available)
+	if err != nil {
+		// the items are kept, so the job is written back again on the next poll
+		return err
+	}
+	failed = append(failed, finishFailed...)
+	failed = append(failed, deferTransient(ctx, append(transient, finishTransient...))...)
+	if len(failed) > 0 {
+		if err := dead.Write(ctx, failed...); err != nil {
+			return err
+		}
+	}
+	if _, err := col.DeleteMany(ctx, bson.M{"jobName": job.Name, "state": itemSubmitted}); err != nil {
+		return err
+	}
+	if err := saveUsage(ctx, usageRecords...); err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Str("jobName", job.Name).
+			Msg("failed to save usage")
+	}
+	return nil
+}
@@ -3,41 +3,92 @@ package main
 import (
 	"context"
 	"fmt"
+	"fromkeith/my-desktop-server/attachments"
+	"fromkeith/my-desktop-server/deadletter"
+	"fromkeith/my-desktop-server/enrichment"
 	"fromkeith/my-desktop-server/globals"
 	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/quoted"
+	"fromkeith/my-desktop-server/redact"
 	"fromkeith/my-desktop-server/services/kafkaservice"
+	"fromkeith/my-desktop-server/settings"
+	"fromkeith/my-desktop-server/taxonomy"
+	"fromkeith/my-desktop-server/usage"
 	"slices"
 	"strings"
 	"time"
 
-	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/kafka-go"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"google.golang.org/genai"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
 type messageBody struct {
 	entry         data.GmailEntry
+	subject       string
 	body          string
-	result        *expectedAnalyzeResult
+	result        *enrichment.Result
 	src           kafka.Message
-	embedding     *genai.ContentEmbedding
+	embedding     []float32
 	embeddingText string
+	// trimmed text of the attachments
+	attachments string
+	// see enrichment.SenderAuthNote
+	senderAuth string
+	version    data.AiVersion
+	// re-processing an already enriched message
+	backfill bool
+	// sent in a batch job, rather than analyzed now
+	batch bool
+	// the system instructions it was analyzed with
+	instructions string
+	// what was masked in the subject and body
+	redactions []data.Redaction
+	// see enrichment.CacheKey
+	cacheKey string
+	// the result and embedding came from the cache
+	cached bool
+	// the entity extraction's response. From the cache, or extracted now
+	entities string
+	// the entities were extracted now, rather than coming from the cache
+	extracted bool
 }
-type expectedAnalyzeResult struct {
-	Theme      string
-	Summary    string
-	Categories []string
-	Tags       []string
-	Todos      []string
+
+// the instructions and resulting version for an account
+type accountPrompt struct {
+	instructions string
+	version      data.AiVersion
+	redaction    redact.Level
 }
 
-var outputDimens int32 = 3072 // its the default, but lets be explict
+// must match the vs_message_summaries index, see LLM_EMBED_DIMENSIONS
+var outputDimens = llm.ConfigFromEnv().EmbedDimensions
+
+const (
+	sourceTopic = "email_injest_available"
+	deadTopic   = "gemini_dlq"
+)
+
+// how finish saves what it found. Tests swap them for stand-ins
+var (
+	saveUsage          = usage.Save
+	saveCached         = enrichment.SaveCached
+	saveAnalyzeResults = writeAnalyzeResult
+	saveEntities       = writeEntities
+	saveSummaries      = data.BulkWriteEmailSummaries
+)
+
+const (
+	// attachment text in the analysis prompt, shared by all the attachments
+	maxPromptAttachmentLength = 8000
+	// attachment text in the embedding. Enough for what the document is
+	maxEmbedAttachmentLength = 500
+)
 
 func main() {
 	ctx := context.WithValue(context.Background(), "service", "gemini")
@@ -45,29 +96,41 @@ func main() {
 	available := globals.KafkaWriter("email_embedding_available")
 	defer available.Close()
 
-	kafkaservice.Run(ctx, kafkaservice.KafkaService{
+	batches := batchConfigFromEnv()
+	if provider, ok := globals.LLM().(llm.BatchProvider); ok && batches.size > 0 {
+		batchMode = true
+		go runBatches(ctx, provider, batches, available)
+	}
+
+	kafkaservice.Run(ctx, service(available))
+
+}
+
+func service(available kafkaservice.Writer) kafkaservice.KafkaService {
+	return kafkaservice.KafkaService{
 		Name:        "gemini",
-		Topic:       "email_injest_available",
+		Topic:       sourceTopic,
 		Group:       "gemini",
 		NumMessages: 10,
 		MaxWait:     time.Second,
 		NumWorkers:  2,
-		Worker: func(ctx context.Context, msgs []kafka.Message) (dlq []kafka.Message, err error) {
+		Worker: func(ctx context.Context, msgs []kafka.Message) (dlq []kafka.Message, retry []kafka.Message, err error) {
 			return work(ctx, msgs, available)
 		},
-		Dlq: "gemini_dlq",
-	})
-
+		Dlq:         deadTopic,
+		RetryDelays: kafkaservice.DefaultRetryDelays,
+	}
 }
 
 func isSpam(labels []string) bool {
 	return slices.Contains(labels, "SPAM")
 }
 
-func work(ctx context.Context, msgs []kafka.Message, available *kafka.Writer) (dlq []kafka.Message, err error) {
+func work(ctx context.Context, msgs []kafka.Message, available kafkaservice.Writer) (dlq []kafka.Message, retry []kafka.Message, err error) {
 
 	failed := make([]kafka.Message, 0)
 	bodies := make([]messageBody, 0, len(msgs))
+	overBudget := make(map[string]bool)
 	for _, msg := range msgs {
 		log.Info().
 			Ctx(ctx).
@@ -80,7 +143,7 @@ func work(ctx context.Context, msgs []kafka.Message, available *kafka.Writer) (d
 				Err(err).
 				Str("taskId", string(msg.Key)).
 				Msg("failed to unmarshal email")
-			failed = append(failed, msg)
+			failed = append(failed, deadletter.WithError(msg, err))
 			continue
 		}
 		entry := payload.Entry
@@ -88,6 +151,32 @@ func work(ctx context.Context, msgs []kafka.Message, available *kafka.Writer) (d
 			continue // don't AI spam
 		}
 		entry.AccountId = payload.AccountId // needed since accountId doesn't marshal to json
+		over, ok := overBudget[entry.AccountId]
+		if !ok {
+			var err error
+			over, err = usage.OverBudget(ctx, entry.AccountId)
+			if err != nil {
+				// don't hold up the account because we couldn't check
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("accountId", entry.AccountId).
+					Msg("failed to check budget")
+			}
+			overBudget[entry.AccountId] = over
+		}
+		if over {
+			// the aiBackfill service re-queues it once the account is under budget
+			if err := usage.Defer(ctx, entry.AccountId, entry.MessageId, msg.Value); err != nil {
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("taskId", string(msg.Key)).
+					Msg("failed to defer over budget message")
+				failed = append(failed, deadletter.WithError(msg, err))
+			}
+			continue
+		}
 		body, err := fetchBody(ctx, entry)
 		if err != nil {
 			log.Error().
@@ -95,103 +184,288 @@ func work(ctx context.Context, msgs []kafka.Message, available *kafka.Writer) (d
 				Err(err).
 				Str("taskId", string(msg.Key)).
 				Msg("failed to fetch message body")
-			failed = append(failed, msg)
+			failed = append(failed, deadletter.WithError(msg, err))
 			continue
 		}
-		// TODO: strip out replies? but how do we know?
-		// TODO: what about attachments?
-		asText, err := stripHtml(ctx, *body)
+		// only the new content, so quoted replies aren't analyzed again
+		asText, err := newContent(*body)
 		if err != nil {
 			log.Error().
 				Ctx(ctx).
 				Err(err).
 				Str("taskId", string(msg.Key)).
 				Msg("failed to strip html")
-			failed = append(failed, msg)
+			failed = append(failed, deadletter.WithError(msg, err))
 			continue
 		}
 		bodies = append(bodies, messageBody{
-			entry: entry,
-			body:  asText,
-			src:   msg,
+			entry:   entry,
+			subject: entry.Subject,
+			body:    asText,
+			// so invoices and contracts sent as attachments are categorized by what they are
+			attachments: attachments.ForPrompt(body.AttachmentTexts, maxPromptAttachmentLength),
+			senderAuth:  enrichment.SenderAuthNote(entry.SenderAuth),
+			src:         msg,
+			backfill:    payload.BackfillJobId != "",
+			// live mail is worth the faster, pricier call
+			batch: batchMode && (payload.BackfillJobId != "" || payload.Bootstrap),
 		})
 	}
 
+	// each account can have their own categories
+	prompts := make(map[string]accountPrompt)
+	for i, msg := range bodies {
+		prompt, ok := prompts[msg.entry.AccountId]
+		if !ok {
+			prompt = promptFor(ctx, msg.entry.AccountId)
+			prompts[msg.entry.AccountId] = prompt
+		}
+		bodies[i].version = prompt.version
+		bodies[i].instructions = prompt.instructions
+		// mask sensitive values before anything leaves for the LLM
+		subject, subjectRedactions := redact.Redact(msg.subject, prompt.redaction)
+		body, bodyRedactions := redact.Redact(msg.body, prompt.redaction)
+		atts, attachmentRedactions := redact.Redact(msg.attachments, prompt.redaction)
+		bodies[i].subject = subject
+		bodies[i].body = body
+		bodies[i].attachments = atts
+		bodies[i].redactions = redact.Merge(redact.Merge(subjectRedactions, bodyRedactions), attachmentRedactions)
+		keyText := body + "\n" + atts
+		if msg.senderAuth != "" {
+			// a forged copy of a newsletter shouldn't reuse the real one's analysis
+			keyText += "\n" + msg.senderAuth
+		}
+		bodies[i].cacheKey = enrichment.CacheKey(globals.LLM(), prompt.instructions, outputDimens, subject, keyText)
+	}
+
+	// identical emails, eg. newsletters, reuse an earlier analysis
+	cacheKeys := make([]string, 0, len(bodies))
+	for _, msg := range bodies {
+		cacheKeys = append(cacheKeys, msg.cacheKey)
+	}
+	cache, err := enrichment.GetCached(ctx, cacheKeys)
+	if err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Msg("failed to read analysis cache")
+		cache = make(map[string]enrichment.CachedAnalysis)
+	}
+
+	usageRecords := make([]usage.Record, 0, len(bodies)*2)
+	batching := make([]messageBody, 0)
+	// still failing after the provider's retries. eg. out of quota
+	transient := make([]messageBody, 0)
 	// analyze each body via gemini
 	for i, msg := range bodies {
+		if hit, ok := cache[msg.cacheKey]; ok {
+			log.Info().
+				Ctx(ctx).
+				Str("taskId", msg.entry.ToDocumentId()).
+				Msg("using cached analysis")
+			result := hit.Result
+			bodies[i].result = &result
+			bodies[i].embeddingText = hit.EmbeddingText
+			bodies[i].embedding = hit.Embedding
+			bodies[i].entities = hit.Entities
+			bodies[i].cached = true
+			continue
+		}
+		if msg.batch {
+			batching = append(batching, msg)
+			continue
+		}
 		log.Info().
 			Ctx(ctx).
 			Str("taskId", msg.entry.ToDocumentId()).
 			Int("payloadSize", len(msg.body)).
 			Msg("ai-ing document")
 
-		analyzeResult, err := anaylze(ctx, msg)
+		analyzeResult, tokens, err := anaylze(ctx, msg, msg.instructions)
+		if tokens != nil {
+			usageRecords = append(usageRecords, usage.Generated(globals.LLM(), msg.entry.AccountId, msg.entry.MessageId, usage.OperationAnalyze, *tokens))
+		}
 		if err != nil {
 			log.Error().
 				Ctx(ctx).
 				Err(err).
 				Str("taskId", msg.entry.ToDocumentId()).
 				Msg("failed to fetch message")
-			failed = append(failed, msg.src)
+			if retryable, _ := llm.Retryable(err); retryable {
+				msg.src = kafkaservice.RetryLater(msg.src, err)
+				transient = append(transient, msg)
+			} else {
+				failed = append(failed, deadletter.WithError(msg.src, err))
+			}
 			continue
 		}
 		bodies[i].result = analyzeResult
 	}
 
-	// write the found tags + categories
-	if err := writeAnalyzeResult(ctx, bodies); err != nil {
-		log.Error().
-			Ctx(ctx).
-			Stack().
-			Err(err).
-			Msg("failed to write tags and categories")
+	finishFailed, finishTransient, err := finish(ctx, bodies, usageRecords, available)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(batching) > 0 {
+		// written back by runBatches once the job is done. After finish, so the rest of the
+		// batch is cached if this fails and it's read again. Queueing again replaces them
+		if err := queueBatch(ctx, batching); err != nil {
+			return nil, nil, fmt.Errorf("queueing for a batch job: %w", err)
+		}
+	}
+	failed = append(failed, finishFailed...)
+	// tried again from the retry topics, after a while
+	retry = make([]kafka.Message, 0, len(transient)+len(finishTransient))
+	for _, msg := range append(transient, finishTransient...) {
+		retry = append(retry, msg.src)
 	}
+	// return failed
+	return failed, retry, nil
 
-	// create the embeddings and assign them to bodies
-	toSave := make([]data.EmailSummaryEmbedding, 0, len(bodies))
-	if len(bodies) > 0 {
-		results, err := createEmbeddings(ctx, bodies)
+}
+
+// extracts the analyzed bodies' entities, embeds them, then writes it all. Bodies without a result are skipped.
+// Returns the messages that failed, and those that failed on a transient LLM error.
+// An error means the results couldn't be written, and the bodies should be finished again.
+// The LLM's work is cached before any of the writes, so finishing again doesn't pay for it twice
+func finish(ctx context.Context, bodies []messageBody, usageRecords []usage.Record, available kafkaservice.Writer) (failed []kafka.Message, transient []messageBody, err error) {
+	failed = make([]kafka.Message, 0)
+	transient = make([]messageBody, 0)
+	normalizeResults(bodies)
+
+	// a second pass for typed records. eg. orders and flights
+	type found struct {
+		entry    data.GmailEntry
+		types    []string
+		entities []data.ExtractedEntity
+	}
+	toWriteEntities := make([]found, 0)
+	for i, msg := range bodies {
+		if msg.result == nil {
+			continue
+		}
+		types := enrichment.EntityTypesFor(msg.result.Categories, msg.result.Tags)
+		if len(types) == 0 {
+			continue
+		}
+		var entities []data.ExtractedEntity
+		var err error
+		if msg.entities != "" {
+			// the same content, so the same entities. Only which message they're in differs
+			entities, err = enrichment.ParseEntities(msg.entities, msg.entry)
+		} else {
+			var text string
+			var tokens *llm.Usage
+			entities, text, tokens, err = extractEntities(ctx, msg, types)
+			if tokens != nil {
+				usageRecords = append(usageRecords, usage.Generated(globals.LLM(), msg.entry.AccountId, msg.entry.MessageId, usage.OperationExtract, *tokens))
+			}
+			if err == nil {
+				bodies[i].entities = text
+				bodies[i].extracted = true
+			}
+		}
+		if err != nil {
+			// the analysis is still good, so don't fail the message
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("taskId", msg.entry.ToDocumentId()).
+				Msg("failed to extract entities")
+			continue
+		}
+		toWriteEntities = append(toWriteEntities, found{entry: msg.entry, types: types, entities: entities})
+	}
+
+	// create the embeddings for the bodies that weren't cached
+	toEmbed := make([]int, 0, len(bodies))
+	for i, msg := range bodies {
+		if msg.result != nil && !msg.cached {
+			toEmbed = append(toEmbed, i)
+		}
+	}
+	if len(toEmbed) > 0 {
+		results, err := createEmbeddings(ctx, bodies, toEmbed)
 		if err != nil {
 			log.Error().
 				Ctx(ctx).
 				Err(err).
 				Msg("failed to create embeddings")
-			for _, msg := range bodies {
-				if msg.result != nil {
-					failed = append(failed, msg.src)
+			retryable, _ := llm.Retryable(err)
+			for _, i := range toEmbed {
+				if retryable {
+					bodies[i].src = kafkaservice.RetryLater(bodies[i].src, err)
+					transient = append(transient, bodies[i])
+				} else {
+					failed = append(failed, deadletter.WithError(bodies[i].src, err))
 				}
 			}
 		} else {
-			// assign embeddings to bodies
-			var bodyI int = 0
-			for _, embd := range results {
-				// we expect things to be in the right order
-				// but if one failed to analyze, we need to skip it
-				// as it won't have an embedding
-				for bodies[bodyI].result == nil {
-					bodyI++
-				}
-				msg := bodies[bodyI]
-				msg.embedding = embd
-				bodies[bodyI] = msg
-				// go to next body
-				bodyI++
-
-				toSave = append(toSave, data.EmailSummaryEmbedding{
-					MessageId: msg.entry.MessageId,
-					AccountId: msg.entry.AccountId,
-					Embedding: embd.Values,
-					Sender:    msg.entry.Sender,
-					Receiver:  msg.entry.Receiver,
-					Summary:   msg.embeddingText,
-					Version:   0,
-				})
+			usageRecords = append(usageRecords, embeddingUsage(bodies, toEmbed, results)...)
+			// the vectors are in the same order as toEmbed
+			for n, i := range toEmbed {
+				bodies[i].embedding = results.Vectors[n]
+			}
+		}
+	}
+	toSave := make([]data.EmailSummaryEmbedding, 0, len(bodies))
+	newlyCached := make([]enrichment.CachedAnalysis, 0, len(bodies))
+	usedCache := make([]string, 0)
+	for _, msg := range bodies {
+		if msg.result == nil || msg.embedding == nil {
+			continue
+		}
+		toSave = append(toSave, data.EmailSummaryEmbedding{
+			MessageId: msg.entry.MessageId,
+			AccountId: msg.entry.AccountId,
+			Embedding: msg.embedding,
+			Sender:    msg.entry.Sender,
+			Receiver:  msg.entry.Receiver,
+			Summary:   msg.embeddingText,
+			AiVersion: msg.version,
+			// used for filtering similar messages
+			InternalDate: msg.entry.InternalDate,
+		})
+		if msg.cached {
+			usedCache = append(usedCache, msg.cacheKey)
+			if !msg.extracted {
+				continue
 			}
+			// cached before its entities were, so add them
+		}
+		newlyCached = append(newlyCached, enrichment.CachedAnalysis{
+			Key:           msg.cacheKey,
+			Result:        *msg.result,
+			EmbeddingText: msg.embeddingText,
+			Embedding:     msg.embedding,
+			Entities:      msg.entities,
+		})
+	}
+	// the tokens were spent, even if the writes below fail
+	if err := saveUsage(ctx, usageRecords...); err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Msg("failed to save usage")
+	}
+	if err := saveCached(ctx, newlyCached, usedCache); err != nil {
+		return nil, nil, fmt.Errorf("saving analysis cache: %w", err)
+	}
+
+	// write the found tags + categories
+	if err := saveAnalyzeResults(ctx, bodies); err != nil {
+		return nil, nil, fmt.Errorf("writing tags and categories: %w", err)
+	}
+	for _, f := range toWriteEntities {
+		if err := saveEntities(ctx, f.entry, f.types, f.entities); err != nil {
+			return nil, nil, fmt.Errorf("writing entities of %s: %w", f.entry.ToDocumentId(), err)
 		}
 	}
 	// save the embeddings with metadata
 	if len(toSave) > 0 {
-		data.BulkWriteEmailSummaries(ctx, toSave)
+		if err := saveSummaries(ctx, toSave); err != nil {
+			return nil, nil, fmt.Errorf("writing summaries: %w", err)
+		}
 		nextStep := make([]kafka.Message, 0, len(toSave))
 		for _, entry := range toSave {
 			entryBytes, _ := json.Marshal(entry)
@@ -202,113 +476,128 @@ func work(ctx context.Context, msgs []kafka.Message, available *kafka.Writer) (d
 		}
 		// make it available to downstream services
 		if err := available.WriteMessages(ctx, nextStep...); err != nil {
+			return nil, nil, fmt.Errorf("writing to available topic: %w", err)
+		}
+	}
+	return failed, transient, nil
+}
+
+// holds back messages in a batch job that still hit a transient LLM error, eg. a quota that's used up,
+// rather than dead lettering them. The aiBackfill service re-queues them.
+// Returns the messages that couldn't be held back
+func deferTransient(ctx context.Context, msgs []messageBody) []kafka.Message {
+	failed := make([]kafka.Message, 0)
+	for _, msg := range msgs {
+		if err := usage.Defer(ctx, msg.entry.AccountId, msg.entry.MessageId, msg.src.Value); err != nil {
 			log.Error().
 				Ctx(ctx).
 				Err(err).
-				Msg("failed to write messages to available topic. Messages lost!")
+				Str("taskId", msg.entry.ToDocumentId()).
+				Msg("failed to defer message")
+			failed = append(failed, deadletter.WithError(msg.src, err))
 		}
 	}
-	// return failed
-	return failed, nil
+	return failed
+}
 
+func promptFor(ctx context.Context, accountId string) accountPrompt {
+	prompt := accountPrompt{
+		redaction: settings.RedactionLevel(ctx, accountId),
+	}
+	accountTaxonomy, err := taxonomy.Get(ctx, accountId)
+	if err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Str("accountId", accountId).
+			Msg("failed to get taxonomy, using the default")
+		// version 0 so a backfill will pick these up again
+		prompt.instructions = enrichment.Instructions(taxonomy.Default())
+		prompt.version = enrichment.CurrentVersion(globals.LLM(), 0)
+		return prompt
+	}
+	prompt.instructions = enrichment.Instructions(accountTaxonomy.Categories)
+	prompt.version = enrichment.CurrentVersion(globals.LLM(), accountTaxonomy.Version)
+	return prompt
 }
 
-var responseSchema = map[string]any{
-	"type": "object",
-	"properties": map[string]any{
-		"Theme": map[string]any{
-			"type":        "string",
-			"description": "Theme of the email",
-		},
-		"Summary": map[string]any{
-			"type":        "string",
-			"description": "1-3 line summary of this email",
-		},
-		"Categories": map[string]any{
-			"type": "array",
-			"items": map[string]any{
-				"type":        "string",
-				"description": "The L1 and L2 categories for this email.",
-			},
-		},
-		"Tags": map[string]any{
-			"type": "array",
-			"items": map[string]any{
-				"type":        "string",
-				"description": "A tag for this email. Must be 1 word.",
-			},
-		},
-		"Todos": map[string]any{
-			"type": "array",
-			"items": map[string]any{
-				"type":        "string",
-				"description": "An action item to complete when replying to this email",
-			},
-			"description": "A list of action items to complete when replying to this email. Can be an empty array if no action is needed.",
-		},
-	},
-	"required": []string{"Theme", "Summary", "Categories", "Tags", "Todos"},
+func promptText(email messageBody) string {
+	text := "Subject: " + email.subject + "\n\n" + email.body
+	if email.senderAuth != "" {
+		text = email.senderAuth + "\n" + text
+	}
+	if email.attachments != "" {
+		text += "\n\n" + email.attachments
+	}
+	return text
 }
 
-func anaylze(ctx context.Context, email messageBody) (*expectedAnalyzeResult, error) {
+// returns the tokens used, even if the result couldn't be parsed
+func anaylze(ctx context.Context, email messageBody, instructions string) (*enrichment.Result, *llm.Usage, error) {
 
-	result, err := globals.Gemini().Models.GenerateContent(
-		ctx,
-		"gemini-flash-latest",
-		genai.Text(`Subject: `+email.entry.Subject+`\n\n`+email.body),
-		&genai.GenerateContentConfig{
-			ResponseMIMEType:   "application/json",
-			ResponseJsonSchema: responseSchema,
-			SystemInstruction: &genai.Content{
-				Parts: []*genai.Part{{
-					Text: gemini_instructions,
-				}},
-			},
-		},
-	)
+	result, err := globals.LLM().GenerateJSON(ctx, llm.JSONRequest{
+		System: instructions,
+		Prompt: promptText(email),
+		Schema: enrichment.ResponseSchema,
+	})
 	if err != nil {
 		log.Error().
 			Ctx(ctx).
 			Err(err).
 			Str("docId", email.entry.ToDocumentId()).
 			Msg("failed to generate content")
-		return nil, err
+		return nil, nil, err
 	}
-	txt := result.Text()
-	var res expectedAnalyzeResult
+	txt := result.Text
+	var res enrichment.Result
 	if err := json.Unmarshal([]byte(txt), &res); err != nil {
 		log.Error().
 			Ctx(ctx).
 			Err(err).
 			Str("docId", email.entry.ToDocumentId()).
 			Msg("failed to unmarshal analyze result")
-		return nil, err
+		return nil, &result.Usage, err
 	}
 	log.Info().Ctx(ctx).Any("analyzeResult", res).Msg("analyzeResult")
-	return &res, nil
+	return &res, &result.Usage, nil
 }
 
-func createEmbeddings(ctx context.Context, items []messageBody) ([]*genai.ContentEmbedding, error) {
-	contents := make([]*genai.Content, 0, len(items))
-	for i, item := range items {
-		if item.result == nil {
-			continue
-		}
-		res := item.result
+// the typed records in the email, for the given types, and the response they were parsed from, to cache.
+// Returns the tokens used, even if the result couldn't be parsed
+func extractEntities(ctx context.Context, email messageBody, types []string) ([]data.ExtractedEntity, string, *llm.Usage, error) {
+	result, err := globals.LLM().GenerateJSON(ctx, llm.JSONRequest{
+		System: enrichment.EntityInstructions,
+		Prompt: promptText(email),
+		Schema: enrichment.EntitySchema(types),
+	})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	entities, err := enrichment.ParseEntities(result.Text, email.entry)
+	if err != nil {
+		return nil, "", &result.Usage, err
+	}
+	return entities, result.Text, &result.Usage, nil
+}
+
+// embeds the items at the given indexes
+func createEmbeddings(ctx context.Context, items []messageBody, indexes []int) (*llm.EmbedResponse, error) {
+	contents := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		res := items[i].result
 		toEmbedd := fmt.Sprintf("%s\n%s\n%s\n%s", res.Theme, res.Summary, strings.Join(res.Categories, ", "), strings.Join(res.Tags, ", "))
+		if items[i].attachments != "" {
+			toEmbedd += "\n" + attachments.Truncate(items[i].attachments, maxEmbedAttachmentLength)
+		}
 		items[i].embeddingText = toEmbedd
-		contents = append(contents, genai.NewContentFromText(toEmbedd, genai.RoleUser))
+		contents = append(contents, toEmbedd)
 	}
 
-	// https://ai.google.dev/gemini-api/docs/embeddings
-	result, err := globals.Gemini().Models.EmbedContent(
-		ctx, "gemini-embedding-001",
-		contents,
-		&genai.EmbedContentConfig{
-			TaskType:             "CLUSTERING",
-			OutputDimensionality: &outputDimens,
-		},
-	)
+	// must match the dimensions of the vs_message_summaries index
+	result, err := globals.LLM().Embed(ctx, contents, llm.EmbedOptions{
+		TaskType:   "CLUSTERING",
+		Dimensions: outputDimens,
+	})
 	if err != nil {
 		log.Error().
 			Ctx(ctx).
@@ -316,7 +605,32 @@ func createEmbeddings(ctx context.Context, items []messageBody) ([]*genai.Conten
 			Msg("failed to create embedding")
 		return nil, err
 	}
-	return result.Embeddings, nil
+	return result, nil
+}
+
+// the embedding call is batched, so split its tokens across the messages by their length
+func embeddingUsage(items []messageBody, indexes []int, res *llm.EmbedResponse) []usage.Record {
+	var estimated int64
+	for _, i := range indexes {
+		estimated += llm.EstimateTokens(items[i].embeddingText)
+	}
+	records := make([]usage.Record, 0, len(indexes))
+	for _, i := range indexes {
+		item := items[i]
+		tokens := res.Usage.PromptTokens
+		if estimated > 0 {
+			tokens = res.Usage.PromptTokens * llm.EstimateTokens(item.embeddingText) / estimated
+		}
+		records = append(records, usage.Record{
+			AccountId:       item.entry.AccountId,
+			MessageId:       item.entry.MessageId,
+			Provider:        globals.LLM().Name(),
+			Model:           res.Model,
+			Operation:       usage.OperationEmbed,
+			EmbeddingTokens: tokens,
+		})
+	}
+	return records
 }
 
 func fetchBody(ctx context.Context, entry data.GmailEntry) (*data.GmailEntryBody, error) {
@@ -331,66 +645,83 @@ func fetchBody(ctx context.Context, entry data.GmailEntry) (*data.GmailEntryBody
 	return &body, nil
 }
 
-func stripHtml(ctx context.Context, body data.GmailEntryBody) (string, error) {
-	if body.PlainText != "" {
-		return strings.TrimSpace(body.PlainText), nil
-	}
-	if body.Html != "" {
-		// niave limit of body size
-		if len(body.Html) > 1024*1024*2 {
-			body.Html = body.Html[:1024*1024*2]
+func newContent(body data.GmailEntryBody) (string, error) {
+	text := body.NewContent
+	if text == "" {
+		// bodies fetched before we stored the new content
+		var err error
+		text, err = quoted.NewContent(body.PlainText, body.Html)
+		if err != nil {
+			return "", err
 		}
-		res, err := htmltomarkdown.ConvertString(body.Html)
-		return strings.TrimSpace(res), err
 	}
-	// empty body.. so be e
-	return "empty body", nil
+	if text == "" {
+		// empty body.. so be e
+		return "empty body", nil
+	}
+	return text, nil
 }
 
-func writeAnalyzeResult(ctx context.Context, bodies []messageBody) error {
-	tagsAndCategories := make([]mongo.WriteModel, 0, len(bodies))
+// enforce normalization, before the results are used or cached
+func normalizeResults(bodies []messageBody) {
 	for _, msg := range bodies {
 		if msg.result == nil {
 			continue
 		}
-		// enforce normalization
 		for i, tag := range msg.result.Tags {
 			msg.result.Tags[i] = strings.TrimSpace(strings.ToLower(tag))
 		}
 		for i, cat := range msg.result.Categories {
 			msg.result.Categories[i] = strings.TrimSpace(strings.ToLower(cat))
 		}
-		// add to the message itself
-		addToSet := bson.M{}
-		if len(msg.result.Tags) > 0 {
-			addToSet["tags"] = bson.D{{"$each", msg.result.Tags}}
+	}
+}
+
+func writeAnalyzeResult(ctx context.Context, bodies []messageBody) error {
+	tagsAndCategories := make([]mongo.WriteModel, 0, len(bodies))
+	for _, msg := range bodies {
+		if msg.result == nil {
+			continue
 		}
-		if len(msg.result.Categories) > 0 {
-			addToSet["categories"] = bson.D{{"$each", msg.result.Categories}}
+		// add to the message itself
+		set := bson.M{
+			"aiVersion":  msg.version,
+			"redactions": msg.redactions,
+			"summary":    msg.result.Summary,
 		}
-		todos := bson.M{}
-		if len(msg.result.Todos) > 0 {
-			todos["todos"] = msg.result.Todos
+		addToSet := bson.M{}
+		if msg.backfill {
+			// the old results came from an older prompt/taxonomy, so replace them
+			set["tags"] = msg.result.Tags
+			set["categories"] = msg.result.Categories
+		} else {
+			if len(msg.result.Tags) > 0 {
+				addToSet["tags"] = bson.D{{"$each", msg.result.Tags}}
+			}
+			if len(msg.result.Categories) > 0 {
+				addToSet["categories"] = bson.D{{"$each", msg.result.Categories}}
+			}
 		}
-		if len(addToSet) == 0 && len(todos) == 0 {
-			continue // nothing to add
+		if len(msg.result.Todos) > 0 || msg.backfill {
+			set["todos"] = msg.result.Todos
 		}
 		update := bson.M{
+			"$set":         set,
 			"$currentDate": bson.M{"updatedAt": true},
 			"$inc":         bson.M{"revisionCount": 1},
 		}
 		if len(addToSet) > 0 {
 			update["$addToSet"] = addToSet
 		}
-		if len(todos) > 0 {
-			update["$set"] = todos
-		}
 		tagsAndCategories = append(tagsAndCategories, mongo.NewUpdateOneModel().
 			SetFilter(bson.D{{"_id", msg.entry.ToDocumentId()}}).
 			SetUpdate(update).
 			SetUpsert(false),
 		)
 	}
+	if len(tagsAndCategories) == 0 {
+		return nil
+	}
 	col := globals.DocDb().Collection("Messages")
 	if _, err := col.BulkWrite(ctx, tagsAndCategories); err != nil {
 		return err
@@ -398,316 +729,53 @@ func writeAnalyzeResult(ctx context.Context, bodies []messageBody) error {
 	return nil
 }
 
-// TODO: pull this from a database
-// allow the user to define their own categories
-const (
-	predefined_categories = `
-	# Accounts & Identity
-- Account setup
-- Profile updates
-- Login activity
-- Password reset
-- Identity verification
-- Account recovery
-- Access permission changes
-- Account closure
-
-# Security & Fraud
-- Security alerts
-- Suspicious activity
-- Fraud prevention
-- Device verification
-- Data breach notifications
-- Authentication issues
-- Privacy notices
-- Security policy updates
-
-# Billing & Payments
-- Payment confirmations
-- Receipts
-- Invoices
-- Billing errors
-- Payment failures
-- Refunds
-- Billing plan changes
-- Tax-related billing
-
-# Finance & Banking
-- Account balance updates
-- Transactions
-- Money transfers
-- Loan and credit updates
-- Investment statements
-- Market advisories
-- Financial reports
-- Tax documents
-
-# Shopping & Ecommerce
-- Order confirmations
-- Shipping updates
-- Delivery notices
-- Returns & refunds
-- Promotions
-- Loyalty rewards
-- Product availability
-- Online receipts
-
-# Travel & Transportation
-- Flight bookings
-- Hotel reservations
-- Itineraries
-- Schedule changes
-- Travel alerts
-- Transportation services
-- Travel insurance
-- Visa & entry documentation
-
-# Health & Medical
-- Appointment confirmations
-- Lab results
-- Medical billing
-- Health insurance claims
-- Pharmacy & prescriptions
-- Health reminders
-- Telehealth communication
-- Provider updates
-
-# Employment & Hiring
-- Job applications
-- Recruiting outreach
-- Interview scheduling
-- Offer letters
-- Hiring decisions
-- Career development
-- Background checks
-- Job search resources
-
-# Human Resources
-- Onboarding materials
-- Payroll notices
-- Benefits updates
-- HR policy updates
-- Performance evaluations
-- Workplace compliance
-- Time-off approvals
-- Employee communication
-
-# Internal Operations
-- Meeting invitations
-- Project updates
-- Scheduling
-- Operational alerts
-- Resource allocation
-- Organizational announcements
-- Workflow coordination
-- Team communication
-
-# Engineering & Technical
-- Deployments
-- Build notifications
-- Incident alerts
-- API changes
-- Bug reports
-- Logging & monitoring
-- Developer tools
-- System upgrades
-
-# Product & UX
-- Product updates
-- Feature announcements
-- Release notes
-- User research
-- UX testing invitations
-- Product onboarding
-- Churn outreach
-- Usage insights
-
-# Sales & Business Development
-- Lead communication
-- Sales proposals
-- Pricing quotes
-- Contract negotiations
-- CRM updates
-- Renewal discussions
-- Partner outreach
-- Deal progress updates
-
-# Marketing & Growth
-- Marketing campaigns
-- Newsletters
-- Market research
-- Audience insights
-- Promotional materials
-- Brand partnerships
-- SEO & content updates
-- Performance reports
-
-# Customer Support
-- Support tickets
-- Case updates
-- Troubleshooting instructions
-- Service notifications
-- Customer follow-ups
-- Satisfaction surveys
-- Replacement approvals
-- Escalation updates
-
-# Leadership & Executive
-- Board communication
-- Investor updates
-- Strategic planning
-- Executive alignment
-- Organizational changes
-- Financial reporting
-- High-level partnerships
-- Crisis communication
-
-# Legal & Compliance
-- Legal notices
-- Contract documents
-- Compliance reminders
-- Licensing updates
-- Privacy rights requests
-- Regulatory filings
-- Policy acknowledgments
-- Audit correspondence
-
-# Government & Civic
-- Government forms
-- Official notices
-- Civic programs
-- Elections & voting
-- Public safety alerts
-- Permits & licensing
-- Local government updates
-- Public policy changes
-
-# Education & School
-- School announcements
-- Teacher communication
-- Academic schedules
-- Assignments
-- Grades & transcripts
-- PTA/parent updates
-- Student services
-- Educational programs
-
-# Parenting & Childcare
-- Childcare scheduling
-- School activities
-- Family event planning
-- Youth programs
-- Parent groups
-- Activity reminders
-- Child health updates
-- Permission forms
-
-# Home & Household
-- Maintenance appointments
-- Home services
-- Utility billing
-- Property management
-- Renovation updates
-- Landscaping & gardening
-- Home monitoring
-- Household purchases
-
-# Real Estate & Property
-- Rental agreements
-- Mortgage communication
-- Property listings
-- Home inspections
-- Realtor communication
-- Tenant updates
-- Move-in/move-out notices
-- Insurance evaluations
-
-# Legal & Financial Services
-- Accounting communication
-- Insurance quotes
-- Financial advising
-- Estate planning
-- Legal consultations
-- Will & trust services
-- Service agreements
-- Professional recommendations
-
-# Social & Community
-- Event invitations
-- RSVPs
-- Group messages
-- Social media notifications
-- Community updates
-- Friend/connection requests
-- Local event notices
-- Social planning
-
-# Sports & Recreation
-- Sports leagues
-- Team updates
-- Fitness classes
-- Event schedules
-- Results & scores
-- Recreational programs
-- Outdoor activities
-- Hobby group communication
-
-# Entertainment & Media
-- Streaming updates
-- Content releases
-- Game updates
-- Podcasts
-- Music events
-- Book/news alerts
-- Subscriptions
-- Media promotions
-
-# Nonprofit & Charity
-- Donation confirmations
-- Fundraising campaigns
-- Volunteering
-- Charity events
-- Nonprofit updates
-- Impact reports
-- Member communication
-- Advocacy alerts
-
-# Product Notifications & Systems
-- App notifications
-- System alerts
-- Feature rollouts
-- Service interruptions
-- Device updates
-- Usage warnings
-- Platform maintenance
-- Data export availability
-
-# Personal Organization
-- Reminders
-- Notes & documents
-- Personal tasks
-- Calendar scheduling
-- Lists & planning
-- Personal updates
-- Life admin
-- Digital tools
-
-# Miscellaneous
-- Uncategorized
-- Unknown intent
-- Mixed-content messages
-- Broad newsletters
-- Irrelevant content
-- Auto-generated noise
-- Spam indicators
-- System noise
-	`
-)
-
-var gemini_instructions = fmt.Sprintf(`You help categorize & summarize emails. Get out the theme (1 line), a summary (1-3 lines), suggested categories (L1, L2), tags (3-8 short tokens, as a string list), and a TODO list for replying to the email.
-Return as JSON (Theme, Summary, Categories, Tags, Todos)
-
-The categories are below, L1 is the heading, L2 is bullet under the heading:
-
-%s
-`, predefined_categories)
+// upserts the found entities. Types that were looked for but not found are removed,
+// as a re-processed message may no longer have them
+func writeEntities(ctx context.Context, entry data.GmailEntry, types []string, entities []data.ExtractedEntity) error {
+	writes := make([]mongo.WriteModel, 0, len(types))
+	for _, entity := range entities {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"_id", entity.ToDocumentId()}}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"accountId":    entity.AccountId,
+					"entityId":     entity.EntityId,
+					"messageId":    entity.MessageId,
+					"threadId":     entity.ThreadId,
+					"type":         entity.Type,
+					"key":          entity.Key,
+					"date":         entity.Date,
+					"order":        entity.Order,
+					"shipment":     entity.Shipment,
+					"flight":       entity.Flight,
+					"bill":         entity.Bill,
+					"internalDate": entity.InternalDate,
+					"isDeleted":    false,
+				},
+				"$currentDate": bson.M{"updatedAt": true},
+				"$setOnInsert": bson.M{"createdAt": time.Now().UTC()},
+			}).
+			SetUpsert(true),
+		)
+	}
+	for _, t := range types {
+		if slices.ContainsFunc(entities, func(e data.ExtractedEntity) bool { return e.Type == t }) {
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{
+				{"_id", data.ToDocumentId(entry.AccountId, data.EntityId(entry.MessageId, t))},
+				{"isDeleted", false},
+			}).
+			SetUpdate(bson.M{
+				"$set":         bson.M{"isDeleted": true},
+				"$currentDate": bson.M{"updatedAt": true},
+			}),
+		)
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err := globals.DocDb().Collection("ExtractedEntities").BulkWrite(ctx, writes)
+	return err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/enrichment"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/services/kafkaservice/kafkatest"
+	"fromkeith/my-desktop-server/usage"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const availableTopic = "email_embedding_available"
+
+// a bill found in an earlier, identical, email
+const cachedBill = `{"bill": {"Found": true, "biller": "Power Co", "amount": 42, "currency": "USD", "dueDate": "2026-11-01", "status": "due"}}`
+
+// stands in for MongoDB and Postgres, recording what finish saved
+type standIn struct {
+	mu sync.Mutex
+	// in the order they were saved. eg. "summaries a;1 a;2"
+	saves []string
+	// the tags written to each message
+	tags map[string][]string
+	// saving these fails this many more times. eg. "cache"
+	fail map[string]int
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{tags: make(map[string][]string), fail: make(map[string]int)}
+	prevUsage, prevCached, prevResults, prevEntities, prevSummaries := saveUsage, saveCached, saveAnalyzeResults, saveEntities, saveSummaries
+	saveUsage = func(ctx context.Context, records ...usage.Record) error {
+		return nil
+	}
+	saveCached = func(ctx context.Context, added []enrichment.CachedAnalysis, usedKeys []string) error {
+		return s.save("cache", usedKeys...)
+	}
+	saveAnalyzeResults = func(ctx context.Context, bodies []messageBody) error {
+		ids := make([]string, 0, len(bodies))
+		for _, body := range bodies {
+			ids = append(ids, body.entry.ToDocumentId())
+		}
+		if err := s.save("results", ids...); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, body := range bodies {
+			s.tags[body.entry.ToDocumentId()] = body.result.Tags
+		}
+		return nil
+	}
+	saveEntities = func(ctx context.Context, entry data.GmailEntry, types []string, entities []data.ExtractedEntity) error {
+		ids := make([]string, 0, len(entities))
+		for _, entity := range entities {
+			ids = append(ids, entity.ToDocumentId())
+		}
+		return s.save("entities", ids...)
+	}
+	saveSummaries = func(ctx context.Context, entries []data.EmailSummaryEmbedding) error {
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.ToDocumentId())
+		}
+		return s.save("summaries", ids...)
+	}
+	t.Cleanup(func() {
+		saveUsage, saveCached, saveAnalyzeResults, saveEntities, saveSummaries = prevUsage, prevCached, prevResults, prevEntities, prevSummaries
+	})
+	return s
+}
+
+func (s *standIn) save(what string, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail[what] > 0 {
+		s.fail[what]--
+		return kafkatest.ErrInjected
+	}
+	s.saves = append(s.saves, strings.Join(append([]string{what}, ids...), " "))
+	return nil
+}
+
+func (s *standIn) saved() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.saves)
+}
+
+// the gemini service, with a worker that finishes the messages as if their analysis was cached,
+// so finish doesn't need the LLM
+func startService(t *testing.T, b *kafkatest.Broker) {
+	available := b.Writer(availableTopic)
+	opt := service(available)
+	opt.MaxWait = 5 * time.Millisecond
+	opt.Worker = func(ctx context.Context, msgs []kafka.Message) ([]kafka.Message, []kafka.Message, error) {
+		bodies := make([]messageBody, 0, len(msgs))
+		for _, msg := range msgs {
+			var payload data.EmailInjestedPayload
+			if err := json.Unmarshal(msg.Value, &payload); err != nil {
+				return nil, nil, err
+			}
+			entry := payload.Entry
+			entry.AccountId = payload.AccountId
+			body := messageBody{
+				entry:         entry,
+				src:           msg,
+				cacheKey:      "key " + entry.MessageId,
+				cached:        true,
+				embedding:     []float32{1, 0},
+				embeddingText: "power bill",
+				result: &enrichment.Result{
+					Summary:    entry.Subject,
+					Categories: []string{" Social "},
+					Tags:       []string{" Friends"},
+				},
+			}
+			if strings.Contains(entry.Subject, "bill") {
+				body.result.Categories = []string{"Finance/Bills"}
+				body.entities = cachedBill
+			}
+			bodies = append(bodies, body)
+		}
+		failed, transient, err := finish(ctx, bodies, nil, available)
+		if err != nil {
+			return nil, nil, err
+		}
+		retry := make([]kafka.Message, 0, len(transient))
+		for _, msg := range transient {
+			retry = append(retry, msg.src)
+		}
+		return failed, retry, nil
+	}
+	kafkatest.Start(t, opt, sourceTopic)
+}
+
+func produce(t *testing.T, b *kafkatest.Broker, entries ...data.GmailEntry) {
+	t.Helper()
+	msgs := make([]kafka.Message, 0, len(entries))
+	for _, entry := range entries {
+		value, _ := json.Marshal(data.EmailInjestedPayload{
+			MessageId: entry.MessageId,
+			AccountId: entry.AccountId,
+			Entry:     entry,
+		})
+		msgs = append(msgs, kafka.Message{Key: []byte(entry.ToDocumentId()), Value: value})
+	}
+	if err := b.Produce(sourceTopic, msgs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var (
+	billEntry   = data.GmailEntry{AccountId: "a", MessageId: "1", Subject: "your power bill"}
+	socialEntry = data.GmailEntry{AccountId: "a", MessageId: "2", Subject: "lunch on friday?"}
+)
+
+func passedOn(t *testing.T, b *kafkatest.Broker) []string {
+	t.Helper()
+	keys := make([]string, 0)
+	for _, msg := range b.Messages(availableTopic) {
+		var summary data.EmailSummaryEmbedding
+		if err := json.Unmarshal(msg.Value, &summary); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(msg.Key))
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestFinishCommitsOnceWritten(t *testing.T) {
+	b := kafkatest.NewBroker()
+	b.Install(t)
+	s := newStandIn(t)
+	startService(t, b)
+
+	produce(t, b, billEntry, socialEntry)
+	kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(sourceTopic, "gemini") == 2 })
+
+	want := []string{
+		// first, so the LLM's work isn't paid for again if a write below fails
+		"cache key 1 key 2",
+		"results a;1 a;2",
+		"entities a;" + data.EntityId("1", data.EntityBill),
+		"summaries a;1 a;2",
+	}
+	if got := s.saved(); !slices.Equal(got, want) {
+		t.Errorf("saved %q, want %q", got, want)
+	}
+	if got := s.tags["a;2"]; !slices.Equal(got, []string{"friends"}) {
+		t.Errorf("tags %q, want them normalized", got)
+	}
+	if got := passedOn(t, b); !slices.Equal(got, []string{"a;1", "a;2"}) {
+		t.Errorf("passed on %v", got)
+	}
+	if got := len(b.Messages(deadTopic)); got != 0 {
+		t.Errorf("%d dead lettered, want 0", got)
+	}
+}
+
+func TestFinishReadsAgainAfterFailedWrite(t *testing.T) {
+	for _, failed := range []string{"cache", "results", "entities", "summaries", availableTopic} {
+		t.Run(failed, func(t *testing.T) {
+			b := kafkatest.NewBroker()
+			b.Install(t)
+			s := newStandIn(t)
+			if failed == availableTopic {
+				b.FailNextWrite(availableTopic)
+			} else {
+				s.fail[failed] = 1
+			}
+			startService(t, b)
+
+			produce(t, b, billEntry, socialEntry)
+			kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(sourceTopic, "gemini") == 2 })
+
+			if got := b.ReadersOpened(sourceTopic); got != 2 {
+				t.Errorf("%d readers opened, want 2", got)
+			}
+			// everything is saved by the time it's committed, and only passed on once
+			saved := s.saved()
+			for _, what := range []string{"cache", "results", "entities", "summaries"} {
+				if !slices.ContainsFunc(saved, func(save string) bool { return strings.HasPrefix(save, what+" ") }) {
+					t.Errorf("%s not saved: %q", what, saved)
+				}
+			}
+			if got := passedOn(t, b); !slices.Equal(got, []string{"a;1", "a;2"}) {
+				t.Errorf("passed on %v, want each once", got)
+			}
+			if got := len(b.Messages(deadTopic)); got != 0 {
+				t.Errorf("%d dead lettered, want 0", got)
+			}
+			if got := len(b.Messages("gemini_retry_1m")); got != 0 {
+				t.Errorf("%d sent to retry, want 0", got)
+			}
+		})
+	}
+}
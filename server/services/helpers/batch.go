@@ -9,10 +9,15 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
+// what FetchBatch reads from. A *kafka.Reader, or a stand-in in tests
+type Fetcher interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+}
+
 // FetchBatch pulls up to N messages. It waits indefinitely for the first
 // message (respecting ctx). Once the first arrives, it allows up to
 // fillWindow for additional messages before returning.
-func FetchBatch(ctx context.Context, r *kafka.Reader, maxMessages int, fillWindow time.Duration) ([]kafka.Message, error) {
+func FetchBatch(ctx context.Context, r Fetcher, maxMessages int, fillWindow time.Duration) ([]kafka.Message, error) {
 	batch := make([]kafka.Message, 0, maxMessages)
 	var deadline time.Time // zero until first message
 
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/importance"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// fields our own writes, importance.Learn's, and the phishingAnalyzer's, touch. Updates to only these don't need a rescore
+var ownFields = []string{"importance", "importanceFeatures", "importanceFeedback", "phishing", "updatedAt", "revisionCount"}
+
+func main() {
+	log.Info().
+		Msg("Starting up importanceScorer")
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "importanceScorer"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType",
+				Value: bson.D{{
+					Key: "$in", Value: bson.A{"insert", "update", "replace"}},
+				}},
+		}}},
+	}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup)
+	stream, err := globals.DocDb().Collection("Messages").Watch(ctx, pipeline, opts)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to start change stream")
+		return
+	}
+	defer stream.Close(ctx)
+
+	// accountId -> their addresses. They rarely change, so cache them for a bit
+	emails := make(map[string][]string)
+	emailsLoadedAt := time.Now()
+
+	for stream.Next(ctx) {
+		var ev bson.M
+		if err := stream.Decode(&ev); err != nil {
+			log.Error().Ctx(ctx).Err(err).Msg("failed to decode change event")
+			continue
+		}
+		if onlyOwnFields(ev) {
+			continue
+		}
+		var entry data.GmailEntry
+		raw, _ := bson.Marshal(ev["fullDocument"])
+		if err := bson.Unmarshal(raw, &entry); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Msg("failed to unmarshal email in stream")
+			continue
+		}
+		if entry.IsDeleted || entry.AccountId == "" {
+			continue
+		}
+		if time.Since(emailsLoadedAt) > 10*time.Minute {
+			emails = make(map[string][]string)
+			emailsLoadedAt = time.Now()
+		}
+		myEmails, ok := emails[entry.AccountId]
+		if !ok {
+			myEmails, err = data.AccountEmails(ctx, entry.AccountId)
+			if err != nil {
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("accountId", entry.AccountId).
+					Msg("failed to load account emails")
+				continue
+			}
+			emails[entry.AccountId] = myEmails
+		}
+
+		if ev["operationType"] == "insert" && slices.Contains(entry.Labels, "SENT") {
+			// we replied, so the message we replied to was important
+			if err := learnFromReply(ctx, entry); err != nil {
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("docId", entry.ToDocumentId()).
+					Msg("failed to learn from reply")
+			}
+			continue // don't score our own messages
+		}
+		if slices.Contains(entry.Labels, "SENT") {
+			continue
+		}
+		if err := importance.Rescore(ctx, entry, myEmails); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("docId", entry.ToDocumentId()).
+				Msg("failed to score message")
+		}
+	}
+
+	if err := stream.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal().Stack().Err(err).Msg("ChangeStream closed")
+	}
+	log.Info().Msg("Exiting")
+}
+
+func onlyOwnFields(ev bson.M) bool {
+	if ev["operationType"] != "update" {
+		return false
+	}
+	desc, ok := ev["updateDescription"].(bson.M)
+	if !ok {
+		return false
+	}
+	updated, _ := desc["updatedFields"].(bson.M)
+	for k := range updated {
+		// an $addToSet shows up as eg. importanceFeedback.1
+		field, _, _ := strings.Cut(k, ".")
+		if !slices.Contains(ownFields, field) {
+			return false
+		}
+	}
+	removed, _ := desc["removedFields"].(bson.A)
+	return len(removed) == 0
+}
+
+// finds the latest received message in the thread before our reply
+func learnFromReply(ctx context.Context, sent data.GmailEntry) error {
+	var repliedTo data.GmailEntry
+	err := globals.DocDb().Collection("Messages").FindOne(
+		ctx,
+		bson.M{
+			"accountId":    sent.AccountId,
+			"threadId":     sent.ThreadId,
+			"labels":       bson.M{"$ne": "SENT"},
+			"internalDate": bson.M{"$lt": sent.InternalDate},
+		},
+		options.FindOne().SetSort(bson.D{{"internalDate", -1}}),
+	).Decode(&repliedTo)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil // we started the thread
+	}
+	if err != nil {
+		return err
+	}
+	return importance.Learn(ctx, repliedTo, "replied")
+}
@@ -0,0 +1,7 @@
+package kafkaservice
+
+// for the external tests
+var (
+	RetryTopic  = retryTopic
+	ReaderGroup = readerGroup
+)
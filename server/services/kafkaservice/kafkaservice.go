@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"fromkeith/my-desktop-server/deadletter"
 	"fromkeith/my-desktop-server/globals"
 	"fromkeith/my-desktop-server/services/helpers"
 	"io"
@@ -16,7 +17,61 @@ import (
 	"github.com/segmentio/kafka-go"
 )
 
-type KafkaWorker func(context.Context, []kafka.Message) (dlq []kafka.Message, err error)
+// returns the messages to dead letter, and those to try again later. Set why with deadletter.WithError and RetryLater.
+// An error means the batch couldn't be finished, eg. a downstream write failed. It isn't committed, and is read again,
+// so the worker's writes must be safe to repeat
+type KafkaWorker func(context.Context, []kafka.Message) (dlq []kafka.Message, retry []kafka.Message, err error)
+
+// what run reads with. A *kafka.Reader, or a stand-in in tests
+type Reader interface {
+	helpers.Fetcher
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// what run writes retries with. A *kafka.Writer, or a stand-in in tests
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// what run dead letters with. A *deadletter.Writer, or a stand-in in tests
+type DeadWriter interface {
+	Write(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// how RunTopic connects to kafka. Tests swap them for in-memory ones, see kafkatest
+type Clients struct {
+	Reader     func(topic string, group string) Reader
+	Writer     func(topic string) Writer
+	DeadWriter func(topic string, source string) DeadWriter
+	// how long to wait before reading uncommitted messages again, after a batch failed
+	RedeliverDelay time.Duration
+}
+
+var clients = Clients{
+	Reader: func(topic string, group string) Reader {
+		return globals.KafkaConsumerGroup(topic, group)
+	},
+	Writer: func(topic string) Writer {
+		return globals.KafkaWriter(topic)
+	},
+	DeadWriter: func(topic string, source string) DeadWriter {
+		return deadletter.NewWriter(topic, source)
+	},
+	RedeliverDelay: 5 * time.Second,
+}
+
+// replaces the clients, returning the ones it replaced so they can be put back
+func SwapClients(c Clients) Clients {
+	prev := clients
+	clients = c
+	return prev
+}
+
+// a good start for RetryDelays
+var DefaultRetryDelays = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
 
 type KafkaService struct {
 	Name        string
@@ -27,6 +82,9 @@ type KafkaService struct {
 	NumWorkers  int
 	Worker      KafkaWorker
 	Dlq         string
+	// how long to wait before each retry of a message the worker asked to retry. Each has its own topic.
+	// Messages that use them all up are dead lettered. None sends them straight to the DLQ
+	RetryDelays []time.Duration
 }
 
 func Run(ctx context.Context, opt KafkaService) {
@@ -52,31 +110,70 @@ func Run(ctx context.Context, opt KafkaService) {
 
 	// spin up each worker
 	wg := sync.WaitGroup{}
-	wg.Add(opt.NumWorkers)
+	wg.Add(opt.NumWorkers + len(opt.RetryDelays))
 	for range opt.NumWorkers {
 		go func() {
-			defer func() {
-				rec := recover()
-				if rec != nil {
-					log.Error().Ctx(cancelContext).Stack().Err(rec.(error)).Msg("panic!")
-				}
-			}()
+			defer recoverWorker(cancelContext)
+			defer wg.Done()
+			RunTopic(cancelContext, opt, opt.Topic)
+		}()
+	}
+	// and one for each retry topic
+	for tier := range opt.RetryDelays {
+		go func() {
+			defer recoverWorker(cancelContext)
 			defer wg.Done()
-			run(cancelContext, opt)
+			RunTopic(cancelContext, opt, retryTopic(opt, tier))
 		}()
 	}
 	// wait for workers to finish.. eg when our terminate signal is received, or all workers failed
 	wg.Wait()
 }
 
-func run(ctx context.Context, opt KafkaService) {
+func recoverWorker(ctx context.Context) {
+	rec := recover()
+	if rec != nil {
+		log.Error().Ctx(ctx).Stack().Err(fmt.Errorf("%v", rec)).Msg("panic!")
+	}
+}
 
-	r := globals.KafkaConsumerGroup(opt.Topic, opt.Group)
-	defer r.Close()
+// reads topic, which is either the service's topic or one of its retry topics, until the context is done.
+// Messages are only committed once the worker, and the retry and dead letter writes, are done.
+// If any of them fail the reader is re-opened, so the uncommitted messages are read again.
+// Run starts one for each worker and retry topic
+func RunTopic(ctx context.Context, opt KafkaService, topic string) {
 
-	dead := globals.KafkaWriter(opt.Dlq)
+	dead := clients.DeadWriter(opt.Dlq, opt.Topic)
 	defer dead.Close()
 
+	retries := make([]Writer, 0, len(opt.RetryDelays))
+	for tier := range opt.RetryDelays {
+		w := clients.Writer(retryTopic(opt, tier))
+		defer w.Close()
+		retries = append(retries, w)
+	}
+
+	for ctx.Err() == nil {
+		r := clients.Reader(topic, readerGroup(opt, topic))
+		err := consume(ctx, opt, r, dead, retries)
+		r.Close()
+		if err == nil {
+			return
+		}
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Str("topic", topic).
+			Msg("failed to process messages. Reading them again")
+		select {
+		case <-ctx.Done():
+		case <-time.After(clients.RedeliverDelay):
+		}
+	}
+}
+
+// processes batches until the context is done or the reader is closed, or one fails
+func consume(ctx context.Context, opt KafkaService, r Reader, dead DeadWriter, retries []Writer) error {
 	for {
 		log.Info().
 			Ctx(ctx).
@@ -87,7 +184,7 @@ func run(ctx context.Context, opt KafkaService) {
 				log.Info().
 					Ctx(ctx).
 					Msg("context canceled; exiting")
-				break
+				return nil
 			}
 			var kerr *kafka.Error
 			if errors.As(err, &kerr) && kerr.Temporary() {
@@ -102,7 +199,7 @@ func run(ctx context.Context, opt KafkaService) {
 					Ctx(ctx).
 					Err(err).
 					Msg("reader closed; exiting")
-				break
+				return nil
 			}
 			log.Error().Ctx(ctx).Err(err).Msg("fetch error")
 			continue
@@ -112,31 +209,98 @@ func run(ctx context.Context, opt KafkaService) {
 			Int("count", len(msgs)).
 			Msg("Got messages")
 
-		failed, err := opt.Worker(ctx, msgs)
-		if err != nil {
-			log.Error().Ctx(ctx).Err(err).Msg("worker error")
-		}
-		if err := r.CommitMessages(ctx, msgs...); err != nil {
-			log.Error().
+		// retried messages wait out their delay first
+		if err := waitUntilDue(ctx, msgs); err != nil {
+			log.Info().
 				Ctx(ctx).
-				Err(err).
-				Msg("Failed to commit messages")
+				Msg("context canceled; exiting")
+			return nil
 		}
-		if len(failed) > 0 {
-			for i, f := range failed {
-				// overwrite topic and other metadata
-				failed[i] = kafka.Message{
-					Key:     f.Key,
-					Value:   f.Value,
-					Headers: f.Headers,
-				}
-			}
-			if err := dead.WriteMessages(ctx, failed...); err != nil {
-				log.Error().
-					Ctx(ctx).
-					Err(err).
-					Msg("failed to write messages to dead topic. Messages lost!")
-			}
+		if err := process(ctx, opt, r, dead, retries, msgs); err != nil {
+			return err
+		}
+	}
+}
+
+// hands the messages to the worker, sends on the ones it failed, then commits them all
+func process(ctx context.Context, opt KafkaService, r Reader, dead DeadWriter, retries []Writer, msgs []kafka.Message) error {
+	failed, retry, err := opt.Worker(ctx, msgs)
+	if err != nil {
+		return fmt.Errorf("worker: %w", err)
+	}
+	byTier := make([][]kafka.Message, len(retries))
+	for _, msg := range retry {
+		tier := retryAttempt(msg)
+		if tier >= len(retries) {
+			// out of retries
+			failed = append(failed, deadletter.WithError(msg, errors.New(retryReason(msg))))
+			continue
+		}
+		byTier[tier] = append(byTier[tier], scheduleRetry(msg, tier, opt.RetryDelays[tier]))
+	}
+	for tier, msgs := range byTier {
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := retries[tier].WriteMessages(ctx, msgs...); err != nil {
+			return fmt.Errorf("writing to retry topic: %w", err)
+		}
+	}
+	if len(failed) > 0 {
+		for i := range failed {
+			// so a replay from the DLQ gets its retries again
+			failed[i] = clearRetry(failed[i])
 		}
+		if err := dead.Write(ctx, failed...); err != nil {
+			return fmt.Errorf("writing to dead topic: %w", err)
+		}
+	}
+	if err := r.CommitMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("committing: %w", err)
+	}
+	return nil
+}
+
+// eg. gemini_retry_10m
+func retryTopic(opt KafkaService, tier int) string {
+	delay := opt.RetryDelays[tier]
+	switch {
+	case delay%time.Hour == 0:
+		return fmt.Sprintf("%s_retry_%dh", opt.Name, delay/time.Hour)
+	case delay%time.Minute == 0:
+		return fmt.Sprintf("%s_retry_%dm", opt.Name, delay/time.Minute)
+	case delay%time.Second == 0:
+		return fmt.Sprintf("%s_retry_%ds", opt.Name, delay/time.Second)
+	default:
+		return fmt.Sprintf("%s_retry_%dms", opt.Name, delay/time.Millisecond)
+	}
+}
+
+// each retry topic is read by its own group, so a rebalance of the main topic's group
+// doesn't revoke the partitions of a retry reader waiting for its messages to be due. eg. gemini_gemini_retry_10m
+func readerGroup(opt KafkaService, topic string) string {
+	if topic == opt.Topic {
+		return opt.Group
+	}
+	return opt.Group + "_" + topic
+}
+
+// the messages in a retry topic were all given the same delay, so the last one is due last
+func waitUntilDue(ctx context.Context, msgs []kafka.Message) error {
+	var due time.Time
+	for _, msg := range msgs {
+		if notBefore := retryNotBefore(msg); notBefore.After(due) {
+			due = notBefore
+		}
+	}
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
 	}
 }
@@ -0,0 +1,209 @@
+package kafkaservice_test
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/deadletter"
+	"fromkeith/my-desktop-server/services/kafkaservice"
+	"fromkeith/my-desktop-server/services/kafkaservice/kafkatest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	testTopic = "test_in"
+	testGroup = "test"
+	testDlq   = "test_dlq"
+)
+
+// counts how many times the worker saw each message
+type seen struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (s *seen) add(msgs []kafka.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	for _, msg := range msgs {
+		s.counts[string(msg.Key)]++
+	}
+}
+
+func (s *seen) count(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[key]
+}
+
+func testService(worker kafkaservice.KafkaWorker) kafkaservice.KafkaService {
+	return kafkaservice.KafkaService{
+		Name:        "test",
+		Topic:       testTopic,
+		Group:       testGroup,
+		NumMessages: 10,
+		MaxWait:     5 * time.Millisecond,
+		NumWorkers:  1,
+		Worker:      worker,
+		Dlq:         testDlq,
+	}
+}
+
+func produceKeys(t *testing.T, b *kafkatest.Broker, keys ...string) {
+	t.Helper()
+	msgs := make([]kafka.Message, 0, len(keys))
+	for _, key := range keys {
+		msgs = append(msgs, kafka.Message{Key: []byte(key), Value: []byte("{}")})
+	}
+	if err := b.Produce(testTopic, msgs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommitsProcessedMessages(t *testing.T) {
+	b := kafkatest.NewBroker()
+	b.Install(t)
+	var s seen
+	kafkatest.Start(t, testService(func(ctx context.Context, msgs []kafka.Message) ([]kafka.Message, []kafka.Message, error) {
+		s.add(msgs)
+		return nil, nil, nil
+	}), testTopic)
+
+	produceKeys(t, b, "a", "b", "c")
+	kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(testTopic, testGroup) == 3 })
+	for _, key := range []string{"a", "b", "c"} {
+		if got := s.count(key); got != 1 {
+			t.Errorf("%s processed %d times, want 1", key, got)
+		}
+	}
+	if got := len(b.Messages(testDlq)); got != 0 {
+		t.Errorf("%d messages dead lettered, want 0", got)
+	}
+}
+
+func TestWorkerErrorReadsAgain(t *testing.T) {
+	b := kafkatest.NewBroker()
+	b.Install(t)
+	var s seen
+	var calls int
+	var mu sync.Mutex
+	kafkatest.Start(t, testService(func(ctx context.Context, msgs []kafka.Message) ([]kafka.Message, []kafka.Message, error) {
+		s.add(msgs)
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			// eg. the downstream write failed
+			return nil, nil, kafkatest.ErrInjected
+		}
+		return nil, nil, nil
+	}), testTopic)
+
+	produceKeys(t, b, "a", "b")
+	kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(testTopic, testGroup) == 2 })
+	for _, key := range []string{"a", "b"} {
+		if got := s.count(key); got != 2 {
+			t.Errorf("%s processed %d times, want 2", key, got)
+		}
+	}
+	if got := b.ReadersOpened(testTopic); got != 2 {
+		t.Errorf("%d readers opened, want 2", got)
+	}
+}
+
+func TestNotCommittedUntilDeadLettered(t *testing.T) {
+	b := kafkatest.NewBroker()
+	b.Install(t)
+	b.FailNextWrite(testDlq)
+	var s seen
+	kafkatest.Start(t, testService(func(ctx context.Context, msgs []kafka.Message) ([]kafka.Message, []kafka.Message, error) {
+		s.add(msgs)
+		failed := make([]kafka.Message, 0, len(msgs))
+		for _, msg := range msgs {
+			failed = append(failed, deadletter.WithError(msg, errors.New("bad payload")))
+		}
+		return failed, nil, nil
+	}), testTopic)
+
+	produceKeys(t, b, "a")
+	kafkatest.WaitFor(t, "commit", func() bool { return b.CommittedOffset(testTopic, testGroup) == 1 })
+	if got := s.count("a"); got != 2 {
+		t.Errorf("processed %d times, want 2", got)
+	}
+	dead := b.Messages(testDlq)
+	if len(dead) != 1 {
+		t.Fatalf("%d messages dead lettered, want 1", len(dead))
+	}
+	if got := kafkatest.Header(dead[0], deadletter.HeaderError); got != "bad payload" {
+		t.Errorf("dead letter error %q, want %q", got, "bad payload")
+	}
+}
+
+func TestRetriesThenDeadLetters(t *testing.T) {
+	b := kafkatest.NewBroker()
+	b.Install(t)
+	var s seen
+	opt := testService(func(ctx context.Context, msgs []kafka.Message) ([]kafka.Message, []kafka.Message, error) {
+		s.add(msgs)
+		retry := make([]kafka.Message, 0, len(msgs))
+		for _, msg := range msgs {
+			retry = append(retry, kafkaservice.RetryLater(msg, errors.New("quota")))
+		}
+		return nil, retry, nil
+	})
+	opt.RetryDelays = []time.Duration{time.Millisecond, 20 * time.Millisecond}
+	// a write to the second tier fails once, so that batch is read again
+	b.FailNextWrite(kafkaservice.RetryTopic(opt, 1))
+	kafkatest.Start(t, opt, testTopic, kafkaservice.RetryTopic(opt, 0), kafkaservice.RetryTopic(opt, 1))
+
+	sent := time.Now()
+	produceKeys(t, b, "a")
+	kafkatest.WaitFor(t, "dead letter", func() bool { return len(b.Messages(testDlq)) == 1 })
+
+	if got := s.count("a"); got != 4 {
+		// the first try, twice from the first tier, then once from the second
+		t.Errorf("processed %d times, want 4", got)
+	}
+	if waited := time.Since(sent); waited < 20*time.Millisecond {
+		t.Errorf("dead lettered after %s, before the retry delay", waited)
+	}
+	tier := b.Messages(kafkaservice.RetryTopic(opt, 0))
+	if len(tier) != 1 {
+		t.Fatalf("%d messages in the first tier, want 1", len(tier))
+	}
+	if got := kafkatest.Header(tier[0], kafkaservice.HeaderRetryAttempt); got != "1" {
+		t.Errorf("first tier attempt %q, want 1", got)
+	}
+	if notBefore, _ := strconv.ParseInt(kafkatest.Header(tier[0], kafkaservice.HeaderRetryNotBefore), 10, 64); notBefore == 0 {
+		t.Error("first tier is missing its not before")
+	}
+	dead := b.Messages(testDlq)[0]
+	if got := kafkatest.Header(dead, deadletter.HeaderError); got != "quota" {
+		t.Errorf("dead letter error %q, want quota", got)
+	}
+	if got := kafkatest.Header(dead, kafkaservice.HeaderRetryAttempt); got != "" {
+		t.Errorf("dead letter kept its retry attempt %q", got)
+	}
+	for _, topic := range []string{testTopic, kafkaservice.RetryTopic(opt, 0), kafkaservice.RetryTopic(opt, 1)} {
+		if got, want := b.CommittedOffset(topic, kafkaservice.ReaderGroup(opt, topic)), int64(len(b.Messages(topic))); got != want {
+			t.Errorf("%s committed %d, want %d", topic, got, want)
+		}
+	}
+}
+
+func TestRetryTopicNames(t *testing.T) {
+	opt := kafkaservice.KafkaService{Name: "gemini", RetryDelays: []time.Duration{time.Minute, 10 * time.Minute, time.Hour, 90 * time.Second, 1500 * time.Millisecond}}
+	want := []string{"gemini_retry_1m", "gemini_retry_10m", "gemini_retry_1h", "gemini_retry_90s", "gemini_retry_1500ms"}
+	for tier, name := range want {
+		if got := kafkaservice.RetryTopic(opt, tier); got != name {
+			t.Errorf("tier %d is %q, want %q", tier, got, name)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// an in-memory stand-in for kafka, to test services built on kafkaservice
+package kafkatest
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/services/kafkaservice"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// what a write fails with after FailNextWrite
+var ErrInjected = errors.New("injected failure")
+
+// Readers start from their group's committed offset, so closing one without committing
+// reads the messages again, like a consumer group does
+type Broker struct {
+	mu        sync.Mutex
+	topics    map[string][]kafka.Message
+	committed map[string]int64
+	// writes to the topic fail this many more times
+	failWrites map[string]int
+	// readers opened, per topic
+	opened map[string]int
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		topics:     make(map[string][]kafka.Message),
+		committed:  make(map[string]int64),
+		failWrites: make(map[string]int),
+		opened:     make(map[string]int),
+	}
+}
+
+// swaps the kafka clients kafkaservice uses for this broker's, until the test ends
+func (b *Broker) Install(t *testing.T) {
+	prev := kafkaservice.SwapClients(kafkaservice.Clients{
+		Reader: func(topic string, group string) kafkaservice.Reader {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.opened[topic]++
+			return &reader{broker: b, topic: topic, group: group, next: b.committed[topic+"/"+group]}
+		},
+		Writer: func(topic string) kafkaservice.Writer {
+			return b.Writer(topic)
+		},
+		DeadWriter: func(topic string, source string) kafkaservice.DeadWriter {
+			return &deadWriter{writer{broker: b, topic: topic}}
+		},
+		RedeliverDelay: time.Millisecond,
+	})
+	t.Cleanup(func() {
+		kafkaservice.SwapClients(prev)
+	})
+}
+
+// a writer to the topic, eg. for the topic a service passes its results on to
+func (b *Broker) Writer(topic string) kafkaservice.Writer {
+	return &writer{broker: b, topic: topic}
+}
+
+func (b *Broker) Produce(topic string, msgs ...kafka.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failWrites[topic] > 0 {
+		b.failWrites[topic]--
+		return ErrInjected
+	}
+	for _, msg := range msgs {
+		msg.Topic = topic
+		msg.Offset = int64(len(b.topics[topic]))
+		b.topics[topic] = append(b.topics[topic], msg)
+	}
+	return nil
+}
+
+func (b *Broker) FailNextWrite(topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failWrites[topic]++
+}
+
+func (b *Broker) Messages(topic string) []kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]kafka.Message(nil), b.topics[topic]...)
+}
+
+func (b *Broker) CommittedOffset(topic string, group string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.committed[topic+"/"+group]
+}
+
+func (b *Broker) ReadersOpened(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opened[topic]
+}
+
+// runs the service's loop for each topic until the test ends
+func Start(t *testing.T, opt kafkaservice.KafkaService, topics ...string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	for _, topic := range topics {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kafkaservice.RunTopic(ctx, opt, topic)
+		}()
+	}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func WaitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// the value of the message's header, or empty
+func Header(msg kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+type reader struct {
+	broker *Broker
+	topic  string
+	group  string
+	next   int64
+	closed bool
+}
+
+func (r *reader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		r.broker.mu.Lock()
+		if r.closed {
+			r.broker.mu.Unlock()
+			return kafka.Message{}, io.EOF
+		}
+		if msgs := r.broker.topics[r.topic]; r.next < int64(len(msgs)) {
+			msg := msgs[r.next]
+			r.next++
+			r.broker.mu.Unlock()
+			return msg, nil
+		}
+		r.broker.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func (r *reader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+	key := r.topic + "/" + r.group
+	for _, msg := range msgs {
+		if msg.Offset+1 > r.broker.committed[key] {
+			r.broker.committed[key] = msg.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (r *reader) Close() error {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+type writer struct {
+	broker *Broker
+	topic  string
+}
+
+func (w *writer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return w.broker.Produce(w.topic, msgs...)
+}
+
+func (w *writer) Close() error {
+	return nil
+}
+
+type deadWriter struct {
+	writer
+}
+
+func (w *deadWriter) Write(ctx context.Context, msgs ...kafka.Message) error {
+	return w.WriteMessages(ctx, msgs...)
+}
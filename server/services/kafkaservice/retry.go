@@ -0,0 +1,95 @@
+package kafkaservice
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// set on messages waiting in a retry topic
+const (
+	// retries so far
+	HeaderRetryAttempt = "retry-attempt"
+	// why the worker asked to retry it
+	HeaderRetryReason = "retry-reason"
+	// unix millis. Not handed to the worker before then
+	HeaderRetryNotBefore = "retry-not-before"
+)
+
+// the message, marked with why it should be retried. Workers return these as retry
+func RetryLater(msg kafka.Message, err error) kafka.Message {
+	reason := "unknown"
+	if err != nil {
+		reason = err.Error()
+	}
+	msg.Headers = setHeader(msg.Headers, HeaderRetryReason, reason)
+	return msg
+}
+
+// retries so far. 0 the first time it's handed to the worker
+func retryAttempt(msg kafka.Message) int {
+	attempt, _ := strconv.Atoi(header(msg.Headers, HeaderRetryAttempt))
+	return attempt
+}
+
+func retryReason(msg kafka.Message) string {
+	if reason := header(msg.Headers, HeaderRetryReason); reason != "" {
+		return reason
+	}
+	return "out of retries"
+}
+
+func retryNotBefore(msg kafka.Message) time.Time {
+	millis, err := strconv.ParseInt(header(msg.Headers, HeaderRetryNotBefore), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// the message for the tier's retry topic, due after delay
+func scheduleRetry(msg kafka.Message, tier int, delay time.Duration) kafka.Message {
+	headers := setHeader(msg.Headers, HeaderRetryAttempt, strconv.Itoa(tier+1))
+	headers = setHeader(headers, HeaderRetryNotBefore, strconv.FormatInt(time.Now().Add(delay).UnixMilli(), 10))
+	// overwrite topic and other metadata
+	return kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}
+}
+
+// the message without its retry headers
+func clearRetry(msg kafka.Message) kafka.Message {
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case HeaderRetryAttempt, HeaderRetryReason, HeaderRetryNotBefore:
+		default:
+			headers = append(headers, h)
+		}
+	}
+	msg.Headers = headers
+	return msg
+}
+
+func header(headers []kafka.Header, key string) string {
+	for _, h := range headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// replaces the header if it's already there. Copies, so the caller's message isn't changed
+func setHeader(headers []kafka.Header, key string, value string) []kafka.Header {
+	res := make([]kafka.Header, 0, len(headers)+1)
+	for _, h := range headers {
+		if h.Key != key {
+			res = append(res, h)
+		}
+	}
+	return append(res, kafka.Header{Key: key, Value: []byte(value)})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/phishing"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// contacts and the people we email change slowly, so they're cached for a bit
+	knownTtl = 10 * time.Minute
+	// how many times to wait for a body that isn't stored yet
+	bodyAttempts = 5
+	bodyWait     = time.Second
+)
+
+func main() {
+	log.Info().
+		Msg("Starting up phishingAnalyzer")
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "phishingAnalyzer"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType",
+				Value: bson.D{{
+					Key: "$in", Value: bson.A{"insert", "update", "replace"}},
+				}},
+		}}},
+	}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup)
+	stream, err := globals.DocDb().Collection("Messages").Watch(ctx, pipeline, opts)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to start change stream")
+		return
+	}
+	defer stream.Close(ctx)
+
+	known := make(map[string]*phishing.Known)
+	knownLoadedAt := time.Now()
+
+	for stream.Next(ctx) {
+		var ev bson.M
+		if err := stream.Decode(&ev); err != nil {
+			log.Error().Ctx(ctx).Err(err).Msg("failed to decode change event")
+			continue
+		}
+		var entry data.GmailEntry
+		raw, _ := bson.Marshal(ev["fullDocument"])
+		if err := bson.Unmarshal(raw, &entry); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Msg("failed to unmarshal email in stream")
+			continue
+		}
+		// the sender and body don't change, so once is enough
+		if entry.Phishing != nil || entry.IsDeleted || entry.AccountId == "" {
+			continue
+		}
+		if slices.Contains(entry.Labels, "SENT") || slices.Contains(entry.Labels, "DRAFT") {
+			continue
+		}
+		if time.Since(knownLoadedAt) > knownTtl {
+			known = make(map[string]*phishing.Known)
+			knownLoadedAt = time.Now()
+		}
+		accountKnown, ok := known[entry.AccountId]
+		if !ok {
+			accountKnown, err = phishing.LoadKnown(ctx, entry.AccountId)
+			if err != nil {
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("accountId", entry.AccountId).
+					Msg("failed to load known contacts")
+				continue
+			}
+			known[entry.AccountId] = accountKnown
+		}
+		html, err := fetchHtml(ctx, entry)
+		if err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("docId", entry.ToDocumentId()).
+				Msg("failed to fetch message body")
+			continue
+		}
+		risk := phishing.Analyze(entry, html, *accountKnown)
+		if err := phishing.Save(ctx, entry, risk); err != nil {
+			log.Error().
+				Ctx(ctx).
+				Err(err).
+				Str("docId", entry.ToDocumentId()).
+				Msg("failed to save phishing risk")
+		}
+	}
+
+	if err := stream.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal().Stack().Err(err).Msg("ChangeStream closed")
+	}
+	log.Info().Msg("Exiting")
+}
+
+// the html body. Empty if the message has none.
+// Bodies are written separately from messages, so it waits a bit for one that isn't stored yet
+func fetchHtml(ctx context.Context, entry data.GmailEntry) (string, error) {
+	for attempt := 0; ; attempt++ {
+		var body data.GmailEntryBody
+		err := globals.DocDb().Collection("MessageBodies").FindOne(
+			ctx,
+			bson.M{"_id": entry.ToDocumentId()},
+			options.FindOne().SetProjection(bson.M{"html": 1}),
+		).Decode(&body)
+		if err == nil {
+			return body.Html, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return "", err
+		}
+		if attempt >= bodyAttempts {
+			return "", nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(bodyWait):
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/savedsearches"
+	"fromkeith/my-desktop-server/utils"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// how many message changes to gather before recounting
+	batchSize = 200
+	// how long to wait for a batch to fill. Also debounces busy accounts.
+	batchWait = 5 * time.Second
+	// searches with "within N days" drift as time passes
+	relativeInterval = time.Hour
+)
+
+func main() {
+	log.Info().
+		Msg("Starting up savedSearchCounts")
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "savedSearchCounts"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType",
+				Value: bson.D{{
+					Key: "$in", Value: bson.A{"insert", "update", "replace", "delete"}},
+				}},
+		}}},
+		// we only need to know which account changed
+		{{Key: "$project", Value: bson.D{
+			{Key: "operationType", Value: 1},
+			{Key: "documentKey", Value: 1},
+		}}},
+	}
+	stream, err := globals.DocDb().Collection("Messages").Watch(ctx, pipeline, options.ChangeStream())
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to start change stream")
+		return
+	}
+	defer stream.Close(ctx)
+
+	batchChan, batchErr := utils.BatchMongoStreamChannel(ctx, stream, batchSize, batchWait)
+	relativeTicker := time.NewTicker(relativeInterval)
+	defer relativeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Exiting")
+			return
+		case err := <-batchErr:
+			if err != nil {
+				log.Fatal().Stack().Err(err).Msg("ChangeStream closed")
+			}
+			log.Info().Msg("Exiting")
+			return
+		case batch := <-batchChan:
+			for _, accountId := range accountsInBatch(batch) {
+				if err := savedsearches.RecountAccount(ctx, accountId); err != nil {
+					log.Error().
+						Ctx(ctx).
+						Err(err).
+						Str("accountId", accountId).
+						Msg("failed to recount saved searches")
+				}
+			}
+		case <-relativeTicker.C:
+			if err := savedsearches.RecountRelative(ctx); err != nil {
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Msg("failed to recount relative saved searches")
+			}
+		}
+	}
+}
+
+// the message id is "accountId;messageId", so we can get the account even for deletes
+func accountsInBatch(batch []bson.M) []string {
+	seen := make(map[string]bool)
+	accounts := make([]string, 0)
+	for _, ev := range batch {
+		key, ok := ev["documentKey"].(bson.M)
+		if !ok {
+			continue
+		}
+		id, ok := key["_id"].(string)
+		if !ok {
+			continue
+		}
+		accountId, _, found := strings.Cut(id, ";")
+		if !found || seen[accountId] {
+			continue
+		}
+		seen[accountId] = true
+		accounts = append(accounts, accountId)
+	}
+	return accounts
+}
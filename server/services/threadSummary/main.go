@@ -0,0 +1,397 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"fromkeith/my-desktop-server/attachments"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/quoted"
+	"fromkeith/my-desktop-server/threads"
+	"fromkeith/my-desktop-server/usage"
+	"fromkeith/my-desktop-server/utils"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	// a single message already has its own summary
+	minMessages = 2
+	// only the most recent messages are sent to the model
+	maxMessages = 20
+	// per message, so a long email doesn't crowd out the rest of the thread
+	maxBodyLength = 4000
+	// how often we check for threads that are done debouncing
+	checkInterval = 5 * time.Second
+	// a failed summary is tried again after this, doubling each time
+	retryBaseDelay = time.Minute
+	// then we give up until the thread changes again
+	maxAttempts = 5
+	// where the change stream's resume token is kept
+	serviceName = "threadSummary"
+)
+
+// a thread waiting to be summarized. Kept with the resume token, so a restart picks it up again
+type pendingThread struct {
+	Id       string    `bson:"id"`
+	At       time.Time `bson:"at"`
+	Failures int       `bson:"failures,omitempty"`
+}
+
+type resumeState struct {
+	Token   bson.Raw        `bson:"token"`
+	Pending []pendingThread `bson:"pending"`
+}
+
+type summaryResult struct {
+	Summary       string
+	OpenQuestions []string
+	Todos         []string
+}
+
+func main() {
+	log.Info().
+		Msg("Starting up threadSummary")
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "threadSummary"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	// wait for a burst of messages (eg. a quick back and forth) to settle before summarizing
+	debounce, err := time.ParseDuration(os.Getenv("THREAD_SUMMARY_DEBOUNCE"))
+	if err != nil || debounce <= 0 {
+		debounce = 2 * time.Minute
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{
+			{Key: "operationType",
+				Value: bson.D{{
+					Key: "$in", Value: bson.A{"insert", "update", "replace"}},
+				}},
+		}}},
+	}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup)
+	// pick up the threads that changed while we were down, and those that were still pending
+	state, err := loadResumeState(ctx)
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to load resume token")
+		return
+	}
+	lastToken := state.Token
+	if lastToken != nil {
+		opts.SetStartAfter(lastToken)
+	}
+	stream, err := globals.DocDb().Collection("MessageThreads").Watch(ctx, pipeline, opts)
+	if err != nil && lastToken != nil {
+		// eg. the oplog has moved past it
+		log.Warn().
+			Err(err).
+			Msg("Failed to resume change stream. Starting from now")
+		opts.SetStartAfter(nil)
+		lastToken = nil
+		stream, err = globals.DocDb().Collection("MessageThreads").Watch(ctx, pipeline, opts)
+	}
+	if err != nil {
+		log.Fatal().Stack().Err(err).Msg("Failed to start change stream")
+		return
+	}
+	defer stream.Close(ctx)
+
+	batchChan, batchErr := utils.BatchMongoStreamChannel(ctx, stream, 100, time.Second)
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	// thread doc id -> when to summarize it
+	pending := make(map[string]time.Time)
+	// thread doc id -> times summarizing it failed
+	failures := make(map[string]int)
+	for _, p := range state.Pending {
+		pending[p.Id] = p.At
+		if p.Failures > 0 {
+			failures[p.Id] = p.Failures
+		}
+	}
+	// the token or pending changed since they were saved. They're saved together,
+	// so a restart neither skips the threads still pending nor reads their changes again
+	dirty := false
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Exiting")
+			return
+		case err := <-batchErr:
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Fatal().Stack().Err(err).Msg("ChangeStream closed")
+			}
+			log.Info().Msg("Exiting")
+			return
+		case batch := <-batchChan:
+			for _, ev := range batch {
+				if raw, err := bson.Marshal(ev["_id"]); err == nil {
+					lastToken = raw
+					dirty = true
+				}
+				var thread threads.ThreadEntry
+				raw, _ := bson.Marshal(ev["fullDocument"])
+				if err := bson.Unmarshal(raw, &thread); err != nil {
+					log.Error().
+						Ctx(ctx).
+						Err(err).
+						Msg("failed to unmarshal thread in stream")
+					continue
+				}
+				// our own writes land here too, but won't need a summary
+				if !thread.NeedsSummary(minMessages) {
+					continue
+				}
+				// each new change pushes the summary back
+				id := data.ToDocumentId(thread.AccountId, thread.ThreadId)
+				pending[id] = time.Now().Add(debounce)
+				delete(failures, id)
+			}
+		case <-ticker.C:
+			now := time.Now()
+			for id, at := range pending {
+				if at.After(now) {
+					continue
+				}
+				delete(pending, id)
+				dirty = true
+				err := summarizeThread(ctx, id)
+				if err == nil {
+					delete(failures, id)
+					continue
+				}
+				failures[id]++
+				if failures[id] >= maxAttempts {
+					log.Error().
+						Ctx(ctx).
+						Err(err).
+						Str("threadId", id).
+						Int("attempts", failures[id]).
+						Msg("failed to summarize thread. Giving up until it changes")
+					delete(failures, id)
+					continue
+				}
+				// eg. out of quota. Try again later
+				retryIn := retryBaseDelay << (failures[id] - 1)
+				pending[id] = now.Add(retryIn)
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("threadId", id).
+					Dur("retryIn", retryIn).
+					Msg("failed to summarize thread")
+			}
+			if dirty {
+				if err := saveResumeState(ctx, lastToken, pending, failures); err != nil {
+					log.Error().
+						Ctx(ctx).
+						Err(err).
+						Msg("failed to save resume token")
+				} else {
+					dirty = false
+				}
+			}
+		}
+	}
+}
+
+func loadResumeState(ctx context.Context) (resumeState, error) {
+	var state resumeState
+	err := globals.DocDb().Collection("ResumeTokens").FindOne(ctx, bson.M{"_id": serviceName}).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return state, nil
+	}
+	return state, err
+}
+
+// the token is left as is if there isn't one yet, eg. nothing has changed since we started from now
+func saveResumeState(ctx context.Context, token bson.Raw, pending map[string]time.Time, failures map[string]int) error {
+	threads := make([]pendingThread, 0, len(pending))
+	for id, at := range pending {
+		threads = append(threads, pendingThread{Id: id, At: at, Failures: failures[id]})
+	}
+	set := bson.M{"pending": threads}
+	if token != nil {
+		set["token"] = token
+	}
+	_, err := globals.DocDb().Collection("ResumeTokens").UpdateOne(
+		ctx,
+		bson.M{"_id": serviceName},
+		bson.M{
+			"$set":         set,
+			"$currentDate": bson.M{"updatedAt": true},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+func summarizeThread(ctx context.Context, id string) error {
+	col := globals.DocDb().Collection("MessageThreads")
+	var thread threads.ThreadEntry
+	if err := col.FindOne(ctx, bson.M{"_id": id}).Decode(&thread); err != nil {
+		return err
+	}
+	// re-check, it may have been summarized by another instance
+	if !thread.NeedsSummary(minMessages) {
+		return nil
+	}
+	log.Info().
+		Ctx(ctx).
+		Str("threadId", id).
+		Int("numMessages", len(thread.Messages)).
+		Msg("summarizing thread")
+
+	messages := slices.Clone(thread.Messages)
+	slices.SortFunc(messages, func(a, b threads.MessageBasic) int {
+		return cmp.Compare(a.InternalDate, b.InternalDate)
+	})
+	if len(messages) > maxMessages {
+		messages = messages[len(messages)-maxMessages:]
+	}
+	prompt, err := buildPrompt(ctx, thread.AccountId, messages)
+	if err != nil {
+		return err
+	}
+	result, err := globals.LLM().GenerateJSON(ctx, llm.JSONRequest{
+		System: summaryInstructions,
+		Prompt: prompt,
+		Schema: summarySchema,
+	})
+	if err != nil {
+		return err
+	}
+	if err := usage.Save(ctx, usage.Generated(globals.LLM(), thread.AccountId, "", usage.OperationThreadSummary, result.Usage)); err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Str("docId", id).
+			Msg("failed to save usage")
+	}
+	var res summaryResult
+	if err := json.Unmarshal([]byte(result.Text), &res); err != nil {
+		return err
+	}
+	if res.OpenQuestions == nil {
+		res.OpenQuestions = make([]string, 0)
+	}
+	if res.Todos == nil {
+		res.Todos = make([]string, 0)
+	}
+	summary := threads.ThreadSummary{
+		Summary:       strings.TrimSpace(res.Summary),
+		OpenQuestions: res.OpenQuestions,
+		Todos:         res.Todos,
+		MessageCount:  len(thread.Messages),
+		LastMessageId: thread.LatestMessage().MessageId,
+		GeneratedAt:   time.Now().UTC(),
+	}
+	// bump updatedAt so clients pull it
+	_, err = col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":         bson.M{"aiSummary": summary},
+		"$currentDate": bson.M{"updatedAt": true},
+	})
+	return err
+}
+
+// the conversation, oldest first
+func buildPrompt(ctx context.Context, accountId string, messages []threads.MessageBasic) (string, error) {
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, data.ToDocumentId(accountId, m.MessageId))
+	}
+	cur, err := globals.DocDb().Collection("MessageBodies").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return "", err
+	}
+	defer cur.Close(ctx)
+	var bodies []data.GmailEntryBody
+	if err := cur.All(ctx, &bodies); err != nil {
+		return "", err
+	}
+	bodyById := make(map[string]string, len(bodies))
+	// every message is included, so the quoted replies would only repeat them
+	for _, b := range bodies {
+		if b.NewContent != "" {
+			bodyById[b.MessageId] = b.NewContent
+		} else {
+			bodyById[b.MessageId] = quoted.StripText(b.PlainText)
+		}
+	}
+
+	var sb strings.Builder
+	for i, m := range messages {
+		body := bodyById[m.MessageId]
+		if body == "" {
+			body = m.Snippet // html only, or not injested yet
+		}
+		body = attachments.Truncate(body, maxBodyLength)
+		if i > 0 {
+			sb.WriteString("\n---\n")
+		}
+		fmt.Fprintf(&sb, "From: %s <%s>\nDate: %s\nSubject: %s\n\n%s\n",
+			m.Sender.Name,
+			m.Sender.Email,
+			time.UnixMilli(m.InternalDate).UTC().Format(time.RFC1123),
+			m.Subject,
+			body,
+		)
+	}
+	return sb.String(), nil
+}
+
+var summarySchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"Summary": map[string]any{
+			"type":        "string",
+			"description": "2-5 line summary of the whole conversation, including where it ended up",
+		},
+		"OpenQuestions": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":        "string",
+				"description": "A question asked in the conversation that has not been answered",
+			},
+		},
+		"Todos": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":        "string",
+				"description": "An action item that is still outstanding",
+			},
+			"description": "Can be an empty array if nothing is outstanding.",
+		},
+	},
+	"required": []string{"Summary", "OpenQuestions", "Todos"},
+}
+
+const summaryInstructions = `You summarize email conversations. The messages are given oldest first.
+Summarize the conversation as a whole, list the questions that are still unanswered, and the action items that are still outstanding.
+Ignore items that were resolved by a later message.
+Return as JSON (Summary, OpenQuestions, Todos)`
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// spherical k-means. The embeddings are compared with cosine similarity,
+// so everything is normalized up front and the dot product is used.
+
+type clusterResult struct {
+	centroids [][]float32
+	// index into centroids for each input vector
+	assignments []int
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if sum == 0 {
+		return out
+	}
+	norm := float32(math.Sqrt(sum))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// picks k based on the number of items. roughly sqrt(n/2)
+func chooseK(n int) int {
+	k := int(math.Round(math.Sqrt(float64(n) / 2)))
+	return max(2, min(k, maxTopics))
+}
+
+// kmeans++ seeding, followed by lloyd iterations until the assignments settle
+func kmeans(vectors [][]float32, k int, maxIterations int) clusterResult {
+	n := len(vectors)
+	if n == 0 {
+		return clusterResult{}
+	}
+	k = min(k, n)
+	rnd := rand.New(rand.NewPCG(uint64(n), uint64(k)))
+
+	centroids := make([][]float32, 0, k)
+	centroids = append(centroids, vectors[rnd.IntN(n)])
+	distances := make([]float64, n)
+	for len(centroids) < k {
+		var total float64
+		for i, v := range vectors {
+			best := math.MaxFloat64
+			for _, c := range centroids {
+				best = min(best, 1-float64(dot(v, c)))
+			}
+			// squared distance, so far away points are more likely
+			distances[i] = best * best
+			total += distances[i]
+		}
+		if total == 0 {
+			break // everything is identical
+		}
+		target := rnd.Float64() * total
+		next := n - 1
+		for i, d := range distances {
+			target -= d
+			if target <= 0 {
+				next = i
+				break
+			}
+		}
+		centroids = append(centroids, vectors[next])
+	}
+	k = len(centroids)
+
+	assignments := make([]int, n)
+	for i := range assignments {
+		assignments[i] = -1
+	}
+	dims := len(vectors[0])
+	for range maxIterations {
+		changed := false
+		for i, v := range vectors {
+			best := 0
+			bestSim := float32(-2)
+			for c, centroid := range centroids {
+				if sim := dot(v, centroid); sim > bestSim {
+					best = c
+					bestSim = sim
+				}
+			}
+			if assignments[i] != best {
+				assignments[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		// recompute the centroids as the normalized mean
+		sums := make([][]float32, k)
+		counts := make([]int, k)
+		for c := range sums {
+			sums[c] = make([]float32, dims)
+		}
+		for i, v := range vectors {
+			c := assignments[i]
+			counts[c]++
+			for d, x := range v {
+				sums[c][d] += x
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue // keep the old centroid for empty clusters
+			}
+			centroids[c] = normalize(sums[c])
+		}
+	}
+	return clusterResult{
+		centroids:   centroids,
+		assignments: assignments,
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/usage"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	maxTopics = 40
+	// don't bother clustering tiny mailboxes
+	minMessages = 20
+	// only cluster the most recent messages. Older ones have their topic cleared
+	maxMessages = 5000
+	// how similar a new centroid must be to an old one to keep its topic id
+	matchThreshold = 0.85
+	// summaries used to label a topic
+	samplesPerTopic = 8
+	// max writes per bulk write
+	writeBatchSize = 500
+)
+
+// embeddings of any other size can't be compared, see LLM_EMBED_DIMENSIONS
+var embedDimensions = llm.ConfigFromEnv().EmbedDimensions
+
+type summaryDoc struct {
+	MessageId    string    `bson:"messageId"`
+	Embedding    []float32 `bson:"embedding"`
+	Summary      string    `bson:"summary"`
+	TopicId      string    `bson:"topicId"`
+	InternalDate int64     `bson:"internalDate"`
+}
+
+type topicLabel struct {
+	Label       string
+	Description string
+}
+
+func main() {
+	log.Info().
+		Msg("Starting up topicClusters")
+	globals.SetupJsonEncoding()
+	defer globals.CloseAll()
+
+	ctx, stop := signal.NotifyContext(
+		context.WithValue(context.Background(), "service", "topicClusters"),
+		syscall.SIGTERM,
+		syscall.SIGINT,
+		syscall.SIGQUIT,
+	)
+	defer stop()
+
+	interval, err := time.ParseDuration(os.Getenv("TOPICS_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 6 * time.Hour
+	}
+
+	for {
+		clusterAll(ctx)
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Exiting")
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func clusterAll(ctx context.Context) {
+	var accountIds []string
+	err := globals.DocDb().Collection("MessageSummaries").
+		Distinct(ctx, "accountId", bson.M{}).
+		Decode(&accountIds)
+	if err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Msg("failed to list accounts to cluster")
+		return
+	}
+	for _, accountId := range accountIds {
+		if ctx.Err() != nil {
+			return
+		}
+		accountCtx := context.WithValue(ctx, "accountId", accountId)
+		if err := clusterAccount(accountCtx, accountId); err != nil {
+			log.Error().
+				Ctx(accountCtx).
+				Stack().
+				Err(err).
+				Msg("failed to cluster account")
+		}
+	}
+}
+
+func clusterAccount(ctx context.Context, accountId string) error {
+	db := globals.DocDb()
+	cur, err := db.Collection("MessageSummaries").Find(
+		ctx,
+		bson.M{"accountId": accountId},
+		options.Find().
+			SetSort(bson.D{{Key: "internalDate", Value: -1}}).
+			SetLimit(maxMessages).
+			SetProjection(bson.M{"messageId": 1, "embedding": 1, "summary": 1, "topicId": 1, "internalDate": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	var summaries []summaryDoc
+	if err := cur.All(ctx, &summaries); err != nil {
+		return err
+	}
+	// the window is what was read, even if some of it can't be clustered
+	windowFull := len(summaries) == maxMessages
+	var windowEnd int64
+	if len(summaries) > 0 {
+		windowEnd = summaries[len(summaries)-1].InternalDate
+	}
+	// eg. embedded before LLM_PROVIDER changed. They'd crash k-means
+	read := len(summaries)
+	summaries = slices.DeleteFunc(summaries, func(s summaryDoc) bool {
+		return len(s.Embedding) != int(embedDimensions)
+	})
+	if skipped := read - len(summaries); skipped > 0 {
+		log.Warn().
+			Ctx(ctx).
+			Int("skipped", skipped).
+			Int32("dimensions", embedDimensions).
+			Msg("skipped summaries with embeddings of another size")
+	}
+	if len(summaries) < minMessages {
+		log.Info().
+			Ctx(ctx).
+			Int("count", len(summaries)).
+			Msg("not enough messages to cluster")
+		return nil
+	}
+
+	vectors := make([][]float32, len(summaries))
+	for i, s := range summaries {
+		vectors[i] = normalize(s.Embedding)
+	}
+	result := kmeans(vectors, chooseK(len(vectors)), 50)
+
+	topicCur, err := db.Collection("Topics").Find(ctx, bson.M{"accountId": accountId, "isDeleted": false})
+	if err != nil {
+		return err
+	}
+	defer topicCur.Close(ctx)
+	var previous []data.Topic
+	if err := topicCur.All(ctx, &previous); err != nil {
+		return err
+	}
+	matches := matchTopics(result.centroids, previous)
+
+	members := make([][]int, len(result.centroids))
+	for i, c := range result.assignments {
+		members[c] = append(members[c], i)
+	}
+
+	topics := make([]data.Topic, 0, len(result.centroids))
+	keep := make(map[string]bool, len(result.centroids))
+	assigned := make(map[string]string, len(summaries))
+	for c, centroid := range result.centroids {
+		if len(members[c]) == 0 {
+			continue
+		}
+		// the samples are the members closest to the centroid
+		samples := slices.Clone(members[c])
+		slices.SortFunc(samples, func(a, b int) int {
+			da, db := dot(vectors[a], centroid), dot(vectors[b], centroid)
+			if da > db {
+				return -1
+			} else if da < db {
+				return 1
+			}
+			return 0
+		})
+		samples = samples[:min(len(samples), samplesPerTopic)]
+
+		topic := data.Topic{
+			AccountId:        accountId,
+			TopicId:          "topic_" + uuid.New().String(),
+			Centroid:         centroid,
+			MessageCount:     int64(len(members[c])),
+			SampleMessageIds: make([]string, 0, len(samples)),
+		}
+		if prev := matches[c]; prev != nil {
+			topic.TopicId = prev.TopicId
+			topic.Label = prev.Label
+			topic.Description = prev.Description
+		}
+		sampleText := make([]string, 0, len(samples))
+		for _, i := range samples {
+			topic.SampleMessageIds = append(topic.SampleMessageIds, summaries[i].MessageId)
+			sampleText = append(sampleText, summaries[i].Summary)
+		}
+		if topic.Label == "" {
+			label, err := labelTopic(ctx, accountId, sampleText)
+			if err != nil {
+				// leave it blank, and we will try again next run
+				log.Error().
+					Ctx(ctx).
+					Err(err).
+					Str("topicId", topic.TopicId).
+					Msg("failed to label topic")
+			} else {
+				topic.Label = label.Label
+				topic.Description = label.Description
+			}
+		}
+		keep[topic.TopicId] = true
+		for _, i := range members[c] {
+			assigned[summaries[i].MessageId] = topic.TopicId
+		}
+		topics = append(topics, topic)
+	}
+
+	if err := writeTopics(ctx, topics, previous, keep); err != nil {
+		return err
+	}
+	if err := assignTopics(ctx, accountId, summaries, assigned); err != nil {
+		return err
+	}
+	if windowFull {
+		// the oldest message read is the end of the window
+		if err := clearTopics(ctx, accountId, windowEnd); err != nil {
+			return err
+		}
+	}
+	log.Info().
+		Ctx(ctx).
+		Int("messages", len(summaries)).
+		Int("topics", len(topics)).
+		Msg("clustered account")
+	return nil
+}
+
+// pairs up new centroids with the previous topics so topic ids stay stable between runs.
+// returns the matched previous topic for each centroid, or nil if it is a new topic.
+func matchTopics(centroids [][]float32, previous []data.Topic) []*data.Topic {
+	type pair struct {
+		centroid int
+		previous int
+		sim      float32
+	}
+	pairs := make([]pair, 0, len(centroids)*len(previous))
+	for c, centroid := range centroids {
+		for p, prev := range previous {
+			if len(prev.Centroid) != len(centroid) {
+				continue
+			}
+			if sim := dot(centroid, prev.Centroid); sim >= matchThreshold {
+				pairs = append(pairs, pair{c, p, sim})
+			}
+		}
+	}
+	// greedy, best match first
+	slices.SortFunc(pairs, func(a, b pair) int {
+		if a.sim > b.sim {
+			return -1
+		} else if a.sim < b.sim {
+			return 1
+		}
+		return 0
+	})
+	matches := make([]*data.Topic, len(centroids))
+	used := make(map[int]bool, len(previous))
+	for _, p := range pairs {
+		if matches[p.centroid] != nil || used[p.previous] {
+			continue
+		}
+		matches[p.centroid] = &previous[p.previous]
+		used[p.previous] = true
+	}
+	return matches
+}
+
+var labelSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"Label": map[string]any{
+			"type":        "string",
+			"description": "A short label for the topic. 1-4 words.",
+		},
+		"Description": map[string]any{
+			"type":        "string",
+			"description": "1 line description of what the emails in this topic are about",
+		},
+	},
+	"required": []string{"Label", "Description"},
+}
+
+const labelInstructions = `You are given summaries of emails that were grouped together because they are similar.
+Name the topic they have in common. Prefer specific labels (eg. "Kids soccer league") over generic ones (eg. "Sports").
+Return as JSON (Label, Description)`
+
+func labelTopic(ctx context.Context, accountId string, summaries []string) (*topicLabel, error) {
+	result, err := globals.LLM().GenerateJSON(ctx, llm.JSONRequest{
+		System: labelInstructions,
+		Prompt: strings.Join(summaries, "\n---\n"),
+		Schema: labelSchema,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := usage.Save(ctx, usage.Generated(globals.LLM(), accountId, "", usage.OperationTopicLabel, result.Usage)); err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Str("accountId", accountId).
+			Msg("failed to save usage")
+	}
+	var label topicLabel
+	if err := json.Unmarshal([]byte(result.Text), &label); err != nil {
+		return nil, err
+	}
+	label.Label = strings.TrimSpace(label.Label)
+	label.Description = strings.TrimSpace(label.Description)
+	return &label, nil
+}
+
+func writeTopics(ctx context.Context, topics []data.Topic, previous []data.Topic, keep map[string]bool) error {
+	toWrite := make([]mongo.WriteModel, 0, len(topics)+len(previous))
+	for _, topic := range topics {
+		doc := bson.M{}
+		b, _ := bson.Marshal(topic)
+		_ = bson.Unmarshal(b, &doc)
+		delete(doc, "updatedAt")
+		delete(doc, "createdAt") // let $setOnInsert handle this
+		toWrite = append(toWrite, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": topic.ToDocumentId()}).
+			SetUpdate(bson.M{
+				"$set":         doc,
+				"$currentDate": bson.M{"updatedAt": true},
+				"$setOnInsert": bson.M{
+					"createdAt": time.Now(),
+				},
+			}).
+			SetUpsert(true),
+		)
+	}
+	// topics that no longer exist. Soft delete so clients see it go away
+	for _, prev := range previous {
+		if keep[prev.TopicId] {
+			continue
+		}
+		toWrite = append(toWrite, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": prev.ToDocumentId()}).
+			SetUpdate(bson.M{
+				"$set":         bson.M{"isDeleted": true, "messageCount": 0},
+				"$currentDate": bson.M{"updatedAt": true},
+			}).
+			SetUpsert(false),
+		)
+	}
+	if len(toWrite) == 0 {
+		return nil
+	}
+	_, err := globals.DocDb().Collection("Topics").BulkWrite(ctx, toWrite)
+	return err
+}
+
+func assignTopics(ctx context.Context, accountId string, summaries []summaryDoc, assigned map[string]string) error {
+	db := globals.DocDb()
+	messageWrites := make([]mongo.WriteModel, 0, writeBatchSize)
+	summaryWrites := make([]mongo.WriteModel, 0, writeBatchSize)
+	flush := func() error {
+		if len(messageWrites) == 0 {
+			return nil
+		}
+		if _, err := db.Collection("Messages").BulkWrite(ctx, messageWrites); err != nil {
+			return err
+		}
+		if _, err := db.Collection("MessageSummaries").BulkWrite(ctx, summaryWrites); err != nil {
+			return err
+		}
+		messageWrites = messageWrites[:0]
+		summaryWrites = summaryWrites[:0]
+		return nil
+	}
+	for _, s := range summaries {
+		topicId := assigned[s.MessageId]
+		if topicId == "" || topicId == s.TopicId {
+			continue // unchanged
+		}
+		docId := data.ToDocumentId(accountId, s.MessageId)
+		messageWrites = append(messageWrites, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": docId, "topicId": bson.M{"$ne": topicId}}).
+			SetUpdate(bson.M{
+				"$set":         bson.M{"topicId": topicId},
+				"$currentDate": bson.M{"updatedAt": true},
+				"$inc":         bson.M{"revisionCount": 1},
+			}).
+			SetUpsert(false),
+		)
+		summaryWrites = append(summaryWrites, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": docId}).
+			SetUpdate(bson.M{"$set": bson.M{"topicId": topicId}}).
+			SetUpsert(false),
+		)
+		if len(messageWrites) == writeBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// clears the topic of messages older than the window, as they weren't clustered
+func clearTopics(ctx context.Context, accountId string, before int64) error {
+	db := globals.DocDb()
+	filter := bson.M{
+		"accountId":    accountId,
+		"internalDate": bson.M{"$lt": before},
+		"topicId":      bson.M{"$exists": true},
+	}
+	_, err := db.Collection("Messages").UpdateMany(
+		ctx,
+		filter,
+		bson.M{
+			"$unset":       bson.M{"topicId": ""},
+			"$currentDate": bson.M{"updatedAt": true},
+			"$inc":         bson.M{"revisionCount": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("MessageSummaries").UpdateMany(
+		ctx,
+		filter,
+		bson.M{"$unset": bson.M{"topicId": ""}},
+	)
+	return err
+}
@@ -0,0 +1,197 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/redact"
+	"fromkeith/my-desktop-server/translation"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	maxToneLength      = 500
+	maxSignatureLength = 2000
+)
+
+// used until the account saves their own
+func Default(accountId string) data.AccountSettings {
+	return data.AccountSettings{
+		AccountId:      accountId,
+		ReplyTone:      "friendly, professional and concise",
+		RedactionLevel: string(redact.LevelStandard),
+		Language:       "en",
+		Digest: data.DigestSettings{
+			DeliveryTime: "07:00",
+			TimeZone:     "UTC",
+			Categories:   make([]string, 0),
+		},
+	}
+}
+
+// gets the account's settings, or the defaults if they haven't saved any
+func Get(ctx context.Context, accountId string) (*data.AccountSettings, error) {
+	settings := Default(accountId)
+	err := globals.DocDb().Collection("AccountSettings").FindOne(ctx, bson.M{"_id": settings.ToDocumentId()}).Decode(&settings)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if settings.Digest.Categories == nil {
+		settings.Digest.Categories = make([]string, 0)
+	}
+	return &settings, nil
+}
+
+// the account's redaction level. Falls back to strict, rather than sending something the account didn't want sent
+func RedactionLevel(ctx context.Context, accountId string) redact.Level {
+	settings, err := Get(ctx, accountId)
+	if err != nil {
+		log.Error().
+			Ctx(ctx).
+			Err(err).
+			Str("accountId", accountId).
+			Msg("failed to get settings, redacting strictly")
+		return redact.LevelStrict
+	}
+	level, err := redact.ParseLevel(settings.RedactionLevel)
+	if err != nil {
+		return redact.LevelStrict
+	}
+	return level
+}
+
+// checks and tidies the digest settings
+func normalizeDigest(digest *data.DigestSettings) error {
+	if _, err := time.Parse("15:04", digest.DeliveryTime); err != nil {
+		return errors.New("digest deliveryTime must be HH:MM")
+	}
+	if digest.TimeZone == "" {
+		digest.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(digest.TimeZone); err != nil {
+		return errors.New("digest timeZone is not a known time zone")
+	}
+	categories := make([]string, 0, len(digest.Categories))
+	for _, c := range digest.Categories {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			categories = append(categories, c)
+		}
+	}
+	digest.Categories = categories
+	return nil
+}
+
+type SaveSettingsRequest struct {
+	ReplyTone string `json:"replyTone"`
+	Signature string `json:"signature"`
+	// off, standard or strict. Defaults to standard
+	RedactionLevel string `json:"redactionLevel"`
+	// ISO 639-1 code, or a BCP 47 tag, messages are translated to. Defaults to en
+	Language string `json:"language"`
+	// left unchanged if missing
+	Digest *data.DigestSettings `json:"digest"`
+} // @name SaveSettingsRequest
+
+// GetSettings godoc
+// @Summary      Get account settings
+// @Tags         settings
+// @Produce      json
+// @Success      200  {object}  data.AccountSettings
+// @Router       /settings [get]
+func GetSettings(r *gin.Context) {
+	settings, err := Get(r, r.GetString("accountId"))
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to get settings")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+	r.JSON(http.StatusOK, settings)
+}
+
+// SaveSettings godoc
+// @Summary      Save account settings
+// @Tags         settings
+// @Accept       json
+// @Param        request body SaveSettingsRequest true "Settings"
+// @Produce      json
+// @Success      200  {object}  data.AccountSettings
+// @Router       /settings [put]
+func SaveSettings(r *gin.Context) {
+	var req SaveSettingsRequest
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ReplyTone = strings.TrimSpace(req.ReplyTone)
+	req.Signature = strings.TrimSpace(req.Signature)
+	if len(req.ReplyTone) > maxToneLength || len(req.Signature) > maxSignatureLength {
+		r.JSON(http.StatusBadRequest, gin.H{"error": "Setting is too long"})
+		return
+	}
+	level, err := redact.ParseLevel(req.RedactionLevel)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Language == "" {
+		req.Language = Default("").Language
+	}
+	lang, err := translation.NormalizeLanguage(req.Language)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	accountId := r.GetString("accountId")
+	set := bson.M{
+		"accountId":      accountId,
+		"replyTone":      req.ReplyTone,
+		"signature":      req.Signature,
+		"redactionLevel": string(level),
+		"language":       lang,
+	}
+	setOnInsert := bson.M{
+		"createdAt": time.Now().UTC(),
+	}
+	if req.Digest != nil {
+		if err := normalizeDigest(req.Digest); err != nil {
+			r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		set["digest"] = req.Digest
+	} else {
+		setOnInsert["digest"] = Default(accountId).Digest
+	}
+	res := globals.DocDb().Collection("AccountSettings").FindOneAndUpdate(
+		r,
+		bson.M{"_id": accountId},
+		bson.M{
+			"$set":         set,
+			"$currentDate": bson.M{"updatedAt": true},
+			"$setOnInsert": setOnInsert,
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	)
+	// older settings may not have every field
+	settings := Default(accountId)
+	if err := res.Decode(&settings); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to save settings")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings"})
+		return
+	}
+	r.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,195 @@
+package taxonomy
+
+import (
+	"errors"
+	"fromkeith/my-desktop-server/gmail/data"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type SaveTaxonomyRequest struct {
+	Categories []data.TaxonomyCategory `validate:"required" json:"categories"`
+	// the version this change was based on. 0 to overwrite regardless
+	Version int64 `json:"version"`
+} // @name SaveTaxonomyRequest
+
+func respondSaved(r *gin.Context, taxonomy *data.AccountTaxonomy, err error) {
+	if errors.Is(err, ErrVersionMismatch) {
+		r.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to save taxonomy")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save taxonomy"})
+		return
+	}
+	r.JSON(http.StatusOK, taxonomy)
+}
+
+// GetTaxonomy godoc
+// @Summary      Get the category taxonomy
+// @Description  The L1/L2 categories the AI uses to categorize this account's emails.
+// @Tags         taxonomy
+// @Produce      json
+// @Success      200  {object}  data.AccountTaxonomy
+// @Router       /taxonomy [get]
+func GetTaxonomy(r *gin.Context) {
+	taxonomy, err := Get(r, r.GetString("accountId"))
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to get taxonomy")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get taxonomy"})
+		return
+	}
+	r.JSON(http.StatusOK, taxonomy)
+}
+
+// SaveTaxonomy godoc
+// @Summary      Replace the category taxonomy
+// @Description  Replaces all the categories. New emails are categorized with it, existing emails are not changed.
+// @Tags         taxonomy
+// @Accept       json
+// @Param        request body SaveTaxonomyRequest true "Taxonomy"
+// @Produce      json
+// @Success      200  {object}  data.AccountTaxonomy
+// @Failure      409  "Version mismatch"
+// @Router       /taxonomy [put]
+func SaveTaxonomy(r *gin.Context) {
+	var req SaveTaxonomyRequest
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := Normalize(req.Categories); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	taxonomy, err := Save(r, r.GetString("accountId"), req.Categories, req.Version)
+	respondSaved(r, taxonomy, err)
+}
+
+// ResetTaxonomy godoc
+// @Summary      Reset the category taxonomy
+// @Description  Restores the default categories.
+// @Tags         taxonomy
+// @Produce      json
+// @Success      200  {object}  data.AccountTaxonomy
+// @Router       /taxonomy/reset [post]
+func ResetTaxonomy(r *gin.Context) {
+	taxonomy, err := Save(r, r.GetString("accountId"), Default(), 0)
+	respondSaved(r, taxonomy, err)
+}
+
+// AddCategory godoc
+// @Summary      Add an L1 category
+// @Tags         taxonomy
+// @Accept       json
+// @Param        request body data.TaxonomyCategory true "Category"
+// @Produce      json
+// @Success      200  {object}  data.AccountTaxonomy
+// @Failure      409  "Category exists, or version mismatch"
+// @Router       /taxonomy/categories [post]
+func AddCategory(r *gin.Context) {
+	var req data.TaxonomyCategory
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	current, err := Get(r, r.GetString("accountId"))
+	if err != nil {
+		respondSaved(r, nil, err)
+		return
+	}
+	if findCategory(current.Categories, req.Name) >= 0 {
+		r.JSON(http.StatusConflict, gin.H{"error": "Category already exists"})
+		return
+	}
+	categories := append(slices.Clone(current.Categories), req)
+	if _, err := Normalize(categories); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	taxonomy, err := Save(r, current.AccountId, categories, current.Version)
+	respondSaved(r, taxonomy, err)
+}
+
+// UpdateCategory godoc
+// @Summary      Update an L1 category
+// @Description  Renames the category and/or replaces its L2 sub categories.
+// @Tags         taxonomy
+// @Accept       json
+// @Param        name path string true "Current category name"
+// @Param        request body data.TaxonomyCategory true "Category"
+// @Produce      json
+// @Success      200  {object}  data.AccountTaxonomy
+// @Failure      404  "Category not found"
+// @Router       /taxonomy/categories/{name} [put]
+func UpdateCategory(r *gin.Context) {
+	var req data.TaxonomyCategory
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	current, err := Get(r, r.GetString("accountId"))
+	if err != nil {
+		respondSaved(r, nil, err)
+		return
+	}
+	i := findCategory(current.Categories, r.Param("name"))
+	if i < 0 {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	categories := slices.Clone(current.Categories)
+	categories[i] = req
+	if _, err := Normalize(categories); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	taxonomy, err := Save(r, current.AccountId, categories, current.Version)
+	respondSaved(r, taxonomy, err)
+}
+
+// DeleteCategory godoc
+// @Summary      Delete an L1 category
+// @Tags         taxonomy
+// @Param        name path string true "Category name"
+// @Produce      json
+// @Success      200  {object}  data.AccountTaxonomy
+// @Failure      404  "Category not found"
+// @Router       /taxonomy/categories/{name} [delete]
+func DeleteCategory(r *gin.Context) {
+	current, err := Get(r, r.GetString("accountId"))
+	if err != nil {
+		respondSaved(r, nil, err)
+		return
+	}
+	i := findCategory(current.Categories, r.Param("name"))
+	if i < 0 {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	categories := slices.Delete(slices.Clone(current.Categories), i, i+1)
+	if _, err := Normalize(categories); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	taxonomy, err := Save(r, current.AccountId, categories, current.Version)
+	respondSaved(r, taxonomy, err)
+}
+
+func findCategory(categories []data.TaxonomyCategory, name string) int {
+	name = strings.TrimSpace(name)
+	return slices.IndexFunc(categories, func(c data.TaxonomyCategory) bool {
+		return strings.EqualFold(c.Name, name)
+	})
+}
@@ -0,0 +1,304 @@
+package taxonomy
+
+// the taxonomy every account starts with. L1 is the heading, L2 is bullet under the heading
+const defaultCategories = `
+	# Accounts & Identity
+- Account setup
+- Profile updates
+- Login activity
+- Password reset
+- Identity verification
+- Account recovery
+- Access permission changes
+- Account closure
+
+# Security & Fraud
+- Security alerts
+- Suspicious activity
+- Fraud prevention
+- Device verification
+- Data breach notifications
+- Authentication issues
+- Privacy notices
+- Security policy updates
+
+# Billing & Payments
+- Payment confirmations
+- Receipts
+- Invoices
+- Billing errors
+- Payment failures
+- Refunds
+- Billing plan changes
+- Tax-related billing
+
+# Finance & Banking
+- Account balance updates
+- Transactions
+- Money transfers
+- Loan and credit updates
+- Investment statements
+- Market advisories
+- Financial reports
+- Tax documents
+
+# Shopping & Ecommerce
+- Order confirmations
+- Shipping updates
+- Delivery notices
+- Returns & refunds
+- Promotions
+- Loyalty rewards
+- Product availability
+- Online receipts
+
+# Travel & Transportation
+- Flight bookings
+- Hotel reservations
+- Itineraries
+- Schedule changes
+- Travel alerts
+- Transportation services
+- Travel insurance
+- Visa & entry documentation
+
+# Health & Medical
+- Appointment confirmations
+- Lab results
+- Medical billing
+- Health insurance claims
+- Pharmacy & prescriptions
+- Health reminders
+- Telehealth communication
+- Provider updates
+
+# Employment & Hiring
+- Job applications
+- Recruiting outreach
+- Interview scheduling
+- Offer letters
+- Hiring decisions
+- Career development
+- Background checks
+- Job search resources
+
+# Human Resources
+- Onboarding materials
+- Payroll notices
+- Benefits updates
+- HR policy updates
+- Performance evaluations
+- Workplace compliance
+- Time-off approvals
+- Employee communication
+
+# Internal Operations
+- Meeting invitations
+- Project updates
+- Scheduling
+- Operational alerts
+- Resource allocation
+- Organizational announcements
+- Workflow coordination
+- Team communication
+
+# Engineering & Technical
+- Deployments
+- Build notifications
+- Incident alerts
+- API changes
+- Bug reports
+- Logging & monitoring
+- Developer tools
+- System upgrades
+
+# Product & UX
+- Product updates
+- Feature announcements
+- Release notes
+- User research
+- UX testing invitations
+- Product onboarding
+- Churn outreach
+- Usage insights
+
+# Sales & Business Development
+- Lead communication
+- Sales proposals
+- Pricing quotes
+- Contract negotiations
+- CRM updates
+- Renewal discussions
+- Partner outreach
+- Deal progress updates
+
+# Marketing & Growth
+- Marketing campaigns
+- Newsletters
+- Market research
+- Audience insights
+- Promotional materials
+- Brand partnerships
+- SEO & content updates
+- Performance reports
+
+# Customer Support
+- Support tickets
+- Case updates
+- Troubleshooting instructions
+- Service notifications
+- Customer follow-ups
+- Satisfaction surveys
+- Replacement approvals
+- Escalation updates
+
+# Leadership & Executive
+- Board communication
+- Investor updates
+- Strategic planning
+- Executive alignment
+- Organizational changes
+- Financial reporting
+- High-level partnerships
+- Crisis communication
+
+# Legal & Compliance
+- Legal notices
+- Contract documents
+- Compliance reminders
+- Licensing updates
+- Privacy rights requests
+- Regulatory filings
+- Policy acknowledgments
+- Audit correspondence
+
+# Government & Civic
+- Government forms
+- Official notices
+- Civic programs
+- Elections & voting
+- Public safety alerts
+- Permits & licensing
+- Local government updates
+- Public policy changes
+
+# Education & School
+- School announcements
+- Teacher communication
+- Academic schedules
+- Assignments
+- Grades & transcripts
+- PTA/parent updates
+- Student services
+- Educational programs
+
+# Parenting & Childcare
+- Childcare scheduling
+- School activities
+- Family event planning
+- Youth programs
+- Parent groups
+- Activity reminders
+- Child health updates
+- Permission forms
+
+# Home & Household
+- Maintenance appointments
+- Home services
+- Utility billing
+- Property management
+- Renovation updates
+- Landscaping & gardening
+- Home monitoring
+- Household purchases
+
+# Real Estate & Property
+- Rental agreements
+- Mortgage communication
+- Property listings
+- Home inspections
+- Realtor communication
+- Tenant updates
+- Move-in/move-out notices
+- Insurance evaluations
+
+# Legal & Financial Services
+- Accounting communication
+- Insurance quotes
+- Financial advising
+- Estate planning
+- Legal consultations
+- Will & trust services
+- Service agreements
+- Professional recommendations
+
+# Social & Community
+- Event invitations
+- RSVPs
+- Group messages
+- Social media notifications
+- Community updates
+- Friend/connection requests
+- Local event notices
+- Social planning
+
+# Sports & Recreation
+- Sports leagues
+- Team updates
+- Fitness classes
+- Event schedules
+- Results & scores
+- Recreational programs
+- Outdoor activities
+- Hobby group communication
+
+# Entertainment & Media
+- Streaming updates
+- Content releases
+- Game updates
+- Podcasts
+- Music events
+- Book/news alerts
+- Subscriptions
+- Media promotions
+
+# Nonprofit & Charity
+- Donation confirmations
+- Fundraising campaigns
+- Volunteering
+- Charity events
+- Nonprofit updates
+- Impact reports
+- Member communication
+- Advocacy alerts
+
+# Product Notifications & Systems
+- App notifications
+- System alerts
+- Feature rollouts
+- Service interruptions
+- Device updates
+- Usage warnings
+- Platform maintenance
+- Data export availability
+
+# Personal Organization
+- Reminders
+- Notes & documents
+- Personal tasks
+- Calendar scheduling
+- Lists & planning
+- Personal updates
+- Life admin
+- Digital tools
+
+# Miscellaneous
+- Uncategorized
+- Unknown intent
+- Mixed-content messages
+- Broad newsletters
+- Irrelevant content
+- Auto-generated noise
+- Spam indicators
+- System noise
+	`
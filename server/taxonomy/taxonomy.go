@@ -0,0 +1,178 @@
+package taxonomy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	maxCategories    = 100
+	maxSubcategories = 50
+	maxNameLength    = 100
+)
+
+var ErrVersionMismatch = errors.New("taxonomy was changed by someone else")
+
+// parses the markdown style list. "# " lines are L1, "- " lines are L2
+func parse(src string) []data.TaxonomyCategory {
+	categories := make([]data.TaxonomyCategory, 0)
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "# "); ok {
+			categories = append(categories, data.TaxonomyCategory{
+				Name:          strings.TrimSpace(name),
+				Subcategories: make([]string, 0),
+			})
+		} else if name, ok := strings.CutPrefix(line, "- "); ok && len(categories) > 0 {
+			last := &categories[len(categories)-1]
+			last.Subcategories = append(last.Subcategories, strings.TrimSpace(name))
+		}
+	}
+	return categories
+}
+
+func Default() []data.TaxonomyCategory {
+	return parse(defaultCategories)
+}
+
+// renders the taxonomy in the same markdown style we give to the AI
+func Format(categories []data.TaxonomyCategory) string {
+	var sb strings.Builder
+	for i, cat := range categories {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("# " + cat.Name + "\n")
+		for _, sub := range cat.Subcategories {
+			sb.WriteString("- " + sub + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// trims names and checks the limits. Duplicate names are not allowed.
+func Normalize(categories []data.TaxonomyCategory) ([]data.TaxonomyCategory, error) {
+	if len(categories) == 0 {
+		return nil, errors.New("at least one category is required")
+	}
+	if len(categories) > maxCategories {
+		return nil, fmt.Errorf("at most %d categories are allowed", maxCategories)
+	}
+	seen := make(map[string]bool)
+	out := make([]data.TaxonomyCategory, 0, len(categories))
+	for _, cat := range categories {
+		name, err := normalizeName(cat.Name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("duplicate category: %s", name)
+		}
+		seen[strings.ToLower(name)] = true
+		if len(cat.Subcategories) > maxSubcategories {
+			return nil, fmt.Errorf("at most %d subcategories are allowed in %s", maxSubcategories, name)
+		}
+		subs := make([]string, 0, len(cat.Subcategories))
+		for _, sub := range cat.Subcategories {
+			sub, err := normalizeName(sub)
+			if err != nil {
+				return nil, err
+			}
+			if slices.ContainsFunc(subs, func(s string) bool { return strings.EqualFold(s, sub) }) {
+				continue
+			}
+			subs = append(subs, sub)
+		}
+		out = append(out, data.TaxonomyCategory{Name: name, Subcategories: subs})
+	}
+	return out, nil
+}
+
+func normalizeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("category names can't be empty")
+	}
+	if len(name) > maxNameLength {
+		return "", fmt.Errorf("category names must be at most %d characters", maxNameLength)
+	}
+	// would break the markdown we give the AI
+	if strings.ContainsAny(name, "\n\r#") {
+		return "", fmt.Errorf("invalid category name: %s", name)
+	}
+	return name, nil
+}
+
+// gets the account's taxonomy. Seeds it from the default list if it doesn't exist yet.
+func Get(ctx context.Context, accountId string) (*data.AccountTaxonomy, error) {
+	col := globals.DocDb().Collection("AccountTaxonomies")
+	seed := data.AccountTaxonomy{
+		AccountId:  accountId,
+		Categories: Default(),
+		Version:    1,
+	}
+	// upsert so concurrent first reads agree on the seeded doc
+	res := col.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": seed.ToDocumentId()},
+		bson.M{
+			"$setOnInsert": bson.M{
+				"accountId":  seed.AccountId,
+				"categories": seed.Categories,
+				"version":    seed.Version,
+				"updatedAt":  time.Now().UTC(),
+				"createdAt":  time.Now().UTC(),
+			},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	)
+	var taxonomy data.AccountTaxonomy
+	if err := res.Decode(&taxonomy); err != nil {
+		return nil, err
+	}
+	return &taxonomy, nil
+}
+
+// replaces the account's categories. If version is > 0 it must match the current version.
+func Save(ctx context.Context, accountId string, categories []data.TaxonomyCategory, version int64) (*data.AccountTaxonomy, error) {
+	categories, err := Normalize(categories)
+	if err != nil {
+		return nil, err
+	}
+	// make sure it has been seeded, so the version check below has something to compare against
+	current, err := Get(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": current.ToDocumentId()}
+	if version > 0 {
+		filter["version"] = version
+	}
+	res := globals.DocDb().Collection("AccountTaxonomies").FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.M{
+			"$set":         bson.M{"categories": categories},
+			"$inc":         bson.M{"version": 1},
+			"$currentDate": bson.M{"updatedAt": true},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	var taxonomy data.AccountTaxonomy
+	if err := res.Decode(&taxonomy); errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrVersionMismatch
+	} else if err != nil {
+		return nil, err
+	}
+	return &taxonomy, nil
+}
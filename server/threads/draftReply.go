@@ -0,0 +1,332 @@
+package threads
+
+import (
+	"errors"
+	"fmt"
+	"fromkeith/my-desktop-server/attachments"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/client"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"fromkeith/my-desktop-server/quoted"
+	"fromkeith/my-desktop-server/settings"
+	"fromkeith/my-desktop-server/usage"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// only the most recent messages are given to the model
+	draftMaxMessages = 10
+	// per message, so a long email doesn't crowd out the rest of the thread
+	draftMaxBodyLength = 4000
+)
+
+type DraftReplyRequest struct {
+	// extra guidance for this reply. eg. "politely decline"
+	Instructions string `json:"instructions"`
+	// reply to everyone on the message, not just the sender
+	ReplyAll bool `json:"replyAll"`
+} // @name DraftReplyRequest
+
+type DraftReplyResponse struct {
+	DraftId string `validate:"required" json:"draftId"`
+	// the gmail message id of the draft
+	MessageId string `validate:"required" json:"messageId"`
+	ThreadId  string `validate:"required" json:"threadId"`
+	// the message being replied to
+	InReplyToMessageId string `validate:"required" json:"inReplyToMessageId"`
+	Body               string `validate:"required" json:"body"`
+} // @name DraftReplyResponse
+
+type draftResult struct {
+	Body string
+}
+
+// DraftReply godoc
+// @Summary      Draft a reply with AI
+// @Description  Writes a reply to the latest message in the thread, using the thread, its todos and the account's tone. Saved as a Gmail draft in the thread for the user to review and send.
+// @Tags         email
+// @Accept       json
+// @Param        threadId path string true "threadId"
+// @Param        request body DraftReplyRequest false "Options"
+// @Produce      json
+// @Success      200  {object}  DraftReplyResponse
+// @Router       /threads/{threadId}/draftReply [post]
+func DraftReply(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	threadId := r.Param("threadId")
+	var req DraftReplyRequest
+	if r.Request.ContentLength > 0 {
+		if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+			r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{"internalDate", 1}})
+	cursor, err := globals.DocDb().Collection("Messages").Find(
+		r,
+		bson.M{
+			"accountId": accountId,
+			"threadId":  threadId,
+			"isDeleted": bson.M{"$ne": true},
+			"labels":    bson.M{"$ne": "DRAFT"}, // don't reply to our own drafts
+		},
+		opts,
+	)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to load thread messages")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load thread"})
+		return
+	}
+	defer cursor.Close(r)
+	var messages []data.GmailEntry
+	if err := cursor.All(r, &messages); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to decode thread messages")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load thread"})
+		return
+	}
+	if len(messages) == 0 {
+		r.JSON(http.StatusNotFound, gin.H{"error": "Thread not found"})
+		return
+	}
+	if len(messages) > draftMaxMessages {
+		messages = messages[len(messages)-draftMaxMessages:]
+	}
+	target := messages[len(messages)-1]
+
+	var thread ThreadEntry
+	err = globals.DocDb().Collection("MessageThreads").FindOne(r, bson.M{"_id": data.ToDocumentId(accountId, threadId)}).Decode(&thread)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to load thread")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load thread"})
+		return
+	}
+	accountSettings, err := settings.Get(r, accountId)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to load settings")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reply"})
+		return
+	}
+	myEmails, err := data.AccountEmails(r, accountId)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to load account emails")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reply"})
+		return
+	}
+
+	prompt, err := draftPrompt(r, accountId, messages, thread.AiSummary, req.Instructions)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to build reply prompt")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reply"})
+		return
+	}
+	result, err := globals.LLM().GenerateJSON(r, llm.JSONRequest{
+		System: fmt.Sprintf(draftInstructions, accountSettings.ReplyTone),
+		Prompt: prompt,
+		Schema: draftSchema,
+	})
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to generate reply")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reply"})
+		return
+	}
+	if err := usage.Save(r, usage.Generated(globals.LLM(), accountId, target.MessageId, usage.OperationDraftReply, result.Usage)); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to save usage")
+	}
+	var draft draftResult
+	if err := json.Unmarshal([]byte(result.Text), &draft); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to parse generated reply")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reply"})
+		return
+	}
+	body := strings.TrimSpace(draft.Body)
+	if accountSettings.Signature != "" {
+		body += "\n\n" + accountSettings.Signature
+	}
+
+	to, cc := replyRecipients(target, myEmails, req.ReplyAll)
+	gmailClient, err := client.GmailClient(r, accountId)
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to create gmail client")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create draft"})
+		return
+	}
+	created, err := gmailClient.CreateReplyDraft(r, client.ReplyDraft{
+		ThreadId:   threadId,
+		To:         to,
+		Cc:         cc,
+		Subject:    target.Subject,
+		InReplyTo:  target.Headers["message-id"],
+		References: target.Headers["references"],
+		Body:       body,
+	})
+	if err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Str("threadId", threadId).
+			Msg("failed to create draft")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create draft"})
+		return
+	}
+	res := DraftReplyResponse{
+		DraftId:            created.Id,
+		ThreadId:           threadId,
+		InReplyToMessageId: target.MessageId,
+		Body:               body,
+	}
+	if created.Message != nil {
+		res.MessageId = created.Message.Id
+	}
+	r.JSON(http.StatusOK, res)
+}
+
+// who the reply goes to. If we sent the last message, it's a follow up to the same people.
+func replyRecipients(target data.GmailEntry, myEmails []string, replyAll bool) (to []data.PersonInfo, cc []data.PersonInfo) {
+	isMe := func(p data.PersonInfo) bool {
+		return slices.Contains(myEmails, strings.ToLower(p.Email))
+	}
+	if isMe(target.Sender) {
+		to = slices.DeleteFunc(slices.Clone(target.Receiver), isMe)
+		cc = slices.DeleteFunc(slices.Clone(target.AdditionalReceivers["cc"]), isMe)
+		return to, cc
+	}
+	if target.ReplyTo != nil && target.ReplyTo.Email != "" {
+		to = []data.PersonInfo{*target.ReplyTo}
+	} else {
+		to = []data.PersonInfo{target.Sender}
+	}
+	if !replyAll {
+		return to, nil
+	}
+	cc = make([]data.PersonInfo, 0)
+	for _, p := range append(slices.Clone(target.Receiver), target.AdditionalReceivers["cc"]...) {
+		if isMe(p) || slices.ContainsFunc(to, func(t data.PersonInfo) bool { return strings.EqualFold(t.Email, p.Email) }) {
+			continue
+		}
+		cc = append(cc, p)
+	}
+	return to, cc
+}
+
+// the conversation oldest first, followed by the todos
+func draftPrompt(r *gin.Context, accountId string, messages []data.GmailEntry, summary *ThreadSummary, instructions string) (string, error) {
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ToDocumentId())
+	}
+	cursor, err := globals.DocDb().Collection("MessageBodies").Find(r, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return "", err
+	}
+	defer cursor.Close(r)
+	var bodies []data.GmailEntryBody
+	if err := cursor.All(r, &bodies); err != nil {
+		return "", err
+	}
+	bodyById := make(map[string]string, len(bodies))
+	// every message is included, so the quoted replies would only repeat them
+	for _, b := range bodies {
+		if b.NewContent != "" {
+			bodyById[b.MessageId] = b.NewContent
+		} else {
+			bodyById[b.MessageId] = quoted.StripText(b.PlainText)
+		}
+	}
+
+	var sb strings.Builder
+	for _, m := range messages {
+		body := bodyById[m.MessageId]
+		if body == "" {
+			body = m.Snippet
+		}
+		body = attachments.Truncate(body, draftMaxBodyLength)
+		fmt.Fprintf(&sb, "From: %s <%s>\nDate: %s\nSubject: %s\n\n%s\n---\n",
+			m.Sender.Name,
+			m.Sender.Email,
+			time.UnixMilli(m.InternalDate).UTC().Format(time.RFC1123),
+			m.Subject,
+			body,
+		)
+	}
+	todos := slices.Clone(messages[len(messages)-1].Todos)
+	if summary != nil {
+		todos = append(todos, summary.Todos...)
+		if len(summary.OpenQuestions) > 0 {
+			sb.WriteString("\nOpen questions:\n- " + strings.Join(summary.OpenQuestions, "\n- ") + "\n")
+		}
+	}
+	if len(todos) > 0 {
+		sb.WriteString("\nThings to address in the reply:\n- " + strings.Join(todos, "\n- ") + "\n")
+	}
+	if instructions = strings.TrimSpace(instructions); instructions != "" {
+		sb.WriteString("\nAlso: " + instructions + "\n")
+	}
+	return sb.String(), nil
+}
+
+var draftSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"Body": map[string]any{
+			"type":        "string",
+			"description": "The plain text body of the reply. No subject line and no signature.",
+		},
+	},
+	"required": []string{"Body"},
+}
+
+const draftInstructions = `You draft email replies on behalf of the user. The conversation is given oldest first, the reply is to the last message.
+Address the action items and open questions where you can. Don't make up facts, dates or commitments; leave a [placeholder] for the user to fill in instead.
+Write in this tone: %s
+Don't include a subject line or a signature.
+Return as JSON (Body)`
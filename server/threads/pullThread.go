@@ -28,6 +28,10 @@ type ThreadEntry struct {
 	MostRecentInternalDate int64          `validate:"required" json:"mostRecentInternalDate" bson:"mostRecentInternalDate"`
 	Categories             []string       `validate:"required" json:"categories" bson:"categories"`
 	Tags                   []string       `validate:"required" json:"tags" bson:"tags"`
+	// used in database, but not returned via API
+	AccountId string `json:"-" bson:"accountId"`
+	// set by the threadSummary service. Missing until the thread has enough messages
+	AiSummary *ThreadSummary `json:"aiSummary,omitempty" bson:"aiSummary,omitempty"`
 } // @name Thread
 
 type SyncCheckpoint struct {
@@ -0,0 +1,38 @@
+package threads
+
+import "time"
+
+// an AI summary of the whole conversation
+type ThreadSummary struct {
+	Summary string `validate:"required" json:"summary" bson:"summary"`
+	// questions asked in the thread that haven't been answered yet
+	OpenQuestions []string `validate:"required" json:"openQuestions" bson:"openQuestions"`
+	// action items that are still outstanding
+	Todos []string `validate:"required" json:"todos" bson:"todos"`
+	// what was summarized, so we know when it is out of date
+	MessageCount  int       `validate:"required" json:"messageCount" bson:"messageCount"`
+	LastMessageId string    `validate:"required" json:"lastMessageId" bson:"lastMessageId"`
+	GeneratedAt   time.Time `validate:"required" json:"generatedAt" bson:"generatedAt"`
+} // @name ThreadSummary
+
+// true if the thread has changed since it was summarized
+func (t ThreadEntry) NeedsSummary(minMessages int) bool {
+	if len(t.Messages) < minMessages {
+		return false
+	}
+	if t.AiSummary == nil {
+		return true
+	}
+	return t.AiSummary.MessageCount != len(t.Messages) || t.AiSummary.LastMessageId != t.LatestMessage().MessageId
+}
+
+// the most recent message by internalDate
+func (t ThreadEntry) LatestMessage() MessageBasic {
+	var latest MessageBasic
+	for _, m := range t.Messages {
+		if m.InternalDate >= latest.InternalDate {
+			latest = m
+		}
+	}
+	return latest
+}
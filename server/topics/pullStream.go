@@ -0,0 +1,99 @@
+package topics
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/utils"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// PullStream godoc
+// @Summary      Stream Topics
+// @Description  Sync endpoint to allow for for push from server to client of changes to the discovered topics.
+// @Tags         email
+// @Produce      event-stream
+// @Router       /topics/pullStream [get]
+func PullStream(r *gin.Context) {
+	accountId := r.GetString("accountId")
+
+	matchStage := bson.D{{
+		"$match", bson.D{
+			{"fullDocument.accountId", accountId},
+		},
+	}}
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(10 * time.Second)
+	stream, err := globals.DocDb().Collection("Topics").Watch(r, mongo.Pipeline{matchStage}, opts)
+	if err != nil {
+		r.Error(err)
+		return
+	}
+	defer stream.Close(r)
+
+	streamCtx, cancel := context.WithCancel(r.Request.Context())
+	defer cancel()
+
+	batchChan, batchErr := utils.BatchMongoStreamChannel(streamCtx, stream, 10, time.Second)
+
+	r.Set("Content-Type", "text/event-stream")
+	r.Stream(func(w io.Writer) bool {
+		select {
+		case err := <-batchErr:
+			if err != nil {
+				log.Error().
+					Ctx(r).
+					Err(err).
+					Msg("failed to batch topics in stream")
+				return false
+			}
+		case batch := <-batchChan:
+			payloads := make([]data.Topic, 0, len(batch))
+			chkPoint := SyncCheckpoint{}
+			for _, ev := range batch {
+				full, ok := ev["fullDocument"]
+				if !ok {
+					return true
+				}
+				raw, _ := bson.Marshal(full)
+				var topic data.Topic
+				if err := bson.Unmarshal(raw, &topic); err != nil {
+					log.Error().
+						Ctx(r).
+						Err(err).
+						Any("full", full).
+						Msg("failed to unmarshal topic in stream")
+					return true
+				}
+				if topic.SampleMessageIds == nil {
+					topic.SampleMessageIds = make([]string, 0)
+				}
+				payloads = append(payloads, topic)
+				at := topic.UpdatedAt.Format(time.RFC3339Nano)
+				if at > chkPoint.UpdatedAt {
+					chkPoint = SyncCheckpoint{TopicId: topic.TopicId, UpdatedAt: at}
+				} else if at == chkPoint.UpdatedAt && topic.TopicId > chkPoint.TopicId {
+					chkPoint = SyncCheckpoint{TopicId: topic.TopicId, UpdatedAt: at}
+				}
+			}
+			payload, _ := json.Marshal(PullTopicsResponse{
+				Topics:     payloads,
+				Checkpoint: chkPoint,
+			})
+			r.SSEvent("message", payload)
+			return true
+		case <-time.After(time.Second):
+			return true // allow the request to check its status or end
+		}
+		return true
+	})
+
+}
@@ -0,0 +1,97 @@
+package topics
+
+import (
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type SyncCheckpoint struct {
+	TopicId   string `validate:"required" json:"topicId"`
+	UpdatedAt string `validate:"required" json:"updatedAt"`
+} // @name CheckpointTopic
+
+type PullTopicsResponse struct {
+	Topics     []data.Topic   `validate:"required" json:"topics"`
+	Checkpoint SyncCheckpoint `validate:"required" json:"checkpoint"`
+} // @name PullTopicsResponse
+
+// PullTopics godoc
+// @Summary      Get Topics
+// @Description  Sync endpoint to pull all changes to the discovered topics for this account.
+// @Tags         email
+// @Produce      json
+// @Param        topicId query string true "topicId"
+// @Param        updatedAt query string true "Last updated time"
+// @Param        limit query int true "Batch size"
+// @Success      200  {object}  PullTopicsResponse
+// @Router       /topics/pull [get]
+func PullTopics(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	topicId := r.Query("topicId")
+	lastId := data.ToDocumentId(accountId, topicId)
+	updatedAtStr := r.Query("updatedAt")
+	updatedAt, _ := time.Parse(time.RFC3339Nano, updatedAtStr)
+
+	batchSizeStr := r.Query("limit")
+	batchSize, _ := strconv.ParseInt(batchSizeStr, 10, 64)
+	if batchSize <= 0 || batchSize > 100 {
+		batchSize = 10
+	}
+
+	opts := options.Find().SetSort(bson.D{{"updatedAt", 1}, {"_id", 1}}).SetLimit(batchSize)
+	cursor, err := globals.DocDb().Collection("Topics").Find(
+		r,
+		bson.M{
+			"$or": []bson.M{
+				bson.M{"updatedAt": bson.M{"$gt": updatedAt}},
+				bson.M{
+					"updatedAt": updatedAt,
+					"_id":       bson.M{"$gt": lastId},
+				},
+			},
+			"accountId": accountId,
+		},
+		opts,
+	)
+	if err != nil {
+		r.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cursor.Close(r)
+
+	var topics []data.Topic
+	if err := cursor.All(r, &topics); err != nil {
+		r.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for i, t := range topics {
+		if t.SampleMessageIds == nil {
+			topics[i].SampleMessageIds = make([]string, 0)
+		}
+	}
+
+	var nextId string
+	var nextUpdatedAt string
+	if len(topics) > 0 {
+		last := topics[len(topics)-1]
+		nextId = last.TopicId
+		nextUpdatedAt = last.UpdatedAt.Format(time.RFC3339Nano)
+	} else {
+		nextId = topicId
+		nextUpdatedAt = updatedAtStr
+	}
+
+	r.JSON(200, PullTopicsResponse{
+		Topics:     topics,
+		Checkpoint: SyncCheckpoint{TopicId: nextId, UpdatedAt: nextUpdatedAt},
+	})
+}
@@ -0,0 +1,116 @@
+package translation
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// less than this and a guess isn't worth much
+	minLetters = 20
+	// only the start of long messages is looked at
+	maxDetectLength = 4000
+	// stop words a latin script language must match to be picked
+	minStopWordHits = 2
+)
+
+// scripts that, on their own, mostly mean one language
+var scripts = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// the most common words of the latin script languages
+var stopWords = map[string][]string{
+	"en": {"the", "and", "you", "that", "for", "with", "this", "have", "are", "your", "will", "not", "from", "our", "please", "thanks"},
+	"fr": {"le", "la", "les", "des", "est", "et", "vous", "pour", "une", "dans", "que", "qui", "pas", "sur", "avec", "merci"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "sie", "mit", "ein", "eine", "für", "auf", "ich", "wir", "bitte", "danke"},
+	"es": {"el", "los", "las", "que", "por", "para", "con", "una", "está", "del", "muy", "pero", "usted", "gracias", "como", "es"},
+	"it": {"il", "che", "per", "non", "una", "sono", "della", "con", "gli", "questo", "anche", "grazie", "del", "alla", "ciao", "è"},
+	"pt": {"que", "não", "para", "com", "uma", "os", "do", "da", "em", "você", "obrigado", "está", "mais", "muito", "pelo", "são"},
+	"nl": {"de", "het", "een", "en", "van", "niet", "voor", "met", "zijn", "dat", "ik", "wij", "u", "bedankt", "ook", "maar"},
+	"sv": {"och", "att", "det", "som", "är", "för", "inte", "med", "på", "jag", "vi", "har", "till", "tack", "av", "en"},
+}
+
+// the ISO 639-1 code of the text's language. Empty if there isn't enough text to tell
+func Detect(text string) string {
+	if len(text) > maxDetectLength {
+		text = text[:maxDetectLength]
+	}
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if r < unicode.MaxLatin1 {
+			continue
+		}
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				counts[s.language]++
+				break
+			}
+		}
+	}
+	if letters < minLetters {
+		return ""
+	}
+	// japanese mixes kana with han, so any kana means japanese
+	if counts["ja"] > 0 && counts["ja"]+counts["zh"] > letters/3 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for language, count := range counts {
+		if count > bestCount {
+			best, bestCount = language, count
+		}
+	}
+	if bestCount > letters/3 {
+		return best
+	}
+	return detectLatin(text)
+}
+
+// picks the language whose stop words are used the most
+func detectLatin(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	hits := make(map[string]int)
+	for _, word := range words {
+		for language, common := range stopWords {
+			for _, w := range common {
+				if w == word {
+					hits[language]++
+					break
+				}
+			}
+		}
+	}
+	best, bestHits, secondHits := "", 0, 0
+	for language, count := range hits {
+		if count > bestHits || (count == bestHits && language < best) {
+			best, bestHits, secondHits = language, count, bestHits
+		} else if count > secondHits {
+			secondHits = count
+		}
+	}
+	// too close to call
+	if bestHits < minStopWordHits || bestHits == secondHits {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,123 @@
+package translation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fromkeith/my-desktop-server/attachments"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// longer bodies are cut, so the translation fits in the model's output
+const maxTranslateLength = 20000
+
+// the base language of a BCP 47 tag. eg. "pt-BR" is "pt"
+func NormalizeLanguage(tag string) (string, error) {
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return "", fmt.Errorf("unknown language %q", tag)
+	}
+	base, confidence := parsed.Base()
+	if confidence == language.No {
+		return "", fmt.Errorf("unknown language %q", tag)
+	}
+	return base.String(), nil
+}
+
+// the cached translation, or nil if there isn't one
+func GetCached(ctx context.Context, accountId, messageId, lang string) (*data.MessageTranslation, error) {
+	key := data.MessageTranslation{AccountId: accountId, MessageId: messageId, Language: lang}
+	var translation data.MessageTranslation
+	err := globals.DocDb().Collection("MessageTranslations").FindOne(ctx, bson.M{"_id": key.ToDocumentId()}).Decode(&translation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &translation, nil
+}
+
+func SaveCached(ctx context.Context, translation data.MessageTranslation) error {
+	_, err := globals.DocDb().Collection("MessageTranslations").UpdateOne(
+		ctx,
+		bson.M{"_id": translation.ToDocumentId()},
+		bson.M{"$set": bson.M{
+			"accountId":      translation.AccountId,
+			"messageId":      translation.MessageId,
+			"language":       translation.Language,
+			"sourceLanguage": translation.SourceLanguage,
+			"text":           translation.Text,
+			"createdAt":      translation.CreatedAt,
+		}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+type translateResult struct {
+	SourceLanguage string
+	Translation    string
+}
+
+var translateSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"SourceLanguage": map[string]any{
+			"type":        "string",
+			"description": "ISO 639-1 code of the language the email is written in",
+		},
+		"Translation": map[string]any{
+			"type":        "string",
+			"description": "The translated email",
+		},
+	},
+	"required": []string{"SourceLanguage", "Translation"},
+}
+
+const translateInstructions = `You translate emails into %s. Keep the markdown formatting, names, numbers, links and anything like [REDACTED x] exactly as they are. If part of the email is already in %s, keep it as is.
+Return as JSON (SourceLanguage, Translation).`
+
+// translates the message's markdown text into the language. Returns the tokens used, even if the result couldn't be parsed
+func Translate(ctx context.Context, accountId, messageId, text, lang string) (*data.MessageTranslation, *llm.Usage, error) {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := display.English.Languages().Name(tag)
+	result, err := globals.LLM().GenerateJSON(ctx, llm.JSONRequest{
+		System: fmt.Sprintf(translateInstructions, name, name),
+		Prompt: attachments.Truncate(text, maxTranslateLength),
+		Schema: translateSchema,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var res translateResult
+	if err := json.Unmarshal([]byte(result.Text), &res); err != nil {
+		return nil, &result.Usage, err
+	}
+	if normalized, err := NormalizeLanguage(res.SourceLanguage); err == nil {
+		res.SourceLanguage = normalized
+	}
+	return &data.MessageTranslation{
+		AccountId:      accountId,
+		MessageId:      messageId,
+		Language:       lang,
+		SourceLanguage: res.SourceLanguage,
+		Text:           res.Translation,
+		CreatedAt:      time.Now().UTC(),
+	}, &result.Usage, nil
+}
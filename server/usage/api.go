@@ -0,0 +1,144 @@
+package usage
+
+import (
+	"fromkeith/my-desktop-server/globals"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const maxReportDays = 90
+
+type DailyUsage struct {
+	// YYYY-MM-DD, in UTC
+	Date      string `validate:"required" json:"date"`
+	Provider  string `validate:"required" json:"provider"`
+	Model     string `validate:"required" json:"model"`
+	Operation string `validate:"required" json:"operation"`
+	Totals
+} // @name AiDailyUsage
+
+type UsageReport struct {
+	Budget Budget `validate:"required" json:"budget"`
+	Today  Totals `validate:"required" json:"today"`
+	Month  Totals `validate:"required" json:"month"`
+	// messages waiting for the account to be under budget
+	Deferred int64 `validate:"required" json:"deferred"`
+	// per day, model and operation. Most recent first
+	Daily []DailyUsage `validate:"required" json:"daily"`
+} // @name AiUsageReport
+
+// GetUsage godoc
+// @Summary      AI usage
+// @Description  Tokens used by this account, against its budget.
+// @Tags         ai
+// @Param        days query int false "Days of daily usage to return. Defaults to 30, max 90"
+// @Produce      json
+// @Success      200  {object}  UsageReport
+// @Router       /ai/usage [get]
+func GetUsage(r *gin.Context) {
+	accountId := r.GetString("accountId")
+	days, _ := strconv.Atoi(r.Query("days"))
+	if days <= 0 || days > maxReportDays {
+		days = 30
+	}
+	fail := func(err error) {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to get usage")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+	}
+
+	budget, err := GetBudget(r, accountId)
+	if err != nil {
+		fail(err)
+		return
+	}
+	today, month, err := CurrentTotals(r, accountId)
+	if err != nil {
+		fail(err)
+		return
+	}
+	deferred, err := CountDeferred(r, accountId)
+	if err != nil {
+		fail(err)
+		return
+	}
+	dayStart, _ := periods(time.Now())
+	rows, err := globals.Db().Query(r, `
+		SELECT
+			to_char(date_trunc('day', createdAt), 'YYYY-MM-DD'),
+			provider,
+			model,
+			operation,
+			SUM(promptTokens),
+			SUM(outputTokens),
+			SUM(embeddingTokens)
+		FROM AiUsage
+		WHERE accountId = $1
+		AND createdAt >= $2
+		GROUP BY 1, 2, 3, 4
+		ORDER BY 1 DESC, 2, 3, 4
+		`, accountId, dayStart.AddDate(0, 0, -(days-1)))
+	if err != nil {
+		fail(err)
+		return
+	}
+	daily, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (DailyUsage, error) {
+		var d DailyUsage
+		err := row.Scan(&d.Date, &d.Provider, &d.Model, &d.Operation, &d.PromptTokens, &d.OutputTokens, &d.EmbeddingTokens)
+		d.Total = d.PromptTokens + d.OutputTokens + d.EmbeddingTokens
+		return d, err
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+	r.JSON(http.StatusOK, UsageReport{
+		Budget:   *budget,
+		Today:    today,
+		Month:    month,
+		Deferred: deferred,
+		Daily:    daily,
+	})
+}
+
+// SaveAiBudget godoc
+// @Summary      Set the AI budget
+// @Description  Token limits per UTC day and month. 0 is unlimited. Messages over budget are deferred, not dropped. When the server sets a limit, it can only be lowered.
+// @Tags         ai
+// @Accept       json
+// @Param        request body Budget true "Budget"
+// @Produce      json
+// @Success      200  {object}  Budget
+// @Router       /ai/budget [put]
+func SaveAiBudget(r *gin.Context) {
+	var req Budget
+	if err := r.ShouldBindBodyWithJSON(&req); err != nil {
+		r.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DailyTokens < 0 || req.MonthlyTokens < 0 {
+		r.JSON(http.StatusBadRequest, gin.H{"error": "Budgets can't be negative"})
+		return
+	}
+	ceiling := DefaultBudget()
+	if overCeiling(req.DailyTokens, ceiling.DailyTokens) || overCeiling(req.MonthlyTokens, ceiling.MonthlyTokens) {
+		r.JSON(http.StatusBadRequest, gin.H{"error": "Budgets can't be above the server's limits"})
+		return
+	}
+	if err := SaveBudget(r, r.GetString("accountId"), req); err != nil {
+		log.Error().
+			Ctx(r).
+			Err(err).
+			Msg("failed to save budget")
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save budget"})
+		return
+	}
+	r.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,292 @@
+package usage
+
+import (
+	"context"
+	"fromkeith/my-desktop-server/globals"
+	"fromkeith/my-desktop-server/gmail/data"
+	"fromkeith/my-desktop-server/llm"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	OperationAnalyze       = "analyze"
+	OperationEmbed         = "embed"
+	OperationThreadSummary = "threadSummary"
+	OperationDraftReply    = "draftReply"
+	OperationExtract       = "extract"
+	OperationAsk           = "ask"
+	OperationTranslate     = "translate"
+	OperationTopicLabel    = "topicLabel"
+)
+
+// tokens used by a single LLM call
+type Record struct {
+	AccountId string
+	// empty when the call wasn't for a single message
+	MessageId       string
+	Provider        string
+	Model           string
+	Operation       string
+	PromptTokens    int64
+	OutputTokens    int64
+	EmbeddingTokens int64
+}
+
+// a record of a GenerateJSON call
+func Generated(provider llm.Provider, accountId, messageId, operation string, u llm.Usage) Record {
+	return Record{
+		AccountId:    accountId,
+		MessageId:    messageId,
+		Provider:     provider.Name(),
+		Model:        provider.Model(),
+		Operation:    operation,
+		PromptTokens: u.PromptTokens,
+		OutputTokens: u.OutputTokens,
+	}
+}
+
+type Totals struct {
+	PromptTokens    int64 `validate:"required" json:"promptTokens"`
+	OutputTokens    int64 `validate:"required" json:"outputTokens"`
+	EmbeddingTokens int64 `validate:"required" json:"embeddingTokens"`
+	Total           int64 `validate:"required" json:"total"`
+} // @name AiUsageTotals
+
+// token limits. 0 is unlimited
+type Budget struct {
+	DailyTokens   int64 `validate:"required" json:"dailyTokens"`
+	MonthlyTokens int64 `validate:"required" json:"monthlyTokens"`
+} // @name AiBudget
+
+// the budget for accounts that haven't set their own. Read from
+// AI_DAILY_TOKEN_BUDGET and AI_MONTHLY_TOKEN_BUDGET, unlimited if unset.
+func DefaultBudget() Budget {
+	daily, _ := strconv.ParseInt(os.Getenv("AI_DAILY_TOKEN_BUDGET"), 10, 64)
+	monthly, _ := strconv.ParseInt(os.Getenv("AI_MONTHLY_TOKEN_BUDGET"), 10, 64)
+	return Budget{
+		DailyTokens:   max(daily, 0),
+		MonthlyTokens: max(monthly, 0),
+	}
+}
+
+func Save(ctx context.Context, records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	batch := &pgx.Batch{}
+	for _, r := range records {
+		batch.Queue(`
+			INSERT INTO AiUsage (
+				accountId, messageId, provider, model, operation,
+				promptTokens, outputTokens, embeddingTokens, createdAt
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			`,
+			r.AccountId, r.MessageId, r.Provider, r.Model, r.Operation,
+			r.PromptTokens, r.OutputTokens, r.EmbeddingTokens, now,
+		)
+	}
+	return globals.Db().SendBatch(ctx, batch).Close()
+}
+
+func GetBudget(ctx context.Context, accountId string) (*Budget, error) {
+	var budget Budget
+	err := globals.Db().QueryRow(ctx, `
+		SELECT dailyTokens, monthlyTokens
+		FROM AiBudgets
+		WHERE accountId = $1
+		`, accountId).Scan(&budget.DailyTokens, &budget.MonthlyTokens)
+	if err == pgx.ErrNoRows {
+		budget = DefaultBudget()
+		return &budget, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// saved before the server's limits were set, or lowered
+	ceiling := DefaultBudget()
+	if overCeiling(budget.DailyTokens, ceiling.DailyTokens) {
+		budget.DailyTokens = ceiling.DailyTokens
+	}
+	if overCeiling(budget.MonthlyTokens, ceiling.MonthlyTokens) {
+		budget.MonthlyTokens = ceiling.MonthlyTokens
+	}
+	return &budget, nil
+}
+
+// the server's limits are a ceiling. 0 would lift them
+func overCeiling(tokens int64, ceiling int64) bool {
+	return ceiling > 0 && (tokens == 0 || tokens > ceiling)
+}
+
+func SaveBudget(ctx context.Context, accountId string, budget Budget) error {
+	_, err := globals.Db().Exec(ctx, `
+		INSERT INTO AiBudgets (accountId, dailyTokens, monthlyTokens, updatedAt)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (accountId) DO UPDATE
+		SET dailyTokens = EXCLUDED.dailyTokens,
+			monthlyTokens = EXCLUDED.monthlyTokens,
+			updatedAt = EXCLUDED.updatedAt
+		`, accountId, budget.DailyTokens, budget.MonthlyTokens, time.Now().UTC())
+	return err
+}
+
+// the start of the current day and month, in UTC. Budgets reset at these times
+func periods(now time.Time) (day time.Time, month time.Time) {
+	now = now.UTC()
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return day, month
+}
+
+// usage since the start of the day, and of the month
+func CurrentTotals(ctx context.Context, accountId string) (today Totals, month Totals, err error) {
+	dayStart, monthStart := periods(time.Now())
+	err = globals.Db().QueryRow(ctx, `
+		SELECT
+			COALESCE(SUM(promptTokens) FILTER (WHERE createdAt >= $2), 0),
+			COALESCE(SUM(outputTokens) FILTER (WHERE createdAt >= $2), 0),
+			COALESCE(SUM(embeddingTokens) FILTER (WHERE createdAt >= $2), 0),
+			COALESCE(SUM(promptTokens), 0),
+			COALESCE(SUM(outputTokens), 0),
+			COALESCE(SUM(embeddingTokens), 0)
+		FROM AiUsage
+		WHERE accountId = $1
+		AND createdAt >= $3
+		`, accountId, dayStart, monthStart).Scan(
+		&today.PromptTokens,
+		&today.OutputTokens,
+		&today.EmbeddingTokens,
+		&month.PromptTokens,
+		&month.OutputTokens,
+		&month.EmbeddingTokens,
+	)
+	today.Total = today.PromptTokens + today.OutputTokens + today.EmbeddingTokens
+	month.Total = month.PromptTokens + month.OutputTokens + month.EmbeddingTokens
+	return today, month, err
+}
+
+// if the account has used up its daily or monthly budget
+func OverBudget(ctx context.Context, accountId string) (bool, error) {
+	budget, err := GetBudget(ctx, accountId)
+	if err != nil {
+		return false, err
+	}
+	if budget.DailyTokens == 0 && budget.MonthlyTokens == 0 {
+		return false, nil // unlimited, don't bother summing
+	}
+	today, month, err := CurrentTotals(ctx, accountId)
+	if err != nil {
+		return false, err
+	}
+	if budget.DailyTokens > 0 && today.Total >= budget.DailyTokens {
+		return true, nil
+	}
+	return budget.MonthlyTokens > 0 && month.Total >= budget.MonthlyTokens, nil
+}
+
+// holds a message back until the account is under budget again, or until a later try for transient LLM errors. See RequeueDeferred
+func Defer(ctx context.Context, accountId, messageId string, payload []byte) error {
+	_, err := globals.Db().Exec(ctx, `
+		INSERT INTO AiDeferredMessages (accountId, messageId, payload, createdAt)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (accountId, messageId) DO UPDATE
+		SET payload = EXCLUDED.payload
+		`, accountId, messageId, payload, time.Now().UTC())
+	return err
+}
+
+func CountDeferred(ctx context.Context, accountId string) (int64, error) {
+	var count int64
+	err := globals.Db().QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM AiDeferredMessages
+		WHERE accountId = $1
+		`, accountId).Scan(&count)
+	return count, err
+}
+
+// re-queues deferred messages, oldest first, for accounts that are back under budget.
+// Up to limit per account. Safe to run from multiple instances.
+func RequeueDeferred(ctx context.Context, available *kafka.Writer, limit int) error {
+	rows, err := globals.Db().Query(ctx, `SELECT DISTINCT accountId FROM AiDeferredMessages`)
+	if err != nil {
+		return err
+	}
+	accountIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	for _, accountId := range accountIds {
+		over, err := OverBudget(ctx, accountId)
+		if err != nil {
+			return err
+		}
+		if over {
+			continue
+		}
+		if err := requeueAccount(ctx, available, accountId, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func requeueAccount(ctx context.Context, available *kafka.Writer, accountId string, limit int) error {
+	tx, err := globals.Db().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT messageId, payload
+		FROM AiDeferredMessages
+		WHERE accountId = $1
+		ORDER BY createdAt
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+		`, accountId, limit)
+	if err != nil {
+		return err
+	}
+	msgs := make([]kafka.Message, 0)
+	messageIds := make([]string, 0)
+	for rows.Next() {
+		var messageId string
+		var payload []byte
+		if err := rows.Scan(&messageId, &payload); err != nil {
+			rows.Close()
+			return err
+		}
+		messageIds = append(messageIds, messageId)
+		msgs = append(msgs, kafka.Message{
+			Key:   []byte(data.ToDocumentId(accountId, messageId)),
+			Value: payload,
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	if err := available.WriteMessages(ctx, msgs...); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		DELETE FROM AiDeferredMessages
+		WHERE accountId = $1
+		AND messageId = ANY($2)
+		`, accountId, messageIds)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}